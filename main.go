@@ -2,25 +2,52 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
+	"io/fs"
 	"log"
+	"math"
 	"net/http"
+	"net/mail"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/arangodb/go-driver"
 	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/services/user/stores/cache"
+	"github.com/gitamped/bud/services/user/stores/instrument"
+	"github.com/gitamped/bud/services/user/stores/memory"
 	"github.com/gitamped/bud/services/user/stores/nosql"
+	"github.com/gitamped/bud/services/user/stores/retry"
+	sqlstore "github.com/gitamped/bud/services/user/stores/sql"
+	"github.com/gitamped/bud/web"
 	"github.com/gitamped/seed/auth"
 	"github.com/gitamped/seed/keystore"
 	"github.com/gitamped/seed/mid"
 	"github.com/gitamped/seed/server"
+	"github.com/gitamped/seed/values"
 	"github.com/gitamped/stem/database"
 	"go.uber.org/zap"
 )
 
+// defaultIssuer is the base URL the server is reachable on when
+// BUD_ISSUER isn't set.
+const defaultIssuer = "http://localhost:8080"
+
+// issuer is the base URL the server is reachable on, as clients see it. It
+// is the iss claim of every token and the base of the published endpoints.
+var issuer = defaultIssuer
+
 func main() {
+	if v := os.Getenv("BUD_ISSUER"); v != "" {
+		issuer = strings.TrimSuffix(v, "/")
+	}
+
 	// New RPCServer
 	s := server.NewServer(mid.CommonMiddleware)
 
@@ -29,16 +56,40 @@ func main() {
 
 	ks, _ := keystore.NewFS(os.DirFS(fsPath))
 
-	a, _ := auth.New("54bb2165-71e1-41a6-af3e-7da4a0e1e2c1", ks)
+	// The kid of each key is the name of its PEM file.
+	pems, _ := fs.Glob(os.DirFS(fsPath), "*.pem")
+	kids := make([]string, 0, len(pems))
+	for _, pem := range pems {
+		kids = append(kids, strings.TrimSuffix(pem, ".pem"))
+	}
+
+	// Address access tokens to the API, which refuses any other token
+	signerOpts := []user.SignerOption{user.WithIssuer(issuer)}
+	if aud := os.Getenv("BUD_AUDIENCE"); aud != "" {
+		signerOpts = append(signerOpts, user.WithAudience(aud))
+	}
+	signer, _ := user.NewSigner("54bb2165-71e1-41a6-af3e-7da4a0e1e2c1", ks, signerOpts...)
 
 	logger, _ := zap.NewProduction()
 	defer logger.Sync() // flushes buffer, if any
 	sugar := logger.Sugar()
 
-	// connect to the database
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Pass the request context and the caller's claims through to the servicers
+	s.Handler = mid.MultipleMiddleware(web.Handler(s), append(mid.CommonMiddleware, web.ClientInfoMiddleware(false), web.AuthMiddleware(signer), web.ImpersonationMiddleware(sugar))...)
 
+	// Keep users in memory or in a SQL database when asked to, so the
+	// service runs without ArangoDB
+	switch store := os.Getenv("BUD_STORE"); store {
+	case "memory":
+		users := memory.NewStore()
+		serveUsers(s, sugar, signer, ks, kids, users, memoryStores(users), "an in-memory store")
+		return
+	case "sqlite", "postgres":
+		serveUsers(s, sugar, signer, ks, kids, openSQL(sugar, store), nil, "a "+store+" store")
+		return
+	}
+
+	// connect to the database
 	dbClient, err := database.Open(database.Config{
 		User:       "root",
 		Password:   "arangodb",
@@ -53,22 +104,644 @@ func main() {
 
 	sugar.Info("Waiting for database to be ready ...")
 
-	if err := database.StatusCheck(ctx, dbClient); err != nil {
+	// Keep checking for BUD_DB_WAIT, so the server can start before the
+	// database is up
+	dbWait := time.Minute
+	if raw := os.Getenv("BUD_DB_WAIT"); raw != "" {
+		if dbWait, err = time.ParseDuration(raw); err != nil {
+			sugar.Fatalf("parsing BUD_DB_WAIT: %v", err)
+		}
+	}
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), dbWait)
+	defer cancelWait()
+	waitCfg := retry.Config{Attempts: math.MaxInt, MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	err = retry.Do(waitCtx, waitCfg, func(error) bool { return waitCtx.Err() == nil }, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		err := database.StatusCheck(ctx, dbClient)
+		if err != nil {
+			sugar.Infow("database not ready", "error", err)
+		}
+		return err
+	})
+	if err != nil {
 		sugar.Fatalf("status check database: %v", err)
 	}
 
 	sugar.Info("Database ready")
 
-	db, _ := dbClient.Database(ctx, "testcreateuser")
-	userStorer := nosql.NewStore(sugar, db)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db, err := nosql.EnsureDatabase(ctx, dbClient, "testcreateuser")
+	if err != nil {
+		sugar.Fatalf("Opening database: %v", err)
+	}
+
+	// bud migrate up|down [steps]|status manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(db, os.Args[2:]); err != nil {
+			sugar.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	// Bring the schema up to date unless it is managed with bud migrate
+	if os.Getenv("BUD_MIGRATE") != "false" {
+		if err := migrate(db, []string{"up"}); err != nil {
+			sugar.Fatalf("Migrating database: %v", err)
+		}
+	}
+
+	// Encrypt the names and emails of users with data keys wrapped by the
+	// BUD_ENCRYPTION_KID key when it is set
+	storeOpts := []nosql.Option{nosql.WithOutbox()}
+	var readOpts []nosql.ReadOption
+	var cipher *nosql.Cipher
+	if kid := os.Getenv("BUD_ENCRYPTION_KID"); kid != "" {
+		cipher, err = nosql.NewCipher(ctx, db, ks, kid)
+		if err != nil {
+			sugar.Fatalf("loading data keys: %v", err)
+		}
+		n, err := cipher.Rewrap(ctx)
+		if err != nil {
+			sugar.Fatalf("rewrapping data keys: %v", err)
+		}
+		if n > 0 {
+			sugar.Infow("data keys rewrapped", "kid", kid, "count", n)
+		}
+		storeOpts = append(storeOpts, nosql.WithEncryption(cipher))
+		readOpts = append(readOpts, nosql.WithCipher(cipher))
+	}
+
+	// bud keys rotate creates a new data key and exits. Users are
+	// re-encrypted with it in the background.
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if len(os.Args) < 3 || os.Args[2] != "rotate" {
+			sugar.Fatal("usage: bud keys rotate")
+		}
+		if cipher == nil {
+			sugar.Fatal("keys: BUD_ENCRYPTION_KID is not set")
+		}
+		id, err := cipher.Rotate(ctx)
+		if err != nil {
+			sugar.Fatalf("rotating data key: %v", err)
+		}
+		fmt.Printf("Data key %s is active\n", id)
+		return
+	}
+
+	userStorer := nosql.NewStore(sugar, db, storeOpts...)
+	groupStorer := nosql.NewGroupStore(sugar, db, readOpts...)
+	orgStorer := nosql.NewOrgStore(sugar, db)
+	attributeStorer := nosql.NewAttributeStore(sugar, db)
+	clientStorer := nosql.NewClientStore(sugar, db)
+	webhookStorer := nosql.NewWebhookStore(sugar, db, readOpts...)
+	loginStorer := nosql.NewLoginStore(sugar, db)
+	departmentStorer := nosql.NewDepartmentStore(sugar, db, readOpts...)
+	tokenStorer := nosql.NewTokenStore(sugar, db)
+
+	// Send user lifecycle events to the registered webhooks
+	dispatcher := user.NewWebhookDispatcher(sugar, webhookStorer, user.DefaultDispatcherConfig)
+	defer dispatcher.Close()
+
+	// Relay the events the user store writes to its outbox
+	sinks, closeSinks := eventSinks(sugar, dispatcher)
+	defer closeSinks()
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	relay := nosql.NewOutboxRelay(sugar, db, sinks...)
+	relay.Cipher = cipher
+	go relay.Run(bgCtx, time.Second)
+
+	userOpts := append(userOptions(sugar), user.WithGroupClaims(groupStorer), user.WithAttributeSchemas(attributeStorer), user.WithLoginHistory(loginStorer, user.DefaultLoginHistory), user.WithDepartments(departmentStorer), user.WithTokens(tokenStorer, tokenConfig(sugar)))
+
+	// Encrypted users can't be searched
+	if cipher == nil {
+		userOpts = append(userOpts, user.WithSearch(userStorer))
+	} else {
+		sugar.Warnw("user search is turned off, encrypted users can't be searched", "kid", os.Getenv("BUD_ENCRYPTION_KID"))
+	}
+
+	// Sign users in with a corporate identity provider when one is configured
+	provider, fedOpts := federation(ctx, sugar, nosql.NewIdentityStore(sugar, db))
+	userOpts = append(userOpts, fedOpts...)
+
+	// Time the calls to ArangoDB, and trace them in the logs when asked to.
+	// The metrics are published at /debug/vars.
+	instrumentOpts := []instrument.Option{instrument.WithErrorKinds(map[error]string{
+		nosql.ErrNotFound:              "not_found",
+		nosql.ErrUniqueEmail:           "conflict",
+		nosql.ErrAuthenticationFailure: "authentication",
+	})}
+	if os.Getenv("BUD_TRACE") == "true" {
+		instrumentOpts = append(instrumentOpts, instrument.WithTracer(instrument.NewLogTracer(sugar)))
+	}
+	instrumented := instrument.NewStore(userStorer, instrumentOpts...)
+	expvar.Publish("user_store", expvar.Func(func() any { return instrumented.Stats() }))
+
+	// Retry transient failures, and fail fast while the database is down
+	resilient := retry.NewStore(sugar, instrumented, nosql.IsTransient, retry.DefaultConfig)
+
+	// Cache users by id and email when a TTL is configured. Other instances'
+	// changes are seen once cached users expire.
+	var storer interface {
+		user.Storer
+		user.DormantStorer
+		user.DeletedPurger
+	} = resilient
+	if raw := os.Getenv("BUD_CACHE_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil {
+			sugar.Fatalf("parsing BUD_CACHE_TTL: %v", err)
+		}
+		cached := cache.NewStore(resilient, cache.Config{Size: cache.DefaultConfig.Size, TTL: ttl})
+		expvar.Publish("user_cache", expvar.Func(func() any { return cached.Stats() }))
+		storer = cached
+	}
 
 	// Register UserServicer
-	gs := user.NewUserServicer(sugar, userStorer, *a)
+	us := user.NewUserServicer(sugar, storer, signer, userOpts...)
+	us.Register(s)
+
+	// Register GroupServicer
+	gs := user.NewGroupServicer(sugar, groupStorer)
 	gs.Register(s)
 
+	// Register OrgServicer
+	orgs := user.NewOrgServicer(sugar, orgStorer)
+	orgs.Register(s)
+
+	// Register DepartmentServicer
+	ds := user.NewDepartmentServicer(sugar, departmentStorer)
+	ds.Register(s)
+
+	// Register ManagerServicer
+	ms := user.NewManagerServicer(sugar, nosql.NewManagerStore(sugar, db, readOpts...))
+	ms.Register(s)
+
+	// Register AttributeServicer
+	as := user.NewAttributeServicer(sugar, attributeStorer)
+	as.Register(s)
+
+	// Register ClientServicer
+	cs := user.NewClientServicer(sugar, clientStorer, signer)
+	cs.Register(s)
+
+	// Register WebhookServicer
+	ws := user.NewWebhookServicer(sugar, webhookStorer, dispatcher)
+	ws.Register(s)
+
+	// Register JobServicer
+	jobStorer := nosql.NewJobStore(sugar, db)
+	js := user.NewJobServicer(sugar, jobStorer)
+	js.Register(s)
+
+	// Run the background jobs. Every replica runs the scheduler; the job
+	// locks make sure each run happens once.
+	scheduler := user.NewScheduler(sugar, jobStorer)
+	scheduleDormant(sugar, scheduler, storer)
+	schedulePurge(sugar, scheduler, storer)
+	scheduleTokenExpiry(sugar, scheduler, tokenStorer)
+	if cipher != nil {
+		err := scheduler.Add(user.Job{
+			Name: "reencrypt-users",
+			Spec: "*/15 * * * *",
+			Run: func(ctx context.Context, now time.Time) error {
+				n, err := userStorer.Reencrypt(ctx, 100)
+				if n > 0 {
+					sugar.Infow("users re-encrypted", "count", n, "key", cipher.ActiveKey())
+				}
+				return err
+			},
+		})
+		if err != nil {
+			sugar.Fatalf("scheduling job: %v", err)
+		}
+	}
+	go scheduler.Run(bgCtx)
+
 	// Listen
 	fmt.Println(`Listening on port 8080`)
-	fmt.Println(`test cmd: curl -X POST  --data '{"username": "user@example.com", "password": "gophers"}' http://localhost:8080/v1/UserService.Authenticate`)
+	fmt.Println(`test cmd: curl -X POST  --data '{"orgId": "<org id>", "username": "user@example.com", "password": "gophers"}' http://localhost:8080/v1/UserService.Authenticate`)
+	http.Handle("/v1/", s)
+
+	// Publish the OpenID Connect metadata so tokens can be verified without
+	// copying the keys
+	jwks, err := web.JWKS(ks, kids...)
+	if err != nil {
+		sugar.Fatalf("building jwks: %v", err)
+	}
+	http.Handle(web.DiscoveryPath, web.Discovery(issuer))
+	http.Handle(web.JWKSPath, jwks)
+	http.Handle(web.TokenPath, mid.MultipleMiddleware(web.Token(cs), mid.CommonMiddleware...))
+	http.Handle(web.AuthorizePath, mid.MultipleMiddleware(web.Authorize(cs, us), mid.CommonMiddleware...))
+
+	// Provision users and groups over SCIM with an admin bearer token
+	http.Handle(web.SCIMPath, mid.MultipleMiddleware(web.SCIM(us, gs), append(mid.CommonMiddleware, web.AuthMiddleware(signer), web.ImpersonationMiddleware(sugar))...))
+	if provider != nil {
+		http.Handle(web.FederatedLoginPath, provider.Login())
+		http.Handle(web.FederatedCallbackPath, mid.MultipleMiddleware(provider.Callback(us), web.ClientInfoMiddleware(false)))
+	}
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
+// migrate runs the migrate command: up applies pending migrations, down
+// reverts the latest one or the given number of them and status lists them.
+func migrate(db driver.Database, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if len(args) == 0 {
+		return errors.New("usage: bud migrate up|down [steps]|status")
+	}
+	switch args[0] {
+	case "up":
+		version, err := nosql.MigrateUp(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Schema is at version %d\n", version)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+		version, err := nosql.MigrateDown(ctx, db, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Schema is at version %d\n", version)
+	case "status":
+		status, err := nosql.Status(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, st := range status {
+			applied := "pending"
+			if !st.AppliedAt.IsZero() {
+				applied = "applied " + st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-24s %s\n", st.Version, st.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	return nil
+}
+
+// openSQL opens the SQL database named by BUD_SQL_DSN and brings its schema
+// up to date.
+func openSQL(sugar *zap.SugaredLogger, store string) *sqlstore.Store {
+	dialect, err := sqlstore.ParseDialect(store)
+	if err != nil {
+		sugar.Fatalf("parsing BUD_STORE: %v", err)
+	}
+	db, err := sqlstore.Open(dialect, os.Getenv("BUD_SQL_DSN"))
+	if err != nil {
+		sugar.Fatalf("Opening database connection: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	version, err := sqlstore.Migrate(ctx, db, dialect)
+	if err != nil {
+		sugar.Fatalf("Migrating database: %v", err)
+	}
+	sugar.Infow("database migrated", "dialect", dialect, "version", version)
+
+	return sqlstore.NewStore(sugar, db, dialect)
+}
+
+// serviceStores are the stores of the services other than UserService
+// when users aren't kept in ArangoDB.
+type serviceStores struct {
+	groups      user.GroupStorer
+	orgs        user.OrgStorer
+	departments user.DepartmentStorer
+	managers    user.ManagerStorer
+	attributes  user.AttributeStorer
+	clients     user.ClientStorer
+	identities  user.IdentityStorer
+	webhooks    user.WebhookStorer
+	logins      user.LoginStorer
+	jobs        user.JobStorer
+	tokens      user.TokenStorer
+}
+
+// memoryStores keeps the other services in memory, next to the in-memory
+// user store of users.
+func memoryStores(users *memory.Store) *serviceStores {
+	return &serviceStores{
+		groups:      memory.NewGroupStore(users),
+		orgs:        memory.NewOrgStore(users),
+		departments: memory.NewDepartmentStore(users),
+		managers:    memory.NewManagerStore(users),
+		attributes:  memory.NewAttributeStore(),
+		clients:     memory.NewClientStore(),
+		identities:  memory.NewIdentityStore(),
+		webhooks:    memory.NewWebhookStore(),
+		logins:      memory.NewLoginStore(),
+		jobs:        memory.NewJobStore(),
+		tokens:      memory.NewTokenStore(),
+	}
+}
+
+// serveUsers serves the user service from a store other than ArangoDB.
+// When stores is set it also serves groups, organizations, departments,
+// managers, attributes, SCIM, clients, webhooks, federation, login history
+// and jobs from them; otherwise those need ArangoDB and aren't available.
+func serveUsers(s *server.Server, sugar *zap.SugaredLogger, signer *user.Signer, ks *keystore.KeyStore, kids []string, storer user.Storer, stores *serviceStores, desc string) {
+	userOpts := userOptions(sugar)
+
+	var publishers []user.EventPublisher
+	var gs user.GroupRpcService
+	var orgs user.OrgRpcService
+	var cs user.ClientRpcService
+	var provider *web.Provider
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	if stores == nil {
+		sugar.Warnw("only UserService is served, groups, organizations, departments, managers, attributes, SCIM, clients, webhooks, federation, login history and jobs need ArangoDB", "store", desc)
+	} else {
+		// Send user lifecycle events to the registered webhooks. Without an
+		// outbox they are published after each change.
+		dispatcher := user.NewWebhookDispatcher(sugar, stores.webhooks, user.DefaultDispatcherConfig)
+		defer dispatcher.Close()
+		publishers = append(publishers, dispatcher)
+
+		userOpts = append(userOpts, user.WithGroupClaims(stores.groups), user.WithAttributeSchemas(stores.attributes), user.WithLoginHistory(stores.logins, user.DefaultLoginHistory), user.WithDepartments(stores.departments), user.WithTokens(stores.tokens, tokenConfig(sugar)))
+		var fedOpts []user.Option
+		provider, fedOpts = federation(bgCtx, sugar, stores.identities)
+		userOpts = append(userOpts, fedOpts...)
+
+		// Register GroupServicer
+		gs = user.NewGroupServicer(sugar, stores.groups)
+		gs.Register(s)
+
+		// Register OrgServicer
+		orgs = user.NewOrgServicer(sugar, stores.orgs)
+		orgs.Register(s)
+
+		// Register DepartmentServicer
+		ds := user.NewDepartmentServicer(sugar, stores.departments)
+		ds.Register(s)
+
+		// Register ManagerServicer
+		ms := user.NewManagerServicer(sugar, stores.managers)
+		ms.Register(s)
+
+		// Register AttributeServicer
+		as := user.NewAttributeServicer(sugar, stores.attributes)
+		as.Register(s)
+
+		// Register ClientServicer
+		cs = user.NewClientServicer(sugar, stores.clients, signer)
+		cs.Register(s)
+
+		// Register WebhookServicer
+		ws := user.NewWebhookServicer(sugar, stores.webhooks, dispatcher)
+		ws.Register(s)
+
+		// Register JobServicer and run the background jobs
+		js := user.NewJobServicer(sugar, stores.jobs)
+		js.Register(s)
+		scheduler := user.NewScheduler(sugar, stores.jobs)
+		if dormant, ok := storer.(user.DormantStorer); ok {
+			scheduleDormant(sugar, scheduler, dormant)
+		}
+		if purger, ok := storer.(user.DeletedPurger); ok {
+			schedulePurge(sugar, scheduler, purger)
+		}
+		scheduleTokenExpiry(sugar, scheduler, stores.tokens)
+		go scheduler.Run(bgCtx)
+	}
+
+	sinks, closeSinks := eventSinks(sugar, publishers...)
+	defer closeSinks()
+	if len(sinks) > 0 {
+		userOpts = append(userOpts, user.WithEvents(sinks))
+	}
+
+	// Register UserServicer
+	us := user.NewUserServicer(sugar, storer, signer, userOpts...)
+	us.Register(s)
+
+	// Create the first organization and admin, so there is someone to sign
+	// in as
+	if orgs != nil {
+		bootstrap(sugar, orgs, us)
+	}
+
+	fmt.Println("Listening on port 8080 with " + desc)
 	http.Handle("/v1/", s)
+
+	jwks, err := web.JWKS(ks, kids...)
+	if err != nil {
+		sugar.Fatalf("building jwks: %v", err)
+	}
+	http.Handle(web.DiscoveryPath, web.Discovery(issuer))
+	http.Handle(web.JWKSPath, jwks)
+	if cs != nil {
+		http.Handle(web.TokenPath, mid.MultipleMiddleware(web.Token(cs), mid.CommonMiddleware...))
+		http.Handle(web.AuthorizePath, mid.MultipleMiddleware(web.Authorize(cs, us), mid.CommonMiddleware...))
+	}
+	if gs != nil {
+		http.Handle(web.SCIMPath, mid.MultipleMiddleware(web.SCIM(us, gs), append(mid.CommonMiddleware, web.AuthMiddleware(signer), web.ImpersonationMiddleware(sugar))...))
+	}
+	if provider != nil {
+		http.Handle(web.FederatedLoginPath, provider.Login())
+		http.Handle(web.FederatedCallbackPath, mid.MultipleMiddleware(provider.Callback(us), web.ClientInfoMiddleware(false)))
+	}
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
+
+// bootstrap creates the organization named by BUD_BOOTSTRAP_ORG, "bud" by
+// default, with an admin signing in with BUD_BOOTSTRAP_ADMIN_EMAIL and
+// BUD_BOOTSTRAP_ADMIN_PASSWORD. Stores that start out empty have no one to
+// sign in as otherwise. The admin is a super admin too, so they can create
+// the other organizations. Nothing is created when the email isn't set.
+func bootstrap(sugar *zap.SugaredLogger, orgs user.OrgRpcService, us user.UserRpcService) {
+	raw := os.Getenv("BUD_BOOTSTRAP_ADMIN_EMAIL")
+	if raw == "" {
+		return
+	}
+	email, err := mail.ParseAddress(raw)
+	if err != nil {
+		sugar.Fatalf("parsing BUD_BOOTSTRAP_ADMIN_EMAIL: %v", err)
+	}
+	password := os.Getenv("BUD_BOOTSTRAP_ADMIN_PASSWORD")
+	if password == "" {
+		sugar.Fatal("bootstrap: BUD_BOOTSTRAP_ADMIN_PASSWORD is not set")
+	}
+	name := os.Getenv("BUD_BOOTSTRAP_ORG")
+	if name == "" {
+		name = "bud"
+	}
+
+	gr := server.GenericRequest{
+		Ctx:    context.Background(),
+		Claims: auth.Claims{Roles: []string{user.RoleAdmin.Name(), user.RoleSuperAdmin.Name()}},
+		Values: &values.Values{Now: time.Now()},
+	}
+	org := orgs.CreateOrg(user.CreateOrgRequest{NewOrganization: user.NewOrganization{Name: name}}, gr)
+	if org.Error != "" {
+		sugar.Fatalf("bootstrap: creating organization: %s", org.Error)
+	}
+
+	gr.Ctx = user.SetClaims(gr.Ctx, user.Claims{OrgID: org.Organization.ID.String()})
+	nu := user.CreateUserRequest{}
+	nu.NewUser.Name = email.Name
+	if nu.NewUser.Name == "" {
+		nu.NewUser.Name = "Admin"
+	}
+	nu.NewUser.Email = *email
+	nu.NewUser.Roles = []user.Role{user.RoleAdmin, user.RoleSuperAdmin}
+	nu.NewUser.Password = password
+	nu.NewUser.PasswordConfirm = password
+	usr := us.CreateUser(nu, gr)
+	if usr.Error != "" {
+		sugar.Fatalf("bootstrap: creating admin: %s", usr.Error)
+	}
+	sugar.Infow("organization bootstrapped", "name", name, "id", org.Organization.ID, "admin", email.Address)
+}
+
+// tokenConfig reads how long password reset tokens and invitations are
+// valid from BUD_PASSWORD_RESET_TTL and BUD_INVITATION_TTL, falling back
+// to user.DefaultTokenConfig.
+func tokenConfig(sugar *zap.SugaredLogger) user.TokenConfig {
+	cfg := user.DefaultTokenConfig
+	for env, ttl := range map[string]*time.Duration{
+		"BUD_PASSWORD_RESET_TTL": &cfg.PasswordResetTTL,
+		"BUD_INVITATION_TTL":     &cfg.InvitationTTL,
+	} {
+		raw := os.Getenv(env)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			sugar.Fatalf("parsing %s: %v", env, err)
+		}
+		*ttl = d
+	}
+	return cfg
+}
+
+// userOptions configures UserServicer from the environment the same way
+// for every store.
+func userOptions(sugar *zap.SugaredLogger) []user.Option {
+	var userOpts []user.Option
+
+	// Expire passwords when a policy is configured
+	if raw := os.Getenv("BUD_PASSWORD_POLICY"); raw != "" {
+		policy, err := user.ParsePasswordPolicy([]byte(raw))
+		if err != nil {
+			sugar.Fatalf("parsing password policy: %v", err)
+		}
+		userOpts = append(userOpts, user.WithPasswordPolicy(policy))
+	}
+	return userOpts
+}
+
+// eventSinks adds the sinks configured in the environment to publishers.
+// The returned function closes them.
+func eventSinks(sugar *zap.SugaredLogger, publishers ...user.EventPublisher) (user.Sinks, func()) {
+	sinks := user.Sinks(publishers)
+	if os.Getenv("BUD_EVENTS_STDOUT") == "true" {
+		sinks = append(sinks, user.NewWriterSink(os.Stdout))
+	}
+	if name := os.Getenv("BUD_EVENTS_FILE"); name != "" {
+		fileSink, err := user.OpenFileSink(name)
+		if err != nil {
+			sugar.Fatalf("opening event file: %v", err)
+		}
+		sinks = append(sinks, fileSink)
+		return sinks, func() { fileSink.Close() }
+	}
+	return sinks, func() {}
+}
+
+// federation signs users in with a corporate identity provider when one is
+// configured, linking its subjects to users in identities.
+func federation(ctx context.Context, sugar *zap.SugaredLogger, identities user.IdentityStorer) (*web.Provider, []user.Option) {
+	idp := os.Getenv("BUD_OIDC_ISSUER")
+	if idp == "" {
+		return nil, nil
+	}
+
+	provider, err := web.NewProvider(ctx, web.ProviderConfig{
+		Issuer:       idp,
+		ClientID:     os.Getenv("BUD_OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("BUD_OIDC_CLIENT_SECRET"),
+		RedirectURL:  issuer + web.FederatedCallbackPath,
+	})
+	if err != nil {
+		sugar.Fatalf("discovering identity provider: %v", err)
+	}
+	return provider, []user.Option{user.WithFederation(user.Federation{
+		Identities: identities,
+		OrgID:      os.Getenv("BUD_OIDC_ORG_ID"),
+		Provision:  os.Getenv("BUD_OIDC_PROVISION") == "true",
+		Roles:      []user.Role{user.RoleUser},
+	})}
+}
+
+// scheduleDormant schedules the job that disables users who haven't signed
+// in for BUD_DORMANT_DAYS days, 90 by default. Zero turns it off.
+func scheduleDormant(sugar *zap.SugaredLogger, scheduler *user.Scheduler, storer user.DormantStorer) {
+	dormantDays := 90
+	if days, err := strconv.Atoi(os.Getenv("BUD_DORMANT_DAYS")); err == nil {
+		dormantDays = days
+	}
+	if dormantDays <= 0 {
+		return
+	}
+	err := scheduler.Add(user.Job{
+		Name: "disable-dormant-users",
+		Spec: "0 3 * * *",
+		Run:  user.DisableDormantUsers(sugar, storer, time.Duration(dormantDays)*24*time.Hour),
+	})
+	if err != nil {
+		sugar.Fatalf("scheduling job: %v", err)
+	}
+}
+
+// scheduleTokenExpiry schedules the job that removes expired password reset
+// tokens and invitations every hour.
+func scheduleTokenExpiry(sugar *zap.SugaredLogger, scheduler *user.Scheduler, tokens user.TokenStorer) {
+	err := scheduler.Add(user.Job{
+		Name: "expire-tokens",
+		Spec: "@hourly",
+		Run:  user.ExpireTokens(sugar, tokens),
+	})
+	if err != nil {
+		sugar.Fatalf("scheduling job: %v", err)
+	}
+}
+
+// schedulePurge schedules the job that removes users deleted more than
+// BUD_DELETED_RETENTION_DAYS days ago, 30 by default. Zero turns it off and
+// keeps deleted users.
+func schedulePurge(sugar *zap.SugaredLogger, scheduler *user.Scheduler, storer user.DeletedPurger) {
+	retentionDays := 30
+	if days, err := strconv.Atoi(os.Getenv("BUD_DELETED_RETENTION_DAYS")); err == nil {
+		retentionDays = days
+	}
+	if retentionDays <= 0 {
+		return
+	}
+	err := scheduler.Add(user.Job{
+		Name: "purge-deleted-users",
+		Spec: "30 3 * * *",
+		Run:  user.PurgeDeletedUsers(sugar, storer, time.Duration(retentionDays)*24*time.Hour),
+	})
+	if err != nil {
+		sugar.Fatalf("scheduling job: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"go.uber.org/zap"
+)
+
+// AttributeService is an API for defining the custom attributes users carry.
+type AttributeService interface {
+	// SetAttributeSchema sets the JSON Schema custom attributes must satisfy
+	SetAttributeSchema(SetAttributeSchemaRequest, server.GenericRequest) SetAttributeSchemaResponse
+	// QueryAttributeSchema gets the JSON Schema that applies to the caller's organization
+	QueryAttributeSchema(QueryAttributeSchemaRequest, server.GenericRequest) QueryAttributeSchemaResponse
+}
+
+// AttributeStorer interface declares the behavior this package needs to
+// persist and retrieve attribute schemas.
+type AttributeStorer interface {
+	Save(ctx context.Context, schema AttributeSchema) (AttributeSchema, error)
+	// Query returns the organization's schema, or the deployment schema when
+	// the organization has none. A zero AttributeSchema is returned when
+	// neither exists.
+	Query(ctx context.Context, orgID string) (AttributeSchema, error)
+}
+
+// Required to register endpoints with the Server
+type AttributeRpcService interface {
+	AttributeService
+	// Registers RPCService with Server
+	Register(s *server.Server)
+}
+
+// Implements interface
+type AttributeServicer struct {
+	log    *zap.SugaredLogger
+	storer AttributeStorer
+}
+
+// SetAttributeSchema implements AttributeRpcService
+func (a AttributeServicer) SetAttributeSchema(req SetAttributeSchemaRequest, gr server.GenericRequest) SetAttributeSchemaResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return SetAttributeSchemaResponse{Error: err.Error()}
+	}
+
+	// Only a super admin may set the schema shared by the whole deployment.
+	if req.Deployment {
+		if !gr.Claims.Authorized(RoleSuperAdmin.Name()) {
+			return SetAttributeSchemaResponse{Error: auth.ErrForbidden.Error()}
+		}
+		orgID = ""
+	}
+
+	if _, err := compileSchema(req.Schema); err != nil {
+		return SetAttributeSchemaResponse{Error: err.Error()}
+	}
+
+	schema := AttributeSchema{
+		OrgID:       orgID,
+		Schema:      req.Schema,
+		DateUpdated: gr.Values.Now,
+	}
+	result, err := a.storer.Save(gr.Ctx, schema)
+	if err != nil {
+		return SetAttributeSchemaResponse{Error: err.Error()}
+	}
+	return SetAttributeSchemaResponse{AttributeSchema: result}
+}
+
+// QueryAttributeSchema implements AttributeRpcService
+func (a AttributeServicer) QueryAttributeSchema(req QueryAttributeSchemaRequest, gr server.GenericRequest) QueryAttributeSchemaResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryAttributeSchemaResponse{Error: err.Error()}
+	}
+	schema, err := a.storer.Query(gr.Ctx, orgID)
+	if err != nil {
+		return QueryAttributeSchemaResponse{Error: err.Error()}
+	}
+	return QueryAttributeSchemaResponse{AttributeSchema: schema}
+}
+
+// Register implements AttributeRpcService
+func (a AttributeServicer) Register(s *server.Server) {
+	s.Register("AttributeService", "SetAttributeSchema", server.RPCEndpoint{Roles: []string{auth.RoleAdmin, RoleSuperAdmin.Name()}, Handler: a.SetAttributeSchemaHandler})
+	s.Register("AttributeService", "QueryAttributeSchema", server.RPCEndpoint{Roles: []string{auth.RoleAdmin, auth.RoleUser}, Handler: a.QueryAttributeSchemaHandler})
+}
+
+// Create new AttributeServicer
+func NewAttributeServicer(log *zap.SugaredLogger, storer AttributeStorer) AttributeRpcService {
+	return AttributeServicer{
+		log:    log,
+		storer: storer,
+	}
+}
+
+// validateAttributes checks attrs against the schema that applies to the
+// organization. Attributes are unrestricted when no schema is defined.
+func validateAttributes(ctx context.Context, schemas AttributeStorer, orgID string, attrs map[string]any) error {
+	schema, err := schemas.Query(ctx, orgID)
+	if err != nil {
+		return fmt.Errorf("query attribute schema: %w", err)
+	}
+	if len(schema.Schema) == 0 {
+		return nil
+	}
+
+	sch, err := compileSchema(schema.Schema)
+	if err != nil {
+		return err
+	}
+
+	// The validator only accepts raw JSON values, so normalize the Go values
+	// by sending them through the encoder.
+	if attrs == nil {
+		attrs = map[string]any{}
+	}
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("encoding attributes: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("decoding attributes: %w", err)
+	}
+
+	if err := sch.Validate(v); err != nil {
+		return fmt.Errorf("validating attributes: %w", err)
+	}
+	return nil
+}
+
+// compileSchema compiles a JSON Schema document.
+func compileSchema(raw json.RawMessage) (*jsonschema.Schema, error) {
+	sch, err := jsonschema.CompileString("attributes.json", string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("compiling attribute schema: %w", err)
+	}
+	return sch, nil
+}
+
+// mergeAttributes applies an attribute update to the current attributes the
+// same way the store does: keys are replaced and nil values remove the key.
+func mergeAttributes(current map[string]any, upd map[string]any) map[string]any {
+	merged := make(map[string]any, len(current)+len(upd))
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range upd {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// SetAttributeSchemaRequest is the request object for AttributeService.SetAttributeSchema.
+type SetAttributeSchemaRequest struct {
+	Schema json.RawMessage `json:"schema" validate:"required"`
+	// Deployment sets the schema used by organizations without their own.
+	Deployment bool `json:"deployment"`
+}
+
+// SetAttributeSchemaResponse is the response object for AttributeService.SetAttributeSchema.
+type SetAttributeSchemaResponse struct {
+	AttributeSchema AttributeSchema `json:"attributeSchema"`
+	Error           string          `json:"error,omitempty"`
+}
+
+// QueryAttributeSchemaRequest is the request object for AttributeService.QueryAttributeSchema.
+type QueryAttributeSchemaRequest struct{}
+
+// QueryAttributeSchemaResponse is the response object for AttributeService.QueryAttributeSchema.
+type QueryAttributeSchemaResponse struct {
+	AttributeSchema AttributeSchema `json:"attributeSchema"`
+	Error           string          `json:"error,omitempty"`
+}
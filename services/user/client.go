@@ -0,0 +1,343 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned by ClientServicer.ClientCredentials and AuthorizeClient.
+// They map onto the error codes of the OAuth2 endpoints.
+var (
+	ErrInvalidClient      = errors.New("invalid client")
+	ErrInvalidScope       = errors.New("invalid scope")
+	ErrInvalidRedirectURI = errors.New("redirect uri is not registered for the client")
+)
+
+// clientTokenTTL is how long a client credentials token is valid.
+const clientTokenTTL = time.Hour
+
+// ClientService is an API for managing the OAuth2 clients used by services.
+type ClientService interface {
+	// CreateClient registers a client and returns its secret
+	CreateClient(CreateClientRequest, server.GenericRequest) CreateClientResponse
+	// RotateClientSecret replaces the secret of a client
+	RotateClientSecret(RotateClientSecretRequest, server.GenericRequest) RotateClientSecretResponse
+	// RevokeClient stops a client from being issued tokens
+	RevokeClient(RevokeClientRequest, server.GenericRequest) RevokeClientResponse
+	// QueryClients lists the clients of the caller's organization
+	QueryClients(QueryClientsRequest, server.GenericRequest) QueryClientsResponse
+}
+
+// ClientStorer interface declares the behavior this package needs to persist
+// and retrieve clients.
+type ClientStorer interface {
+	Create(ctx context.Context, clt Client) (Client, error)
+	// QueryByID is not tenant-scoped since a client authenticates before
+	// its organization is known.
+	QueryByID(ctx context.Context, id string) (Client, error)
+	Query(ctx context.Context, orgID string) ([]Client, error)
+	UpdateSecret(ctx context.Context, orgID string, id string, hash []byte, now time.Time) (Client, error)
+	Revoke(ctx context.Context, orgID string, id string, now time.Time) (Client, error)
+}
+
+// Required to register endpoints with the Server
+type ClientRpcService interface {
+	ClientService
+	// ClientCredentials issues a token for the client_credentials grant
+	ClientCredentials(ctx context.Context, clientID string, secret string, scope []string) (ClientToken, error)
+	// AuthorizeClient returns the client an authorization request is made
+	// for, once the redirect URI is known to be registered for it
+	AuthorizeClient(ctx context.Context, clientID string, redirectURI string) (Client, error)
+	// Registers RPCService with Server
+	Register(s *server.Server)
+}
+
+// ClientToken is a token issued to a client.
+type ClientToken struct {
+	Token     string
+	ExpiresIn time.Duration
+	Roles     []string
+}
+
+// Implements interface
+type ClientServicer struct {
+	log    *zap.SugaredLogger
+	storer ClientStorer
+	signer *Signer
+}
+
+// CreateClient implements ClientRpcService
+func (c ClientServicer) CreateClient(req CreateClientRequest, gr server.GenericRequest) CreateClientResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return CreateClientResponse{Error: err.Error()}
+	}
+
+	// Credentials are only issued to admins acting as themselves.
+	if _, ok := impersonator(gr); ok {
+		return CreateClientResponse{Error: ErrImpersonated.Error()}
+	}
+
+	oid, err := uuid.Parse(orgID)
+	if err != nil {
+		return CreateClientResponse{Error: fmt.Errorf("parsing org id: %w", err).Error()}
+	}
+
+	if err := authorizeRoles(req.NewClient.Roles, gr); err != nil {
+		return CreateClientResponse{Error: err.Error()}
+	}
+	for _, uri := range req.NewClient.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return CreateClientResponse{Error: err.Error()}
+		}
+	}
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return CreateClientResponse{Error: err.Error()}
+	}
+
+	clt := Client{
+		ID:           uuid.New(),
+		OrgID:        oid,
+		Name:         req.NewClient.Name,
+		Roles:        req.NewClient.Roles,
+		RedirectURIs: req.NewClient.RedirectURIs,
+		SecretHash:   hash,
+		DateCreated:  gr.Values.Now,
+		DateUpdated:  gr.Values.Now,
+	}
+	result, err := c.storer.Create(gr.Ctx, clt)
+	if err != nil {
+		return CreateClientResponse{Error: err.Error()}
+	}
+	return CreateClientResponse{Client: result, Secret: secret}
+}
+
+// RotateClientSecret implements ClientRpcService
+func (c ClientServicer) RotateClientSecret(req RotateClientSecretRequest, gr server.GenericRequest) RotateClientSecretResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return RotateClientSecretResponse{Error: err.Error()}
+	}
+
+	// Credentials are only issued to admins acting as themselves.
+	if _, ok := impersonator(gr); ok {
+		return RotateClientSecretResponse{Error: ErrImpersonated.Error()}
+	}
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return RotateClientSecretResponse{Error: err.Error()}
+	}
+
+	clt, err := c.storer.UpdateSecret(gr.Ctx, orgID, req.ID, hash, gr.Values.Now)
+	if err != nil {
+		return RotateClientSecretResponse{Error: err.Error()}
+	}
+	return RotateClientSecretResponse{Client: clt, Secret: secret}
+}
+
+// RevokeClient implements ClientRpcService
+func (c ClientServicer) RevokeClient(req RevokeClientRequest, gr server.GenericRequest) RevokeClientResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return RevokeClientResponse{Error: err.Error()}
+	}
+	clt, err := c.storer.Revoke(gr.Ctx, orgID, req.ID, gr.Values.Now)
+	if err != nil {
+		return RevokeClientResponse{Error: err.Error()}
+	}
+	return RevokeClientResponse{Client: clt}
+}
+
+// QueryClients implements ClientRpcService
+func (c ClientServicer) QueryClients(req QueryClientsRequest, gr server.GenericRequest) QueryClientsResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryClientsResponse{Error: err.Error()}
+	}
+	clts, err := c.storer.Query(gr.Ctx, orgID)
+	if err != nil {
+		return QueryClientsResponse{Error: err.Error()}
+	}
+	return QueryClientsResponse{Clients: clts}
+}
+
+// ClientCredentials implements ClientRpcService. The token carries the
+// requested scope as its roles, or every role of the client when no scope
+// is requested. Like the tokens issued to users, it is addressed to the API.
+func (c ClientServicer) ClientCredentials(ctx context.Context, clientID string, secret string, scope []string) (ClientToken, error) {
+	clt, err := c.storer.QueryByID(ctx, clientID)
+	if err != nil {
+		c.log.Infow("client credentials", "client_id", clientID, "error", err)
+		return ClientToken{}, ErrInvalidClient
+	}
+	if clt.Revoked {
+		return ClientToken{}, ErrInvalidClient
+	}
+	if err := bcrypt.CompareHashAndPassword(clt.SecretHash, []byte(secret)); err != nil {
+		return ClientToken{}, ErrInvalidClient
+	}
+
+	allowed := make(map[string]bool, len(clt.Roles))
+	roles := make([]string, 0, len(clt.Roles))
+	for _, r := range clt.Roles {
+		allowed[r.Name()] = true
+		roles = append(roles, r.Name())
+	}
+	if len(scope) > 0 {
+		for _, s := range scope {
+			if !allowed[s] {
+				return ClientToken{}, ErrInvalidScope
+			}
+		}
+		roles = scope
+	}
+
+	claims := Claims{
+		Claims: auth.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   clt.ID.String(),
+				Issuer:    c.signer.issuer,
+				Audience:  jwt.ClaimStrings{c.signer.audience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(clientTokenTTL)),
+				IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			},
+			Roles: roles,
+		},
+		ClientID: clt.ID.String(),
+		Name:     clt.Name,
+		OrgID:    clt.OrgID.String(),
+	}
+
+	tkn, err := c.signer.GenerateToken(claims)
+	if err != nil {
+		return ClientToken{}, fmt.Errorf("generatetoken: %w", err)
+	}
+	return ClientToken{Token: tkn, ExpiresIn: clientTokenTTL, Roles: roles}, nil
+}
+
+// AuthorizeClient implements ClientRpcService. The redirect URI must be one
+// registered for the client, compared as a string, so tokens are never
+// sent anywhere else.
+func (c ClientServicer) AuthorizeClient(ctx context.Context, clientID string, redirectURI string) (Client, error) {
+	clt, err := c.storer.QueryByID(ctx, clientID)
+	if err != nil {
+		c.log.Infow("authorize client", "client_id", clientID, "error", err)
+		return Client{}, ErrInvalidClient
+	}
+	if clt.Revoked {
+		return Client{}, ErrInvalidClient
+	}
+	for _, uri := range clt.RedirectURIs {
+		if uri == redirectURI {
+			return clt, nil
+		}
+	}
+	return Client{}, ErrInvalidRedirectURI
+}
+
+// Register implements ClientRpcService
+func (c ClientServicer) Register(s *server.Server) {
+	s.Register("ClientService", "CreateClient", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: c.CreateClientHandler})
+	s.Register("ClientService", "RotateClientSecret", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: c.RotateClientSecretHandler})
+	s.Register("ClientService", "RevokeClient", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: c.RevokeClientHandler})
+	s.Register("ClientService", "QueryClients", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: c.QueryClientsHandler})
+}
+
+// Create new ClientServicer. Tokens are signed by signer.
+func NewClientServicer(log *zap.SugaredLogger, storer ClientStorer, signer *Signer) ClientRpcService {
+	return ClientServicer{
+		log:    log,
+		storer: storer,
+		signer: signer,
+	}
+}
+
+// validateRedirectURI checks that uri is an absolute http or https URL
+// without a fragment, which the authorization endpoint appends its response
+// to.
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("redirect uri %q must be an absolute http or https url", uri)
+	}
+	if u.Fragment != "" || strings.Contains(uri, "#") {
+		return fmt.Errorf("redirect uri %q must not have a fragment", uri)
+	}
+	return nil
+}
+
+// newClientSecret generates a random client secret and its hash. Only the
+// hash is stored, so the secret is shown once when it is generated.
+func newClientSecret() (string, []byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("generating secret: %w", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", nil, fmt.Errorf("generatefrompassword: %w", err)
+	}
+	return secret, hash, nil
+}
+
+// CreateClientRequest is the request object for ClientService.CreateClient.
+type CreateClientRequest struct {
+	NewClient NewClient `json:"newClient"`
+}
+
+// CreateClientResponse is the response object for ClientService.CreateClient.
+type CreateClientResponse struct {
+	Client Client `json:"client"`
+	Secret string `json:"secret"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RotateClientSecretRequest is the request object for ClientService.RotateClientSecret.
+type RotateClientSecretRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// RotateClientSecretResponse is the response object for ClientService.RotateClientSecret.
+type RotateClientSecretResponse struct {
+	Client Client `json:"client"`
+	Secret string `json:"secret"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RevokeClientRequest is the request object for ClientService.RevokeClient.
+type RevokeClientRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// RevokeClientResponse is the response object for ClientService.RevokeClient.
+type RevokeClientResponse struct {
+	Client Client `json:"client"`
+	Error  string `json:"error,omitempty"`
+}
+
+// QueryClientsRequest is the request object for ClientService.QueryClients.
+type QueryClientsRequest struct{}
+
+// QueryClientsResponse is the response object for ClientService.QueryClients.
+type QueryClientsResponse struct {
+	Clients []Client `json:"clients"`
+	Error   string   `json:"error,omitempty"`
+}
@@ -0,0 +1,55 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gitamped/stem/data/nosql/dbtest"
+)
+
+func Test_Schedule(t *testing.T) {
+	// 2018-10-01 was a Monday.
+	from := time.Date(2018, time.October, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2018, time.October, 1, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2018, time.October, 1, 10, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2018, time.October, 2, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2018, time.October, 2, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2018, time.October, 1, 11, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2018, time.October, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2018, time.October, 7, 0, 0, 0, 0, time.UTC)},
+		{"30 10 1,15 * *", time.Date(2018, time.October, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2018, time.October, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	t.Log("Given the need to schedule jobs with cron specs.")
+	{
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen scheduling %q.", testID, tt.spec)
+			{
+				s, err := ParseSchedule(tt.spec)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to parse the spec : %s.", dbtest.Failed, testID, err)
+				}
+				if got := s.Next(from); !got.Equal(tt.want) {
+					t.Fatalf("\t%s\tTest %d:\tShould run next at %s : got %s.", dbtest.Failed, testID, tt.want, got)
+				}
+				t.Logf("\t%s\tTest %d:\tShould run next at %s.", dbtest.Success, testID, tt.want)
+			}
+		}
+
+		for _, bad := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+			if _, err := ParseSchedule(bad); err == nil {
+				t.Fatalf("\t%s\tShould reject invalid spec %q.", dbtest.Failed, bad)
+			}
+		}
+		t.Logf("\t%s\tShould reject invalid specs.", dbtest.Success)
+	}
+}
@@ -0,0 +1,298 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrDepartmentCycle is returned when a department would be moved
+	// under itself or one of its descendants.
+	ErrDepartmentCycle = errors.New("department cannot be moved under itself")
+	// ErrDepartmentNotEmpty is returned when deleting a department that
+	// still has sub-departments or users.
+	ErrDepartmentNotEmpty = errors.New("department has sub-departments or users")
+)
+
+// DepartmentService is an API for managing the department tree of an
+// organization.
+type DepartmentService interface {
+	// CreateDepartment creates a department
+	CreateDepartment(CreateDepartmentRequest, server.GenericRequest) CreateDepartmentResponse
+	// UpdateDepartment updates a department
+	UpdateDepartment(UpdateDepartmentRequest, server.GenericRequest) UpdateDepartmentResponse
+	// DeleteDepartment deletes an empty department
+	DeleteDepartment(DeleteDepartmentRequest, server.GenericRequest) DeleteDepartmentResponse
+	// MoveDepartment moves a department, with its sub-departments, under
+	// another parent
+	MoveDepartment(MoveDepartmentRequest, server.GenericRequest) MoveDepartmentResponse
+	// QueryDepartmentByID gets the specified department by id
+	QueryDepartmentByID(QueryDepartmentByIDRequest, server.GenericRequest) QueryDepartmentByIDResponse
+	// QueryDepartments lists the departments of the caller's organization
+	QueryDepartments(QueryDepartmentsRequest, server.GenericRequest) QueryDepartmentsResponse
+	// QueryDepartmentMembers lists the users of a department and, optionally,
+	// of all of its descendants
+	QueryDepartmentMembers(QueryDepartmentMembersRequest, server.GenericRequest) QueryDepartmentMembersResponse
+}
+
+// DepartmentStorer interface declares the behavior this package needs to
+// persist and retrieve departments.
+type DepartmentStorer interface {
+	Create(ctx context.Context, dept Department) (Department, error)
+	Update(ctx context.Context, orgID string, id string, ud UpdateDepartment, now time.Time) (Department, error)
+	// Delete fails with ErrDepartmentNotEmpty when the department has
+	// sub-departments or users.
+	Delete(ctx context.Context, orgID string, id string) (Department, error)
+	// Move makes parentID the parent of the department, or makes it a top
+	// level department when parentID is empty. It fails with
+	// ErrDepartmentCycle when parentID is the department or one of its
+	// descendants.
+	Move(ctx context.Context, orgID string, id string, parentID string, now time.Time) (Department, error)
+	QueryByID(ctx context.Context, orgID string, id string) (Department, error)
+	Query(ctx context.Context, orgID string) ([]Department, error)
+	QueryMembers(ctx context.Context, orgID string, id string, descendants bool) ([]User, error)
+}
+
+// WithDepartments makes users reference departments by ID. Creating or
+// updating a user with a department that doesn't exist fails.
+func WithDepartments(departments DepartmentStorer) Option {
+	return func(u *UserServicer) {
+		u.departments = departments
+	}
+}
+
+// validateDepartment checks that id is a department of the organization.
+func (u UserServicer) validateDepartment(ctx context.Context, orgID string, id string) error {
+	if u.departments == nil || id == "" {
+		return nil
+	}
+	if _, err := u.departments.QueryByID(ctx, orgID, id); err != nil {
+		return fmt.Errorf("department %s: %w", id, err)
+	}
+	return nil
+}
+
+// Required to register endpoints with the Server
+type DepartmentRpcService interface {
+	DepartmentService
+	// Registers RPCService with Server
+	Register(s *server.Server)
+}
+
+// Implements interface
+type DepartmentServicer struct {
+	log    *zap.SugaredLogger
+	storer DepartmentStorer
+}
+
+// CreateDepartment implements DepartmentRpcService
+func (d DepartmentServicer) CreateDepartment(req CreateDepartmentRequest, gr server.GenericRequest) CreateDepartmentResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return CreateDepartmentResponse{Error: err.Error()}
+	}
+	oid, err := uuid.Parse(orgID)
+	if err != nil {
+		return CreateDepartmentResponse{Error: fmt.Errorf("parsing org id: %w", err).Error()}
+	}
+	dept := Department{
+		ID:          uuid.New(),
+		OrgID:       oid,
+		ParentID:    req.NewDepartment.ParentID,
+		Name:        req.NewDepartment.Name,
+		DateCreated: gr.Values.Now,
+		DateUpdated: gr.Values.Now,
+	}
+	result, err := d.storer.Create(gr.Ctx, dept)
+	if err != nil {
+		return CreateDepartmentResponse{Error: err.Error()}
+	}
+	return CreateDepartmentResponse{Department: result}
+}
+
+// UpdateDepartment implements DepartmentRpcService
+func (d DepartmentServicer) UpdateDepartment(req UpdateDepartmentRequest, gr server.GenericRequest) UpdateDepartmentResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return UpdateDepartmentResponse{Error: err.Error()}
+	}
+	dept, err := d.storer.Update(gr.Ctx, orgID, req.ID, req.UpdateDepartment, gr.Values.Now)
+	if err != nil {
+		return UpdateDepartmentResponse{Error: err.Error()}
+	}
+	return UpdateDepartmentResponse{Department: dept}
+}
+
+// DeleteDepartment implements DepartmentRpcService
+func (d DepartmentServicer) DeleteDepartment(req DeleteDepartmentRequest, gr server.GenericRequest) DeleteDepartmentResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return DeleteDepartmentResponse{Error: err.Error()}
+	}
+	dept, err := d.storer.Delete(gr.Ctx, orgID, req.ID)
+	if err != nil {
+		return DeleteDepartmentResponse{Error: err.Error()}
+	}
+	return DeleteDepartmentResponse{Department: dept}
+}
+
+// MoveDepartment implements DepartmentRpcService
+func (d DepartmentServicer) MoveDepartment(req MoveDepartmentRequest, gr server.GenericRequest) MoveDepartmentResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return MoveDepartmentResponse{Error: err.Error()}
+	}
+	if req.ParentID == req.ID {
+		return MoveDepartmentResponse{Error: ErrDepartmentCycle.Error()}
+	}
+	dept, err := d.storer.Move(gr.Ctx, orgID, req.ID, req.ParentID, gr.Values.Now)
+	if err != nil {
+		return MoveDepartmentResponse{Error: err.Error()}
+	}
+	return MoveDepartmentResponse{Department: dept}
+}
+
+// QueryDepartmentByID implements DepartmentRpcService
+func (d DepartmentServicer) QueryDepartmentByID(req QueryDepartmentByIDRequest, gr server.GenericRequest) QueryDepartmentByIDResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryDepartmentByIDResponse{Error: err.Error()}
+	}
+	dept, err := d.storer.QueryByID(gr.Ctx, orgID, req.ID)
+	if err != nil {
+		return QueryDepartmentByIDResponse{Error: err.Error()}
+	}
+	return QueryDepartmentByIDResponse{Department: dept}
+}
+
+// QueryDepartments implements DepartmentRpcService
+func (d DepartmentServicer) QueryDepartments(req QueryDepartmentsRequest, gr server.GenericRequest) QueryDepartmentsResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryDepartmentsResponse{Error: err.Error()}
+	}
+	depts, err := d.storer.Query(gr.Ctx, orgID)
+	if err != nil {
+		return QueryDepartmentsResponse{Error: err.Error()}
+	}
+	return QueryDepartmentsResponse{Departments: depts}
+}
+
+// QueryDepartmentMembers implements DepartmentRpcService
+func (d DepartmentServicer) QueryDepartmentMembers(req QueryDepartmentMembersRequest, gr server.GenericRequest) QueryDepartmentMembersResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryDepartmentMembersResponse{Error: err.Error()}
+	}
+	usrs, err := d.storer.QueryMembers(gr.Ctx, orgID, req.ID, req.IncludeDescendants)
+	if err != nil {
+		return QueryDepartmentMembersResponse{Error: err.Error()}
+	}
+	return QueryDepartmentMembersResponse{Users: usrs}
+}
+
+// Register implements DepartmentRpcService
+func (d DepartmentServicer) Register(s *server.Server) {
+	s.Register("DepartmentService", "CreateDepartment", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: d.CreateDepartmentHandler})
+	s.Register("DepartmentService", "UpdateDepartment", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: d.UpdateDepartmentHandler})
+	s.Register("DepartmentService", "DeleteDepartment", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: d.DeleteDepartmentHandler})
+	s.Register("DepartmentService", "MoveDepartment", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: d.MoveDepartmentHandler})
+	s.Register("DepartmentService", "QueryDepartmentByID", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: d.QueryDepartmentByIDHandler})
+	s.Register("DepartmentService", "QueryDepartments", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: d.QueryDepartmentsHandler})
+	s.Register("DepartmentService", "QueryDepartmentMembers", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: d.QueryDepartmentMembersHandler})
+}
+
+// Create new DepartmentServicer
+func NewDepartmentServicer(log *zap.SugaredLogger, storer DepartmentStorer) DepartmentRpcService {
+	return DepartmentServicer{
+		log:    log,
+		storer: storer,
+	}
+}
+
+// CreateDepartmentRequest is the request object for DepartmentService.CreateDepartment.
+type CreateDepartmentRequest struct {
+	NewDepartment NewDepartment `json:"newDepartment"`
+}
+
+// CreateDepartmentResponse is the response object for DepartmentService.CreateDepartment.
+type CreateDepartmentResponse struct {
+	Department Department `json:"department"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// UpdateDepartmentRequest is the request object for DepartmentService.UpdateDepartment.
+type UpdateDepartmentRequest struct {
+	ID               string           `json:"id" validate:"required"`
+	UpdateDepartment UpdateDepartment `json:"department"`
+}
+
+// UpdateDepartmentResponse is the response object for DepartmentService.UpdateDepartment.
+type UpdateDepartmentResponse struct {
+	Department Department `json:"department"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// DeleteDepartmentRequest is the request object for DepartmentService.DeleteDepartment.
+type DeleteDepartmentRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// DeleteDepartmentResponse is the response object for DepartmentService.DeleteDepartment.
+type DeleteDepartmentResponse struct {
+	Department Department `json:"department"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// MoveDepartmentRequest is the request object for DepartmentService.MoveDepartment.
+type MoveDepartmentRequest struct {
+	ID string `json:"id" validate:"required"`
+	// ParentID is the new parent. An empty ParentID makes the department a
+	// top level department.
+	ParentID string `json:"parentId"`
+}
+
+// MoveDepartmentResponse is the response object for DepartmentService.MoveDepartment.
+type MoveDepartmentResponse struct {
+	Department Department `json:"department"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// QueryDepartmentByIDRequest is the request object for DepartmentService.QueryDepartmentByID.
+type QueryDepartmentByIDRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// QueryDepartmentByIDResponse is the response object for DepartmentService.QueryDepartmentByID.
+type QueryDepartmentByIDResponse struct {
+	Department Department `json:"department"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// QueryDepartmentsRequest is the request object for DepartmentService.QueryDepartments.
+type QueryDepartmentsRequest struct{}
+
+// QueryDepartmentsResponse is the response object for DepartmentService.QueryDepartments.
+type QueryDepartmentsResponse struct {
+	Departments []Department `json:"departments"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// QueryDepartmentMembersRequest is the request object for DepartmentService.QueryDepartmentMembers.
+type QueryDepartmentMembersRequest struct {
+	ID                 string `json:"id" validate:"required"`
+	IncludeDescendants bool   `json:"includeDescendants"`
+}
+
+// QueryDepartmentMembersResponse is the response object for DepartmentService.QueryDepartmentMembers.
+type QueryDepartmentMembersResponse struct {
+	Users []User `json:"users"`
+	Error string `json:"error,omitempty"`
+}
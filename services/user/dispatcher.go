@@ -0,0 +1,268 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Headers set on every webhook request.
+const (
+	HeaderEvent     = "X-Bud-Event"
+	HeaderDelivery  = "X-Bud-Delivery"
+	HeaderSignature = "X-Bud-Signature"
+)
+
+// DispatcherConfig tunes how a WebhookDispatcher retries deliveries.
+type DispatcherConfig struct {
+	// MaxAttempts is the number of attempts before a delivery is dead.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles with every
+	// attempt up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Client sends the requests. An http.Client with a 10 second timeout
+	// when nil.
+	Client *http.Client
+}
+
+// DefaultDispatcherConfig retries for about an hour.
+var DefaultDispatcherConfig = DispatcherConfig{
+	MaxAttempts: 8,
+	BaseDelay:   30 * time.Second,
+	MaxDelay:    15 * time.Minute,
+}
+
+// WebhookDispatcher publishes events to the webhooks subscribed to them.
+// Each delivery is attempted in the background and every attempt is
+// recorded, so the delivery log survives failed endpoints.
+type WebhookDispatcher struct {
+	log    *zap.SugaredLogger
+	storer WebhookStorer
+	cfg    DispatcherConfig
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewWebhookDispatcher constructs a WebhookDispatcher. Close must be called
+// to stop the deliveries in flight.
+func NewWebhookDispatcher(log *zap.SugaredLogger, storer WebhookStorer, cfg DispatcherConfig) *WebhookDispatcher {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultDispatcherConfig.MaxAttempts
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = DefaultDispatcherConfig.BaseDelay
+	}
+	if cfg.MaxDelay < cfg.BaseDelay {
+		cfg.MaxDelay = cfg.BaseDelay
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookDispatcher{
+		log:    log,
+		storer: storer,
+		cfg:    cfg,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Publish implements EventPublisher. It records a pending delivery for
+// every subscribed webhook and returns without waiting for them to be sent.
+func (d *WebhookDispatcher) Publish(ctx context.Context, evt Event) error {
+	whs, err := d.storer.QueryByEvent(ctx, evt.OrgID, evt.Type)
+	if err != nil {
+		return fmt.Errorf("querying webhooks: %w", err)
+	}
+	if len(whs) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+
+	for _, wh := range whs {
+		dl := Delivery{
+			ID:            uuid.New(),
+			OrgID:         wh.OrgID,
+			WebhookID:     wh.ID,
+			EventID:       evt.ID,
+			EventType:     evt.Type,
+			Payload:       payload,
+			Status:        DeliveryPending,
+			NextAttemptAt: evt.OccurredAt,
+			DateCreated:   evt.OccurredAt,
+			DateUpdated:   evt.OccurredAt,
+		}
+		dl, err := d.storer.SaveDelivery(ctx, dl)
+		if err != nil {
+			return fmt.Errorf("saving delivery: %w", err)
+		}
+		d.start(wh, dl)
+	}
+	return nil
+}
+
+// Redeliver sends a delivery again with a fresh set of attempts.
+func (d *WebhookDispatcher) Redeliver(ctx context.Context, orgID string, id string) (Delivery, error) {
+	dl, err := d.storer.QueryDeliveryByID(ctx, orgID, id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	wh, err := d.storer.QueryByID(ctx, orgID, dl.WebhookID.String())
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	now := time.Now().UTC()
+	dl.Status = DeliveryPending
+	dl.Attempts = 0
+	dl.NextAttemptAt = now
+	dl.DateUpdated = now
+	if dl, err = d.storer.SaveDelivery(ctx, dl); err != nil {
+		return Delivery{}, err
+	}
+	d.start(wh, dl)
+	return dl, nil
+}
+
+// Close stops retrying and waits for the attempts in flight to finish.
+// Deliveries left pending or failed can be sent with Redeliver.
+func (d *WebhookDispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// start delivers dl in the background.
+func (d *WebhookDispatcher) start(wh Webhook, dl Delivery) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.deliver(wh, dl)
+	}()
+}
+
+// deliver attempts dl until it succeeds, runs out of attempts or the
+// dispatcher is closed.
+func (d *WebhookDispatcher) deliver(wh Webhook, dl Delivery) {
+	for {
+		code, err := d.send(wh, dl)
+
+		now := time.Now().UTC()
+		dl.Attempts++
+		dl.LastStatusCode = code
+		dl.LastError = ""
+		dl.DateUpdated = now
+
+		switch {
+		case err == nil:
+			dl.Status = DeliverySucceeded
+			dl.NextAttemptAt = time.Time{}
+		case dl.Attempts >= d.cfg.MaxAttempts:
+			dl.Status = DeliveryDead
+			dl.LastError = err.Error()
+			dl.NextAttemptAt = time.Time{}
+		default:
+			dl.Status = DeliveryFailed
+			dl.LastError = err.Error()
+			dl.NextAttemptAt = now.Add(d.backoff(dl.Attempts))
+		}
+
+		// Record the attempt even when closing, so it isn't lost.
+		if _, serr := d.storer.SaveDelivery(context.Background(), dl); serr != nil {
+			d.log.Errorw("saving delivery", "delivery_id", dl.ID, "error", serr)
+		}
+		if dl.Status != DeliveryFailed {
+			if dl.Status == DeliveryDead {
+				d.log.Errorw("webhook delivery dead", "delivery_id", dl.ID, "webhook_id", wh.ID, "error", dl.LastError)
+			}
+			return
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(time.Until(dl.NextAttemptAt)):
+		}
+	}
+}
+
+// backoff returns the delay after attempt, doubling from BaseDelay up to
+// MaxDelay.
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.BaseDelay
+	for i := 1; i < attempt && delay < d.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.MaxDelay {
+		delay = d.cfg.MaxDelay
+	}
+	return delay
+}
+
+// send makes a single attempt. Any status other than 2xx is a failure.
+func (d *WebhookDispatcher) send(wh Webhook, dl Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, wh.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, dl.EventType)
+	req.Header.Set(HeaderDelivery, dl.ID.String())
+	req.Header.Set(HeaderSignature, Sign(wh.Secret, time.Now(), dl.Payload))
+
+	resp, err := d.cfg.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header of payload sent at t. The signature is
+// the hex HMAC-SHA256 of "<unix t>.<payload>" keyed by the webhook secret,
+// formatted as "t=<unix t>,v1=<signature>". Receivers recompute it to
+// authenticate the request and reject stale timestamps to prevent replays.
+func Sign(secret string, t time.Time, payload []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookSecret generates a random webhook secret. Unlike client secrets
+// it is stored as is, since it is needed to sign every payload.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,98 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of the events emitted when users change.
+const (
+	EventUserCreated  = "user.created"
+	EventUserUpdated  = "user.updated"
+	EventUserDisabled = "user.disabled"
+	EventUserDeleted  = "user.deleted"
+)
+
+// EventTypes is the set of event types that can be subscribed to.
+var EventTypes = []string{EventUserCreated, EventUserUpdated, EventUserDisabled, EventUserDeleted}
+
+// Event is a change to a user.
+type Event struct {
+	ID         uuid.UUID `json:"id"`
+	Type       string    `json:"type"`
+	OrgID      string    `json:"org_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       EventData `json:"data"`
+}
+
+// EventData is the state of the user after the change, or before it for
+// deletions.
+type EventData struct {
+	User User `json:"user"`
+}
+
+// EventPublisher interface declares the behavior this package needs to
+// publish events.
+type EventPublisher interface {
+	Publish(ctx context.Context, evt Event) error
+}
+
+// OutboxStorer is implemented by storers that can write an event for every
+// change to a user to an outbox, in the same transaction as the change.
+// Decorators implement it by asking the storer they decorate.
+type OutboxStorer interface {
+	WritesOutbox() bool
+}
+
+// WritesOutbox reports whether storer writes an outbox.
+func WritesOutbox(storer Storer) bool {
+	ob, ok := storer.(OutboxStorer)
+	return ok && ob.WritesOutbox()
+}
+
+// WithEvents publishes an event for every change UserServicer makes to a
+// user. The event is published after the change is stored, so it is lost if
+// the process dies in between; a storer with a transactional outbox avoids
+// that and should be used instead of WithEvents, not with it.
+// NewUserServicer panics when given both, since every event would be
+// published twice.
+func WithEvents(events EventPublisher) Option {
+	return func(u *UserServicer) {
+		u.events = events
+	}
+}
+
+// NewEvent builds the event of type typ for usr. The password hash never
+// leaves the service.
+func NewEvent(typ string, usr User, now time.Time) Event {
+	usr.PasswordHash = nil
+	return Event{
+		ID:         uuid.New(),
+		Type:       typ,
+		OrgID:      usr.OrgID.String(),
+		OccurredAt: now,
+		Data:       EventData{User: usr},
+	}
+}
+
+// publish publishes an event when events are enabled. A failure to publish
+// is logged rather than failing the change that has already been made.
+func (u UserServicer) publish(ctx context.Context, typ string, usr User, now time.Time) {
+	if u.events == nil {
+		return
+	}
+	evt := NewEvent(typ, usr, now)
+	if err := u.events.Publish(ctx, evt); err != nil {
+		u.log.Errorw("publishing event", "event_id", evt.ID, "type", typ, "user_id", usr.ID, "error", err)
+	}
+}
+
+// UpdateEventType returns the type of the event for an update.
+func UpdateEventType(uu UpdateUser) string {
+	if uu.Enabled != nil && !*uu.Enabled {
+		return EventUserDisabled
+	}
+	return EventUserUpdated
+}
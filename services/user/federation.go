@@ -0,0 +1,190 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrNoLocalUser is returned when a federated identity doesn't map to a
+// local user and just in time provisioning is disabled.
+var ErrNoLocalUser = errors.New("no local user for federated identity")
+
+// FederatedIdentity is an identity asserted by an external identity
+// provider's ID token.
+type FederatedIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// IdentityLink connects an identity provider's subject to a local user.
+type IdentityLink struct {
+	Issuer      string    `json:"issuer"`
+	Subject     string    `json:"subject"`
+	OrgID       uuid.UUID `json:"org_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+// IdentityStorer interface declares the behavior this package needs to
+// persist and retrieve identity links.
+type IdentityStorer interface {
+	Create(ctx context.Context, link IdentityLink) (IdentityLink, error)
+	// QueryBySubject returns a zero IdentityLink when the subject has not
+	// been linked.
+	QueryBySubject(ctx context.Context, issuer string, subject string) (IdentityLink, error)
+}
+
+// Federation configures sign in through an external identity provider.
+type Federation struct {
+	Identities IdentityStorer
+	// OrgID is the organization the provider's users belong to.
+	OrgID string
+	// Provision creates a local user the first time an unknown identity
+	// signs in, with Roles.
+	Provision bool
+	Roles     []Role
+}
+
+// WithFederation lets users sign in with identities asserted by an external
+// identity provider.
+func WithFederation(f Federation) Option {
+	return func(u *UserServicer) {
+		u.federation = &f
+	}
+}
+
+// FederatedLogin implements UserRpcService. The identity is mapped to a
+// local user by its subject, then by its verified email, linking the two
+// on first use. Users are provisioned when federation allows it.
+func (u UserServicer) FederatedLogin(ctx context.Context, id FederatedIdentity) (string, error) {
+	if u.federation == nil {
+		return "", errors.New("federated login is not configured")
+	}
+	f := u.federation
+
+	usr, err := u.federatedUser(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if !usr.Enabled {
+		u.recordLogin(ctx, usr, LoginFederated, ErrUserDisabled, time.Now().UTC())
+		return "", ErrUserDisabled
+	}
+
+	claims, err := u.newClaims(ctx, usr, time.Hour)
+	if err != nil {
+		return "", err
+	}
+
+	tkn, err := u.signer.GenerateToken(claims)
+	if err != nil {
+		return "", fmt.Errorf("generatetoken: %w", err)
+	}
+
+	u.log.Infow("federated login", "issuer", id.Issuer, "subject", id.Subject, "user_id", usr.ID, "org_id", f.OrgID)
+	u.recordLogin(ctx, usr, LoginFederated, nil, time.Now().UTC())
+
+	return tkn, nil
+}
+
+// federatedUser finds or provisions the local user for id.
+func (u UserServicer) federatedUser(ctx context.Context, id FederatedIdentity) (User, error) {
+	f := u.federation
+
+	link, err := f.Identities.QueryBySubject(ctx, id.Issuer, id.Subject)
+	if err != nil {
+		return User{}, fmt.Errorf("querybysubject: %w", err)
+	}
+	if link.UserID != uuid.Nil {
+		return u.storer.QueryByID(ctx, link.OrgID.String(), link.UserID.String())
+	}
+
+	// Only a verified email can be trusted to identify an existing user.
+	if id.Email == "" || !id.EmailVerified {
+		return User{}, ErrNoLocalUser
+	}
+
+	var usr User
+	usrs, err := u.storer.Query(ctx, f.OrgID, QueryFilter{Email: &id.Email}, DefaultOrderBy, 1, 1)
+	if err != nil {
+		return User{}, fmt.Errorf("query: %w", err)
+	}
+	switch {
+	case len(usrs) == 1:
+		usr = usrs[0]
+	case f.Provision:
+		if usr, err = u.provision(ctx, id); err != nil {
+			return User{}, err
+		}
+	default:
+		return User{}, ErrNoLocalUser
+	}
+
+	link = IdentityLink{
+		Issuer:      id.Issuer,
+		Subject:     id.Subject,
+		OrgID:       usr.OrgID,
+		UserID:      usr.ID,
+		DateCreated: time.Now(),
+	}
+	if _, err := f.Identities.Create(ctx, link); err != nil {
+		return User{}, fmt.Errorf("linking identity: %w", err)
+	}
+
+	return usr, nil
+}
+
+// provision creates a local user for id. The user is given a random
+// password, so it can only sign in through the identity provider until the
+// password is reset.
+func (u UserServicer) provision(ctx context.Context, id FederatedIdentity) (User, error) {
+	f := u.federation
+
+	oid, err := uuid.Parse(f.OrgID)
+	if err != nil {
+		return User{}, fmt.Errorf("parsing org id: %w", err)
+	}
+
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return User{}, fmt.Errorf("generating password: %w", err)
+	}
+	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, fmt.Errorf("generatefrompassword: %w", err)
+	}
+
+	name := id.Name
+	if name == "" {
+		name = id.Email
+	}
+
+	now := time.Now()
+	usr := User{
+		ID:           uuid.New(),
+		OrgID:        oid,
+		Name:         name,
+		Email:        mail.Address{Name: id.Name, Address: id.Email},
+		PasswordHash: hash,
+		Roles:        f.Roles,
+		Enabled:      true,
+		DateCreated:  now,
+		DateUpdated:  now,
+	}
+	result, err := u.storer.Create(ctx, usr)
+	if err != nil {
+		return User{}, err
+	}
+	u.publish(ctx, EventUserCreated, result, now)
+	return result, nil
+}
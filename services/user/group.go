@@ -0,0 +1,307 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// GroupService is an API for managing teams of users.
+type GroupService interface {
+	// CreateGroup creates a group
+	CreateGroup(CreateGroupRequest, server.GenericRequest) CreateGroupResponse
+	// UpdateGroup updates a group
+	UpdateGroup(UpdateGroupRequest, server.GenericRequest) UpdateGroupResponse
+	// DeleteGroup deletes a group and its memberships
+	DeleteGroup(DeleteGroupRequest, server.GenericRequest) DeleteGroupResponse
+	// QueryGroupByID gets the specified group by id
+	QueryGroupByID(QueryGroupByIDRequest, server.GenericRequest) QueryGroupByIDResponse
+	// QueryGroups lists the groups of the caller's organization
+	QueryGroups(QueryGroupsRequest, server.GenericRequest) QueryGroupsResponse
+	// AddGroupMember adds a user to a group
+	AddGroupMember(AddGroupMemberRequest, server.GenericRequest) AddGroupMemberResponse
+	// RemoveGroupMember removes a user from a group
+	RemoveGroupMember(RemoveGroupMemberRequest, server.GenericRequest) RemoveGroupMemberResponse
+	// QueryUserGroups lists the groups a user belongs to
+	QueryUserGroups(QueryUserGroupsRequest, server.GenericRequest) QueryUserGroupsResponse
+	// QueryGroupMembers lists the users that belong to a group
+	QueryGroupMembers(QueryGroupMembersRequest, server.GenericRequest) QueryGroupMembersResponse
+}
+
+// GroupStorer interface declares the behavior this package needs to persist
+// and retrieve groups and their memberships.
+type GroupStorer interface {
+	Create(ctx context.Context, grp Group) (Group, error)
+	Update(ctx context.Context, orgID string, id string, ug UpdateGroup, now time.Time) (Group, error)
+	Delete(ctx context.Context, orgID string, id string) (Group, error)
+	QueryByID(ctx context.Context, orgID string, id string) (Group, error)
+	Query(ctx context.Context, orgID string) ([]Group, error)
+	AddMember(ctx context.Context, orgID string, groupID string, userID string) error
+	RemoveMember(ctx context.Context, orgID string, groupID string, userID string) error
+	QueryByUser(ctx context.Context, orgID string, userID string) ([]Group, error)
+	QueryMembers(ctx context.Context, orgID string, groupID string) ([]User, error)
+}
+
+// Required to register endpoints with the Server
+type GroupRpcService interface {
+	GroupService
+	// Registers RPCService with Server
+	Register(s *server.Server)
+}
+
+// Implements interface
+type GroupServicer struct {
+	log    *zap.SugaredLogger
+	storer GroupStorer
+}
+
+// CreateGroup implements GroupRpcService
+func (g GroupServicer) CreateGroup(req CreateGroupRequest, gr server.GenericRequest) CreateGroupResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return CreateGroupResponse{Error: err.Error()}
+	}
+	oid, err := uuid.Parse(orgID)
+	if err != nil {
+		return CreateGroupResponse{Error: fmt.Errorf("parsing org id: %w", err).Error()}
+	}
+	grp := Group{
+		ID:          uuid.New(),
+		OrgID:       oid,
+		Name:        req.NewGroup.Name,
+		Description: req.NewGroup.Description,
+		DateCreated: gr.Values.Now,
+		DateUpdated: gr.Values.Now,
+	}
+	result, err := g.storer.Create(gr.Ctx, grp)
+	if err != nil {
+		return CreateGroupResponse{Error: err.Error()}
+	}
+	return CreateGroupResponse{Group: result}
+}
+
+// UpdateGroup implements GroupRpcService
+func (g GroupServicer) UpdateGroup(req UpdateGroupRequest, gr server.GenericRequest) UpdateGroupResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return UpdateGroupResponse{Error: err.Error()}
+	}
+	grp, err := g.storer.Update(gr.Ctx, orgID, req.ID, req.UpdateGroup, gr.Values.Now)
+	if err != nil {
+		return UpdateGroupResponse{Error: err.Error()}
+	}
+	return UpdateGroupResponse{Group: grp}
+}
+
+// DeleteGroup implements GroupRpcService
+func (g GroupServicer) DeleteGroup(req DeleteGroupRequest, gr server.GenericRequest) DeleteGroupResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return DeleteGroupResponse{Error: err.Error()}
+	}
+	grp, err := g.storer.Delete(gr.Ctx, orgID, req.ID)
+	if err != nil {
+		return DeleteGroupResponse{Error: err.Error()}
+	}
+	return DeleteGroupResponse{Group: grp}
+}
+
+// QueryGroupByID implements GroupRpcService
+func (g GroupServicer) QueryGroupByID(req QueryGroupByIDRequest, gr server.GenericRequest) QueryGroupByIDResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryGroupByIDResponse{Error: err.Error()}
+	}
+	grp, err := g.storer.QueryByID(gr.Ctx, orgID, req.ID)
+	if err != nil {
+		return QueryGroupByIDResponse{Error: err.Error()}
+	}
+	return QueryGroupByIDResponse{Group: grp}
+}
+
+// QueryGroups implements GroupRpcService
+func (g GroupServicer) QueryGroups(req QueryGroupsRequest, gr server.GenericRequest) QueryGroupsResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryGroupsResponse{Error: err.Error()}
+	}
+	grps, err := g.storer.Query(gr.Ctx, orgID)
+	if err != nil {
+		return QueryGroupsResponse{Error: err.Error()}
+	}
+	return QueryGroupsResponse{Groups: grps}
+}
+
+// AddGroupMember implements GroupRpcService
+func (g GroupServicer) AddGroupMember(req AddGroupMemberRequest, gr server.GenericRequest) AddGroupMemberResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return AddGroupMemberResponse{Error: err.Error()}
+	}
+	if err = g.storer.AddMember(gr.Ctx, orgID, req.GroupID, req.UserID); err != nil {
+		return AddGroupMemberResponse{Error: err.Error()}
+	}
+	return AddGroupMemberResponse{}
+}
+
+// RemoveGroupMember implements GroupRpcService
+func (g GroupServicer) RemoveGroupMember(req RemoveGroupMemberRequest, gr server.GenericRequest) RemoveGroupMemberResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return RemoveGroupMemberResponse{Error: err.Error()}
+	}
+	if err = g.storer.RemoveMember(gr.Ctx, orgID, req.GroupID, req.UserID); err != nil {
+		return RemoveGroupMemberResponse{Error: err.Error()}
+	}
+	return RemoveGroupMemberResponse{}
+}
+
+// QueryUserGroups implements GroupRpcService
+func (g GroupServicer) QueryUserGroups(req QueryUserGroupsRequest, gr server.GenericRequest) QueryUserGroupsResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryUserGroupsResponse{Error: err.Error()}
+	}
+	grps, err := g.storer.QueryByUser(gr.Ctx, orgID, req.UserID)
+	if err != nil {
+		return QueryUserGroupsResponse{Error: err.Error()}
+	}
+	return QueryUserGroupsResponse{Groups: grps}
+}
+
+// QueryGroupMembers implements GroupRpcService
+func (g GroupServicer) QueryGroupMembers(req QueryGroupMembersRequest, gr server.GenericRequest) QueryGroupMembersResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryGroupMembersResponse{Error: err.Error()}
+	}
+	usrs, err := g.storer.QueryMembers(gr.Ctx, orgID, req.GroupID)
+	if err != nil {
+		return QueryGroupMembersResponse{Error: err.Error()}
+	}
+	return QueryGroupMembersResponse{Users: usrs}
+}
+
+// Register implements GroupRpcService
+func (g GroupServicer) Register(s *server.Server) {
+	s.Register("GroupService", "CreateGroup", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: g.CreateGroupHandler})
+	s.Register("GroupService", "UpdateGroup", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: g.UpdateGroupHandler})
+	s.Register("GroupService", "DeleteGroup", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: g.DeleteGroupHandler})
+	s.Register("GroupService", "QueryGroupByID", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: g.QueryGroupByIDHandler})
+	s.Register("GroupService", "QueryGroups", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: g.QueryGroupsHandler})
+	s.Register("GroupService", "AddGroupMember", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: g.AddGroupMemberHandler})
+	s.Register("GroupService", "RemoveGroupMember", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: g.RemoveGroupMemberHandler})
+	s.Register("GroupService", "QueryUserGroups", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: g.QueryUserGroupsHandler})
+	s.Register("GroupService", "QueryGroupMembers", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: g.QueryGroupMembersHandler})
+}
+
+// Create new GroupServicer
+func NewGroupServicer(log *zap.SugaredLogger, storer GroupStorer) GroupRpcService {
+	return GroupServicer{
+		log:    log,
+		storer: storer,
+	}
+}
+
+// CreateGroupRequest is the request object for GroupService.CreateGroup.
+type CreateGroupRequest struct {
+	NewGroup NewGroup `json:"newGroup"`
+}
+
+// CreateGroupResponse is the response object for GroupService.CreateGroup.
+type CreateGroupResponse struct {
+	Group Group  `json:"group"`
+	Error string `json:"error,omitempty"`
+}
+
+// UpdateGroupRequest is the request object for GroupService.UpdateGroup.
+type UpdateGroupRequest struct {
+	ID          string      `json:"id" validate:"required"`
+	UpdateGroup UpdateGroup `json:"group"`
+}
+
+// UpdateGroupResponse is the response object for GroupService.UpdateGroup.
+type UpdateGroupResponse struct {
+	Group Group  `json:"group"`
+	Error string `json:"error,omitempty"`
+}
+
+// DeleteGroupRequest is the request object for GroupService.DeleteGroup.
+type DeleteGroupRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// DeleteGroupResponse is the response object for GroupService.DeleteGroup.
+type DeleteGroupResponse struct {
+	Group Group  `json:"group"`
+	Error string `json:"error,omitempty"`
+}
+
+// QueryGroupByIDRequest is the request object for GroupService.QueryGroupByID.
+type QueryGroupByIDRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// QueryGroupByIDResponse is the response object for GroupService.QueryGroupByID.
+type QueryGroupByIDResponse struct {
+	Group Group  `json:"group"`
+	Error string `json:"error,omitempty"`
+}
+
+// QueryGroupsRequest is the request object for GroupService.QueryGroups.
+type QueryGroupsRequest struct{}
+
+// QueryGroupsResponse is the response object for GroupService.QueryGroups.
+type QueryGroupsResponse struct {
+	Groups []Group `json:"groups"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// AddGroupMemberRequest is the request object for GroupService.AddGroupMember.
+type AddGroupMemberRequest struct {
+	GroupID string `json:"groupId" validate:"required"`
+	UserID  string `json:"userId" validate:"required"`
+}
+
+// AddGroupMemberResponse is the response object for GroupService.AddGroupMember.
+type AddGroupMemberResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// RemoveGroupMemberRequest is the request object for GroupService.RemoveGroupMember.
+type RemoveGroupMemberRequest struct {
+	GroupID string `json:"groupId" validate:"required"`
+	UserID  string `json:"userId" validate:"required"`
+}
+
+// RemoveGroupMemberResponse is the response object for GroupService.RemoveGroupMember.
+type RemoveGroupMemberResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// QueryUserGroupsRequest is the request object for GroupService.QueryUserGroups.
+type QueryUserGroupsRequest struct {
+	UserID string `json:"userId" validate:"required"`
+}
+
+// QueryUserGroupsResponse is the response object for GroupService.QueryUserGroups.
+type QueryUserGroupsResponse struct {
+	Groups []Group `json:"groups"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// QueryGroupMembersRequest is the request object for GroupService.QueryGroupMembers.
+type QueryGroupMembersRequest struct {
+	GroupID string `json:"groupId" validate:"required"`
+}
+
+// QueryGroupMembersResponse is the response object for GroupService.QueryGroupMembers.
+type QueryGroupMembersResponse struct {
+	Users []User `json:"users"`
+	Error string `json:"error,omitempty"`
+}
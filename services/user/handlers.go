@@ -8,6 +8,442 @@ import (
 	"github.com/gitamped/seed/validate"
 ) 
  
+// QueryAttributeSchemaHandler validates input data prior to calling QueryAttributeSchema
+func (h AttributeServicer) QueryAttributeSchemaHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryAttributeSchemaRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryAttributeSchema(hr, r), nil
+} 
+// SetAttributeSchemaHandler validates input data prior to calling SetAttributeSchema
+func (h AttributeServicer) SetAttributeSchemaHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr SetAttributeSchemaRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.SetAttributeSchema(hr, r), nil
+} 
+ 
+// CreateClientHandler validates input data prior to calling CreateClient
+func (h ClientServicer) CreateClientHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr CreateClientRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.CreateClient(hr, r), nil
+} 
+// QueryClientsHandler validates input data prior to calling QueryClients
+func (h ClientServicer) QueryClientsHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryClientsRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryClients(hr, r), nil
+} 
+// RevokeClientHandler validates input data prior to calling RevokeClient
+func (h ClientServicer) RevokeClientHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr RevokeClientRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.RevokeClient(hr, r), nil
+} 
+// RotateClientSecretHandler validates input data prior to calling RotateClientSecret
+func (h ClientServicer) RotateClientSecretHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr RotateClientSecretRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.RotateClientSecret(hr, r), nil
+} 
+ 
+// CreateDepartmentHandler validates input data prior to calling CreateDepartment
+func (h DepartmentServicer) CreateDepartmentHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr CreateDepartmentRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.CreateDepartment(hr, r), nil
+} 
+// DeleteDepartmentHandler validates input data prior to calling DeleteDepartment
+func (h DepartmentServicer) DeleteDepartmentHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr DeleteDepartmentRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.DeleteDepartment(hr, r), nil
+} 
+// MoveDepartmentHandler validates input data prior to calling MoveDepartment
+func (h DepartmentServicer) MoveDepartmentHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr MoveDepartmentRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.MoveDepartment(hr, r), nil
+} 
+// QueryDepartmentByIDHandler validates input data prior to calling QueryDepartmentByID
+func (h DepartmentServicer) QueryDepartmentByIDHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryDepartmentByIDRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryDepartmentByID(hr, r), nil
+} 
+// QueryDepartmentMembersHandler validates input data prior to calling QueryDepartmentMembers
+func (h DepartmentServicer) QueryDepartmentMembersHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryDepartmentMembersRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryDepartmentMembers(hr, r), nil
+} 
+// QueryDepartmentsHandler validates input data prior to calling QueryDepartments
+func (h DepartmentServicer) QueryDepartmentsHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryDepartmentsRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryDepartments(hr, r), nil
+} 
+// UpdateDepartmentHandler validates input data prior to calling UpdateDepartment
+func (h DepartmentServicer) UpdateDepartmentHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr UpdateDepartmentRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.UpdateDepartment(hr, r), nil
+} 
+ 
+// AddGroupMemberHandler validates input data prior to calling AddGroupMember
+func (h GroupServicer) AddGroupMemberHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr AddGroupMemberRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.AddGroupMember(hr, r), nil
+} 
+// CreateGroupHandler validates input data prior to calling CreateGroup
+func (h GroupServicer) CreateGroupHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr CreateGroupRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.CreateGroup(hr, r), nil
+} 
+// DeleteGroupHandler validates input data prior to calling DeleteGroup
+func (h GroupServicer) DeleteGroupHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr DeleteGroupRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.DeleteGroup(hr, r), nil
+} 
+// QueryGroupByIDHandler validates input data prior to calling QueryGroupByID
+func (h GroupServicer) QueryGroupByIDHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryGroupByIDRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryGroupByID(hr, r), nil
+} 
+// QueryGroupMembersHandler validates input data prior to calling QueryGroupMembers
+func (h GroupServicer) QueryGroupMembersHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryGroupMembersRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryGroupMembers(hr, r), nil
+} 
+// QueryGroupsHandler validates input data prior to calling QueryGroups
+func (h GroupServicer) QueryGroupsHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryGroupsRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryGroups(hr, r), nil
+} 
+// QueryUserGroupsHandler validates input data prior to calling QueryUserGroups
+func (h GroupServicer) QueryUserGroupsHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryUserGroupsRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryUserGroups(hr, r), nil
+} 
+// RemoveGroupMemberHandler validates input data prior to calling RemoveGroupMember
+func (h GroupServicer) RemoveGroupMemberHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr RemoveGroupMemberRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.RemoveGroupMember(hr, r), nil
+} 
+// UpdateGroupHandler validates input data prior to calling UpdateGroup
+func (h GroupServicer) UpdateGroupHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr UpdateGroupRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.UpdateGroup(hr, r), nil
+} 
+ 
+// QueryJobRunsHandler validates input data prior to calling QueryJobRuns
+func (h JobServicer) QueryJobRunsHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryJobRunsRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryJobRuns(hr, r), nil
+} 
+ 
+// QueryDirectReportsHandler validates input data prior to calling QueryDirectReports
+func (h ManagerServicer) QueryDirectReportsHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryDirectReportsRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryDirectReports(hr, r), nil
+} 
+// QueryReportingChainHandler validates input data prior to calling QueryReportingChain
+func (h ManagerServicer) QueryReportingChainHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryReportingChainRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryReportingChain(hr, r), nil
+} 
+// QueryReportingTreeHandler validates input data prior to calling QueryReportingTree
+func (h ManagerServicer) QueryReportingTreeHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryReportingTreeRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryReportingTree(hr, r), nil
+} 
+// SetManagerHandler validates input data prior to calling SetManager
+func (h ManagerServicer) SetManagerHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr SetManagerRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.SetManager(hr, r), nil
+} 
+ 
+// CreateOrgHandler validates input data prior to calling CreateOrg
+func (h OrgServicer) CreateOrgHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr CreateOrgRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.CreateOrg(hr, r), nil
+} 
+// DeleteOrgHandler validates input data prior to calling DeleteOrg
+func (h OrgServicer) DeleteOrgHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr DeleteOrgRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.DeleteOrg(hr, r), nil
+} 
+// QueryOrgByIDHandler validates input data prior to calling QueryOrgByID
+func (h OrgServicer) QueryOrgByIDHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryOrgByIDRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryOrgByID(hr, r), nil
+} 
+// QueryOrgsHandler validates input data prior to calling QueryOrgs
+func (h OrgServicer) QueryOrgsHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryOrgsRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryOrgs(hr, r), nil
+} 
+// UpdateOrgHandler validates input data prior to calling UpdateOrg
+func (h OrgServicer) UpdateOrgHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr UpdateOrgRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.UpdateOrg(hr, r), nil
+} 
+ 
+// AcceptInvitationHandler validates input data prior to calling AcceptInvitation
+func (h UserServicer) AcceptInvitationHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr AcceptInvitationRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.AcceptInvitation(hr, r), nil
+} 
 // AuthenticateHandler validates input data prior to calling Authenticate
 func (h UserServicer) AuthenticateHandler(r server.GenericRequest, b []byte) (any, error) {
 	var hr AuthenticateRequest
@@ -21,6 +457,19 @@ func (h UserServicer) AuthenticateHandler(r server.GenericRequest, b []byte) (an
 
 	return h.Authenticate(hr, r), nil
 } 
+// ChangePasswordHandler validates input data prior to calling ChangePassword
+func (h UserServicer) ChangePasswordHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr ChangePasswordRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.ChangePassword(hr, r), nil
+} 
 // CreateUserHandler validates input data prior to calling CreateUser
 func (h UserServicer) CreateUserHandler(r server.GenericRequest, b []byte) (any, error) {
 	var hr CreateUserRequest
@@ -47,6 +496,58 @@ func (h UserServicer) DeleteUserHandler(r server.GenericRequest, b []byte) (any,
 
 	return h.DeleteUser(hr, r), nil
 } 
+// ImpersonateUserHandler validates input data prior to calling ImpersonateUser
+func (h UserServicer) ImpersonateUserHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr ImpersonateUserRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.ImpersonateUser(hr, r), nil
+} 
+// InviteUserHandler validates input data prior to calling InviteUser
+func (h UserServicer) InviteUserHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr InviteUserRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.InviteUser(hr, r), nil
+} 
+// IssuePasswordResetHandler validates input data prior to calling IssuePasswordReset
+func (h UserServicer) IssuePasswordResetHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr IssuePasswordResetRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.IssuePasswordReset(hr, r), nil
+} 
+// QueryLoginHistoryHandler validates input data prior to calling QueryLoginHistory
+func (h UserServicer) QueryLoginHistoryHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryLoginHistoryRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryLoginHistory(hr, r), nil
+} 
 // QueryUserHandler validates input data prior to calling QueryUser
 func (h UserServicer) QueryUserHandler(r server.GenericRequest, b []byte) (any, error) {
 	var hr QueryUserRequest
@@ -86,6 +587,32 @@ func (h UserServicer) QueryUserByIDHandler(r server.GenericRequest, b []byte) (a
 
 	return h.QueryUserByID(hr, r), nil
 } 
+// ResetPasswordHandler validates input data prior to calling ResetPassword
+func (h UserServicer) ResetPasswordHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr ResetPasswordRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.ResetPassword(hr, r), nil
+} 
+// SearchUsersHandler validates input data prior to calling SearchUsers
+func (h UserServicer) SearchUsersHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr SearchUsersRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.SearchUsers(hr, r), nil
+} 
 // UpdateUserHandler validates input data prior to calling UpdateUser
 func (h UserServicer) UpdateUserHandler(r server.GenericRequest, b []byte) (any, error) {
 	var hr UpdateUserRequest
@@ -98,4 +625,70 @@ func (h UserServicer) UpdateUserHandler(r server.GenericRequest, b []byte) (any,
 	}
 
 	return h.UpdateUser(hr, r), nil
+} 
+ 
+// CreateWebhookHandler validates input data prior to calling CreateWebhook
+func (h WebhookServicer) CreateWebhookHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr CreateWebhookRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.CreateWebhook(hr, r), nil
+} 
+// DeleteWebhookHandler validates input data prior to calling DeleteWebhook
+func (h WebhookServicer) DeleteWebhookHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr DeleteWebhookRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.DeleteWebhook(hr, r), nil
+} 
+// QueryDeliveriesHandler validates input data prior to calling QueryDeliveries
+func (h WebhookServicer) QueryDeliveriesHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryDeliveriesRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryDeliveries(hr, r), nil
+} 
+// QueryWebhooksHandler validates input data prior to calling QueryWebhooks
+func (h WebhookServicer) QueryWebhooksHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr QueryWebhooksRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.QueryWebhooks(hr, r), nil
+} 
+// RedeliverWebhookHandler validates input data prior to calling RedeliverWebhook
+func (h WebhookServicer) RedeliverWebhookHandler(r server.GenericRequest, b []byte) (any, error) {
+	var hr RedeliverWebhookRequest
+	if err := json.Unmarshal(b, &hr); err != nil {
+		return nil, fmt.Errorf("Unmarshalling data: %w", err)
+	}
+
+	if err := validate.Check(hr); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	return h.RedeliverWebhook(hr, r), nil
 }
@@ -0,0 +1,140 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrUnsupported is returned when a storer can't do what was asked, such
+// as a decorator asked for dormant users by a store that can't query them.
+var ErrUnsupported = errors.New("operation not supported by the store")
+
+// DormantQuerier is implemented by storers that can query dormant users.
+type DormantQuerier interface {
+	// QueryDormant retrieves enabled users in any organization who haven't
+	// signed in since before, or were created before it and never have.
+	QueryDormant(ctx context.Context, before time.Time, limit int) ([]User, error)
+}
+
+// DormantStorer interface declares the behavior DisableDormantUsers needs.
+type DormantStorer interface {
+	DormantQuerier
+	Update(ctx context.Context, orgID string, usr UpdateUser, now time.Time) (User, error)
+}
+
+// QueryDormant queries dormant users from storer, or returns
+// ErrUnsupported when it can't. Decorators use it to reach the store they
+// decorate.
+func QueryDormant(ctx context.Context, storer Storer, before time.Time, limit int) ([]User, error) {
+	dq, ok := storer.(DormantQuerier)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return dq.QueryDormant(ctx, before, limit)
+}
+
+// DeletedPurger is implemented by storers that keep deleted users until
+// they are purged.
+type DeletedPurger interface {
+	// PurgeDeleted permanently removes up to limit users deleted before
+	// before and returns how many it removed.
+	PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// PurgeDeleted purges deleted users from storer, or returns ErrUnsupported
+// when it can't. Decorators use it to reach the store they decorate.
+func PurgeDeleted(ctx context.Context, storer Storer, before time.Time, limit int) (int, error) {
+	dp, ok := storer.(DeletedPurger)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	return dp.PurgeDeleted(ctx, before, limit)
+}
+
+// DisableDormantUsers returns a job that disables the users who haven't
+// signed in for the inactive period.
+func DisableDormantUsers(log *zap.SugaredLogger, storer DormantStorer, inactive time.Duration) JobFunc {
+	const batch = 100
+
+	return func(ctx context.Context, now time.Time) error {
+		before := now.Add(-inactive)
+		disabled := false
+
+		var count int
+		for {
+			usrs, err := storer.QueryDormant(ctx, before, batch)
+			if err != nil {
+				return fmt.Errorf("querydormant: %w", err)
+			}
+
+			var errs []error
+			for _, usr := range usrs {
+				email := usr.Email
+				if _, err := storer.Update(ctx, usr.OrgID.String(), UpdateUser{Email: &email, Enabled: &disabled}, now); err != nil {
+					errs = append(errs, fmt.Errorf("disabling user[%s]: %w", usr.ID, err))
+					continue
+				}
+				log.Infow("disabled dormant user", "user_id", usr.ID, "org_id", usr.OrgID, "last_login_at", usr.LastLoginAt)
+				count++
+			}
+
+			// Stop on errors, or the users that failed would be queried
+			// again forever.
+			if len(errs) > 0 {
+				return errors.Join(errs...)
+			}
+			if len(usrs) < batch {
+				log.Infow("disabled dormant users", "count", count)
+				return nil
+			}
+		}
+	}
+}
+
+// PurgeDeletedUsers returns a job that permanently removes the users
+// deleted longer than retention ago.
+func PurgeDeletedUsers(log *zap.SugaredLogger, storer DeletedPurger, retention time.Duration) JobFunc {
+	const batch = 100
+
+	return func(ctx context.Context, now time.Time) error {
+		before := now.Add(-retention)
+
+		var count int
+		for {
+			n, err := storer.PurgeDeleted(ctx, before, batch)
+			if err != nil {
+				return fmt.Errorf("purgedeleted: %w", err)
+			}
+			count += n
+			if n < batch {
+				log.Infow("purged deleted users", "count", count)
+				return nil
+			}
+		}
+	}
+}
+
+// ExpireTokens returns a job that removes the tokens that have expired.
+// Expired tokens are refused anyway; this keeps them from piling up.
+func ExpireTokens(log *zap.SugaredLogger, tokens TokenStorer) JobFunc {
+	const batch = 100
+
+	return func(ctx context.Context, now time.Time) error {
+		var count int
+		for {
+			n, err := tokens.DeleteExpired(ctx, now, batch)
+			if err != nil {
+				return fmt.Errorf("deleteexpired: %w", err)
+			}
+			count += n
+			if n < batch {
+				log.Infow("removed expired tokens", "count", count)
+				return nil
+			}
+		}
+	}
+}
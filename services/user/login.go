@@ -0,0 +1,135 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/gitamped/seed/server"
+	"github.com/google/uuid"
+)
+
+// DefaultLoginHistory is the number of logins kept per user when
+// WithLoginHistory is given no limit.
+const DefaultLoginHistory = 50
+
+// ClientInfo describes the client a request came from.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// clientKey is how client info is stored/retrieved.
+const clientKey ctxKey = 2
+
+// SetClientInfo stores the client info in the context.
+func SetClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientKey, info)
+}
+
+// GetClientInfo returns the client info from the context. It is empty when
+// none was stored.
+func GetClientInfo(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientKey).(ClientInfo)
+	return info
+}
+
+// LoginStorer interface declares the behavior this package needs to
+// persist and retrieve login history.
+type LoginStorer interface {
+	// Create records a login and drops the user's logins beyond the latest
+	// keep.
+	Create(ctx context.Context, l Login, keep int) error
+	Query(ctx context.Context, orgID string, userID string, filter LoginFilter, pageNumber int, rowsPerPage int) ([]Login, error)
+}
+
+// WithLoginHistory records the latest keep sign in attempts of every user.
+func WithLoginHistory(logins LoginStorer, keep int) Option {
+	return func(u *UserServicer) {
+		if keep <= 0 {
+			keep = DefaultLoginHistory
+		}
+		u.logins = logins
+		u.loginHistory = keep
+	}
+}
+
+// QueryLoginHistory implements UserRpcService
+func (u UserServicer) QueryLoginHistory(req QueryLoginHistoryRequest, gr server.GenericRequest) QueryLoginHistoryResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryLoginHistoryResponse{Error: err.Error()}
+	}
+
+	usr, err := u.storer.QueryByID(gr.Ctx, orgID, req.UserID)
+	if err != nil {
+		return QueryLoginHistoryResponse{Error: err.Error()}
+	}
+	resp := QueryLoginHistoryResponse{LastLoginAt: usr.LastLoginAt, LastLoginIP: usr.LastLoginIP}
+	if u.logins == nil {
+		return resp
+	}
+
+	pageNumber, rowsPerPage := req.PageNumber, req.RowsPerPage
+	if pageNumber == 0 {
+		pageNumber = 1
+	}
+	if rowsPerPage == 0 {
+		rowsPerPage = 50
+	}
+
+	resp.Logins, err = u.logins.Query(gr.Ctx, orgID, req.UserID, req.Filter, pageNumber, rowsPerPage)
+	if err != nil {
+		return QueryLoginHistoryResponse{Error: err.Error()}
+	}
+	return resp
+}
+
+// recordLogin records a sign in attempt as usr. A successful attempt also
+// becomes the user's last login. Failures to record are logged rather than
+// failing the sign in.
+func (u UserServicer) recordLogin(ctx context.Context, usr User, method string, loginErr error, now time.Time) {
+	info := GetClientInfo(ctx)
+
+	if loginErr == nil {
+		if err := u.storer.UpdateLastLogin(ctx, usr.OrgID.String(), usr.Email.Address, now, info.IP); err != nil {
+			u.log.Errorw("updating last login", "user_id", usr.ID, "error", err)
+		}
+	}
+
+	if u.logins == nil {
+		return
+	}
+	l := Login{
+		ID:          uuid.New(),
+		OrgID:       usr.OrgID,
+		UserID:      usr.ID,
+		Success:     loginErr == nil,
+		Method:      method,
+		IP:          info.IP,
+		UserAgent:   info.UserAgent,
+		DateCreated: now,
+	}
+	if loginErr != nil {
+		l.Error = loginErr.Error()
+	}
+	if err := u.logins.Create(ctx, l, u.loginHistory); err != nil {
+		u.log.Errorw("recording login", "user_id", usr.ID, "error", err)
+	}
+}
+
+// QueryLoginHistoryRequest is the request object for UserService.QueryLoginHistory.
+type QueryLoginHistoryRequest struct {
+	UserID      string      `json:"userId" validate:"required"`
+	Filter      LoginFilter `json:"filter"`
+	PageNumber  int         `json:"page" validate:"gte=0"`
+	RowsPerPage int         `json:"rows" validate:"gte=0,lte=1000"`
+}
+
+// QueryLoginHistoryResponse is the response object for UserService.QueryLoginHistory.
+type QueryLoginHistoryResponse struct {
+	LastLoginAt time.Time `json:"last_login_at"`
+	LastLoginIP string    `json:"last_login_ip,omitempty"`
+	// Logins are the user's latest sign in attempts, newest first.
+	Logins []Login `json:"logins"`
+	Error  string  `json:"error,omitempty"`
+}
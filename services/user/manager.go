@@ -0,0 +1,173 @@
+package user
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+	"go.uber.org/zap"
+)
+
+// ErrManagerCycle is returned when a user would report to themselves or to
+// one of the users who report to them.
+var ErrManagerCycle = errors.New("user cannot report to themselves or their reports")
+
+// ManagerService is an API for recording who reports to whom and querying
+// the org chart.
+type ManagerService interface {
+	// SetManager sets or clears the manager of a user
+	SetManager(SetManagerRequest, server.GenericRequest) SetManagerResponse
+	// QueryDirectReports lists the users who report to a manager
+	QueryDirectReports(QueryDirectReportsRequest, server.GenericRequest) QueryDirectReportsResponse
+	// QueryReportingChain lists a user's manager, their manager's manager
+	// and so on up to the top of the org chart
+	QueryReportingChain(QueryReportingChainRequest, server.GenericRequest) QueryReportingChainResponse
+	// QueryReportingTree lists everyone who reports to a manager, directly
+	// or indirectly
+	QueryReportingTree(QueryReportingTreeRequest, server.GenericRequest) QueryReportingTreeResponse
+}
+
+// ManagerStorer interface declares the behavior this package needs to
+// persist and retrieve reporting lines.
+type ManagerStorer interface {
+	// SetManager makes managerID the manager of userID, replacing their
+	// current manager, or clears it when managerID is empty. It fails with
+	// ErrManagerCycle when managerID is userID or reports to them.
+	SetManager(ctx context.Context, orgID string, userID string, managerID string) error
+	QueryDirectReports(ctx context.Context, orgID string, managerID string) ([]User, error)
+	// QueryChain returns the managers above userID, nearest first.
+	QueryChain(ctx context.Context, orgID string, userID string) ([]User, error)
+	// QueryTree returns the users below managerID, nearest first.
+	QueryTree(ctx context.Context, orgID string, managerID string) ([]Report, error)
+}
+
+// Required to register endpoints with the Server
+type ManagerRpcService interface {
+	ManagerService
+	// Registers RPCService with Server
+	Register(s *server.Server)
+}
+
+// Implements interface
+type ManagerServicer struct {
+	log    *zap.SugaredLogger
+	storer ManagerStorer
+}
+
+// SetManager implements ManagerRpcService
+func (m ManagerServicer) SetManager(req SetManagerRequest, gr server.GenericRequest) SetManagerResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return SetManagerResponse{Error: err.Error()}
+	}
+	if req.ManagerID == req.UserID {
+		return SetManagerResponse{Error: ErrManagerCycle.Error()}
+	}
+	if err := m.storer.SetManager(gr.Ctx, orgID, req.UserID, req.ManagerID); err != nil {
+		return SetManagerResponse{Error: err.Error()}
+	}
+	return SetManagerResponse{}
+}
+
+// QueryDirectReports implements ManagerRpcService
+func (m ManagerServicer) QueryDirectReports(req QueryDirectReportsRequest, gr server.GenericRequest) QueryDirectReportsResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryDirectReportsResponse{Error: err.Error()}
+	}
+	usrs, err := m.storer.QueryDirectReports(gr.Ctx, orgID, req.ManagerID)
+	if err != nil {
+		return QueryDirectReportsResponse{Error: err.Error()}
+	}
+	return QueryDirectReportsResponse{Users: usrs}
+}
+
+// QueryReportingChain implements ManagerRpcService
+func (m ManagerServicer) QueryReportingChain(req QueryReportingChainRequest, gr server.GenericRequest) QueryReportingChainResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryReportingChainResponse{Error: err.Error()}
+	}
+	usrs, err := m.storer.QueryChain(gr.Ctx, orgID, req.UserID)
+	if err != nil {
+		return QueryReportingChainResponse{Error: err.Error()}
+	}
+	return QueryReportingChainResponse{Managers: usrs}
+}
+
+// QueryReportingTree implements ManagerRpcService
+func (m ManagerServicer) QueryReportingTree(req QueryReportingTreeRequest, gr server.GenericRequest) QueryReportingTreeResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryReportingTreeResponse{Error: err.Error()}
+	}
+	reports, err := m.storer.QueryTree(gr.Ctx, orgID, req.ManagerID)
+	if err != nil {
+		return QueryReportingTreeResponse{Error: err.Error()}
+	}
+	return QueryReportingTreeResponse{Reports: reports}
+}
+
+// Register implements ManagerRpcService
+func (m ManagerServicer) Register(s *server.Server) {
+	s.Register("ManagerService", "SetManager", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: m.SetManagerHandler})
+	s.Register("ManagerService", "QueryDirectReports", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: m.QueryDirectReportsHandler})
+	s.Register("ManagerService", "QueryReportingChain", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: m.QueryReportingChainHandler})
+	s.Register("ManagerService", "QueryReportingTree", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: m.QueryReportingTreeHandler})
+}
+
+// Create new ManagerServicer
+func NewManagerServicer(log *zap.SugaredLogger, storer ManagerStorer) ManagerRpcService {
+	return ManagerServicer{
+		log:    log,
+		storer: storer,
+	}
+}
+
+// SetManagerRequest is the request object for ManagerService.SetManager.
+type SetManagerRequest struct {
+	UserID string `json:"userId" validate:"required"`
+	// ManagerID is the user's new manager. An empty ManagerID clears it.
+	ManagerID string `json:"managerId"`
+}
+
+// SetManagerResponse is the response object for ManagerService.SetManager.
+type SetManagerResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// QueryDirectReportsRequest is the request object for ManagerService.QueryDirectReports.
+type QueryDirectReportsRequest struct {
+	ManagerID string `json:"managerId" validate:"required"`
+}
+
+// QueryDirectReportsResponse is the response object for ManagerService.QueryDirectReports.
+type QueryDirectReportsResponse struct {
+	Users []User `json:"users"`
+	Error string `json:"error,omitempty"`
+}
+
+// QueryReportingChainRequest is the request object for ManagerService.QueryReportingChain.
+type QueryReportingChainRequest struct {
+	UserID string `json:"userId" validate:"required"`
+}
+
+// QueryReportingChainResponse is the response object for ManagerService.QueryReportingChain.
+type QueryReportingChainResponse struct {
+	// Managers starts with the user's manager and ends at the top of the
+	// org chart.
+	Managers []User `json:"managers"`
+	Error    string `json:"error,omitempty"`
+}
+
+// QueryReportingTreeRequest is the request object for ManagerService.QueryReportingTree.
+type QueryReportingTreeRequest struct {
+	ManagerID string `json:"managerId" validate:"required"`
+}
+
+// QueryReportingTreeResponse is the response object for ManagerService.QueryReportingTree.
+type QueryReportingTreeResponse struct {
+	Reports []Report `json:"reports"`
+	Error   string   `json:"error,omitempty"`
+}
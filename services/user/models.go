@@ -1,6 +1,7 @@
 package user
 
 import (
+	"encoding/json"
 	"net/mail"
 	"time"
 
@@ -10,33 +11,275 @@ import (
 // User represents information about an individual user.
 type User struct {
 	ID           uuid.UUID    `json:"id"`
+	OrgID        uuid.UUID    `json:"org_id"`
 	Name         string       `json:"name"`
 	Email        mail.Address `json:"email"`
 	Roles        []Role       `json:"roles"`
 	PasswordHash []byte       `json:"password_hash"`
-	Department   string       `json:"department"`
-	Enabled      bool         `json:"enabled"`
-	DateCreated  time.Time    `json:"date_created"`
-	DateUpdated  time.Time    `json:"date_updated"`
+	// Department is the ID of the user's department.
+	Department string         `json:"department"`
+	Enabled    bool           `json:"enabled"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	// PasswordChangedAt is zero for passwords set before it was tracked.
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+	// MustChangePassword makes the user change their password at their next
+	// sign in.
+	MustChangePassword bool `json:"must_change_password"`
+	// LastLoginAt is zero when the user has never signed in.
+	LastLoginAt time.Time `json:"last_login_at"`
+	LastLoginIP string    `json:"last_login_ip,omitempty"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
 }
 
 // NewUser contains information needed to create a new user.
 type NewUser struct {
-	Name            string       `json:"name"`
-	Email           mail.Address `json:"email"`
-	Roles           []Role       `json:"roles"`
-	Department      string       `json:"department"`
-	Password        string       `json:"password"`
-	PasswordConfirm string       `json:"password_confirm"`
+	Name            string         `json:"name"`
+	Email           mail.Address   `json:"email"`
+	Roles           []Role         `json:"roles"`
+	Department      string         `json:"department"`
+	Attributes      map[string]any `json:"attributes"`
+	Password        string         `json:"password"`
+	PasswordConfirm string         `json:"password_confirm"`
 }
 
 // UpdateUser contains information needed to update a user.
 type UpdateUser struct {
-	Name            *string       `json:"name"`
-	Email           *mail.Address `json:"email"`
-	Roles           []Role        `json:"roles"`
-	Department      *string       `json:"department"`
-	Password        *string       `json:"passowrd"`
-	PasswordConfirm *string       `json:"password_confirm"`
-	Enabled         *bool         `json:"enabled"`
+	Name       *string       `json:"name"`
+	Email      *mail.Address `json:"email"`
+	Roles      []Role        `json:"roles"`
+	Department *string       `json:"department"`
+	// Password and PasswordConfirm are rejected: passwords are changed
+	// with ChangePassword, which checks the current one and the policy.
+	Password        *string `json:"password"`
+	PasswordConfirm *string `json:"password_confirm"`
+	Enabled         *bool   `json:"enabled"`
+	// MustChangePassword is set by admins to make the user change their
+	// password at their next sign in.
+	MustChangePassword *bool `json:"must_change_password"`
+	// Attributes are merged into the user's attributes. A nil value removes
+	// the attribute.
+	Attributes map[string]any `json:"attributes"`
+}
+
+// QueryFilter holds the available fields a user query can be filtered on.
+type QueryFilter struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+	// Attributes matches users whose custom attributes equal every value.
+	Attributes map[string]any `json:"attributes"`
+	// LastLoginBefore matches users who haven't signed in since the time,
+	// including users who never have.
+	LastLoginBefore *time.Time `json:"lastLoginBefore"`
+	// LastLoginAfter matches users who have signed in since the time.
+	LastLoginAfter *time.Time `json:"lastLoginAfter"`
+}
+
+// Fields users can be ordered by.
+const (
+	OrderByName        = "name"
+	OrderByEmail       = "email"
+	OrderByDateCreated = "date_created"
+	OrderByLastLoginAt = "last_login_at"
+)
+
+// Order directions.
+const (
+	ASC  = "ASC"
+	DESC = "DESC"
+)
+
+// OrderBy is the field and direction users are ordered by.
+type OrderBy struct {
+	Field     string `json:"field"`
+	Direction string `json:"direction"`
+}
+
+// DefaultOrderBy orders users by name.
+var DefaultOrderBy = OrderBy{Field: OrderByName, Direction: ASC}
+
+// Login methods.
+const (
+	LoginPassword  = "password"
+	LoginFederated = "federated"
+)
+
+// Login is an attempt to sign in as a user.
+type Login struct {
+	ID        uuid.UUID `json:"id"`
+	OrgID     uuid.UUID `json:"org_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Success   bool      `json:"success"`
+	Method    string    `json:"method"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	// Error is why a failed attempt failed.
+	Error       string    `json:"error,omitempty"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+// LoginFilter holds the available fields a login history query can be
+// filtered on.
+type LoginFilter struct {
+	Success *bool   `json:"success"`
+	Method  *string `json:"method"`
+}
+
+// Group represents a team of users.
+type Group struct {
+	ID          uuid.UUID `json:"id"`
+	OrgID       uuid.UUID `json:"org_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+// NewGroup contains information needed to create a new group.
+type NewGroup struct {
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+}
+
+// UpdateGroup contains information needed to update a group.
+type UpdateGroup struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// Report is a user in the org chart below a manager.
+type Report struct {
+	User User `json:"user"`
+	// ManagerID is the ID of the user's direct manager.
+	ManagerID string `json:"manager_id"`
+	// Depth is 1 for direct reports, 2 for their reports and so on.
+	Depth int `json:"depth"`
+}
+
+// Department is a unit of an organization. Departments form a tree.
+type Department struct {
+	ID    uuid.UUID `json:"id"`
+	OrgID uuid.UUID `json:"org_id"`
+	// ParentID is empty for top level departments.
+	ParentID    string    `json:"parent_id,omitempty"`
+	Name        string    `json:"name"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+// NewDepartment contains information needed to create a new department.
+type NewDepartment struct {
+	Name     string `json:"name" validate:"required"`
+	ParentID string `json:"parentId"`
+}
+
+// UpdateDepartment contains information needed to update a department.
+type UpdateDepartment struct {
+	Name *string `json:"name"`
+}
+
+// Organization represents a tenant. Every user and group belongs to exactly
+// one organization.
+type Organization struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+// NewOrganization contains information needed to create a new organization.
+type NewOrganization struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// UpdateOrganization contains information needed to update an organization.
+type UpdateOrganization struct {
+	Name *string `json:"name"`
+}
+
+// AttributeSchema is a JSON Schema that the custom attributes of users must
+// satisfy. A schema without an OrgID applies to the whole deployment.
+type AttributeSchema struct {
+	OrgID       string          `json:"org_id,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	DateUpdated time.Time       `json:"date_updated"`
+}
+
+// Client is an OAuth2 client that authenticates with its own credentials,
+// such as a backend job, rather than on behalf of a user.
+type Client struct {
+	ID    uuid.UUID `json:"id"`
+	OrgID uuid.UUID `json:"org_id"`
+	Name  string    `json:"name"`
+	Roles []Role    `json:"roles"`
+	// RedirectURIs are where the authorization endpoint may send ID tokens
+	// issued to the client.
+	RedirectURIs []string  `json:"redirect_uris,omitempty"`
+	SecretHash   []byte    `json:"-"`
+	Revoked      bool      `json:"revoked"`
+	DateCreated  time.Time `json:"date_created"`
+	DateUpdated  time.Time `json:"date_updated"`
+}
+
+// NewClient contains information needed to register a new client.
+type NewClient struct {
+	Name string `json:"name" validate:"required"`
+	// Roles are the most a token issued to the client may carry.
+	Roles []Role `json:"roles" validate:"required"`
+	// RedirectURIs are the absolute URLs ID tokens may be sent to by the
+	// authorization endpoint.
+	RedirectURIs []string `json:"redirectUris"`
+}
+
+// Webhook is an endpoint that is sent the events of an organization.
+type Webhook struct {
+	ID    uuid.UUID `json:"id"`
+	OrgID uuid.UUID `json:"org_id"`
+	URL   string    `json:"url"`
+	// Events are the event types sent to the endpoint. All events are sent
+	// when it is empty.
+	Events []string `json:"events"`
+	// Secret signs the payloads sent to the endpoint.
+	Secret      string    `json:"-"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+// NewWebhook contains information needed to register a new webhook.
+type NewWebhook struct {
+	URL    string   `json:"url" validate:"required,url"`
+	Events []string `json:"events"`
+}
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryFailed    = "failed"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// Delivery records the attempts to send an event to a webhook. Deliveries
+// that run out of attempts are dead and form the dead-letter queue.
+type Delivery struct {
+	ID             uuid.UUID       `json:"id"`
+	OrgID          uuid.UUID       `json:"org_id"`
+	WebhookID      uuid.UUID       `json:"webhook_id"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at,omitempty"`
+	DateCreated    time.Time       `json:"date_created"`
+	DateUpdated    time.Time       `json:"date_updated"`
+}
+
+// DeliveryFilter holds the available fields a delivery query can be
+// filtered on.
+type DeliveryFilter struct {
+	WebhookID *string `json:"webhookId"`
+	Status    *string `json:"status"`
 }
@@ -0,0 +1,57 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Errors returned by UserServicer.IDToken. They tell the authorization
+// endpoint whether to ask for the credentials again or turn the user away.
+var (
+	ErrInvalidCredentials     = errors.New("invalid email or password")
+	ErrPasswordChangeRequired = errors.New("password must be changed")
+)
+
+// idTokenTTL is how long an ID token issued by the authorization endpoint
+// is valid.
+const idTokenTTL = time.Hour
+
+// IDToken implements UserRpcService. The user must belong to the client's
+// organization. Users who must change their password are turned away, since
+// they can't sign in anywhere until they have. The ID token identifies the
+// user to the client and carries no roles: it is addressed to the client,
+// so the API refuses it.
+func (u UserServicer) IDToken(ctx context.Context, clt Client, email string, password string, nonce string) (string, error) {
+	now := time.Now().UTC()
+
+	usr, err := u.checkPassword(ctx, clt.OrgID.String(), email, password, now)
+	switch {
+	case errors.Is(err, ErrUserDisabled):
+		return "", err
+	case err != nil:
+		return "", fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+	if u.passwordChangeRequired(usr, now) {
+		return "", ErrPasswordChangeRequired
+	}
+
+	claims, err := u.newClaims(ctx, usr, idTokenTTL)
+	if err != nil {
+		return "", err
+	}
+	claims.Audience = jwt.ClaimStrings{clt.ID.String()}
+	claims.Roles = nil
+	claims.Nonce = nonce
+
+	tkn, err := u.signer.GenerateToken(claims)
+	if err != nil {
+		return "", fmt.Errorf("generatetoken: %w", err)
+	}
+
+	u.log.Infow("id token issued", "client_id", clt.ID, "user_id", usr.ID, "org_id", usr.OrgID)
+	return tkn, nil
+}
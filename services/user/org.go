@@ -0,0 +1,183 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gitamped/seed/server"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrNoTenant is returned when a request is not scoped to an organization.
+var ErrNoTenant = errors.New("request is not scoped to an organization")
+
+// OrgService is an API for managing the organizations hosted by a deployment.
+type OrgService interface {
+	// CreateOrg creates an organization
+	CreateOrg(CreateOrgRequest, server.GenericRequest) CreateOrgResponse
+	// UpdateOrg updates an organization
+	UpdateOrg(UpdateOrgRequest, server.GenericRequest) UpdateOrgResponse
+	// DeleteOrg deletes an organization that has no users
+	DeleteOrg(DeleteOrgRequest, server.GenericRequest) DeleteOrgResponse
+	// QueryOrgByID gets the specified organization by id
+	QueryOrgByID(QueryOrgByIDRequest, server.GenericRequest) QueryOrgByIDResponse
+	// QueryOrgs retrieves all organizations
+	QueryOrgs(QueryOrgsRequest, server.GenericRequest) QueryOrgsResponse
+}
+
+// OrgStorer interface declares the behavior this package needs to persist
+// and retrieve organizations.
+type OrgStorer interface {
+	Create(ctx context.Context, org Organization) (Organization, error)
+	Update(ctx context.Context, id string, uo UpdateOrganization, now time.Time) (Organization, error)
+	Delete(ctx context.Context, id string) (Organization, error)
+	QueryByID(ctx context.Context, id string) (Organization, error)
+	Query(ctx context.Context) ([]Organization, error)
+}
+
+// Required to register endpoints with the Server
+type OrgRpcService interface {
+	OrgService
+	// Registers RPCService with Server
+	Register(s *server.Server)
+}
+
+// Implements interface
+type OrgServicer struct {
+	log    *zap.SugaredLogger
+	storer OrgStorer
+}
+
+// CreateOrg implements OrgRpcService
+func (o OrgServicer) CreateOrg(req CreateOrgRequest, gr server.GenericRequest) CreateOrgResponse {
+	org := Organization{
+		ID:          uuid.New(),
+		Name:        req.NewOrganization.Name,
+		DateCreated: gr.Values.Now,
+		DateUpdated: gr.Values.Now,
+	}
+	result, err := o.storer.Create(gr.Ctx, org)
+	if err != nil {
+		return CreateOrgResponse{Error: err.Error()}
+	}
+	return CreateOrgResponse{Organization: result}
+}
+
+// UpdateOrg implements OrgRpcService
+func (o OrgServicer) UpdateOrg(req UpdateOrgRequest, gr server.GenericRequest) UpdateOrgResponse {
+	org, err := o.storer.Update(gr.Ctx, req.ID, req.UpdateOrganization, gr.Values.Now)
+	if err != nil {
+		return UpdateOrgResponse{Error: err.Error()}
+	}
+	return UpdateOrgResponse{Organization: org}
+}
+
+// DeleteOrg implements OrgRpcService
+func (o OrgServicer) DeleteOrg(req DeleteOrgRequest, gr server.GenericRequest) DeleteOrgResponse {
+	org, err := o.storer.Delete(gr.Ctx, req.ID)
+	if err != nil {
+		return DeleteOrgResponse{Error: err.Error()}
+	}
+	return DeleteOrgResponse{Organization: org}
+}
+
+// QueryOrgByID implements OrgRpcService
+func (o OrgServicer) QueryOrgByID(req QueryOrgByIDRequest, gr server.GenericRequest) QueryOrgByIDResponse {
+	org, err := o.storer.QueryByID(gr.Ctx, req.ID)
+	if err != nil {
+		return QueryOrgByIDResponse{Error: err.Error()}
+	}
+	return QueryOrgByIDResponse{Organization: org}
+}
+
+// QueryOrgs implements OrgRpcService
+func (o OrgServicer) QueryOrgs(req QueryOrgsRequest, gr server.GenericRequest) QueryOrgsResponse {
+	orgs, err := o.storer.Query(gr.Ctx)
+	if err != nil {
+		return QueryOrgsResponse{Error: err.Error()}
+	}
+	return QueryOrgsResponse{Organizations: orgs}
+}
+
+// Register implements OrgRpcService
+func (o OrgServicer) Register(s *server.Server) {
+	s.Register("OrgService", "CreateOrg", server.RPCEndpoint{Roles: []string{RoleSuperAdmin.Name()}, Handler: o.CreateOrgHandler})
+	s.Register("OrgService", "UpdateOrg", server.RPCEndpoint{Roles: []string{RoleSuperAdmin.Name()}, Handler: o.UpdateOrgHandler})
+	s.Register("OrgService", "DeleteOrg", server.RPCEndpoint{Roles: []string{RoleSuperAdmin.Name()}, Handler: o.DeleteOrgHandler})
+	s.Register("OrgService", "QueryOrgByID", server.RPCEndpoint{Roles: []string{RoleSuperAdmin.Name()}, Handler: o.QueryOrgByIDHandler})
+	s.Register("OrgService", "QueryOrgs", server.RPCEndpoint{Roles: []string{RoleSuperAdmin.Name()}, Handler: o.QueryOrgsHandler})
+}
+
+// Create new OrgServicer
+func NewOrgServicer(log *zap.SugaredLogger, storer OrgStorer) OrgRpcService {
+	return OrgServicer{
+		log:    log,
+		storer: storer,
+	}
+}
+
+// tenant returns the organization a request is scoped to. It is taken from
+// the caller's token so a caller can never address another tenant's data.
+func tenant(gr server.GenericRequest) (string, error) {
+	claims, err := GetClaims(gr.Ctx)
+	if err != nil || claims.OrgID == "" {
+		return "", ErrNoTenant
+	}
+	return claims.OrgID, nil
+}
+
+// CreateOrgRequest is the request object for OrgService.CreateOrg.
+type CreateOrgRequest struct {
+	NewOrganization NewOrganization `json:"newOrg"`
+}
+
+// CreateOrgResponse is the response object for OrgService.CreateOrg.
+type CreateOrgResponse struct {
+	Organization Organization `json:"org"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// UpdateOrgRequest is the request object for OrgService.UpdateOrg.
+type UpdateOrgRequest struct {
+	ID                 string             `json:"id" validate:"required"`
+	UpdateOrganization UpdateOrganization `json:"org"`
+}
+
+// UpdateOrgResponse is the response object for OrgService.UpdateOrg.
+type UpdateOrgResponse struct {
+	Organization Organization `json:"org"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// DeleteOrgRequest is the request object for OrgService.DeleteOrg.
+type DeleteOrgRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// DeleteOrgResponse is the response object for OrgService.DeleteOrg.
+type DeleteOrgResponse struct {
+	Organization Organization `json:"org"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// QueryOrgByIDRequest is the request object for OrgService.QueryOrgByID.
+type QueryOrgByIDRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// QueryOrgByIDResponse is the response object for OrgService.QueryOrgByID.
+type QueryOrgByIDResponse struct {
+	Organization Organization `json:"org"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// QueryOrgsRequest is the request object for OrgService.QueryOrgs.
+type QueryOrgsRequest struct{}
+
+// QueryOrgsResponse is the response object for OrgService.QueryOrgs.
+type QueryOrgsResponse struct {
+	Organizations []Organization `json:"orgs"`
+	Error         string         `json:"error,omitempty"`
+}
@@ -0,0 +1,192 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordChangeTTL is how long a token restricted to changing the
+// password is valid.
+const passwordChangeTTL = 15 * time.Minute
+
+// PasswordPolicy sets how long passwords stay valid. A password expires
+// after the shortest max age among the user's roles and department; users
+// none of them apply to keep their passwords indefinitely.
+type PasswordPolicy struct {
+	// RoleMaxAge is keyed by role name.
+	RoleMaxAge map[string]time.Duration
+	// DepartmentMaxAge is keyed by department ID.
+	DepartmentMaxAge map[string]time.Duration
+}
+
+// ParsePasswordPolicy parses a policy from JSON with durations in Go
+// syntax, such as {"roles": {"ADMIN": "720h"}, "departments": {"<department id>": "2160h"}}.
+func ParsePasswordPolicy(b []byte) (PasswordPolicy, error) {
+	var raw struct {
+		Roles       map[string]string `json:"roles"`
+		Departments map[string]string `json:"departments"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return PasswordPolicy{}, fmt.Errorf("parsing password policy: %w", err)
+	}
+
+	parse := func(in map[string]string) (map[string]time.Duration, error) {
+		out := make(map[string]time.Duration, len(in))
+		for k, v := range in {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("parsing password policy: %s: %w", k, err)
+			}
+			out[k] = d
+		}
+		return out, nil
+	}
+
+	roles, err := parse(raw.Roles)
+	if err != nil {
+		return PasswordPolicy{}, err
+	}
+	departments, err := parse(raw.Departments)
+	if err != nil {
+		return PasswordPolicy{}, err
+	}
+	return PasswordPolicy{RoleMaxAge: roles, DepartmentMaxAge: departments}, nil
+}
+
+// MaxAge returns the max age of usr's password, if it has one.
+func (p PasswordPolicy) MaxAge(usr User) (time.Duration, bool) {
+	var maxAge time.Duration
+	found := false
+	consider := func(d time.Duration, ok bool) {
+		if ok && d > 0 && (!found || d < maxAge) {
+			maxAge, found = d, true
+		}
+	}
+
+	for _, role := range usr.Roles {
+		d, ok := p.RoleMaxAge[role.Name()]
+		consider(d, ok)
+	}
+	if usr.Department != "" {
+		d, ok := p.DepartmentMaxAge[usr.Department]
+		consider(d, ok)
+	}
+	return maxAge, found
+}
+
+// WithPasswordPolicy expires passwords according to policy.
+func WithPasswordPolicy(policy PasswordPolicy) Option {
+	return func(u *UserServicer) {
+		u.passwords = policy
+	}
+}
+
+// passwordChangeRequired reports whether usr must change their password
+// before they are issued a full token.
+func (u UserServicer) passwordChangeRequired(usr User, now time.Time) bool {
+	if usr.MustChangePassword {
+		return true
+	}
+	maxAge, ok := u.passwords.MaxAge(usr)
+	if !ok {
+		return false
+	}
+
+	// Passwords set before their change time was tracked count from the
+	// user's creation.
+	changed := usr.PasswordChangedAt
+	if changed.IsZero() {
+		changed = usr.DateCreated
+	}
+	return !now.Before(changed.Add(maxAge))
+}
+
+// passwordChangeToken issues a token that carries no roles, so it is
+// refused by every endpoint but ChangePassword.
+func (u UserServicer) passwordChangeToken(usr User) (string, error) {
+	claims := Claims{
+		Claims: auth.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   usr.ID.String(),
+				Issuer:    u.signer.issuer,
+				Audience:  jwt.ClaimStrings{u.signer.audience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(passwordChangeTTL)),
+				IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			},
+		},
+		Email:          usr.Email.Address,
+		OrgID:          usr.OrgID.String(),
+		PasswordChange: true,
+	}
+	tkn, err := u.signer.GenerateToken(claims)
+	if err != nil {
+		return "", fmt.Errorf("generatetoken: %w", err)
+	}
+	return tkn, nil
+}
+
+// ChangePassword implements UserRpcService
+func (u UserServicer) ChangePassword(req ChangePasswordRequest, gr server.GenericRequest) ChangePasswordResponse {
+	claims, err := GetClaims(gr.Ctx)
+	if err != nil || claims.Subject == "" || claims.ClientID != "" {
+		return ChangePasswordResponse{Error: "authentication required"}
+	}
+	if claims.Act != nil {
+		return ChangePasswordResponse{Error: ErrImpersonated.Error()}
+	}
+	if req.Password != req.PasswordConfirm {
+		return ChangePasswordResponse{Error: "passwords do not match"}
+	}
+
+	usr, err := u.storer.QueryByID(gr.Ctx, claims.OrgID, claims.Subject)
+	if err != nil {
+		return ChangePasswordResponse{Error: err.Error()}
+	}
+	if err := bcrypt.CompareHashAndPassword(usr.PasswordHash, []byte(req.CurrentPassword)); err != nil {
+		return ChangePasswordResponse{Error: "current password is incorrect"}
+	}
+	if req.Password == req.CurrentPassword {
+		return ChangePasswordResponse{Error: "new password must differ from the current password"}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return ChangePasswordResponse{Error: fmt.Errorf("generatefrompassword: %w", err).Error()}
+	}
+	usr, err = u.storer.UpdatePassword(gr.Ctx, claims.OrgID, usr.Email.Address, hash, gr.Values.Now)
+	if err != nil {
+		return ChangePasswordResponse{Error: err.Error()}
+	}
+	u.publish(gr.Ctx, EventUserUpdated, usr, gr.Values.Now)
+
+	// The restricted token has served its purpose; issue a full one.
+	full, err := u.newClaims(gr.Ctx, usr, time.Hour)
+	if err != nil {
+		return ChangePasswordResponse{Error: err.Error()}
+	}
+	tkn, err := u.signer.GenerateToken(full)
+	if err != nil {
+		return ChangePasswordResponse{Error: fmt.Errorf("generatetoken: %w", err).Error()}
+	}
+	return ChangePasswordResponse{Token: tkn}
+}
+
+// ChangePasswordRequest is the request object for UserService.ChangePassword.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+	PasswordConfirm string `json:"passwordConfirm" validate:"required"`
+}
+
+// ChangePasswordResponse is the response object for UserService.ChangePassword.
+type ChangePasswordResponse struct {
+	// Token is a full token for the user.
+	Token string `json:"token"`
+	Error string `json:"error,omitempty"`
+}
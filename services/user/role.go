@@ -1,17 +1,31 @@
 package user
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+)
 
 // Set of possible roles for a user.
 var (
-	RoleAdmin = Role{"ADMIN"}
-	RoleUser  = Role{"USER"}
+	RoleSuperAdmin = Role{"SUPER_ADMIN"}
+	RoleAdmin      = Role{"ADMIN"}
+	RoleUser       = Role{"USER"}
 )
 
 // Set of known roles.
 var roles = map[string]Role{
-	RoleAdmin.name: RoleAdmin,
-	RoleUser.name:  RoleUser,
+	RoleSuperAdmin.name: RoleSuperAdmin,
+	RoleAdmin.name:      RoleAdmin,
+	RoleUser.name:       RoleUser,
+}
+
+// ranks orders the known roles by privilege.
+var ranks = map[Role]int{
+	RoleUser:       1,
+	RoleAdmin:      2,
+	RoleSuperAdmin: 3,
 }
 
 // Role represents a role in the system.
@@ -60,3 +74,28 @@ func (r Role) MarshalText() ([]byte, error) {
 func (r Role) Equal(r2 Role) bool {
 	return r.name == r2.name
 }
+
+// authorizeRoles checks that the roles exist and that the caller may grant
+// them. Nobody can be granted more than the admin granting it holds, so
+// only a super admin may grant RoleSuperAdmin.
+func authorizeRoles(roles []Role, gr server.GenericRequest) error {
+	for _, r := range roles {
+		if _, err := ParseRole(r.Name()); err != nil {
+			return err
+		}
+		if r.Equal(RoleSuperAdmin) && !gr.Claims.Authorized(RoleSuperAdmin.Name()) {
+			return auth.ErrForbidden
+		}
+	}
+	return nil
+}
+
+// holds reports whether the claims carry the role or one ranked above it.
+func holds(claims auth.Claims, r Role) bool {
+	for _, name := range claims.Roles {
+		if has, err := ParseRole(name); err == nil && ranks[has] >= ranks[r] {
+			return true
+		}
+	}
+	return false
+}
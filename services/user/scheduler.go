@@ -0,0 +1,291 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gitamped/seed/server"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// DefaultJobTimeout bounds a job run when its Timeout is not set.
+const DefaultJobTimeout = time.Hour
+
+// Job run statuses.
+const (
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// JobFunc does the work of a job. now is the scheduled time of the run.
+type JobFunc func(ctx context.Context, now time.Time) error
+
+// Job is work run on a cron schedule.
+type Job struct {
+	Name string
+	// Spec is a cron spec, see ParseSchedule.
+	Spec string
+	Run  JobFunc
+	// Timeout cancels a run that takes too long. It also bounds how long
+	// the run holds its lock should the scheduler die. DefaultJobTimeout
+	// when zero.
+	Timeout time.Duration
+}
+
+// JobRun records a run of a job.
+type JobRun struct {
+	ID  uuid.UUID `json:"id"`
+	Job string    `json:"job"`
+	// Owner identifies the scheduler that ran the job.
+	Owner       string    `json:"owner"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// JobStorer interface declares the behavior the scheduler needs to
+// coordinate runs and persist their history.
+type JobStorer interface {
+	// Lock acquires the lock of the job's run scheduled at slot for owner
+	// until the given time. It returns false when the run has already been
+	// made or another owner holds the lock.
+	Lock(ctx context.Context, job string, owner string, slot time.Time, until time.Time) (bool, error)
+	Unlock(ctx context.Context, job string, owner string) error
+	CreateRun(ctx context.Context, run JobRun) (JobRun, error)
+	QueryRuns(ctx context.Context, job string, pageNumber int, rowsPerPage int) ([]JobRun, error)
+}
+
+// Scheduler runs jobs on their cron schedules. Every replica runs its own
+// scheduler; the lock taken through the JobStorer makes sure each scheduled
+// run is made by only one of them.
+type Scheduler struct {
+	log    *zap.SugaredLogger
+	storer JobStorer
+	owner  string
+
+	mu   sync.Mutex
+	jobs map[string]*scheduledJob
+}
+
+type scheduledJob struct {
+	Job
+	schedule Schedule
+	next     time.Time
+}
+
+// NewScheduler constructs a Scheduler.
+func NewScheduler(log *zap.SugaredLogger, storer JobStorer) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		log:    log,
+		storer: storer,
+		owner:  host + "/" + uuid.NewString(),
+		jobs:   map[string]*scheduledJob{},
+	}
+}
+
+// Add schedules a job. Job names must be unique.
+func (s *Scheduler) Add(job Job) error {
+	schedule, err := ParseSchedule(job.Spec)
+	if err != nil {
+		return err
+	}
+	if job.Timeout <= 0 {
+		job.Timeout = DefaultJobTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.Name]; ok {
+		return fmt.Errorf("job %q already scheduled", job.Name)
+	}
+	s.jobs[job.Name] = &scheduledJob{Job: job, schedule: schedule}
+	return nil
+}
+
+// Run runs the jobs as they come due until ctx is canceled, then waits for
+// the runs in progress to finish. Runs missed while the scheduler wasn't
+// running are skipped.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		now := time.Now()
+		var wake time.Time
+		var due []*scheduledJob
+
+		s.mu.Lock()
+		for _, job := range s.jobs {
+			if job.next.IsZero() {
+				job.next = job.schedule.Next(now)
+			}
+			if !job.next.After(now) {
+				due = append(due, job)
+				continue
+			}
+			if wake.IsZero() || job.next.Before(wake) {
+				wake = job.next
+			}
+		}
+		s.mu.Unlock()
+
+		for _, job := range due {
+			wg.Add(1)
+			go func(job Job, slot time.Time) {
+				defer wg.Done()
+				if _, err := s.RunJob(ctx, job.Name, slot); err != nil {
+					s.log.Errorw("running job", "job", job.Name, "error", err)
+				}
+			}(job.Job, job.next)
+
+			s.mu.Lock()
+			job.next = job.schedule.Next(now)
+			s.mu.Unlock()
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		delay := time.Minute
+		if !wake.IsZero() {
+			delay = time.Until(wake)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// RunJob makes the named job's run scheduled at slot, unless another
+// scheduler already has. It reports whether this scheduler made the run.
+// A failed run is recorded in the history and is not an error.
+func (s *Scheduler) RunJob(ctx context.Context, name string, slot time.Time) (bool, error) {
+	s.mu.Lock()
+	job, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return false, fmt.Errorf("unknown job %q", name)
+	}
+
+	started := time.Now()
+	locked, err := s.storer.Lock(ctx, name, s.owner, slot, started.Add(job.Timeout))
+	if err != nil {
+		return false, fmt.Errorf("locking: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		if err := s.storer.Unlock(context.Background(), name, s.owner); err != nil {
+			s.log.Errorw("unlocking job", "job", name, "error", err)
+		}
+	}()
+
+	runCtx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+	runErr := runSafely(runCtx, job.Run, slot)
+
+	run := JobRun{
+		ID:          uuid.New(),
+		Job:         name,
+		Owner:       s.owner,
+		ScheduledAt: slot,
+		Status:      JobSucceeded,
+		StartedAt:   started,
+		FinishedAt:  time.Now(),
+	}
+	if runErr != nil {
+		run.Status = JobFailed
+		run.Error = runErr.Error()
+		s.log.Errorw("job failed", "job", name, "error", runErr)
+	}
+	if _, err := s.storer.CreateRun(context.Background(), run); err != nil {
+		return true, fmt.Errorf("recording run: %w", err)
+	}
+	return true, nil
+}
+
+// runSafely runs fn, turning a panic into an error so a broken job can't
+// take the process down.
+func runSafely(ctx context.Context, fn JobFunc, now time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, now)
+}
+
+// JobService is an API for inspecting scheduled jobs.
+type JobService interface {
+	// QueryJobRuns gets the run history of a job, newest first
+	QueryJobRuns(QueryJobRunsRequest, server.GenericRequest) QueryJobRunsResponse
+}
+
+// Required to register endpoints with the Server
+type JobRpcService interface {
+	JobService
+	// Registers RPCService with Server
+	Register(s *server.Server)
+}
+
+// Implements interface
+type JobServicer struct {
+	log    *zap.SugaredLogger
+	storer JobStorer
+}
+
+// QueryJobRuns implements JobRpcService
+func (j JobServicer) QueryJobRuns(req QueryJobRunsRequest, gr server.GenericRequest) QueryJobRunsResponse {
+	pageNumber, rowsPerPage := req.PageNumber, req.RowsPerPage
+	if pageNumber == 0 {
+		pageNumber = 1
+	}
+	if rowsPerPage == 0 {
+		rowsPerPage = 50
+	}
+
+	runs, err := j.storer.QueryRuns(gr.Ctx, req.Job, pageNumber, rowsPerPage)
+	if err != nil {
+		return QueryJobRunsResponse{Error: err.Error()}
+	}
+	return QueryJobRunsResponse{Runs: runs}
+}
+
+// Register implements JobRpcService. Jobs span organizations, so only
+// super admins can see them.
+func (j JobServicer) Register(s *server.Server) {
+	s.Register("JobService", "QueryJobRuns", server.RPCEndpoint{Roles: []string{RoleSuperAdmin.Name()}, Handler: j.QueryJobRunsHandler})
+}
+
+// Create new JobServicer
+func NewJobServicer(log *zap.SugaredLogger, storer JobStorer) JobRpcService {
+	return JobServicer{
+		log:    log,
+		storer: storer,
+	}
+}
+
+// QueryJobRunsRequest is the request object for JobService.QueryJobRuns.
+type QueryJobRunsRequest struct {
+	Job         string `json:"job" validate:"required"`
+	PageNumber  int    `json:"page" validate:"gte=0"`
+	RowsPerPage int    `json:"rows" validate:"gte=0,lte=1000"`
+}
+
+// QueryJobRunsResponse is the response object for JobService.QueryJobRuns.
+type QueryJobRunsResponse struct {
+	Runs  []JobRun `json:"runs"`
+	Error string   `json:"error,omitempty"`
+}
@@ -0,0 +1,216 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/gitamped/seed/server"
+)
+
+const (
+	// DefaultSearchLimit is the number of results returned when a search
+	// doesn't set a limit.
+	DefaultSearchLimit = 20
+	// MaxSearchLimit is the most results a search returns.
+	MaxSearchLimit = 100
+)
+
+// Highlighted matches are wrapped in these tags.
+const (
+	HighlightPre  = "<em>"
+	HighlightPost = "</em>"
+)
+
+// ErrSearchUnavailable is returned by SearchUsers when no Searcher was
+// configured.
+var ErrSearchUnavailable = errors.New("search is not available")
+
+// Searcher interface declares the behavior this package needs to search
+// users. A user matches when every term matches a word of their name, email
+// or department name, either as a prefix or within Fuzziness(term) edits.
+// Results are ordered by relevance.
+type Searcher interface {
+	Search(ctx context.Context, orgID string, terms []string, limit int) ([]SearchResult, error)
+}
+
+// WithSearch enables SearchUsers.
+func WithSearch(searcher Searcher) Option {
+	return func(u *UserServicer) {
+		u.searcher = searcher
+	}
+}
+
+// SearchResult is a user that matched a search.
+type SearchResult struct {
+	User User `json:"user"`
+	// Score is the relevance of the user. It is only comparable between
+	// results of the same search.
+	Score float64 `json:"score"`
+	// Highlights holds the matched fields, keyed by name, HTML escaped with
+	// the matching words wrapped in HighlightPre and HighlightPost. The
+	// department is only highlighted when WithDepartments is used.
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// SearchUsers implements UserRpcService
+func (u UserServicer) SearchUsers(req SearchUsersRequest, gr server.GenericRequest) SearchUsersResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return SearchUsersResponse{Error: err.Error()}
+	}
+	if u.searcher == nil {
+		return SearchUsersResponse{Error: ErrSearchUnavailable.Error()}
+	}
+
+	terms := SearchTerms(req.Query)
+	if len(terms) == 0 {
+		return SearchUsersResponse{Error: "query has no words"}
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	results, err := u.searcher.Search(gr.Ctx, orgID, terms, limit)
+	if err != nil {
+		return SearchUsersResponse{Error: err.Error()}
+	}
+	// Users reference departments by ID, so look up the names to highlight.
+	departments := make(map[string]string)
+	for i := range results {
+		id := results[i].User.Department
+		if _, ok := departments[id]; !ok && id != "" && u.departments != nil {
+			if dept, err := u.departments.QueryByID(gr.Ctx, orgID, id); err == nil {
+				departments[id] = dept.Name
+			}
+		}
+		results[i].Highlights = highlights(results[i].User, departments[id], terms)
+	}
+	return SearchUsersResponse{Results: results}
+}
+
+// SearchTerms splits a query into the lower case words that are searched
+// for.
+func SearchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), isSeparator)
+}
+
+// Fuzziness is the number of edits a word may be from term and still match
+// it. Short terms have to match exactly, since a single edit changes too
+// much of them.
+func Fuzziness(term string) int {
+	switch n := len([]rune(term)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// highlights marks the words of usr's searchable fields, including the
+// name of their department, that match terms.
+func highlights(usr User, department string, terms []string) map[string]string {
+	fields := map[string]string{
+		"name":       usr.Name,
+		"email":      usr.Email.Address,
+		"department": department,
+	}
+
+	hl := make(map[string]string)
+	for name, value := range fields {
+		if marked, ok := highlight(value, terms); ok {
+			hl[name] = marked
+		}
+	}
+	return hl
+}
+
+// highlight wraps the words of s that match any of terms. It reports
+// whether any did.
+func highlight(s string, terms []string) (string, bool) {
+	var b strings.Builder
+	matched := false
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		j := i
+		for j < len(rs) && isSeparator(rs[j]) == isSeparator(rs[i]) {
+			j++
+		}
+		part := string(rs[i:j])
+		if !isSeparator(rs[i]) && matchesAny(strings.ToLower(part), terms) {
+			b.WriteString(HighlightPre + html.EscapeString(part) + HighlightPost)
+			matched = true
+		} else {
+			b.WriteString(html.EscapeString(part))
+		}
+		i = j
+	}
+	return b.String(), matched
+}
+
+func matchesAny(word string, terms []string) bool {
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) || levenshtein(word, term) <= Fuzziness(term) {
+			return true
+		}
+	}
+	return false
+}
+
+// levenshtein returns the number of single rune edits that turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// SearchUsersRequest is the request object for UserService.SearchUsers.
+type SearchUsersRequest struct {
+	Query string `json:"query" validate:"required"`
+	Limit int    `json:"limit"`
+}
+
+// SearchUsersResponse is the response object for UserService.SearchUsers.
+type SearchUsersResponse struct {
+	Results []SearchResult `json:"results"`
+	Error   string         `json:"error,omitempty"`
+}
@@ -0,0 +1,46 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/gitamped/stem/data/nosql/dbtest"
+)
+
+func Test_Highlight(t *testing.T) {
+	tests := []struct {
+		value string
+		query string
+		want  string
+	}{
+		{"Jane Doe", "jan", "<em>Jane</em> Doe"},
+		{"Jane Doe", "DOE", "Jane <em>Doe</em>"},
+		{"Jonathan Smith", "jonatan", "<em>Jonathan</em> Smith"},
+		{"Jonathan Smith", "smyth jon", "<em>Jonathan</em> <em>Smith</em>"},
+		{"jane.doe@example.com", "examp", "jane.doe@<em>example</em>.com"},
+		{"Research & Development", "devel", "Research &amp; <em>Development</em>"},
+		{"Éloïse Dubois", "éloïse", "<em>Éloïse</em> Dubois"},
+		{"Sales", "tax", ""},
+		{"Bob", "rob", ""},
+	}
+
+	t.Log("Given the need to highlight search matches.")
+	{
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen searching %q for %q.", testID, tt.value, tt.query)
+			{
+				got, ok := highlight(tt.value, SearchTerms(tt.query))
+				if tt.want == "" {
+					if ok {
+						t.Fatalf("\t%s\tTest %d:\tShould not match : got %s.", dbtest.Failed, testID, got)
+					}
+					t.Logf("\t%s\tTest %d:\tShould not match.", dbtest.Success, testID)
+					continue
+				}
+				if !ok || got != tt.want {
+					t.Fatalf("\t%s\tTest %d:\tShould highlight %s : got %s.", dbtest.Failed, testID, tt.want, got)
+				}
+				t.Logf("\t%s\tTest %d:\tShould highlight %s.", dbtest.Success, testID, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package user_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/services/user/stores/cache"
+	"github.com/gitamped/bud/services/user/stores/instrument"
+	"github.com/gitamped/bud/services/user/stores/memory"
+	"github.com/gitamped/bud/services/user/stores/retry"
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/keystore"
+	"github.com/gitamped/seed/server"
+	"github.com/gitamped/seed/values"
+	"github.com/gitamped/stem/data/nosql/dbtest"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// The tests in this file run the servicers against the in-memory stores, so
+// they run without Docker.
+
+// memoryRequest builds a request from a caller in the organization holding
+// the roles.
+func memoryRequest(orgID string, now time.Time, roles ...string) server.GenericRequest {
+	claims := user.Claims{Claims: auth.Claims{Roles: roles}, OrgID: orgID}
+	claims.Subject = uuid.NewString()
+	return server.GenericRequest{
+		Ctx:    user.SetClaims(context.Background(), claims),
+		Claims: claims.Claims,
+		Values: &values.Values{Now: now},
+	}
+}
+
+func Test_GrantRoles(t *testing.T) {
+	core := user.NewUserServicer(zap.NewNop().Sugar(), memory.NewStore(), newSigner(t))
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	orgID := uuid.NewString()
+	admin := memoryRequest(orgID, now, auth.RoleAdmin)
+	super := memoryRequest(orgID, now, user.RoleSuperAdmin.Name())
+
+	newUser := func(email string, roles ...user.Role) user.CreateUserRequest {
+		return user.CreateUserRequest{NewUser: user.NewUser{
+			Name:            "Jane Doe",
+			Email:           mail.Address{Address: email},
+			Roles:           roles,
+			Password:        "gophers",
+			PasswordConfirm: "gophers",
+		}}
+	}
+	var bogus user.Role
+	bogus.UnmarshalText([]byte("ROOT"))
+
+	t.Log("Given the need to keep admins from granting more than they hold.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen an admin creates a user.", testID)
+		{
+			if resp := core.CreateUser(newUser("super@example.com", user.RoleSuperAdmin), admin); resp.Error != auth.ErrForbidden.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould forbid granting SUPER_ADMIN : got %+v.", dbtest.Failed, testID, resp)
+			}
+			if resp := core.CreateUser(newUser("root@example.com", bogus), admin); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject unknown roles : got %+v.", dbtest.Failed, testID, resp)
+			}
+			if resp := core.CreateUser(newUser("admin@example.com", user.RoleAdmin), admin); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to grant ADMIN : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only grant the roles the admin holds.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen an admin updates a user.", testID)
+		{
+			created := core.CreateUser(newUser("jane@example.com", user.RoleUser), admin)
+			if created.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, created.Error)
+			}
+
+			uu := user.UpdateUserRequest{UpdateUser: user.UpdateUser{Email: &created.User.Email, Roles: []user.Role{user.RoleSuperAdmin}}}
+			if resp := core.UpdateUser(uu, admin); resp.Error != auth.ErrForbidden.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould forbid promoting to SUPER_ADMIN : got %+v.", dbtest.Failed, testID, resp)
+			}
+			uu.UpdateUser.Roles = []user.Role{bogus}
+			if resp := core.UpdateUser(uu, admin); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject unknown roles : got %+v.", dbtest.Failed, testID, resp)
+			}
+			q := core.QueryUserByID(user.QueryUserByIDRequest{ID: created.User.ID.String()}, admin)
+			if len(q.User.Roles) != 1 || !q.User.Roles[0].Equal(user.RoleUser) {
+				t.Fatalf("\t%s\tTest %d:\tShould leave the roles unchanged : got %v.", dbtest.Failed, testID, q.User.Roles)
+			}
+
+			uu.UpdateUser.Roles = []user.Role{user.RoleSuperAdmin}
+			if resp := core.UpdateUser(uu, super); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould let a super admin grant SUPER_ADMIN : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only let a super admin grant SUPER_ADMIN.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_ImpersonationLimits(t *testing.T) {
+	log := zap.NewNop().Sugar()
+	signer := newSigner(t)
+	core := user.NewUserServicer(log, memory.NewStore(), signer)
+	clients := user.NewClientServicer(log, nil, signer)
+	webhooks := user.NewWebhookServicer(log, nil, nil)
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	orgID := uuid.NewString()
+	admin := memoryRequest(orgID, now, auth.RoleAdmin)
+	super := memoryRequest(orgID, now, user.RoleSuperAdmin.Name())
+
+	create := func(email string, role user.Role) user.User {
+		t.Helper()
+		resp := core.CreateUser(user.CreateUserRequest{NewUser: user.NewUser{
+			Name:            "Jane Doe",
+			Email:           mail.Address{Address: email},
+			Roles:           []user.Role{role},
+			Password:        "gophers",
+			PasswordConfirm: "gophers",
+		}}, super)
+		if resp.Error != "" {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", dbtest.Failed, resp.Error)
+		}
+		return resp.User
+	}
+	target := create("target@example.com", user.RoleAdmin)
+	root := create("root@example.com", user.RoleSuperAdmin)
+
+	t.Log("Given the need to limit what impersonation allows.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen an admin impersonates someone with more privileges.", testID)
+		{
+			if resp := core.ImpersonateUser(user.ImpersonateUserRequest{UserID: root.ID.String()}, admin); resp.Error != auth.ErrForbidden.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould forbid impersonating a super admin : got %+v.", dbtest.Failed, testID, resp)
+			}
+			if resp := core.ImpersonateUser(user.ImpersonateUserRequest{UserID: root.ID.String()}, super); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould let a super admin impersonate a super admin : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only impersonate users the admin outranks or equals.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen an impersonation token is used for credentials.", testID)
+		{
+			iu := core.ImpersonateUser(user.ImpersonateUserRequest{UserID: target.ID.String()}, admin)
+			if iu.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to impersonate user : %s.", dbtest.Failed, testID, iu.Error)
+			}
+			claims, err := signer.ValidateToken(iu.Token)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to validate token : %s.", dbtest.Failed, testID, err)
+			}
+			impersonated := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), claims),
+				Claims: claims.Claims,
+				Values: &values.Values{Now: now},
+			}
+
+			errs := map[string]string{
+				"ChangePassword":     core.ChangePassword(user.ChangePasswordRequest{CurrentPassword: "gophers", Password: "hijacked", PasswordConfirm: "hijacked"}, impersonated).Error,
+				"CreateClient":       clients.CreateClient(user.CreateClientRequest{NewClient: user.NewClient{Name: "backdoor"}}, impersonated).Error,
+				"RotateClientSecret": clients.RotateClientSecret(user.RotateClientSecretRequest{ID: uuid.NewString()}, impersonated).Error,
+				"CreateWebhook":      webhooks.CreateWebhook(user.CreateWebhookRequest{NewWebhook: user.NewWebhook{URL: "https://example.com/hook"}}, impersonated).Error,
+			}
+			for method, err := range errs {
+				if err != user.ErrImpersonated.Error() {
+					t.Fatalf("\t%s\tTest %d:\tShould refuse %s while impersonating : got %q.", dbtest.Failed, testID, method, err)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse to change credentials while impersonating.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_TokenAudience(t *testing.T) {
+	const kid = "4754d86b-7a6d-4df5-9c65-224741361492"
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := keystore.NewMap(map[string]*rsa.PrivateKey{kid: key})
+	newSigner := func(opts ...user.SignerOption) *user.Signer {
+		t.Helper()
+		signer, err := user.NewSigner(kid, keys, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+
+	log := zap.NewNop().Sugar()
+	signer := newSigner(user.WithIssuer("https://bud.example.com"), user.WithAudience("https://api.example.com"))
+	storer := memory.NewStore()
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	orgID := uuid.NewString()
+
+	admin := memoryRequest(orgID, now, auth.RoleAdmin)
+	core := user.NewUserServicer(log, storer, signer)
+	created := core.CreateUser(user.CreateUserRequest{NewUser: user.NewUser{
+		Name:            "Jane Doe",
+		Email:           mail.Address{Address: "jane@example.com"},
+		Roles:           []user.Role{user.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}}, admin)
+	if created.Error != "" {
+		t.Fatalf("\t%s\tShould be able to create user : %s.", dbtest.Failed, created.Error)
+	}
+
+	t.Log("Given the need to only accept access tokens addressed to the API.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a user authenticates.", testID)
+		{
+			au := core.Authenticate(user.AuthenticateRequest{OrgID: orgID, Username: "jane@example.com", Password: "gophers"}, admin)
+			claims, err := signer.ValidateToken(au.Token)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to validate token : %v %s.", dbtest.Failed, testID, err, au.Error)
+			}
+			if claims.Issuer != "https://bud.example.com" || len(claims.Audience) != 1 || claims.Audience[0] != "https://api.example.com" {
+				t.Fatalf("\t%s\tTest %d:\tShould set the iss and aud claims : got %v %v.", dbtest.Failed, testID, claims.Issuer, claims.Audience)
+			}
+			t.Logf("\t%s\tTest %d:\tShould set the iss and aud claims.", dbtest.Success, testID)
+
+			if _, err := newSigner(user.WithIssuer("https://bud.example.com")).ValidateToken(au.Token); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould reject a token for another audience.", dbtest.Failed, testID)
+			}
+			if _, err := newSigner(user.WithAudience("https://api.example.com")).ValidateToken(au.Token); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould reject a token from another issuer.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject tokens for another audience or from another issuer.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a user signs in to a client.", testID)
+		{
+			clt := user.Client{ID: uuid.New(), OrgID: uuid.MustParse(orgID)}
+			tkn, err := core.IDToken(context.Background(), clt, "jane@example.com", "gophers", "nonce")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to issue an ID token : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := signer.ValidateToken(tkn); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould not accept the ID token as an access token.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not accept the ID token as an access token.", dbtest.Success, testID)
+		}
+	}
+}
+
+// outboxStore stands in for a store that writes events to an outbox.
+type outboxStore struct {
+	user.Storer
+}
+
+func (outboxStore) WritesOutbox() bool { return true }
+
+func Test_EventsWithOutbox(t *testing.T) {
+	log := zap.NewNop().Sugar()
+	signer := newSigner(t)
+	events := user.NewWriterSink(io.Discard)
+
+	panics := func(storer user.Storer) (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		user.NewUserServicer(log, storer, signer, user.WithEvents(events))
+		return false
+	}
+
+	t.Log("Given the need to publish every event once.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen events are published by the servicer.", testID)
+		{
+			if !panics(outboxStore{memory.NewStore()}) {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse a store that writes an outbox.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse a store that writes an outbox.", dbtest.Success, testID)
+			if !panics(cache.NewStore(outboxStore{memory.NewStore()}, cache.DefaultConfig)) {
+				t.Fatalf("\t%s\tTest %d:\tShould see the outbox through decorators.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould see the outbox through decorators.", dbtest.Success, testID)
+			if panics(memory.NewStore()) {
+				t.Fatalf("\t%s\tTest %d:\tShould accept a store without an outbox.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould accept a store without an outbox.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Events(t *testing.T) {
+	log := zap.NewNop().Sugar()
+	first, second := &recordingSink{}, &recordingSink{fail: true}
+	file := filepath.Join(t.TempDir(), "events.jsonl")
+	fileSink, err := user.OpenFileSink(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fileSink.Close() })
+	users := user.NewUserServicer(log, memory.NewStore(), newSigner(t), user.WithEvents(user.Sinks{first, second, fileSink}))
+
+	t.Log("Given the need to publish user events without an outbox.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a user changes.", testID)
+		{
+			gr := memoryRequest(uuid.NewString(), time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC), auth.RoleAdmin)
+
+			email, _ := mail.ParseAddress("events@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Events User"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			if resp := users.CreateUser(nu, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not fail the change when a sink fails.", dbtest.Success, testID)
+
+			if resp := users.CreateUser(nu, gr); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject a duplicate user.", dbtest.Failed, testID)
+			}
+			disabled := false
+			if resp := users.UpdateUser(user.UpdateUserRequest{UpdateUser: user.UpdateUser{Email: email, Enabled: &disabled}}, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to disable user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			if resp := users.DeleteUser(user.DeleteUserRequest{User: user.User{Email: *email}}, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+
+			want := []string{user.EventUserCreated, user.EventUserDisabled, user.EventUserDeleted}
+			if len(first.events) != len(want) {
+				t.Fatalf("\t%s\tTest %d:\tShould publish an event for each change : got %+v.", dbtest.Failed, testID, first.events)
+			}
+			for i, evt := range first.events {
+				if evt.Type != want[i] || evt.Data.User.Email.Address != email.Address {
+					t.Fatalf("\t%s\tTest %d:\tShould publish the events in order : got %+v.", dbtest.Failed, testID, first.events)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould publish the events in order.", dbtest.Success, testID)
+
+			b, _ := os.ReadFile(file)
+			if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != len(want) {
+				t.Fatalf("\t%s\tTest %d:\tShould publish to the sinks after a failing one : got %s.", dbtest.Failed, testID, b)
+			}
+			t.Logf("\t%s\tTest %d:\tShould publish to the sinks after a failing one.", dbtest.Success, testID)
+		}
+	}
+}
+
+// plainStore hides everything but user.Storer from the store it embeds.
+type plainStore struct {
+	user.Storer
+}
+
+func Test_DecoratorsUnsupported(t *testing.T) {
+	transient := func(error) bool { return false }
+	stacks := map[string]user.Storer{
+		"cache":             cache.NewStore(plainStore{memory.NewStore()}, cache.DefaultConfig),
+		"instrument":        instrument.NewStore(plainStore{memory.NewStore()}),
+		"retry":             retry.NewStore(zap.NewNop().Sugar(), plainStore{memory.NewStore()}, transient, retry.DefaultConfig),
+		"cache over retry":  cache.NewStore(instrument.NewStore(retry.NewStore(zap.NewNop().Sugar(), plainStore{memory.NewStore()}, transient, retry.DefaultConfig)), cache.DefaultConfig),
+		"retry over cache":  retry.NewStore(zap.NewNop().Sugar(), instrument.NewStore(cache.NewStore(plainStore{memory.NewStore()}, cache.DefaultConfig)), transient, retry.DefaultConfig),
+		"supported (cache)": cache.NewStore(memory.NewStore(), cache.DefaultConfig),
+	}
+
+	t.Log("Given the need to tell when a decorated store can't query dormant or purge deleted users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen stacking decorators.", testID)
+		{
+			for name, storer := range stacks {
+				_, err := user.QueryDormant(context.Background(), storer, time.Now(), 10)
+				if want := name != "supported (cache)"; errors.Is(err, user.ErrUnsupported) != want {
+					t.Fatalf("\t%s\tTest %d:\tShould report ErrUnsupported the same way whatever the order, %s : got %v.", dbtest.Failed, testID, name, err)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould report ErrUnsupported the same way whatever the order.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen purging deleted users through stacked decorators.", testID)
+		{
+			for name, storer := range stacks {
+				_, err := user.PurgeDeleted(context.Background(), storer, time.Now(), 10)
+				if want := name != "supported (cache)"; errors.Is(err, user.ErrUnsupported) != want {
+					t.Fatalf("\t%s\tTest %d:\tShould report ErrUnsupported the same way whatever the order, %s : got %v.", dbtest.Failed, testID, name, err)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould report ErrUnsupported the same way whatever the order.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_UpdateUser(t *testing.T) {
+	core := user.NewUserServicer(zap.NewNop().Sugar(), memory.NewStore(), newSigner(t))
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	orgID := uuid.NewString()
+
+	t.Log("Given the need to update users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen an admin updates a user.", testID)
+		{
+			nu := user.CreateUserRequest{NewUser: user.NewUser{
+				Name:            "Jane Doe",
+				Email:           mail.Address{Address: "jane@example.com"},
+				Roles:           []user.Role{user.RoleUser},
+				Password:        "gophers",
+				PasswordConfirm: "gophers",
+			}}
+			created := core.CreateUser(nu, memoryRequest(orgID, now, auth.RoleAdmin))
+			if created.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, created.Error)
+			}
+
+			later := now.Add(time.Hour)
+			admin := memoryRequest(orgID, later, auth.RoleAdmin)
+			password := "hijacked"
+			uu := user.UpdateUserRequest{UpdateUser: user.UpdateUser{Email: &created.User.Email, Password: &password, PasswordConfirm: &password}}
+			if resp := core.UpdateUser(uu, admin); resp.Error != user.ErrPasswordUpdate.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse to set a password : got %+v.", dbtest.Failed, testID, resp)
+			}
+			au := core.Authenticate(user.AuthenticateRequest{OrgID: orgID, Username: "jane@example.com", Password: "gophers"}, admin)
+			if au.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the password : %s.", dbtest.Failed, testID, au.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse to set a password.", dbtest.Success, testID)
+
+			name := "Jane Roe"
+			resp := core.UpdateUser(user.UpdateUserRequest{UpdateUser: user.UpdateUser{Email: &created.User.Email, Name: &name}}, admin)
+			if resp.Error != "" || !resp.User.DateUpdated.Equal(later) || !resp.User.DateCreated.Equal(now) {
+				t.Fatalf("\t%s\tTest %d:\tShould set the update date : got %+v.", dbtest.Failed, testID, resp)
+			}
+			t.Logf("\t%s\tTest %d:\tShould set the update date.", dbtest.Success, testID)
+		}
+	}
+}
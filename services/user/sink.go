@@ -0,0 +1,78 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Sinks publishes events to every sink in turn, so WithEvents can feed
+// more than one. A sink failing doesn't keep the event from the others.
+type Sinks []EventPublisher
+
+// Publish implements EventPublisher.
+func (s Sinks) Publish(ctx context.Context, evt Event) error {
+	var errs []error
+	for _, sink := range s {
+		if err := sink.Publish(ctx, evt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriterSink publishes events as JSON lines to a writer, such as
+// os.Stdout.
+type WriterSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriterSink constructs a WriterSink writing to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{enc: json.NewEncoder(w)}
+}
+
+// Publish implements EventPublisher.
+func (s *WriterSink) Publish(ctx context.Context, evt Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(evt)
+}
+
+// FileSink appends events as JSON lines to a file. Every event is synced to
+// disk before Publish returns, so an event the outbox has let go of can't
+// be lost with the page cache.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// OpenFileSink opens the file at path for appending, creating it if needed.
+func OpenFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening event file: %w", err)
+	}
+	return &FileSink{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Publish implements EventPublisher.
+func (s *FileSink) Publish(ctx context.Context, evt Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(evt); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
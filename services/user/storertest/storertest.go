@@ -0,0 +1,604 @@
+// Package storertest checks that implementations of user.Storer behave the
+// same. Each store runs the suite from its own tests:
+//
+//	func Test_Conformance(t *testing.T) {
+//		storertest.Run(t, memory.NewStore(), storertest.Errors{
+//			NotFound:              memory.ErrNotFound,
+//			UniqueEmail:           memory.ErrUniqueEmail,
+//			AuthenticationFailure: memory.ErrAuthenticationFailure,
+//		})
+//	}
+package storertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/stem/data/nosql/dbtest"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors are the errors the store under test returns. The suite checks
+// returned errors with errors.Is, so stores may wrap them.
+type Errors struct {
+	// NotFound is returned for users that don't exist.
+	NotFound error
+	// UniqueEmail is returned when a user is created with an email that is
+	// already taken in the organization.
+	UniqueEmail error
+	// AuthenticationFailure is returned for a wrong password.
+	AuthenticationFailure error
+}
+
+// password is the password of every user the suite creates.
+const password = "gophers"
+
+// Run runs the suite against the store. Every test works in organizations
+// of its own, so the store may hold other data and be shared with other
+// tests.
+func Run(t *testing.T, store user.Storer, errs Errors) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generating password hash: %s", err)
+	}
+	s := suite{store: store, errs: errs, hash: hash}
+
+	t.Run("Create", s.create)
+	t.Run("Query", s.query)
+	t.Run("Update", s.update)
+	t.Run("Delete", s.delete)
+	t.Run("Authenticate", s.authenticate)
+	t.Run("Password", s.password)
+	t.Run("LastLogin", s.lastLogin)
+	t.Run("Dormant", s.dormant)
+	t.Run("Concurrency", s.concurrency)
+}
+
+type suite struct {
+	store user.Storer
+	errs  Errors
+	hash  []byte
+}
+
+// now is when the suite's users are created. It has no monotonic reading
+// and no sub-second part, so it survives any store's time precision.
+var now = time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+// newUser returns a user of the organization that isn't stored yet.
+func (s suite) newUser(orgID uuid.UUID, name string) user.User {
+	return user.User{
+		ID:                uuid.New(),
+		OrgID:             orgID,
+		Name:              name,
+		Email:             mail.Address{Name: name, Address: name + "@example.com"},
+		Roles:             []user.Role{user.RoleUser},
+		PasswordHash:      s.hash,
+		PasswordChangedAt: now,
+		Department:        "engineering",
+		Enabled:           true,
+		Attributes:        map[string]any{"level": 3.0, "address": map[string]any{"city": "Oslo", "zip": "0150"}},
+		DateCreated:       now,
+		DateUpdated:       now,
+	}
+}
+
+func (s suite) create(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	t.Log("Given the need to create users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen creating a user.", testID)
+		{
+			want := s.newUser(orgID, "bill")
+			got, err := s.store.Create(ctx, want)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+			}
+			if diff := compare(got, want); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould return the user as created : %s.", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create user.", dbtest.Success, testID)
+
+			dup := s.newUser(orgID, "bill")
+			if _, err := s.store.Create(ctx, dup); !errors.Is(err, s.errs.UniqueEmail) {
+				t.Fatalf("\t%s\tTest %d:\tShould reject a duplicate email : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject a duplicate email.", dbtest.Success, testID)
+
+			other := s.newUser(uuid.New(), "bill")
+			if _, err := s.store.Create(ctx, other); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould allow the same email in another organization : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould allow the same email in another organization.", dbtest.Success, testID)
+		}
+	}
+}
+
+func (s suite) query(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	t.Log("Given the need to query users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen querying by id and email.", testID)
+		{
+			want, err := s.store.Create(ctx, s.newUser(orgID, "bill"))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+			}
+
+			got, err := s.store.QueryByID(ctx, orgID.String(), want.ID.String())
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query by id : %s.", dbtest.Failed, testID, err)
+			}
+			if diff := compare(got, want); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get the user by id : %s.", dbtest.Failed, testID, diff)
+			}
+			got, err = s.store.QueryByEmail(ctx, orgID.String(), want.Email.Address)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query by email : %s.", dbtest.Failed, testID, err)
+			}
+			if diff := compare(got, want); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get the user by email : %s.", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to query by id and email.", dbtest.Success, testID)
+
+			if _, err := s.store.QueryByID(ctx, orgID.String(), uuid.NewString()); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not find an unknown id : got %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := s.store.QueryByEmail(ctx, orgID.String(), "nobody@example.com"); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not find an unknown email : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not find unknown users.", dbtest.Success, testID)
+
+			other := uuid.NewString()
+			if _, err := s.store.QueryByID(ctx, other, want.ID.String()); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not find users of another organization by id : got %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := s.store.QueryByEmail(ctx, other, want.Email.Address); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not find users of another organization by email : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not find users of another organization.", dbtest.Success, testID)
+		}
+	}
+}
+
+func (s suite) update(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	t.Log("Given the need to update users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen updating some fields of a user.", testID)
+		{
+			want, err := s.store.Create(ctx, s.newUser(orgID, "bill"))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+			}
+
+			name := "William"
+			later := now.Add(time.Hour)
+			got, err := s.store.Update(ctx, orgID.String(), user.UpdateUser{Email: &want.Email, Name: &name}, later)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update the name : %s.", dbtest.Failed, testID, err)
+			}
+			want.Name = name
+			want.DateUpdated = later
+			if diff := compare(got, want); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould only change the name : %s.", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only change the fields that are set.", dbtest.Success, testID)
+
+			enabled := false
+			got, err = s.store.Update(ctx, orgID.String(), user.UpdateUser{
+				Email:      &want.Email,
+				Roles:      []user.Role{user.RoleAdmin},
+				Enabled:    &enabled,
+				Attributes: map[string]any{"level": nil, "address": map[string]any{"zip": "0151"}, "team": "core"},
+			}, later)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update attributes : %s.", dbtest.Failed, testID, err)
+			}
+			want.Roles = []user.Role{user.RoleAdmin}
+			want.Enabled = false
+			want.Attributes = map[string]any{"address": map[string]any{"city": "Oslo", "zip": "0151"}, "team": "core"}
+			if diff := compare(got, want); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould merge attributes and remove nil ones : %s.", dbtest.Failed, testID, diff)
+			}
+			stored, err := s.store.QueryByEmail(ctx, orgID.String(), want.Email.Address)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the user : %s.", dbtest.Failed, testID, err)
+			}
+			if diff := compare(stored, want); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould store the update : %s.", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould merge attributes.", dbtest.Success, testID)
+
+			nobody := mail.Address{Address: "nobody@example.com"}
+			if _, err := s.store.Update(ctx, orgID.String(), user.UpdateUser{Email: &nobody, Name: &name}, later); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not update an unknown user : got %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := s.store.Update(ctx, uuid.NewString(), user.UpdateUser{Email: &want.Email, Name: &name}, later); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not update users of another organization : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not update unknown users.", dbtest.Success, testID)
+		}
+	}
+}
+
+func (s suite) delete(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	t.Log("Given the need to delete users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen deleting a user.", testID)
+		{
+			want, err := s.store.Create(ctx, s.newUser(orgID, "bill"))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+			}
+
+			if _, err := s.store.Delete(ctx, uuid.NewString(), want.Email); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not delete users of another organization : got %v.", dbtest.Failed, testID, err)
+			}
+			got, err := s.store.Delete(ctx, orgID.String(), want.Email)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, err)
+			}
+			if diff := compare(got, want); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould return the deleted user : %s.", dbtest.Failed, testID, diff)
+			}
+			if _, err := s.store.QueryByID(ctx, orgID.String(), want.ID.String()); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not find the deleted user : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete user.", dbtest.Success, testID)
+
+			if _, err := s.store.Delete(ctx, orgID.String(), want.Email); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not delete a missing user : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not delete a missing user.", dbtest.Success, testID)
+
+			if _, err := s.store.Create(ctx, s.newUser(orgID, "bill")); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reuse the email : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to reuse the email.", dbtest.Success, testID)
+		}
+	}
+}
+
+func (s suite) authenticate(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	t.Log("Given the need to authenticate users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen signing in.", testID)
+		{
+			want, err := s.store.Create(ctx, s.newUser(orgID, "bill"))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+			}
+
+			got, err := s.store.Authenticate(ctx, orgID.String(), want.Email.Address, password)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould authenticate with the right password : %s.", dbtest.Failed, testID, err)
+			}
+			if got.ID != want.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould return the user : got %s, want %s.", dbtest.Failed, testID, got.ID, want.ID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould authenticate with the right password.", dbtest.Success, testID)
+
+			if _, err := s.store.Authenticate(ctx, orgID.String(), want.Email.Address, "wrong"); !errors.Is(err, s.errs.AuthenticationFailure) {
+				t.Fatalf("\t%s\tTest %d:\tShould reject a wrong password : got %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := s.store.Authenticate(ctx, orgID.String(), "nobody@example.com", password); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould reject an unknown email : got %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := s.store.Authenticate(ctx, uuid.NewString(), want.Email.Address, password); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould reject users of another organization : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject wrong credentials.", dbtest.Success, testID)
+		}
+	}
+}
+
+func (s suite) password(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	t.Log("Given the need to change passwords.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen replacing a user's password.", testID)
+		{
+			usr := s.newUser(orgID, "bill")
+			usr.MustChangePassword = true
+			want, err := s.store.Create(ctx, usr)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+			}
+
+			hash, err := bcrypt.GenerateFromPassword([]byte("gophers2"), bcrypt.MinCost)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to hash the password : %s.", dbtest.Failed, testID, err)
+			}
+			later := now.Add(time.Hour)
+			got, err := s.store.UpdatePassword(ctx, orgID.String(), want.Email.Address, hash, later)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update the password : %s.", dbtest.Failed, testID, err)
+			}
+			want.PasswordHash = hash
+			want.PasswordChangedAt = later
+			want.MustChangePassword = false
+			want.DateUpdated = later
+			if diff := compare(got, want); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould replace the hash and clear the flag : %s.", dbtest.Failed, testID, diff)
+			}
+			if _, err := s.store.Authenticate(ctx, orgID.String(), want.Email.Address, "gophers2"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould authenticate with the new password : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := s.store.Authenticate(ctx, orgID.String(), want.Email.Address, password); !errors.Is(err, s.errs.AuthenticationFailure) {
+				t.Fatalf("\t%s\tTest %d:\tShould reject the old password : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould replace the password.", dbtest.Success, testID)
+
+			if _, err := s.store.UpdatePassword(ctx, uuid.NewString(), want.Email.Address, hash, later); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not update users of another organization : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not update unknown users.", dbtest.Success, testID)
+		}
+	}
+}
+
+func (s suite) lastLogin(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	t.Log("Given the need to track sign ins.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a user signs in.", testID)
+		{
+			want, err := s.store.Create(ctx, s.newUser(orgID, "bill"))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+			}
+
+			at := now.Add(time.Hour)
+			if err := s.store.UpdateLastLogin(ctx, orgID.String(), want.Email.Address, at, "203.0.113.7"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to record the sign in : %s.", dbtest.Failed, testID, err)
+			}
+			got, err := s.store.QueryByEmail(ctx, orgID.String(), want.Email.Address)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the user : %s.", dbtest.Failed, testID, err)
+			}
+			want.LastLoginAt = at
+			want.LastLoginIP = "203.0.113.7"
+			if diff := compare(got, want); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould only record the sign in : %s.", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record the sign in.", dbtest.Success, testID)
+
+			if err := s.store.UpdateLastLogin(ctx, uuid.NewString(), want.Email.Address, at, ""); !errors.Is(err, s.errs.NotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not update users of another organization : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not update unknown users.", dbtest.Success, testID)
+		}
+	}
+}
+
+// dormant checks QueryDormant, which stores implement for the job that
+// disables dormant users. Stores without it are skipped.
+func (s suite) dormant(t *testing.T) {
+	dq, ok := s.store.(user.DormantQuerier)
+	if !ok {
+		t.Skip("store can't query dormant users")
+	}
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	t.Log("Given the need to find dormant users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen users stop signing in.", testID)
+		{
+			// The users are older than every other user of the suite, so
+			// the query only finds users of this test.
+			created := now.AddDate(-2, 0, 0)
+			before := now.AddDate(-1, 0, 0)
+			logins := map[string]time.Time{
+				"never":    {},
+				"inactive": before.Add(-time.Hour),
+				"active":   before.Add(time.Hour),
+				"disabled": {},
+			}
+			for name, at := range logins {
+				usr := s.newUser(orgID, name)
+				usr.DateCreated = created
+				usr.Enabled = name != "disabled"
+				if _, err := s.store.Create(ctx, usr); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+				}
+				if !at.IsZero() {
+					if err := s.store.UpdateLastLogin(ctx, orgID.String(), usr.Email.Address, at, ""); err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to record the sign in : %s.", dbtest.Failed, testID, err)
+					}
+				}
+			}
+
+			usrs, err := dq.QueryDormant(ctx, before, 100)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query dormant users : %s.", dbtest.Failed, testID, err)
+			}
+			found := map[string]bool{}
+			for _, usr := range usrs {
+				if usr.OrgID == orgID {
+					found[usr.Name] = true
+				}
+			}
+			if len(found) != 2 || !found["never"] || !found["inactive"] {
+				t.Fatalf("\t%s\tTest %d:\tShould find enabled users who haven't signed in since before : got %v.", dbtest.Failed, testID, found)
+			}
+			t.Logf("\t%s\tTest %d:\tShould find enabled users who haven't signed in since before.", dbtest.Success, testID)
+
+			if usrs, err := dq.QueryDormant(ctx, before, 1); err != nil || len(usrs) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould respect the limit : got %d, %v.", dbtest.Failed, testID, len(usrs), err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould respect the limit.", dbtest.Success, testID)
+		}
+	}
+}
+
+func (s suite) concurrency(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+	const writers = 20
+
+	t.Log("Given the need to write users concurrently.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen creating the same email concurrently.", testID)
+		{
+			var created, rejected int
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			errs := make(chan error, writers)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := s.store.Create(ctx, s.newUser(orgID, "bill"))
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						created++
+					case errors.Is(err, s.errs.UniqueEmail):
+						rejected++
+					default:
+						errs <- err
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatalf("\t%s\tTest %d:\tShould only fail with a unique email error : %s.", dbtest.Failed, testID, err)
+			}
+			if created != 1 || rejected != writers-1 {
+				t.Fatalf("\t%s\tTest %d:\tShould create the user once : created %d, rejected %d.", dbtest.Failed, testID, created, rejected)
+			}
+			t.Logf("\t%s\tTest %d:\tShould create the user once.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen creating and updating different users concurrently.", testID)
+		{
+			var wg sync.WaitGroup
+			errs := make(chan error, writers)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					usr, err := s.store.Create(ctx, s.newUser(orgID, fmt.Sprintf("user%d", i)))
+					if err != nil {
+						errs <- err
+						return
+					}
+					name := fmt.Sprintf("User %d", i)
+					if _, err := s.store.Update(ctx, orgID.String(), user.UpdateUser{Email: &usr.Email, Name: &name}, now); err != nil {
+						errs <- err
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to write users concurrently : %s.", dbtest.Failed, testID, err)
+			}
+
+			for i := 0; i < writers; i++ {
+				usr, err := s.store.QueryByEmail(ctx, orgID.String(), fmt.Sprintf("user%d@example.com", i))
+				if err != nil || usr.Name != fmt.Sprintf("User %d", i) {
+					t.Fatalf("\t%s\tTest %d:\tShould keep every write : got %q, %v.", dbtest.Failed, testID, usr.Name, err)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep every write.", dbtest.Success, testID)
+		}
+	}
+}
+
+// compare describes how got differs from want, or returns "" when they
+// are the same user. Times are compared as instants and attributes as
+// JSON, since stores return them in their own location and number types.
+func compare(got, want user.User) string {
+	var diffs []string
+	add := func(field string, got, want any) {
+		diffs = append(diffs, fmt.Sprintf("%s: got %v, want %v", field, got, want))
+	}
+
+	if got.ID != want.ID {
+		add("ID", got.ID, want.ID)
+	}
+	if got.OrgID != want.OrgID {
+		add("OrgID", got.OrgID, want.OrgID)
+	}
+	if got.Name != want.Name {
+		add("Name", got.Name, want.Name)
+	}
+	if got.Email != want.Email {
+		add("Email", got.Email, want.Email)
+	}
+	if fmt.Sprint(got.Roles) != fmt.Sprint(want.Roles) {
+		add("Roles", got.Roles, want.Roles)
+	}
+	if string(got.PasswordHash) != string(want.PasswordHash) {
+		add("PasswordHash", string(got.PasswordHash), string(want.PasswordHash))
+	}
+	if got.Department != want.Department {
+		add("Department", got.Department, want.Department)
+	}
+	if got.Enabled != want.Enabled {
+		add("Enabled", got.Enabled, want.Enabled)
+	}
+	if fmt.Sprint(got.Attributes) != fmt.Sprint(want.Attributes) {
+		add("Attributes", got.Attributes, want.Attributes)
+	}
+	if !got.PasswordChangedAt.Equal(want.PasswordChangedAt) {
+		add("PasswordChangedAt", got.PasswordChangedAt, want.PasswordChangedAt)
+	}
+	if got.MustChangePassword != want.MustChangePassword {
+		add("MustChangePassword", got.MustChangePassword, want.MustChangePassword)
+	}
+	if !got.LastLoginAt.Equal(want.LastLoginAt) {
+		add("LastLoginAt", got.LastLoginAt, want.LastLoginAt)
+	}
+	if got.LastLoginIP != want.LastLoginIP {
+		add("LastLoginIP", got.LastLoginIP, want.LastLoginIP)
+	}
+	if !got.DateCreated.Equal(want.DateCreated) {
+		add("DateCreated", got.DateCreated, want.DateCreated)
+	}
+	if !got.DateUpdated.Equal(want.DateUpdated) {
+		add("DateUpdated", got.DateUpdated, want.DateUpdated)
+	}
+
+	if len(diffs) == 0 {
+		return ""
+	}
+	return fmt.Sprint(diffs)
+}
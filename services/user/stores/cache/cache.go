@@ -0,0 +1,320 @@
+// Package cache decorates a user.Storer with a bounded, expiring cache of
+// users by id and by email. Changes made through the decorator invalidate
+// the cache; changes made elsewhere, such as by another instance, are
+// seen once the cached user expires.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"net/mail"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+// Config configures the cache.
+type Config struct {
+	// Size is the number of users kept. The least recently used user is
+	// evicted to make room.
+	Size int
+	// TTL is how long a user is kept after it was fetched.
+	TTL time.Duration
+	// FetchTimeout bounds a fetch from the decorated store. Fetches are
+	// shared by every caller waiting for the user, so they don't stop when
+	// the caller that started them gives up.
+	FetchTimeout time.Duration
+}
+
+// DefaultConfig keeps a short TTL, so users changed by other instances
+// aren't stale for long.
+var DefaultConfig = Config{
+	Size:         10000,
+	TTL:          30 * time.Second,
+	FetchTimeout: 10 * time.Second,
+}
+
+// Stats counts how the cache has been used.
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	// Entries is the number of users cached now.
+	Entries int `json:"entries"`
+}
+
+// Store is a user.Storer that caches QueryByID and QueryByEmail. The other
+// methods go to the decorated store, and those that change users
+// invalidate them.
+type Store struct {
+	user.Storer
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List // of *entry, most recently used first
+	byID    map[string]*list.Element
+	byEmail map[string]*list.Element
+	// gen changes on every invalidation, so fetches that started before
+	// one don't cache what they read.
+	gen   uint64
+	calls map[string]*call
+
+	hits, misses, evictions atomic.Uint64
+}
+
+type entry struct {
+	idKey    string
+	emailKey string
+	usr      user.User
+	expires  time.Time
+}
+
+// call is a fetch in flight, which concurrent queries for the same key
+// wait for instead of fetching again.
+type call struct {
+	done chan struct{}
+	usr  user.User
+	err  error
+}
+
+// NewStore decorates storer with a cache.
+func NewStore(storer user.Storer, cfg Config) *Store {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultConfig.Size
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultConfig.TTL
+	}
+	if cfg.FetchTimeout <= 0 {
+		cfg.FetchTimeout = DefaultConfig.FetchTimeout
+	}
+	return &Store{
+		Storer:  storer,
+		cfg:     cfg,
+		now:     time.Now,
+		lru:     list.New(),
+		byID:    make(map[string]*list.Element),
+		byEmail: make(map[string]*list.Element),
+		calls:   make(map[string]*call),
+	}
+}
+
+// Stats returns the cache statistics.
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	entries := s.lru.Len()
+	s.mu.Unlock()
+
+	return Stats{
+		Hits:      s.hits.Load(),
+		Misses:    s.misses.Load(),
+		Evictions: s.evictions.Load(),
+		Entries:   entries,
+	}
+}
+
+// QueryByID queries a user by id, from the cache when it has them.
+func (s *Store) QueryByID(ctx context.Context, orgID string, id string) (user.User, error) {
+	return s.get(ctx, s.byID, idKey(orgID, id), func(ctx context.Context) (user.User, error) {
+		return s.Storer.QueryByID(ctx, orgID, id)
+	})
+}
+
+// QueryByEmail queries a user by email, from the cache when it has them.
+func (s *Store) QueryByEmail(ctx context.Context, orgID string, email string) (user.User, error) {
+	return s.get(ctx, s.byEmail, emailKey(orgID, email), func(ctx context.Context) (user.User, error) {
+		return s.Storer.QueryByEmail(ctx, orgID, email)
+	})
+}
+
+// Create creates a user.
+func (s *Store) Create(ctx context.Context, usr user.User) (user.User, error) {
+	defer s.invalidate(usr.OrgID.String(), usr.Email.Address)
+	return s.Storer.Create(ctx, usr)
+}
+
+// Update updates a user.
+func (s *Store) Update(ctx context.Context, orgID string, uu user.UpdateUser, now time.Time) (user.User, error) {
+	if uu.Email != nil {
+		defer s.invalidate(orgID, uu.Email.Address)
+	}
+	return s.Storer.Update(ctx, orgID, uu, now)
+}
+
+// Delete deletes a user.
+func (s *Store) Delete(ctx context.Context, orgID string, email mail.Address) (user.User, error) {
+	defer s.invalidate(orgID, email.Address)
+	return s.Storer.Delete(ctx, orgID, email)
+}
+
+// UpdateLastLogin records when and where a user last signed in.
+func (s *Store) UpdateLastLogin(ctx context.Context, orgID string, email string, at time.Time, ip string) error {
+	defer s.invalidate(orgID, email)
+	return s.Storer.UpdateLastLogin(ctx, orgID, email, at, ip)
+}
+
+// UpdatePassword replaces the password hash of a user.
+func (s *Store) UpdatePassword(ctx context.Context, orgID string, email string, hash []byte, now time.Time) (user.User, error) {
+	defer s.invalidate(orgID, email)
+	return s.Storer.UpdatePassword(ctx, orgID, email, hash, now)
+}
+
+// QueryDormant queries dormant users from the decorated store, so the job
+// that disables them can update them through the cache.
+func (s *Store) QueryDormant(ctx context.Context, before time.Time, limit int) ([]user.User, error) {
+	return user.QueryDormant(ctx, s.Storer, before, limit)
+}
+
+// PurgeDeleted purges deleted users from the decorated store. Deleted users
+// aren't cached, so nothing needs evicting.
+func (s *Store) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	return user.PurgeDeleted(ctx, s.Storer, before, limit)
+}
+
+// WritesOutbox implements user.OutboxStorer for the decorated store.
+func (s *Store) WritesOutbox() bool {
+	return user.WritesOutbox(s.Storer)
+}
+
+// get returns the user cached under key in index, or fetches them. Only
+// one fetch per key runs at a time; concurrent callers share its result.
+// The fetch runs detached from the caller that started it, so that caller
+// going away doesn't fail the others, and every caller stops waiting when
+// its own context is done.
+func (s *Store) get(ctx context.Context, index map[string]*list.Element, key string, fetch func(ctx context.Context) (user.User, error)) (user.User, error) {
+	s.mu.Lock()
+	if el, ok := index[key]; ok {
+		e := el.Value.(*entry)
+		if s.now().Before(e.expires) {
+			s.lru.MoveToFront(el)
+			usr := clone(e.usr)
+			s.mu.Unlock()
+			s.hits.Add(1)
+			return usr, nil
+		}
+		s.remove(el)
+	}
+	s.misses.Add(1)
+
+	c, ok := s.calls[key]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		s.calls[key] = c
+		go s.fetch(context.WithoutCancel(ctx), c, key, s.gen, fetch)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return clone(c.usr), c.err
+	case <-ctx.Done():
+		return user.User{}, ctx.Err()
+	}
+}
+
+// fetch runs the call for key and caches the user unless the cache was
+// invalidated since gen.
+func (s *Store) fetch(ctx context.Context, c *call, key string, gen uint64, fetch func(ctx context.Context) (user.User, error)) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.FetchTimeout)
+	defer cancel()
+
+	c.usr, c.err = fetch(ctx)
+
+	s.mu.Lock()
+	delete(s.calls, key)
+	if c.err == nil && gen == s.gen {
+		s.add(c.usr)
+	}
+	s.mu.Unlock()
+	close(c.done)
+}
+
+// add caches usr under their id and email. s.mu must be held.
+func (s *Store) add(usr user.User) {
+	e := &entry{
+		idKey:    idKey(usr.OrgID.String(), usr.ID.String()),
+		emailKey: emailKey(usr.OrgID.String(), usr.Email.Address),
+		usr:      clone(usr),
+		expires:  s.now().Add(s.cfg.TTL),
+	}
+	if el, ok := s.byID[e.idKey]; ok {
+		s.remove(el)
+	}
+	if el, ok := s.byEmail[e.emailKey]; ok {
+		s.remove(el)
+	}
+
+	el := s.lru.PushFront(e)
+	s.byID[e.idKey] = el
+	s.byEmail[e.emailKey] = el
+
+	for s.lru.Len() > s.cfg.Size {
+		s.remove(s.lru.Back())
+		s.evictions.Add(1)
+	}
+}
+
+// remove removes an entry from the cache. s.mu must be held.
+func (s *Store) remove(el *list.Element) {
+	e := s.lru.Remove(el).(*entry)
+	delete(s.byID, e.idKey)
+	delete(s.byEmail, e.emailKey)
+}
+
+// invalidate removes the user with the email from the cache.
+func (s *Store) invalidate(orgID string, email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gen++
+	if el, ok := s.byEmail[emailKey(orgID, email)]; ok {
+		s.remove(el)
+	}
+}
+
+// idKey and emailKey scope keys by organization, since ids and emails
+// are only looked up within one.
+func idKey(orgID string, id string) string {
+	return orgID + "\x00id\x00" + id
+}
+
+func emailKey(orgID string, email string) string {
+	return orgID + "\x00email\x00" + email
+}
+
+// clone copies the parts of usr callers could change.
+func clone(usr user.User) user.User {
+	usr.Roles = append([]user.Role(nil), usr.Roles...)
+	usr.PasswordHash = append([]byte(nil), usr.PasswordHash...)
+	usr.Attributes = cloneMap(usr.Attributes)
+	return usr
+}
+
+func cloneMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = cloneValue(v)
+	}
+	return out
+}
+
+func cloneValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return cloneMap(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = cloneValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/services/user/storertest"
+	"github.com/gitamped/bud/services/user/stores/memory"
+	"github.com/gitamped/stem/data/nosql/dbtest"
+	"github.com/google/uuid"
+)
+
+// countingStore counts the queries that reach it and, when gate is set,
+// holds them until it is closed. Like a database, it fails queries whose
+// context is done.
+type countingStore struct {
+	user.Storer
+	queries atomic.Int64
+	gate    chan struct{}
+}
+
+func (s *countingStore) QueryByID(ctx context.Context, orgID string, id string) (user.User, error) {
+	s.queries.Add(1)
+	if s.gate != nil {
+		<-s.gate
+	}
+	if err := ctx.Err(); err != nil {
+		return user.User{}, err
+	}
+	return s.Storer.QueryByID(ctx, orgID, id)
+}
+
+func (s *countingStore) QueryByEmail(ctx context.Context, orgID string, email string) (user.User, error) {
+	s.queries.Add(1)
+	if s.gate != nil {
+		<-s.gate
+	}
+	if err := ctx.Err(); err != nil {
+		return user.User{}, err
+	}
+	return s.Storer.QueryByEmail(ctx, orgID, email)
+}
+
+func Test_Cache(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	backend := &countingStore{Storer: memory.NewStore()}
+	store := NewStore(backend, Config{Size: 2, TTL: time.Minute})
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	create := func(name string) user.User {
+		usr, err := store.Create(ctx, user.User{
+			ID:          uuid.New(),
+			OrgID:       orgID,
+			Name:        name,
+			Email:       mail.Address{Address: name + "@example.com"},
+			Roles:       []user.Role{user.RoleUser},
+			Enabled:     true,
+			DateCreated: now,
+			DateUpdated: now,
+		})
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", dbtest.Failed, err)
+		}
+		return usr
+	}
+	bill := create("bill")
+
+	t.Log("Given the need to cache users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen querying a user repeatedly.", testID)
+		{
+			for i := 0; i < 3; i++ {
+				if _, err := store.QueryByID(ctx, orgID.String(), bill.ID.String()); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to query by id : %s.", dbtest.Failed, testID, err)
+				}
+			}
+			if _, err := store.QueryByEmail(ctx, orgID.String(), bill.Email.Address); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query by email : %s.", dbtest.Failed, testID, err)
+			}
+			if n := backend.queries.Load(); n != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould query the store once : got %d.", dbtest.Failed, testID, n)
+			}
+			if st := store.Stats(); st.Hits != 3 || st.Misses != 1 || st.Entries != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould count hits and misses : got %+v.", dbtest.Failed, testID, st)
+			}
+			if _, err := store.QueryByID(ctx, uuid.NewString(), bill.ID.String()); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould not serve users to another organization.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould serve the user by id and email from the cache.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen the user changes.", testID)
+		{
+			got, _ := store.QueryByID(ctx, orgID.String(), bill.ID.String())
+			got.Roles[0] = user.RoleAdmin
+			if again, _ := store.QueryByID(ctx, orgID.String(), bill.ID.String()); !again.Roles[0].Equal(user.RoleUser) {
+				t.Fatalf("\t%s\tTest %d:\tShould not let callers change cached users.", dbtest.Failed, testID)
+			}
+
+			name := "William"
+			if _, err := store.Update(ctx, orgID.String(), user.UpdateUser{Email: &bill.Email, Name: &name}, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update user : %s.", dbtest.Failed, testID, err)
+			}
+			if got, _ := store.QueryByID(ctx, orgID.String(), bill.ID.String()); got.Name != name {
+				t.Fatalf("\t%s\tTest %d:\tShould invalidate updated users : got %q.", dbtest.Failed, testID, got.Name)
+			}
+			if _, err := store.Delete(ctx, orgID.String(), bill.Email); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := store.QueryByEmail(ctx, orgID.String(), bill.Email.Address); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould invalidate deleted users.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould invalidate changed users.", dbtest.Success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen users expire or don't fit.", testID)
+		{
+			usrs := []user.User{create("alice"), create("carol"), create("dave")}
+			for _, usr := range usrs {
+				store.QueryByID(ctx, orgID.String(), usr.ID.String())
+			}
+			if st := store.Stats(); st.Entries != 2 || st.Evictions != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould evict the least recently used user : got %+v.", dbtest.Failed, testID, st)
+			}
+
+			before := backend.queries.Load()
+			store.QueryByID(ctx, orgID.String(), usrs[2].ID.String())
+			now = now.Add(2 * time.Minute)
+			store.QueryByID(ctx, orgID.String(), usrs[2].ID.String())
+			if n := backend.queries.Load() - before; n != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould fetch expired users again : got %d queries.", dbtest.Failed, testID, n)
+			}
+			t.Logf("\t%s\tTest %d:\tShould bound and expire the cache.", dbtest.Success, testID)
+		}
+
+		testID = 3
+		t.Logf("\tTest %d:\tWhen querying a user concurrently.", testID)
+		{
+			erin := create("erin")
+			backend.gate = make(chan struct{})
+			before := backend.queries.Load()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if got, err := store.QueryByEmail(ctx, orgID.String(), erin.Email.Address); err != nil || got.ID != erin.ID {
+						t.Errorf("\t%s\tTest %d:\tShould share the fetched user : got %v, %v.", dbtest.Failed, testID, got.ID, err)
+					}
+				}()
+			}
+			// Let the callers pile up on the first fetch before it returns.
+			for backend.queries.Load() == before {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond)
+			close(backend.gate)
+			wg.Wait()
+			backend.gate = nil
+
+			if n := backend.queries.Load() - before; n != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould fetch the user once : got %d queries.", dbtest.Failed, testID, n)
+			}
+			t.Logf("\t%s\tTest %d:\tShould fetch the user once.", dbtest.Success, testID)
+		}
+
+		testID = 4
+		t.Logf("\tTest %d:\tWhen the caller that started a fetch gives up.", testID)
+		{
+			frank := create("frank")
+			backend.gate = make(chan struct{})
+			before := backend.queries.Load()
+
+			first, cancel := context.WithCancel(ctx)
+			firstErr := make(chan error, 1)
+			go func() {
+				_, err := store.QueryByID(first, orgID.String(), frank.ID.String())
+				firstErr <- err
+			}()
+			for backend.queries.Load() == before {
+				time.Sleep(time.Millisecond)
+			}
+
+			second := make(chan error, 1)
+			go func() {
+				got, err := store.QueryByID(ctx, orgID.String(), frank.ID.String())
+				if err == nil && got.ID != frank.ID {
+					err = fmt.Errorf("got user %v", got.ID)
+				}
+				second <- err
+			}()
+			time.Sleep(10 * time.Millisecond)
+
+			cancel()
+			if err := <-firstErr; !errors.Is(err, context.Canceled) {
+				t.Fatalf("\t%s\tTest %d:\tShould stop waiting when its context is done : got %v.", dbtest.Failed, testID, err)
+			}
+			close(backend.gate)
+			if err := <-second; err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould still fetch the user for the others : %s.", dbtest.Failed, testID, err)
+			}
+			backend.gate = nil
+			if n := backend.queries.Load() - before; n != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould fetch the user once : got %d queries.", dbtest.Failed, testID, n)
+			}
+			t.Logf("\t%s\tTest %d:\tShould finish the fetch for the callers still waiting.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Conformance(t *testing.T) {
+	storertest.Run(t, NewStore(memory.NewStore(), DefaultConfig), storertest.Errors{
+		NotFound:              memory.ErrNotFound,
+		UniqueEmail:           memory.ErrUniqueEmail,
+		AuthenticationFailure: memory.ErrAuthenticationFailure,
+	})
+}
@@ -0,0 +1,210 @@
+// Package instrument decorates a user.Storer with metrics and tracing:
+// latency histograms, error counts by kind and in-flight gauges for every
+// method, and a span for every call. Decorate the store closest to the
+// database, so the metrics time the database rather than other
+// decorators such as a cache.
+package instrument
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+// QueryNamer is implemented by stores that can name the query each method
+// runs. Spans carry the name as the db.query attribute.
+type QueryNamer interface {
+	QueryName(method string) string
+}
+
+// Store is a user.Storer that records metrics and spans for the calls to
+// the decorated store.
+type Store struct {
+	user.Storer
+	tracer  Tracer
+	kinds   map[error]string
+	metrics metrics
+	now     func() time.Time
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithTracer emits a span for every call.
+func WithTracer(tracer Tracer) Option {
+	return func(s *Store) {
+		s.tracer = tracer
+	}
+}
+
+// WithErrorKinds counts the errors matching each error, as checked with
+// errors.Is, under its kind. Cancellations and timeouts are counted as
+// canceled and timeout, and other errors as other.
+func WithErrorKinds(kinds map[error]string) Option {
+	return func(s *Store) {
+		for err, kind := range kinds {
+			s.kinds[err] = kind
+		}
+	}
+}
+
+// NewStore decorates storer with metrics and tracing.
+func NewStore(storer user.Storer, opts ...Option) *Store {
+	s := &Store{
+		Storer:  storer,
+		tracer:  nopTracer{},
+		kinds:   make(map[error]string),
+		metrics: metrics{methods: make(map[string]*methodMetrics)},
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Stats returns the metrics of every method that has been called.
+func (s *Store) Stats() Stats {
+	return s.metrics.stats()
+}
+
+// Create creates a user.
+func (s *Store) Create(ctx context.Context, usr user.User) (_ user.User, err error) {
+	ctx, done := s.start(ctx, "Create", usr.OrgID.String())
+	defer func() { done(err) }()
+	return s.Storer.Create(ctx, usr)
+}
+
+// Delete deletes a user.
+func (s *Store) Delete(ctx context.Context, orgID string, email mail.Address) (_ user.User, err error) {
+	ctx, done := s.start(ctx, "Delete", orgID)
+	defer func() { done(err) }()
+	return s.Storer.Delete(ctx, orgID, email)
+}
+
+// QueryByID queries a user by id.
+func (s *Store) QueryByID(ctx context.Context, orgID string, id string) (_ user.User, err error) {
+	ctx, done := s.start(ctx, "QueryByID", orgID)
+	defer func() { done(err) }()
+	return s.Storer.QueryByID(ctx, orgID, id)
+}
+
+// QueryByEmail queries a user by email.
+func (s *Store) QueryByEmail(ctx context.Context, orgID string, email string) (_ user.User, err error) {
+	ctx, done := s.start(ctx, "QueryByEmail", orgID)
+	defer func() { done(err) }()
+	return s.Storer.QueryByEmail(ctx, orgID, email)
+}
+
+// Update updates a user.
+func (s *Store) Update(ctx context.Context, orgID string, uu user.UpdateUser, now time.Time) (_ user.User, err error) {
+	ctx, done := s.start(ctx, "Update", orgID)
+	defer func() { done(err) }()
+	return s.Storer.Update(ctx, orgID, uu, now)
+}
+
+// Query retrieves a page of users.
+func (s *Store) Query(ctx context.Context, orgID string, filter user.QueryFilter, orderBy user.OrderBy, pageNumber int, rowsPerPage int) (_ []user.User, err error) {
+	ctx, done := s.start(ctx, "Query", orgID)
+	defer func() { done(err) }()
+	return s.Storer.Query(ctx, orgID, filter, orderBy, pageNumber, rowsPerPage)
+}
+
+// Authenticate checks the password of a user.
+func (s *Store) Authenticate(ctx context.Context, orgID string, email string, password string) (_ user.User, err error) {
+	ctx, done := s.start(ctx, "Authenticate", orgID)
+	defer func() { done(err) }()
+	return s.Storer.Authenticate(ctx, orgID, email, password)
+}
+
+// UpdateLastLogin records when and where a user last signed in.
+func (s *Store) UpdateLastLogin(ctx context.Context, orgID string, email string, at time.Time, ip string) (err error) {
+	ctx, done := s.start(ctx, "UpdateLastLogin", orgID)
+	defer func() { done(err) }()
+	return s.Storer.UpdateLastLogin(ctx, orgID, email, at, ip)
+}
+
+// UpdatePassword replaces the password hash of a user.
+func (s *Store) UpdatePassword(ctx context.Context, orgID string, email string, hash []byte, now time.Time) (_ user.User, err error) {
+	ctx, done := s.start(ctx, "UpdatePassword", orgID)
+	defer func() { done(err) }()
+	return s.Storer.UpdatePassword(ctx, orgID, email, hash, now)
+}
+
+// QueryDormant queries dormant users from the decorated store, so other
+// decorators can reach it through this one.
+func (s *Store) QueryDormant(ctx context.Context, before time.Time, limit int) (_ []user.User, err error) {
+	if _, ok := s.Storer.(user.DormantQuerier); !ok {
+		return nil, user.ErrUnsupported
+	}
+
+	ctx, done := s.start(ctx, "QueryDormant", "")
+	defer func() { done(err) }()
+	return user.QueryDormant(ctx, s.Storer, before, limit)
+}
+
+// PurgeDeleted purges deleted users from the decorated store, so other
+// decorators can reach it through this one.
+func (s *Store) PurgeDeleted(ctx context.Context, before time.Time, limit int) (_ int, err error) {
+	if _, ok := s.Storer.(user.DeletedPurger); !ok {
+		return 0, user.ErrUnsupported
+	}
+
+	ctx, done := s.start(ctx, "PurgeDeleted", "")
+	defer func() { done(err) }()
+	return user.PurgeDeleted(ctx, s.Storer, before, limit)
+}
+
+// WritesOutbox implements user.OutboxStorer for the decorated store.
+func (s *Store) WritesOutbox() bool {
+	return user.WritesOutbox(s.Storer)
+}
+
+// start records the start of a call to the method and starts its span.
+// The returned function records the end of the call.
+func (s *Store) start(ctx context.Context, method string, orgID string) (context.Context, func(err error)) {
+	ctx, span := s.tracer.Start(ctx, "user.Storer."+method)
+	if namer, ok := s.Storer.(QueryNamer); ok {
+		if name := namer.QueryName(method); name != "" {
+			span.SetAttribute("db.query", name)
+		}
+	}
+	if orgID != "" {
+		span.SetAttribute("org_id", orgID)
+	}
+
+	s.metrics.start(method)
+	start := s.now()
+
+	return ctx, func(err error) {
+		kind := s.kind(err)
+		s.metrics.done(method, s.now().Sub(start), kind)
+		if err != nil {
+			span.SetAttribute("error.kind", kind)
+			span.SetError(err)
+		}
+		span.End()
+	}
+}
+
+// kind returns the kind of err, or "" when it is nil.
+func (s *Store) kind(err error) string {
+	if err == nil {
+		return ""
+	}
+	for target, kind := range s.kinds {
+		if errors.Is(err, target) {
+			return kind
+		}
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "other"
+}
@@ -0,0 +1,171 @@
+package instrument
+
+import (
+	"context"
+	"net/mail"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/services/user/storertest"
+	"github.com/gitamped/bud/services/user/stores/memory"
+	"github.com/gitamped/stem/data/nosql/dbtest"
+	"github.com/google/uuid"
+)
+
+// namedStore names its queries and, when gate is set, holds QueryByID
+// until it is closed.
+type namedStore struct {
+	user.Storer
+	gate chan struct{}
+}
+
+func (s *namedStore) QueryName(method string) string {
+	return "users." + method
+}
+
+func (s *namedStore) QueryByID(ctx context.Context, orgID string, id string) (user.User, error) {
+	if s.gate != nil {
+		<-s.gate
+	}
+	return s.Storer.QueryByID(ctx, orgID, id)
+}
+
+// recordingTracer keeps the spans it starts.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]any
+	err   error
+	ended bool
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	span := &recordedSpan{name: name, attrs: make(map[string]any)}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (t *recordingTracer) last() *recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.spans[len(t.spans)-1]
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *recordedSpan) SetError(err error)                 { s.err = err }
+func (s *recordedSpan) End()                               { s.ended = true }
+
+func Test_Instrument(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	backend := &namedStore{Storer: memory.NewStore()}
+	tracer := &recordingTracer{}
+	store := NewStore(backend, WithTracer(tracer), WithErrorKinds(map[error]string{
+		memory.ErrNotFound: "not_found",
+	}))
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time {
+		now = now.Add(20 * time.Millisecond)
+		return now
+	}
+
+	usr, err := store.Create(ctx, user.User{
+		ID:          uuid.New(),
+		OrgID:       orgID,
+		Name:        "bill",
+		Email:       mail.Address{Address: "bill@example.com"},
+		Roles:       []user.Role{user.RoleUser},
+		DateCreated: now,
+		DateUpdated: now,
+	})
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to create user : %s.", dbtest.Failed, err)
+	}
+
+	t.Log("Given the need to instrument the user store.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen calling the store.", testID)
+		{
+			if _, err := store.QueryByID(ctx, orgID.String(), usr.ID.String()); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query by id : %s.", dbtest.Failed, testID, err)
+			}
+			ms := store.Stats()["QueryByID"]
+			if ms.Count != 1 || ms.Seconds != 0.02 || len(ms.Errors) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould count the call : got %+v.", dbtest.Failed, testID, ms)
+			}
+			for _, b := range ms.Buckets {
+				want := uint64(0)
+				if b.LE >= 0.02 {
+					want = 1
+				}
+				if b.Count != want {
+					t.Fatalf("\t%s\tTest %d:\tShould record the latency : got %+v.", dbtest.Failed, testID, ms.Buckets)
+				}
+			}
+
+			span := tracer.last()
+			if span.name != "user.Storer.QueryByID" || span.attrs["db.query"] != "users.QueryByID" || span.attrs["org_id"] != orgID.String() || !span.ended {
+				t.Fatalf("\t%s\tTest %d:\tShould trace the call : got %+v.", dbtest.Failed, testID, span)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record the latency and a span.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen calls fail.", testID)
+		{
+			store.QueryByEmail(ctx, orgID.String(), "nobody@example.com")
+			store.Query(ctx, orgID.String(), user.QueryFilter{}, user.OrderBy{Field: "bogus"}, 1, 10)
+
+			if errs := store.Stats()["QueryByEmail"].Errors; errs["not_found"] != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould count errors by kind : got %v.", dbtest.Failed, testID, errs)
+			}
+			if errs := store.Stats()["Query"].Errors; errs["other"] != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould count unknown errors as other : got %v.", dbtest.Failed, testID, errs)
+			}
+			if span := tracer.last(); span.err == nil || span.attrs["error.kind"] != "other" {
+				t.Fatalf("\t%s\tTest %d:\tShould mark the span as failed : got %+v.", dbtest.Failed, testID, span)
+			}
+			t.Logf("\t%s\tTest %d:\tShould count errors by kind.", dbtest.Success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen calls are running.", testID)
+		{
+			backend.gate = make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				store.QueryByID(ctx, orgID.String(), usr.ID.String())
+			}()
+			for store.Stats()["QueryByID"].InFlight != 1 {
+				time.Sleep(time.Millisecond)
+			}
+			close(backend.gate)
+			<-done
+			if ms := store.Stats()["QueryByID"]; ms.InFlight != 0 || ms.Count != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould gauge calls in flight : got %+v.", dbtest.Failed, testID, ms)
+			}
+			t.Logf("\t%s\tTest %d:\tShould gauge calls in flight.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Conformance(t *testing.T) {
+	storertest.Run(t, NewStore(memory.NewStore()), storertest.Errors{
+		NotFound:              memory.ErrNotFound,
+		UniqueEmail:           memory.ErrUniqueEmail,
+		AuthenticationFailure: memory.ErrAuthenticationFailure,
+	})
+}
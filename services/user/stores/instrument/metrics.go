@@ -0,0 +1,131 @@
+package instrument
+
+import (
+	"sync"
+	"time"
+)
+
+// Buckets are the upper bounds of the latency histogram buckets.
+var Buckets = []time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Stats are the metrics of every method that has been called, by method
+// name.
+type Stats map[string]MethodStats
+
+// MethodStats are the metrics of one method.
+type MethodStats struct {
+	// Count is the number of calls that have returned.
+	Count uint64 `json:"count"`
+	// Seconds is the total time the calls took.
+	Seconds float64 `json:"seconds"`
+	// Buckets count the calls that took up to each bound. They are
+	// cumulative; Count includes the calls slower than the last bound.
+	Buckets []Bucket `json:"buckets"`
+	// InFlight is the number of calls running now.
+	InFlight int64 `json:"in_flight"`
+	// Errors counts the calls that failed, by error kind.
+	Errors map[string]uint64 `json:"errors"`
+}
+
+// Bucket is a bucket of the latency histogram.
+type Bucket struct {
+	// LE is the upper bound of the bucket in seconds.
+	LE    float64 `json:"le"`
+	Count uint64  `json:"count"`
+}
+
+// metrics records the calls of every method.
+type metrics struct {
+	mu      sync.Mutex
+	methods map[string]*methodMetrics
+}
+
+type methodMetrics struct {
+	count    uint64
+	total    time.Duration
+	buckets  []uint64 // not cumulative, indexed like Buckets
+	inFlight int64
+	errors   map[string]uint64
+}
+
+// method returns the metrics of the method. m.mu must be held.
+func (m *metrics) method(name string) *methodMetrics {
+	mm, ok := m.methods[name]
+	if !ok {
+		mm = &methodMetrics{
+			buckets: make([]uint64, len(Buckets)),
+			errors:  make(map[string]uint64),
+		}
+		m.methods[name] = mm
+	}
+	return mm
+}
+
+// start records that a call to the method started.
+func (m *metrics) start(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.method(name).inFlight++
+}
+
+// done records that a call to the method returned after d, failing with
+// an error of the kind unless it is "".
+func (m *metrics) done(name string, d time.Duration, kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mm := m.method(name)
+	mm.inFlight--
+	mm.count++
+	mm.total += d
+	for i, bound := range Buckets {
+		if d <= bound {
+			mm.buckets[i]++
+			break
+		}
+	}
+	if kind != "" {
+		mm.errors[kind]++
+	}
+}
+
+func (m *metrics) stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := make(Stats, len(m.methods))
+	for name, mm := range m.methods {
+		ms := MethodStats{
+			Count:    mm.count,
+			Seconds:  mm.total.Seconds(),
+			Buckets:  make([]Bucket, len(Buckets)),
+			InFlight: mm.inFlight,
+			Errors:   make(map[string]uint64, len(mm.errors)),
+		}
+		var n uint64
+		for i, bound := range Buckets {
+			n += mm.buckets[i]
+			ms.Buckets[i] = Bucket{LE: bound.Seconds(), Count: n}
+		}
+		for kind, n := range mm.errors {
+			ms.Errors[kind] = n
+		}
+		st[name] = ms
+	}
+	return st
+}
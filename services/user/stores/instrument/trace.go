@@ -0,0 +1,123 @@
+package instrument
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Tracer starts spans, which time an operation and describe it with
+// attributes.
+type Tracer interface {
+	// Start starts a span, as a child of the span in ctx if there is one,
+	// and returns a context carrying it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation being traced.
+type Span interface {
+	SetAttribute(key string, value any)
+	// SetError marks the span as failed.
+	SetError(err error)
+	// End ends the span. It must be called once.
+	End()
+}
+
+// nopTracer discards spans.
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value any) {}
+func (nopSpan) SetError(err error)                 {}
+func (nopSpan) End()                               {}
+
+// LogTracer logs spans as they end, with their trace and parent ids, so a
+// call can be followed through the logs.
+type LogTracer struct {
+	log *zap.SugaredLogger
+}
+
+// NewLogTracer constructs a tracer that logs spans at info level, with the
+// error of those that failed.
+func NewLogTracer(log *zap.SugaredLogger) *LogTracer {
+	return &LogTracer{log: log}
+}
+
+type spanKey struct{}
+
+// Start starts a span.
+func (t *LogTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &logSpan{
+		log:   t.log,
+		name:  name,
+		id:    newID(8),
+		start: time.Now(),
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*logSpan); ok {
+		span.traceID = parent.traceID
+		span.parentID = parent.id
+	} else {
+		span.traceID = newID(16)
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+type logSpan struct {
+	log      *zap.SugaredLogger
+	name     string
+	traceID  string
+	id       string
+	parentID string
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs []any
+	err   error
+}
+
+func (s *logSpan) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attrs = append(s.attrs, key, value)
+}
+
+func (s *logSpan) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+func (s *logSpan) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kvs := append([]any{
+		"span", s.name,
+		"trace_id", s.traceID,
+		"span_id", s.id,
+		"parent_id", s.parentID,
+		"duration", time.Since(s.start),
+	}, s.attrs...)
+	if s.err != nil {
+		kvs = append(kvs, "error", s.err)
+	}
+	s.log.Infow("span", kvs...)
+}
+
+// newID returns n random bytes in hex.
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
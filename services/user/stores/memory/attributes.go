@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+// AttributeStore is a thread-safe, in-memory store of the JSON Schemas that
+// custom user attributes are validated against.
+type AttributeStore struct {
+	mu      sync.RWMutex
+	schemas map[string]user.AttributeSchema
+}
+
+// NewAttributeStore constructs an empty attribute schema store.
+func NewAttributeStore() *AttributeStore {
+	return &AttributeStore{
+		schemas: make(map[string]user.AttributeSchema),
+	}
+}
+
+// Save creates or replaces the attribute schema of an organization, or of the
+// deployment when the schema has no organization.
+func (s *AttributeStore) Save(ctx context.Context, schema user.AttributeSchema) (user.AttributeSchema, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schema = copySchema(schema)
+	schema.DateUpdated = schema.DateUpdated.UTC()
+	s.schemas[schema.OrgID] = schema
+	return copySchema(schema), nil
+}
+
+// Query returns the organization's schema, or the deployment schema when the
+// organization has none. A zero AttributeSchema is returned when neither
+// exists.
+func (s *AttributeStore) Query(ctx context.Context, orgID string) (user.AttributeSchema, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range []string{orgID, ""} {
+		if schema, exists := s.schemas[key]; exists {
+			return copySchema(schema), nil
+		}
+	}
+	return user.AttributeSchema{}, nil
+}
+
+// copySchema copies a schema so callers and the store don't share it.
+func copySchema(schema user.AttributeSchema) user.AttributeSchema {
+	schema.Schema = append([]byte(nil), schema.Schema...)
+	return schema
+}
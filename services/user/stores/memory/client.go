@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+var (
+	ErrClientNotFound = errors.New("client not found")
+	ErrClientExists   = errors.New("client already exists")
+)
+
+// ClientStore is a thread-safe, in-memory OAuth2 client store.
+type ClientStore struct {
+	mu      sync.RWMutex
+	clients map[string]user.Client
+}
+
+// NewClientStore constructs an empty client store.
+func NewClientStore() *ClientStore {
+	return &ClientStore{
+		clients: make(map[string]user.Client),
+	}
+}
+
+// Create adds a new client.
+func (s *ClientStore) Create(ctx context.Context, clt user.Client) (user.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.clients[clt.ID.String()]; exists {
+		return user.Client{}, ErrClientExists
+	}
+	clt = copyClient(clt)
+	clt.DateCreated = clt.DateCreated.UTC()
+	clt.DateUpdated = clt.DateUpdated.UTC()
+	s.clients[clt.ID.String()] = clt
+	return copyClient(clt), nil
+}
+
+// QueryByID queries a client by id in any organization.
+func (s *ClientStore) QueryByID(ctx context.Context, id string) (user.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clt, exists := s.clients[id]
+	if !exists {
+		return user.Client{}, ErrClientNotFound
+	}
+	return copyClient(clt), nil
+}
+
+// Query retrieves the clients of an organization ordered by name.
+func (s *ClientStore) Query(ctx context.Context, orgID string) ([]user.Client, error) {
+	s.mu.RLock()
+	var clts []user.Client
+	for _, clt := range s.clients {
+		if clt.OrgID.String() == orgID {
+			clts = append(clts, copyClient(clt))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(clts, func(i, j int) bool {
+		if clts[i].Name != clts[j].Name {
+			return clts[i].Name < clts[j].Name
+		}
+		return clts[i].ID.String() < clts[j].ID.String()
+	})
+	return clts, nil
+}
+
+// UpdateSecret replaces the secret hash of a client.
+func (s *ClientStore) UpdateSecret(ctx context.Context, orgID string, id string, hash []byte, now time.Time) (user.Client, error) {
+	return s.update(orgID, id, func(clt *user.Client) {
+		clt.SecretHash = append([]byte(nil), hash...)
+		clt.DateUpdated = now.UTC()
+	})
+}
+
+// Revoke marks a client as revoked.
+func (s *ClientStore) Revoke(ctx context.Context, orgID string, id string, now time.Time) (user.Client, error) {
+	return s.update(orgID, id, func(clt *user.Client) {
+		clt.Revoked = true
+		clt.DateUpdated = now.UTC()
+	})
+}
+
+// update applies upd to a client of the organization.
+func (s *ClientStore) update(orgID string, id string, upd func(clt *user.Client)) (user.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clt, exists := s.clients[id]
+	if !exists || clt.OrgID.String() != orgID {
+		return user.Client{}, ErrClientNotFound
+	}
+	upd(&clt)
+	s.clients[id] = clt
+	return copyClient(clt), nil
+}
+
+// copyClient copies a client so callers and the store don't share slices.
+func copyClient(clt user.Client) user.Client {
+	clt.Roles = append([]user.Role(nil), clt.Roles...)
+	clt.RedirectURIs = append([]string(nil), clt.RedirectURIs...)
+	clt.SecretHash = append([]byte(nil), clt.SecretHash...)
+	return clt
+}
@@ -0,0 +1,190 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+var (
+	ErrDepartmentNotFound = errors.New("department not found")
+	ErrDepartmentExists   = errors.New("department already exists")
+)
+
+// DepartmentStore is a thread-safe, in-memory department store. Each
+// department keeps the id of its parent.
+type DepartmentStore struct {
+	mu    sync.RWMutex
+	users *Store
+	depts map[string]user.Department
+}
+
+// NewDepartmentStore constructs an empty department store for the users of
+// users.
+func NewDepartmentStore(users *Store) *DepartmentStore {
+	return &DepartmentStore{
+		users: users,
+		depts: make(map[string]user.Department),
+	}
+}
+
+// Create adds a new department below its parent.
+func (s *DepartmentStore) Create(ctx context.Context, dept user.Department) (user.Department, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.depts[dept.ID.String()]; exists {
+		return user.Department{}, ErrDepartmentExists
+	}
+	if dept.ParentID != "" {
+		if _, err := s.queryByID(dept.OrgID.String(), dept.ParentID); err != nil {
+			return user.Department{}, fmt.Errorf("parent: %w", err)
+		}
+	}
+	dept.DateCreated = dept.DateCreated.UTC()
+	dept.DateUpdated = dept.DateUpdated.UTC()
+	s.depts[dept.ID.String()] = dept
+	return dept, nil
+}
+
+// Update updates the set fields of a department.
+func (s *DepartmentStore) Update(ctx context.Context, orgID string, id string, ud user.UpdateDepartment, now time.Time) (user.Department, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dept, err := s.queryByID(orgID, id)
+	if err != nil {
+		return user.Department{}, err
+	}
+	if ud.Name != nil {
+		dept.Name = *ud.Name
+	}
+	dept.DateUpdated = now.UTC()
+	s.depts[id] = dept
+	return dept, nil
+}
+
+// Delete deletes a department that has no sub-departments or users.
+func (s *DepartmentStore) Delete(ctx context.Context, orgID string, id string) (user.Department, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dept, err := s.queryByID(orgID, id)
+	if err != nil {
+		return user.Department{}, err
+	}
+	for _, d := range s.depts {
+		if d.ParentID == id {
+			return user.Department{}, user.ErrDepartmentNotEmpty
+		}
+	}
+	for _, usr := range s.users.byID(orgID) {
+		if usr.Department == id {
+			return user.Department{}, user.ErrDepartmentNotEmpty
+		}
+	}
+	delete(s.depts, id)
+	return dept, nil
+}
+
+// Move makes parentID the parent of the department. The department's
+// descendants move with it.
+func (s *DepartmentStore) Move(ctx context.Context, orgID string, id string, parentID string, now time.Time) (user.Department, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dept, err := s.queryByID(orgID, id)
+	if err != nil {
+		return user.Department{}, err
+	}
+	if parentID != "" {
+		if _, err := s.queryByID(orgID, parentID); err != nil {
+			return user.Department{}, fmt.Errorf("parent: %w", err)
+		}
+		// The department can't move below itself or one of its descendants.
+		for p := parentID; p != ""; p = s.depts[p].ParentID {
+			if p == id {
+				return user.Department{}, user.ErrDepartmentCycle
+			}
+		}
+	}
+	dept.ParentID = parentID
+	dept.DateUpdated = now.UTC()
+	s.depts[id] = dept
+	return dept, nil
+}
+
+// QueryByID queries a department by id.
+func (s *DepartmentStore) QueryByID(ctx context.Context, orgID string, id string) (user.Department, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.queryByID(orgID, id)
+}
+
+// Query retrieves the departments of an organization ordered by name.
+func (s *DepartmentStore) Query(ctx context.Context, orgID string) ([]user.Department, error) {
+	s.mu.RLock()
+	var depts []user.Department
+	for _, dept := range s.depts {
+		if dept.OrgID.String() == orgID {
+			depts = append(depts, dept)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(depts, func(i, j int) bool {
+		if depts[i].Name != depts[j].Name {
+			return depts[i].Name < depts[j].Name
+		}
+		return depts[i].ID.String() < depts[j].ID.String()
+	})
+	return depts, nil
+}
+
+// QueryMembers queries the users of a department and, when descendants is
+// set, the users of every department below it.
+func (s *DepartmentStore) QueryMembers(ctx context.Context, orgID string, id string, descendants bool) ([]user.User, error) {
+	s.mu.RLock()
+	if _, err := s.queryByID(orgID, id); err != nil {
+		s.mu.RUnlock()
+		return nil, err
+	}
+	ids := map[string]bool{id: true}
+	// Add the children of the departments found so far until no more are
+	// found.
+	for grew := descendants; grew; {
+		grew = false
+		for _, dept := range s.depts {
+			if ids[dept.ParentID] && !ids[dept.ID.String()] {
+				ids[dept.ID.String()] = true
+				grew = true
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	var usrs []user.User
+	for _, usr := range s.users.byID(orgID) {
+		if ids[usr.Department] {
+			usrs = append(usrs, usr)
+		}
+	}
+	sortByName(usrs)
+	return usrs, nil
+}
+
+// queryByID returns a department of the organization. The caller must hold
+// the lock.
+func (s *DepartmentStore) queryByID(orgID string, id string) (user.Department, error) {
+	dept, exists := s.depts[id]
+	if !exists || dept.OrgID.String() != orgID {
+		return user.Department{}, ErrDepartmentNotFound
+	}
+	return dept, nil
+}
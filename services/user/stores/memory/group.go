@@ -0,0 +1,195 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+var (
+	ErrGroupNotFound       = errors.New("group not found")
+	ErrGroupMemberNotFound = errors.New("group or member not found")
+	ErrGroupExists         = errors.New("group already exists")
+)
+
+// GroupStore is a thread-safe, in-memory group store. Members are kept by
+// user id and resolved through the user store.
+type GroupStore struct {
+	mu      sync.RWMutex
+	users   *Store
+	groups  map[string]user.Group
+	members map[string]map[string]struct{}
+}
+
+// NewGroupStore constructs an empty group store for the users of users.
+func NewGroupStore(users *Store) *GroupStore {
+	return &GroupStore{
+		users:   users,
+		groups:  make(map[string]user.Group),
+		members: make(map[string]map[string]struct{}),
+	}
+}
+
+// Create adds a new group.
+func (s *GroupStore) Create(ctx context.Context, grp user.Group) (user.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.groups[grp.ID.String()]; exists {
+		return user.Group{}, ErrGroupExists
+	}
+	grp.DateCreated = grp.DateCreated.UTC()
+	grp.DateUpdated = grp.DateUpdated.UTC()
+	s.groups[grp.ID.String()] = grp
+	return grp, nil
+}
+
+// Update updates the set fields of a group.
+func (s *GroupStore) Update(ctx context.Context, orgID string, id string, ug user.UpdateGroup, now time.Time) (user.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grp, exists := s.groups[id]
+	if !exists || grp.OrgID.String() != orgID {
+		return user.Group{}, ErrGroupNotFound
+	}
+	if ug.Name != nil {
+		grp.Name = *ug.Name
+	}
+	if ug.Description != nil {
+		grp.Description = *ug.Description
+	}
+	grp.DateUpdated = now.UTC()
+	s.groups[id] = grp
+	return grp, nil
+}
+
+// Delete deletes a group and all of its memberships.
+func (s *GroupStore) Delete(ctx context.Context, orgID string, id string) (user.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grp, exists := s.groups[id]
+	if !exists || grp.OrgID.String() != orgID {
+		return user.Group{}, ErrGroupNotFound
+	}
+	delete(s.groups, id)
+	delete(s.members, id)
+	return grp, nil
+}
+
+// QueryByID queries a group by id.
+func (s *GroupStore) QueryByID(ctx context.Context, orgID string, id string) (user.Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	grp, exists := s.groups[id]
+	if !exists || grp.OrgID.String() != orgID {
+		return user.Group{}, ErrGroupNotFound
+	}
+	return grp, nil
+}
+
+// Query retrieves the groups of an organization ordered by name.
+func (s *GroupStore) Query(ctx context.Context, orgID string) ([]user.Group, error) {
+	s.mu.RLock()
+	var grps []user.Group
+	for _, grp := range s.groups {
+		if grp.OrgID.String() == orgID {
+			grps = append(grps, grp)
+		}
+	}
+	s.mu.RUnlock()
+
+	sortGroups(grps)
+	return grps, nil
+}
+
+// AddMember adds the user to the group. Adding an existing member is a no-op.
+// The user and the group must both belong to the organization.
+func (s *GroupStore) AddMember(ctx context.Context, orgID string, groupID string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grp, exists := s.groups[groupID]
+	if !exists || grp.OrgID.String() != orgID {
+		return ErrGroupMemberNotFound
+	}
+	if _, err := s.users.QueryByID(ctx, orgID, userID); err != nil {
+		return ErrGroupMemberNotFound
+	}
+	if s.members[groupID] == nil {
+		s.members[groupID] = make(map[string]struct{})
+	}
+	s.members[groupID][userID] = struct{}{}
+	return nil
+}
+
+// RemoveMember removes the user from the group.
+func (s *GroupStore) RemoveMember(ctx context.Context, orgID string, groupID string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grp, exists := s.groups[groupID]
+	if !exists || grp.OrgID.String() != orgID {
+		return ErrGroupMemberNotFound
+	}
+	if _, member := s.members[groupID][userID]; !member {
+		return ErrGroupMemberNotFound
+	}
+	if _, err := s.users.QueryByID(ctx, orgID, userID); err != nil {
+		return ErrGroupMemberNotFound
+	}
+	delete(s.members[groupID], userID)
+	return nil
+}
+
+// QueryByUser queries the groups a user belongs to.
+func (s *GroupStore) QueryByUser(ctx context.Context, orgID string, userID string) ([]user.Group, error) {
+	if _, err := s.users.QueryByID(ctx, orgID, userID); err != nil {
+		return nil, nil
+	}
+
+	s.mu.RLock()
+	var grps []user.Group
+	for id, members := range s.members {
+		if _, member := members[userID]; member && s.groups[id].OrgID.String() == orgID {
+			grps = append(grps, s.groups[id])
+		}
+	}
+	s.mu.RUnlock()
+
+	sortGroups(grps)
+	return grps, nil
+}
+
+// QueryMembers queries the users that belong to a group.
+func (s *GroupStore) QueryMembers(ctx context.Context, orgID string, groupID string) ([]user.User, error) {
+	usrs := s.users.byID(orgID)
+
+	s.mu.RLock()
+	var members []user.User
+	for id := range s.members[groupID] {
+		if usr, exists := usrs[id]; exists {
+			members = append(members, usr)
+		}
+	}
+	s.mu.RUnlock()
+
+	sortByName(members)
+	return members, nil
+}
+
+// sortGroups orders groups by name, then by id.
+func sortGroups(grps []user.Group) {
+	sort.Slice(grps, func(i, j int) bool {
+		if grps[i].Name != grps[j].Name {
+			return grps[i].Name < grps[j].Name
+		}
+		return grps[i].ID.String() < grps[j].ID.String()
+	})
+}
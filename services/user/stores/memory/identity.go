@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+// ErrIdentityLinked is returned when an identity provider's subject is
+// already linked to a user.
+var ErrIdentityLinked = errors.New("identity is already linked")
+
+// IdentityStore is a thread-safe, in-memory store of the links between
+// external identity provider subjects and local users.
+type IdentityStore struct {
+	mu    sync.RWMutex
+	links map[string]user.IdentityLink
+}
+
+// NewIdentityStore constructs an empty identity link store.
+func NewIdentityStore() *IdentityStore {
+	return &IdentityStore{
+		links: make(map[string]user.IdentityLink),
+	}
+}
+
+// Create adds a new identity link. A subject can only be linked once.
+func (s *IdentityStore) Create(ctx context.Context, link user.IdentityLink) (user.IdentityLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := identityKey(link.Issuer, link.Subject)
+	if _, exists := s.links[key]; exists {
+		return user.IdentityLink{}, ErrIdentityLinked
+	}
+	link.DateCreated = link.DateCreated.UTC()
+	s.links[key] = link
+	return link, nil
+}
+
+// QueryBySubject queries the link of an identity provider's subject. It
+// returns a zero IdentityLink when the subject has not been linked.
+func (s *IdentityStore) QueryBySubject(ctx context.Context, issuer string, subject string) (user.IdentityLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.links[identityKey(issuer, subject)], nil
+}
+
+// identityKey identifies a subject. Subjects are only unique per issuer.
+func identityKey(issuer string, subject string) string {
+	return issuer + "\x00" + subject
+}
@@ -0,0 +1,87 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+// JobStore is a thread-safe, in-memory store of job locks and run history.
+// It only coordinates the schedulers of one process.
+type JobStore struct {
+	mu    sync.Mutex
+	locks map[string]jobLock
+	runs  []user.JobRun
+}
+
+// jobLock is the lock of a job and the latest slot run.
+type jobLock struct {
+	owner string
+	slot  time.Time
+	until time.Time
+}
+
+// NewJobStore constructs an empty job store.
+func NewJobStore() *JobStore {
+	return &JobStore{
+		locks: make(map[string]jobLock),
+	}
+}
+
+// Lock acquires the lock of a job for the run scheduled at slot. The lock
+// remembers the latest slot run, so a run is made once even when
+// schedulers reach it one after the other.
+func (s *JobStore) Lock(ctx context.Context, job string, owner string, slot time.Time, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, exists := s.locks[job]; exists && (!l.until.Before(time.Now()) || !l.slot.Before(slot)) {
+		return false, nil
+	}
+	s.locks[job] = jobLock{owner: owner, slot: slot, until: until}
+	return true, nil
+}
+
+// Unlock releases the lock of a job held by owner.
+func (s *JobStore) Unlock(ctx context.Context, job string, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, exists := s.locks[job]; exists && l.owner == owner {
+		l.until = time.Time{}
+		s.locks[job] = l
+	}
+	return nil
+}
+
+// CreateRun adds a run to the history.
+func (s *JobStore) CreateRun(ctx context.Context, run user.JobRun) (user.JobRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run.ScheduledAt = run.ScheduledAt.UTC()
+	run.StartedAt = run.StartedAt.UTC()
+	run.FinishedAt = run.FinishedAt.UTC()
+	s.runs = append(s.runs, run)
+	return run, nil
+}
+
+// QueryRuns retrieves a page of a job's runs, newest first.
+func (s *JobStore) QueryRuns(ctx context.Context, job string, pageNumber int, rowsPerPage int) ([]user.JobRun, error) {
+	s.mu.Lock()
+	var runs []user.JobRun
+	for _, run := range s.runs {
+		if run.Job == job {
+			runs = append(runs, run)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return paged(runs, pageNumber, rowsPerPage), nil
+}
@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+// LoginStore is a thread-safe, in-memory login history store.
+type LoginStore struct {
+	mu sync.RWMutex
+	// logins holds the logins of each user, newest first, keyed by
+	// organization and user id.
+	logins map[string][]user.Login
+}
+
+// NewLoginStore constructs an empty login history store.
+func NewLoginStore() *LoginStore {
+	return &LoginStore{
+		logins: make(map[string][]user.Login),
+	}
+}
+
+// Create records a login and drops the user's logins beyond the latest
+// keep.
+func (s *LoginStore) Create(ctx context.Context, l user.Login, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l.DateCreated = l.DateCreated.UTC()
+	key := l.OrgID.String() + ":" + l.UserID.String()
+	ls := append(s.logins[key], l)
+	sort.SliceStable(ls, func(i, j int) bool {
+		if !ls[i].DateCreated.Equal(ls[j].DateCreated) {
+			return ls[i].DateCreated.After(ls[j].DateCreated)
+		}
+		return ls[i].ID.String() > ls[j].ID.String()
+	})
+	if len(ls) > keep {
+		ls = ls[:keep]
+	}
+	s.logins[key] = ls
+	return nil
+}
+
+// Query retrieves a page of a user's logins, newest first.
+func (s *LoginStore) Query(ctx context.Context, orgID string, userID string, filter user.LoginFilter, pageNumber int, rowsPerPage int) ([]user.Login, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ls []user.Login
+	for _, l := range s.logins[orgID+":"+userID] {
+		switch {
+		case filter.Success != nil && l.Success != *filter.Success:
+		case filter.Method != nil && l.Method != *filter.Method:
+		default:
+			ls = append(ls, l)
+		}
+	}
+	return paged(ls, pageNumber, rowsPerPage), nil
+}
@@ -0,0 +1,149 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+// maxReportingDepth bounds walks of the org chart, as it bounds the nosql
+// traversals.
+const maxReportingDepth = 100
+
+// ManagerStore is a thread-safe, in-memory store of reporting lines. It
+// keeps the id of each user's manager and resolves both through the user
+// store.
+type ManagerStore struct {
+	mu       sync.RWMutex
+	users    *Store
+	managers map[string]string
+}
+
+// NewManagerStore constructs an empty reporting line store for the users
+// of users.
+func NewManagerStore(users *Store) *ManagerStore {
+	return &ManagerStore{
+		users:    users,
+		managers: make(map[string]string),
+	}
+}
+
+// SetManager replaces the user's manager. An empty managerID removes it.
+func (s *ManagerStore) SetManager(ctx context.Context, orgID string, userID string, managerID string) error {
+	usrs := s.users.byID(orgID)
+	if _, exists := usrs[userID]; !exists {
+		return ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if managerID == "" {
+		delete(s.managers, userID)
+		return nil
+	}
+	if _, exists := usrs[managerID]; !exists {
+		return fmt.Errorf("manager: %w", ErrNotFound)
+	}
+
+	// The user can't report to someone who already reports to them.
+	for _, mgr := range append([]user.User{usrs[managerID]}, s.chain(usrs, managerID)...) {
+		if mgr.ID.String() == userID {
+			return user.ErrManagerCycle
+		}
+	}
+	s.managers[userID] = managerID
+	return nil
+}
+
+// QueryDirectReports queries the users who report to the manager.
+func (s *ManagerStore) QueryDirectReports(ctx context.Context, orgID string, managerID string) ([]user.User, error) {
+	usrs := s.users.byID(orgID)
+	if _, exists := usrs[managerID]; !exists {
+		return nil, ErrNotFound
+	}
+
+	s.mu.RLock()
+	var reports []user.User
+	for id, usr := range usrs {
+		if s.managers[id] == managerID {
+			reports = append(reports, usr)
+		}
+	}
+	s.mu.RUnlock()
+
+	sortByName(reports)
+	return reports, nil
+}
+
+// QueryChain queries the managers above the user, nearest first.
+func (s *ManagerStore) QueryChain(ctx context.Context, orgID string, userID string) ([]user.User, error) {
+	usrs := s.users.byID(orgID)
+	if _, exists := usrs[userID]; !exists {
+		return nil, ErrNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.chain(usrs, userID), nil
+}
+
+// QueryTree queries everyone below the manager, nearest first.
+func (s *ManagerStore) QueryTree(ctx context.Context, orgID string, managerID string) ([]user.Report, error) {
+	usrs := s.users.byID(orgID)
+	if _, exists := usrs[managerID]; !exists {
+		return nil, ErrNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tree []user.Report
+	level := []string{managerID}
+	for depth := 1; depth <= maxReportingDepth && len(level) > 0; depth++ {
+		var reports []user.User
+		for id, usr := range usrs {
+			if contains(level, s.managers[id]) {
+				reports = append(reports, usr)
+			}
+		}
+		sortByName(reports)
+
+		level = level[:0]
+		for _, usr := range reports {
+			tree = append(tree, user.Report{
+				User:      usr,
+				ManagerID: s.managers[usr.ID.String()],
+				Depth:     depth,
+			})
+			level = append(level, usr.ID.String())
+		}
+	}
+	return tree, nil
+}
+
+// chain returns the managers above the user, nearest first. Managers who
+// were deleted end the chain. The caller must hold the lock.
+func (s *ManagerStore) chain(usrs map[string]user.User, userID string) []user.User {
+	var mgrs []user.User
+	for id := s.managers[userID]; id != "" && len(mgrs) < maxReportingDepth; id = s.managers[id] {
+		mgr, exists := usrs[id]
+		if !exists {
+			break
+		}
+		mgrs = append(mgrs, mgr)
+	}
+	return mgrs
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,485 @@
+// Package memory keeps users, and the groups, departments, reporting lines,
+// organizations, attribute schemas, OAuth2 clients, identity links,
+// webhooks, login history and jobs of the other services, in memory. The
+// stores have the same semantics as the nosql stores and are meant for
+// development and tests, where running a database is more trouble than
+// it's worth. Nothing survives a restart.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrNotFound              = errors.New("user not found")
+	ErrUniqueEmail           = errors.New("email is not unique")
+	ErrAuthenticationFailure = errors.New("authentication failed")
+)
+
+// Store is a thread-safe, in-memory user store.
+type Store struct {
+	mu    sync.RWMutex
+	users map[string]user.User
+	// deleted keeps deleted users by id until they are purged.
+	deleted map[string]deletedUser
+}
+
+// deletedUser is a deleted user and when they were deleted.
+type deletedUser struct {
+	usr user.User
+	at  time.Time
+}
+
+// NewStore constructs an empty store.
+func NewStore() *Store {
+	return &Store{
+		users:   make(map[string]user.User),
+		deleted: make(map[string]deletedUser),
+	}
+}
+
+// Create adds a new user. Emails are unique within an organization.
+func (s *Store) Create(ctx context.Context, usr user.User) (user.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := userKey(usr.OrgID.String(), usr.Email.Address)
+	if _, exists := s.users[key]; exists {
+		return user.User{}, ErrUniqueEmail
+	}
+
+	stored, err := store(usr)
+	if err != nil {
+		return user.User{}, err
+	}
+	s.users[key] = stored
+	return load(stored), nil
+}
+
+// Delete deletes a user. The user is kept until PurgeDeleted removes them.
+func (s *Store) Delete(ctx context.Context, orgID string, email mail.Address) (user.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := userKey(orgID, email.Address)
+	usr, exists := s.users[key]
+	if !exists {
+		return user.User{}, ErrNotFound
+	}
+	delete(s.users, key)
+	s.deleted[usr.ID.String()] = deletedUser{usr: usr, at: time.Now().UTC()}
+	return load(usr), nil
+}
+
+// PurgeDeleted permanently removes up to limit users deleted before before.
+func (s *Store) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for id, d := range s.deleted {
+		if n == limit {
+			break
+		}
+		if d.at.Before(before) {
+			delete(s.deleted, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// QueryByID queries a user by id.
+func (s *Store) QueryByID(ctx context.Context, orgID string, id string) (user.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, usr := range s.users {
+		if usr.OrgID.String() == orgID && usr.ID.String() == id {
+			return load(usr), nil
+		}
+	}
+	return user.User{}, ErrNotFound
+}
+
+// QueryByEmail queries a user by email.
+func (s *Store) QueryByEmail(ctx context.Context, orgID string, email string) (user.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usr, exists := s.users[userKey(orgID, email)]
+	if !exists {
+		return user.User{}, ErrNotFound
+	}
+	return load(usr), nil
+}
+
+// Update merges the set fields of uu into the user. Attributes are merged
+// too, and attributes set to nil are removed.
+func (s *Store) Update(ctx context.Context, orgID string, uu user.UpdateUser, now time.Time) (user.User, error) {
+	if uu.Email == nil {
+		return user.User{}, ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := userKey(orgID, uu.Email.Address)
+	usr, exists := s.users[key]
+	if !exists {
+		return user.User{}, ErrNotFound
+	}
+
+	if uu.Name != nil {
+		usr.Name = *uu.Name
+	}
+	if len(uu.Roles) > 0 {
+		usr.Roles = append([]user.Role(nil), uu.Roles...)
+	}
+	if uu.Department != nil {
+		usr.Department = *uu.Department
+	}
+	if uu.Enabled != nil {
+		usr.Enabled = *uu.Enabled
+	}
+	if uu.MustChangePassword != nil {
+		usr.MustChangePassword = *uu.MustChangePassword
+	}
+	if uu.Attributes != nil {
+		attrs, err := normalize(uu.Attributes)
+		if err != nil {
+			return user.User{}, err
+		}
+		usr.Attributes = merge(usr.Attributes, attrs)
+	}
+	usr.DateUpdated = now.UTC()
+
+	s.users[key] = usr
+	return load(usr), nil
+}
+
+// Query retrieves a page of users in the organization that match the filter.
+func (s *Store) Query(ctx context.Context, orgID string, filter user.QueryFilter, orderBy user.OrderBy, pageNumber int, rowsPerPage int) ([]user.User, error) {
+	less, ok := orderByFields[orderBy.Field]
+	if !ok {
+		return nil, fmt.Errorf("cannot order by %q", orderBy.Field)
+	}
+
+	attrs, err := normalize(filter.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	var usrs []user.User
+	for _, usr := range s.users {
+		if usr.OrgID.String() == orgID && matches(usr, filter, attrs) {
+			usrs = append(usrs, usr)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(usrs, func(i, j int) bool {
+		a, b := usrs[i], usrs[j]
+		if orderBy.Direction == user.DESC {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return userKey(usrs[i].OrgID.String(), usrs[i].Email.Address) < userKey(usrs[j].OrgID.String(), usrs[j].Email.Address)
+	})
+
+	return page(usrs, pageNumber, rowsPerPage), nil
+}
+
+// QueryDormant retrieves enabled users in any organization who haven't
+// signed in since before, or were created before it and never have.
+func (s *Store) QueryDormant(ctx context.Context, before time.Time, limit int) ([]user.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var usrs []user.User
+	for _, usr := range s.users {
+		if len(usrs) == limit {
+			break
+		}
+		last := usr.LastLoginAt
+		if last.IsZero() {
+			last = usr.DateCreated
+		}
+		if usr.Enabled && last.Before(before) {
+			usrs = append(usrs, load(usr))
+		}
+	}
+	return usrs, nil
+}
+
+// UpdateLastLogin records when and where a user last signed in.
+func (s *Store) UpdateLastLogin(ctx context.Context, orgID string, email string, at time.Time, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := userKey(orgID, email)
+	usr, exists := s.users[key]
+	if !exists {
+		return ErrNotFound
+	}
+	usr.LastLoginAt = at.UTC()
+	usr.LastLoginIP = ip
+	s.users[key] = usr
+	return nil
+}
+
+// UpdatePassword replaces the password hash of a user and clears the must
+// change password flag.
+func (s *Store) UpdatePassword(ctx context.Context, orgID string, email string, hash []byte, now time.Time) (user.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := userKey(orgID, email)
+	usr, exists := s.users[key]
+	if !exists {
+		return user.User{}, ErrNotFound
+	}
+	usr.PasswordHash = append([]byte(nil), hash...)
+	usr.PasswordChangedAt = now.UTC()
+	usr.MustChangePassword = false
+	usr.DateUpdated = now.UTC()
+	s.users[key] = usr
+	return load(usr), nil
+}
+
+// Authenticate finds a user by their email and verifies their password.
+func (s *Store) Authenticate(ctx context.Context, orgID string, email string, password string) (user.User, error) {
+	usr, err := s.QueryByEmail(ctx, orgID, email)
+	if err != nil {
+		return user.User{}, fmt.Errorf("query: email[%s]: %w", email, err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword(usr.PasswordHash, []byte(password)); err != nil {
+		return user.User{}, fmt.Errorf("comparehashandpassword: %w", ErrAuthenticationFailure)
+	}
+
+	return usr, nil
+}
+
+// byID returns copies of the users of the organization by id. The group,
+// department and manager stores resolve the users they refer to with it, so
+// deleted users drop out of memberships and reporting lines as they do when
+// the nosql store removes their edges.
+func (s *Store) byID(orgID string) map[string]user.User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usrs := make(map[string]user.User)
+	for _, usr := range s.users {
+		if usr.OrgID.String() == orgID {
+			usrs[usr.ID.String()] = load(usr)
+		}
+	}
+	return usrs
+}
+
+// sortByName orders users by name, then by id so that the order is stable.
+func sortByName(usrs []user.User) {
+	sort.Slice(usrs, func(i, j int) bool {
+		if usrs[i].Name != usrs[j].Name {
+			return usrs[i].Name < usrs[j].Name
+		}
+		return usrs[i].ID.String() < usrs[j].ID.String()
+	})
+}
+
+// orderByFields maps the fields users can be ordered by to how they
+// compare. Users who never signed in sort first, as null does in AQL.
+var orderByFields = map[string]func(a, b user.User) bool{
+	user.OrderByName:        func(a, b user.User) bool { return a.Name < b.Name },
+	user.OrderByEmail:       func(a, b user.User) bool { return a.Email.Address < b.Email.Address },
+	user.OrderByDateCreated: func(a, b user.User) bool { return a.DateCreated.Before(b.DateCreated) },
+	user.OrderByLastLoginAt: func(a, b user.User) bool { return a.LastLoginAt.Before(b.LastLoginAt) },
+}
+
+// matches reports whether usr matches the filter. attrs are the filter's
+// attributes, normalized.
+func matches(usr user.User, filter user.QueryFilter, attrs map[string]any) bool {
+	if filter.Name != nil && usr.Name != *filter.Name {
+		return false
+	}
+	if filter.Email != nil && usr.Email.Address != *filter.Email {
+		return false
+	}
+	if filter.LastLoginBefore != nil && !usr.LastLoginAt.IsZero() && !usr.LastLoginAt.Before(*filter.LastLoginBefore) {
+		return false
+	}
+	if filter.LastLoginAfter != nil && (usr.LastLoginAt.IsZero() || usr.LastLoginAt.Before(*filter.LastLoginAfter)) {
+		return false
+	}
+	for name, want := range attrs {
+		if !equal(usr.Attributes[name], want) {
+			return false
+		}
+	}
+	return true
+}
+
+// page returns the users on the page, copied.
+func page(usrs []user.User, pageNumber int, rowsPerPage int) []user.User {
+	offset := (pageNumber - 1) * rowsPerPage
+	if offset < 0 || offset >= len(usrs) {
+		return nil
+	}
+	end := offset + rowsPerPage
+	if end > len(usrs) {
+		end = len(usrs)
+	}
+
+	out := make([]user.User, 0, end-offset)
+	for _, usr := range usrs[offset:end] {
+		out = append(out, load(usr))
+	}
+	return out
+}
+
+// paged returns a copy of the items on the page.
+func paged[T any](items []T, pageNumber int, rowsPerPage int) []T {
+	offset := (pageNumber - 1) * rowsPerPage
+	if offset < 0 || offset >= len(items) {
+		return nil
+	}
+	end := offset + rowsPerPage
+	if end > len(items) {
+		end = len(items)
+	}
+	return append([]T(nil), items[offset:end]...)
+}
+
+// userKey identifies a user. Emails are only unique within an
+// organization, so the key is scoped by the organization id.
+func userKey(orgID string, email string) string {
+	return orgID + ":" + email
+}
+
+// store copies usr for keeping. Times are kept in UTC and attributes as
+// they would be decoded from JSON, like the nosql store does.
+func store(usr user.User) (user.User, error) {
+	attrs, err := normalize(usr.Attributes)
+	if err != nil {
+		return user.User{}, err
+	}
+	usr.Attributes = attrs
+	usr.Roles = append([]user.Role(nil), usr.Roles...)
+	usr.PasswordHash = append([]byte(nil), usr.PasswordHash...)
+	usr.PasswordChangedAt = usr.PasswordChangedAt.UTC()
+	usr.LastLoginAt = usr.LastLoginAt.UTC()
+	usr.DateCreated = usr.DateCreated.UTC()
+	usr.DateUpdated = usr.DateUpdated.UTC()
+	return usr, nil
+}
+
+// load copies a kept user so callers can't change it.
+func load(usr user.User) user.User {
+	usr.Attributes = clone(usr.Attributes)
+	usr.Roles = append([]user.Role(nil), usr.Roles...)
+	usr.PasswordHash = append([]byte(nil), usr.PasswordHash...)
+	usr.PasswordChangedAt = usr.PasswordChangedAt.In(time.Local)
+	if !usr.LastLoginAt.IsZero() {
+		usr.LastLoginAt = usr.LastLoginAt.In(time.Local)
+	}
+	usr.DateCreated = usr.DateCreated.In(time.Local)
+	usr.DateUpdated = usr.DateUpdated.In(time.Local)
+	return usr
+}
+
+// normalize returns attrs as they would be decoded from JSON, so that
+// numbers compare the same however they were given.
+func normalize(attrs map[string]any) (map[string]any, error) {
+	if attrs == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, fmt.Errorf("encoding attributes: %w", err)
+	}
+	var out map[string]any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("decoding attributes: %w", err)
+	}
+	return out, nil
+}
+
+// merge merges upd into current the way ArangoDB merges documents without
+// keeping nulls: objects are merged recursively and nil values are
+// removed.
+func merge(current map[string]any, upd map[string]any) map[string]any {
+	merged := clone(current)
+	if merged == nil {
+		merged = make(map[string]any, len(upd))
+	}
+	for k, v := range upd {
+		switch v := v.(type) {
+		case nil:
+			delete(merged, k)
+		case map[string]any:
+			cur, _ := merged[k].(map[string]any)
+			merged[k] = merge(cur, v)
+		default:
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// clone deep copies JSON values.
+func clone(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = cloneValue(v)
+	}
+	return out
+}
+
+func cloneValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return clone(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = cloneValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// equal reports whether two JSON values are equal.
+func equal(a, b any) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ja, jb)
+}
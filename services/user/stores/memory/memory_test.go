@@ -0,0 +1,380 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/services/user/storertest"
+	"github.com/gitamped/bud/services/user/stores/memory"
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+	"github.com/gitamped/seed/values"
+	"github.com/gitamped/stem/data/nosql/dbtest"
+	"github.com/google/uuid"
+)
+
+func Test_Store(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	orgID := uuid.New()
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	newUser := func(name string) user.User {
+		return user.User{
+			ID:          uuid.New(),
+			OrgID:       orgID,
+			Name:        name,
+			Email:       mail.Address{Address: name + "@example.com"},
+			Roles:       []user.Role{user.RoleUser},
+			Enabled:     true,
+			Attributes:  map[string]any{"level": 3, "address": map[string]any{"city": "Oslo", "zip": "0150"}},
+			DateCreated: now,
+			DateUpdated: now,
+		}
+	}
+
+	t.Log("Given the need to keep users in memory.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling users.", testID)
+		{
+			bill, err := store.Create(ctx, newUser("bill"))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := store.Create(ctx, newUser("bill")); !errors.Is(err, memory.ErrUniqueEmail) {
+				t.Fatalf("\t%s\tTest %d:\tShould reject duplicate emails : got %v.", dbtest.Failed, testID, err)
+			}
+			other := newUser("bill")
+			other.OrgID = uuid.New()
+			if _, err := store.Create(ctx, other); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould allow the same email in another organization : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep emails unique within an organization.", dbtest.Success, testID)
+
+			got, err := store.QueryByID(ctx, orgID.String(), bill.ID.String())
+			if err != nil || got.Email != bill.Email {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query by id : got %+v, %v.", dbtest.Failed, testID, got, err)
+			}
+			if _, err := store.QueryByID(ctx, other.OrgID.String(), bill.ID.String()); !errors.Is(err, memory.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not find users of another organization : got %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := store.QueryByEmail(ctx, orgID.String(), "nobody@example.com"); !errors.Is(err, memory.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not find unknown users : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to query users.", dbtest.Success, testID)
+
+			// Changing what the store returned must not change what it keeps.
+			got.Attributes["address"].(map[string]any)["city"] = "Bergen"
+			name := "William"
+			upd, err := store.Update(ctx, orgID.String(), user.UpdateUser{
+				Email:      &bill.Email,
+				Name:       &name,
+				Attributes: map[string]any{"level": nil, "address": map[string]any{"zip": "0151"}},
+			}, now.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update user : %s.", dbtest.Failed, testID, err)
+			}
+			want := map[string]any{"address": map[string]any{"city": "Oslo", "zip": "0151"}}
+			if upd.Name != "William" || len(upd.Roles) != 1 || fmt.Sprint(upd.Attributes) != fmt.Sprint(want) || !upd.DateUpdated.Equal(now.Add(time.Hour)) {
+				t.Fatalf("\t%s\tTest %d:\tShould merge updates : got %+v.", dbtest.Failed, testID, upd)
+			}
+			nobody := mail.Address{Address: "nobody@example.com"}
+			if _, err := store.Update(ctx, orgID.String(), user.UpdateUser{Email: &nobody, Name: &name}, now); !errors.Is(err, memory.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not update unknown users : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould merge updates.", dbtest.Success, testID)
+
+			for _, name := range []string{"carol", "alice"} {
+				if _, err := store.Create(ctx, newUser(name)); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+				}
+			}
+			usrs, err := store.Query(ctx, orgID.String(), user.QueryFilter{}, user.OrderBy{Field: user.OrderByName, Direction: user.DESC}, 1, 2)
+			if err != nil || len(usrs) != 2 || usrs[0].Name != "carol" || usrs[1].Name != "alice" {
+				t.Fatalf("\t%s\tTest %d:\tShould order and page users : got %+v, %v.", dbtest.Failed, testID, usrs, err)
+			}
+			usrs, err = store.Query(ctx, orgID.String(), user.QueryFilter{Attributes: map[string]any{"level": 3.0}}, user.DefaultOrderBy, 1, 10)
+			if err != nil || len(usrs) != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould filter by attributes : got %+v, %v.", dbtest.Failed, testID, usrs, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould filter, order and page users.", dbtest.Success, testID)
+
+			if _, err := store.Delete(ctx, orgID.String(), bill.Email); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := store.Delete(ctx, orgID.String(), bill.Email); !errors.Is(err, memory.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not delete unknown users : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete users.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen used concurrently.", testID)
+		{
+			var wg sync.WaitGroup
+			errs := make(chan error, 50)
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					usr := newUser(fmt.Sprintf("user%d", i%10))
+					if _, err := store.Create(ctx, usr); err != nil && !errors.Is(err, memory.ErrUniqueEmail) {
+						errs <- err
+					}
+					store.Query(ctx, orgID.String(), user.QueryFilter{}, user.DefaultOrderBy, 1, 10)
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatalf("\t%s\tTest %d:\tShould be safe to use concurrently : %s.", dbtest.Failed, testID, err)
+			}
+			usrs, _ := store.Query(ctx, orgID.String(), user.QueryFilter{Name: strPtr("user3")}, user.DefaultOrderBy, 1, 10)
+			if len(usrs) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould create each email once : got %d.", dbtest.Failed, testID, len(usrs))
+			}
+			t.Logf("\t%s\tTest %d:\tShould be safe to use concurrently.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_ServiceStores(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+	userID := uuid.New()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	t.Log("Given the need to keep the other services in memory.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen locking jobs.", testID)
+		{
+			jobs := memory.NewJobStore()
+			slot := now.Truncate(time.Hour)
+			if ok, err := jobs.Lock(ctx, "job", "a", slot, now.Add(time.Minute)); !ok || err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould lock a free job : got %v, %v.", dbtest.Failed, testID, ok, err)
+			}
+			if ok, _ := jobs.Lock(ctx, "job", "b", slot.Add(time.Hour), now.Add(time.Minute)); ok {
+				t.Fatalf("\t%s\tTest %d:\tShould not lock a held job.", dbtest.Failed, testID)
+			}
+			jobs.Unlock(ctx, "job", "a")
+			if ok, _ := jobs.Lock(ctx, "job", "b", slot, now.Add(time.Minute)); ok {
+				t.Fatalf("\t%s\tTest %d:\tShould not run a slot twice.", dbtest.Failed, testID)
+			}
+			if ok, _ := jobs.Lock(ctx, "job", "b", slot.Add(time.Hour), now.Add(time.Minute)); !ok {
+				t.Fatalf("\t%s\tTest %d:\tShould lock the next slot once released.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould run each slot once.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen recording logins.", testID)
+		{
+			logins := memory.NewLoginStore()
+			for i := 0; i < 3; i++ {
+				l := user.Login{ID: uuid.New(), OrgID: orgID, UserID: userID, Success: i != 1, Method: "password", DateCreated: now.Add(time.Duration(i) * time.Minute)}
+				if err := logins.Create(ctx, l, 2); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to record a login : %s.", dbtest.Failed, testID, err)
+				}
+			}
+			ls, err := logins.Query(ctx, orgID.String(), userID.String(), user.LoginFilter{}, 1, 10)
+			if err != nil || len(ls) != 2 || !ls[0].DateCreated.Equal(now.Add(2*time.Minute)) {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the latest logins, newest first : got %+v, %v.", dbtest.Failed, testID, ls, err)
+			}
+			failed := false
+			ls, _ = logins.Query(ctx, orgID.String(), userID.String(), user.LoginFilter{Success: &failed}, 1, 10)
+			if len(ls) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould filter failed logins : got %d.", dbtest.Failed, testID, len(ls))
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep and filter the latest logins.", dbtest.Success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen linking identities and registering webhooks.", testID)
+		{
+			identities := memory.NewIdentityStore()
+			link := user.IdentityLink{Issuer: "https://idp.example.com", Subject: "bill", OrgID: orgID, UserID: userID, DateCreated: now}
+			if _, err := identities.Create(ctx, link); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to link an identity : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := identities.Create(ctx, link); !errors.Is(err, memory.ErrIdentityLinked) {
+				t.Fatalf("\t%s\tTest %d:\tShould link a subject once : got %v.", dbtest.Failed, testID, err)
+			}
+			if got, _ := identities.QueryBySubject(ctx, link.Issuer, "other"); got.UserID != uuid.Nil {
+				t.Fatalf("\t%s\tTest %d:\tShould not find unlinked subjects : got %+v.", dbtest.Failed, testID, got)
+			}
+
+			webhooks := memory.NewWebhookStore()
+			wh := user.Webhook{ID: uuid.New(), OrgID: orgID, URL: "https://example.com/hook", Events: []string{"user.created"}, DateCreated: now, DateUpdated: now}
+			if _, err := webhooks.Create(ctx, wh); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to register a webhook : %s.", dbtest.Failed, testID, err)
+			}
+			if whs, _ := webhooks.QueryByEvent(ctx, orgID.String(), "user.deleted"); len(whs) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould only match subscribed events : got %d.", dbtest.Failed, testID, len(whs))
+			}
+			if _, err := webhooks.QueryByID(ctx, uuid.NewString(), wh.ID.String()); !errors.Is(err, memory.ErrWebhookNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould scope webhooks to their organization : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould link identities and match webhooks.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_RelationStores(t *testing.T) {
+	ctx := context.Background()
+	users := memory.NewStore()
+	orgID := uuid.New()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	newUser := func(name string, dept string) user.User {
+		usr, err := users.Create(ctx, user.User{ID: uuid.New(), OrgID: orgID, Name: name, Email: mail.Address{Address: name + "@example.com"}, Department: dept, Enabled: true, DateCreated: now, DateUpdated: now})
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", dbtest.Failed, err)
+		}
+		return usr
+	}
+
+	t.Log("Given the need to keep groups, departments, reporting lines and organizations in memory.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen managing group members.", testID)
+		{
+			groups := memory.NewGroupStore(users)
+			grp, _ := groups.Create(ctx, user.Group{ID: uuid.New(), OrgID: orgID, Name: "admins", DateCreated: now, DateUpdated: now})
+			bill, sue := newUser("bill", ""), newUser("sue", "")
+			for _, usr := range []user.User{sue, bill, bill} {
+				if err := groups.AddMember(ctx, orgID.String(), grp.ID.String(), usr.ID.String()); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to add a member : %s.", dbtest.Failed, testID, err)
+				}
+			}
+			if err := groups.AddMember(ctx, uuid.NewString(), grp.ID.String(), bill.ID.String()); !errors.Is(err, memory.ErrGroupMemberNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not add members to groups of another organization : got %v.", dbtest.Failed, testID, err)
+			}
+			members, err := groups.QueryMembers(ctx, orgID.String(), grp.ID.String())
+			if err != nil || len(members) != 2 || members[0].Name != "bill" {
+				t.Fatalf("\t%s\tTest %d:\tShould list the members by name : got %+v, %v.", dbtest.Failed, testID, members, err)
+			}
+			users.Delete(ctx, orgID.String(), bill.Email)
+			newUser("bill", "")
+			if members, _ := groups.QueryMembers(ctx, orgID.String(), grp.ID.String()); len(members) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould drop deleted users from their groups : got %+v.", dbtest.Failed, testID, members)
+			}
+			t.Logf("\t%s\tTest %d:\tShould manage group members.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen managing departments.", testID)
+		{
+			depts := memory.NewDepartmentStore(users)
+			eng, _ := depts.Create(ctx, user.Department{ID: uuid.New(), OrgID: orgID, Name: "engineering", DateCreated: now, DateUpdated: now})
+			web, err := depts.Create(ctx, user.Department{ID: uuid.New(), OrgID: orgID, ParentID: eng.ID.String(), Name: "web", DateCreated: now, DateUpdated: now})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a sub-department : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := depts.Move(ctx, orgID.String(), eng.ID.String(), web.ID.String(), now); !errors.Is(err, user.ErrDepartmentCycle) {
+				t.Fatalf("\t%s\tTest %d:\tShould not move a department below its descendants : got %v.", dbtest.Failed, testID, err)
+			}
+			newUser("ann", web.ID.String())
+			members, err := depts.QueryMembers(ctx, orgID.String(), eng.ID.String(), true)
+			if err != nil || len(members) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould list the members of sub-departments : got %+v, %v.", dbtest.Failed, testID, members, err)
+			}
+			if _, err := depts.Delete(ctx, orgID.String(), web.ID.String()); !errors.Is(err, user.ErrDepartmentNotEmpty) {
+				t.Fatalf("\t%s\tTest %d:\tShould not delete departments with users : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep departments a tree.", dbtest.Success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen setting managers.", testID)
+		{
+			managers := memory.NewManagerStore(users)
+			ceo, cto, dev := newUser("ceo", ""), newUser("cto", ""), newUser("dev", "")
+			managers.SetManager(ctx, orgID.String(), cto.ID.String(), ceo.ID.String())
+			managers.SetManager(ctx, orgID.String(), dev.ID.String(), cto.ID.String())
+			if err := managers.SetManager(ctx, orgID.String(), ceo.ID.String(), dev.ID.String()); !errors.Is(err, user.ErrManagerCycle) {
+				t.Fatalf("\t%s\tTest %d:\tShould not let managers report to their reports : got %v.", dbtest.Failed, testID, err)
+			}
+			chain, err := managers.QueryChain(ctx, orgID.String(), dev.ID.String())
+			if err != nil || len(chain) != 2 || chain[0].ID != cto.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould list the managers nearest first : got %+v, %v.", dbtest.Failed, testID, chain, err)
+			}
+			tree, err := managers.QueryTree(ctx, orgID.String(), ceo.ID.String())
+			if err != nil || len(tree) != 2 || tree[1].Depth != 2 || tree[1].ManagerID != cto.ID.String() {
+				t.Fatalf("\t%s\tTest %d:\tShould list the reporting tree : got %+v, %v.", dbtest.Failed, testID, tree, err)
+			}
+			users.Delete(ctx, orgID.String(), cto.Email)
+			if tree, _ := managers.QueryTree(ctx, orgID.String(), ceo.ID.String()); len(tree) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould drop the reporting lines of deleted users : got %+v.", dbtest.Failed, testID, tree)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep reporting lines free of cycles.", dbtest.Success, testID)
+		}
+
+		testID = 3
+		t.Logf("\tTest %d:\tWhen deleting organizations.", testID)
+		{
+			orgs := memory.NewOrgStore(users)
+			orgs.Create(ctx, user.Organization{ID: orgID, Name: "acme", DateCreated: now, DateUpdated: now})
+			if _, err := orgs.Delete(ctx, orgID.String()); !errors.Is(err, memory.ErrOrgNotEmpty) {
+				t.Fatalf("\t%s\tTest %d:\tShould not delete organizations with users : got %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := orgs.Delete(ctx, uuid.NewString()); !errors.Is(err, memory.ErrOrgNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould not delete unknown organizations : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only delete empty organizations.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Conformance(t *testing.T) {
+	storertest.Run(t, memory.NewStore(), storertest.Errors{
+		NotFound:              memory.ErrNotFound,
+		UniqueEmail:           memory.ErrUniqueEmail,
+		AuthenticationFailure: memory.ErrAuthenticationFailure,
+	})
+}
+
+func Test_UserService(t *testing.T) {
+	core := user.NewUserServicer(nil, memory.NewStore(), nil)
+	gr := server.GenericRequest{
+		Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: uuid.NewString()}),
+		Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+		Values: &values.Values{Now: time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	t.Log("Given the need to run the user service without a database.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen creating and querying a user.", testID)
+		{
+			email, _ := mail.ParseAddress("bill@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Bill"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			resp := core.CreateUser(nu, gr)
+			if resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+
+			qu := core.QueryUserByEmail(user.QueryUserByEmailRequest{Email: email.Address}, gr)
+			if qu.Error != "" || qu.User.ID != resp.User.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the user : got %+v.", dbtest.Failed, testID, qu)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create and query the user.", dbtest.Success, testID)
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+var (
+	ErrOrgNotFound = errors.New("organization not found")
+	ErrOrgNotEmpty = errors.New("organization still has users")
+	ErrOrgExists   = errors.New("organization already exists")
+)
+
+// OrgStore is a thread-safe, in-memory organization store. Organizations
+// are the tenants themselves, so unlike the other stores its queries are
+// not tenant-filtered.
+type OrgStore struct {
+	mu    sync.RWMutex
+	users *Store
+	orgs  map[string]user.Organization
+}
+
+// NewOrgStore constructs an empty organization store for the users of
+// users.
+func NewOrgStore(users *Store) *OrgStore {
+	return &OrgStore{
+		users: users,
+		orgs:  make(map[string]user.Organization),
+	}
+}
+
+// Create adds a new organization.
+func (s *OrgStore) Create(ctx context.Context, org user.Organization) (user.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.orgs[org.ID.String()]; exists {
+		return user.Organization{}, ErrOrgExists
+	}
+	org.DateCreated = org.DateCreated.UTC()
+	org.DateUpdated = org.DateUpdated.UTC()
+	s.orgs[org.ID.String()] = org
+	return org, nil
+}
+
+// Update updates the set fields of an organization.
+func (s *OrgStore) Update(ctx context.Context, id string, uo user.UpdateOrganization, now time.Time) (user.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, exists := s.orgs[id]
+	if !exists {
+		return user.Organization{}, ErrOrgNotFound
+	}
+	if uo.Name != nil {
+		org.Name = *uo.Name
+	}
+	org.DateUpdated = now.UTC()
+	s.orgs[id] = org
+	return org, nil
+}
+
+// Delete deletes an organization. Organizations that still have users can't
+// be deleted.
+func (s *OrgStore) Delete(ctx context.Context, id string) (user.Organization, error) {
+	if len(s.users.byID(id)) > 0 {
+		return user.Organization{}, ErrOrgNotEmpty
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, exists := s.orgs[id]
+	if !exists {
+		return user.Organization{}, ErrOrgNotFound
+	}
+	delete(s.orgs, id)
+	return org, nil
+}
+
+// QueryByID queries an organization by id.
+func (s *OrgStore) QueryByID(ctx context.Context, id string) (user.Organization, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	org, exists := s.orgs[id]
+	if !exists {
+		return user.Organization{}, ErrOrgNotFound
+	}
+	return org, nil
+}
+
+// Query retrieves all organizations ordered by name.
+func (s *OrgStore) Query(ctx context.Context) ([]user.Organization, error) {
+	s.mu.RLock()
+	orgs := make([]user.Organization, 0, len(s.orgs))
+	for _, org := range s.orgs {
+		orgs = append(orgs, org)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(orgs, func(i, j int) bool {
+		if orgs[i].Name != orgs[j].Name {
+			return orgs[i].Name < orgs[j].Name
+		}
+		return orgs[i].ID.String() < orgs[j].ID.String()
+	})
+	return orgs, nil
+}
@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+// ErrTokenExists is returned when a token with the same hash is stored twice.
+var ErrTokenExists = errors.New("token already exists")
+
+// TokenStore is a thread-safe, in-memory token store keyed by hash.
+type TokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]user.Token
+}
+
+// NewTokenStore constructs an empty token store.
+func NewTokenStore() *TokenStore {
+	return &TokenStore{
+		tokens: make(map[string]user.Token),
+	}
+}
+
+// Create stores a token.
+func (s *TokenStore) Create(ctx context.Context, tkn user.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.tokens[tkn.Hash]; exists {
+		return ErrTokenExists
+	}
+	tkn.ExpiresAt = tkn.ExpiresAt.UTC()
+	tkn.DateCreated = tkn.DateCreated.UTC()
+	s.tokens[tkn.Hash] = tkn
+	return nil
+}
+
+// Consume removes and returns the unexpired token with the hash.
+func (s *TokenStore) Consume(ctx context.Context, purpose string, hash string, now time.Time) (user.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tkn, exists := s.tokens[hash]
+	if !exists || tkn.Purpose != purpose || !now.Before(tkn.ExpiresAt) {
+		return user.Token{}, user.ErrTokenInvalid
+	}
+	delete(s.tokens, hash)
+	return tkn, nil
+}
+
+// DeleteExpired removes up to limit tokens that expired before before.
+func (s *TokenStore) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for hash, tkn := range s.tokens {
+		if n == limit {
+			break
+		}
+		if tkn.ExpiresAt.Before(before) {
+			delete(s.tokens, hash)
+			n++
+		}
+	}
+	return n, nil
+}
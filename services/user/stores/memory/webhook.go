@@ -0,0 +1,180 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/gitamped/bud/services/user"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	ErrWebhookExists    = errors.New("webhook already exists")
+)
+
+// WebhookStore is a thread-safe, in-memory store of webhooks and their
+// delivery log.
+type WebhookStore struct {
+	mu         sync.RWMutex
+	webhooks   map[string]user.Webhook
+	deliveries map[string]user.Delivery
+}
+
+// NewWebhookStore constructs an empty webhook store.
+func NewWebhookStore() *WebhookStore {
+	return &WebhookStore{
+		webhooks:   make(map[string]user.Webhook),
+		deliveries: make(map[string]user.Delivery),
+	}
+}
+
+// Create adds a new webhook.
+func (s *WebhookStore) Create(ctx context.Context, wh user.Webhook) (user.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.webhooks[wh.ID.String()]; exists {
+		return user.Webhook{}, ErrWebhookExists
+	}
+	wh = copyWebhook(wh)
+	wh.DateCreated = wh.DateCreated.UTC()
+	wh.DateUpdated = wh.DateUpdated.UTC()
+	s.webhooks[wh.ID.String()] = wh
+	return copyWebhook(wh), nil
+}
+
+// Delete removes a webhook of the organization. Its deliveries are kept
+// in the log.
+func (s *WebhookStore) Delete(ctx context.Context, orgID string, id string) (user.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wh, exists := s.webhooks[id]
+	if !exists || wh.OrgID.String() != orgID {
+		return user.Webhook{}, ErrWebhookNotFound
+	}
+	delete(s.webhooks, id)
+	return copyWebhook(wh), nil
+}
+
+// Query retrieves the webhooks of an organization ordered by url.
+func (s *WebhookStore) Query(ctx context.Context, orgID string) ([]user.Webhook, error) {
+	whs := s.query(orgID, func(user.Webhook) bool { return true })
+	sort.Slice(whs, func(i, j int) bool {
+		if whs[i].URL != whs[j].URL {
+			return whs[i].URL < whs[j].URL
+		}
+		return whs[i].ID.String() < whs[j].ID.String()
+	})
+	return whs, nil
+}
+
+// QueryByEvent retrieves the webhooks of an organization subscribed to
+// eventType, either explicitly or by subscribing to every event.
+func (s *WebhookStore) QueryByEvent(ctx context.Context, orgID string, eventType string) ([]user.Webhook, error) {
+	return s.query(orgID, func(wh user.Webhook) bool {
+		if len(wh.Events) == 0 {
+			return true
+		}
+		for _, typ := range wh.Events {
+			if typ == eventType {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// QueryByID gets the specified webhook of the organization.
+func (s *WebhookStore) QueryByID(ctx context.Context, orgID string, id string) (user.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wh, exists := s.webhooks[id]
+	if !exists || wh.OrgID.String() != orgID {
+		return user.Webhook{}, ErrWebhookNotFound
+	}
+	return copyWebhook(wh), nil
+}
+
+// SaveDelivery adds a delivery or replaces it with its latest attempt.
+func (s *WebhookStore) SaveDelivery(ctx context.Context, d user.Delivery) (user.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d = copyDelivery(d)
+	d.NextAttemptAt = d.NextAttemptAt.UTC()
+	d.DateCreated = d.DateCreated.UTC()
+	d.DateUpdated = d.DateUpdated.UTC()
+	s.deliveries[d.ID.String()] = d
+	return copyDelivery(d), nil
+}
+
+// QueryDeliveryByID gets the specified delivery of the organization.
+func (s *WebhookStore) QueryDeliveryByID(ctx context.Context, orgID string, id string) (user.Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, exists := s.deliveries[id]
+	if !exists || d.OrgID.String() != orgID {
+		return user.Delivery{}, ErrDeliveryNotFound
+	}
+	return copyDelivery(d), nil
+}
+
+// QueryDeliveries retrieves the delivery log of an organization, newest
+// first.
+func (s *WebhookStore) QueryDeliveries(ctx context.Context, orgID string, filter user.DeliveryFilter, pageNumber int, rowsPerPage int) ([]user.Delivery, error) {
+	s.mu.RLock()
+	var ds []user.Delivery
+	for _, d := range s.deliveries {
+		switch {
+		case d.OrgID.String() != orgID:
+		case filter.WebhookID != nil && d.WebhookID.String() != *filter.WebhookID:
+		case filter.Status != nil && d.Status != *filter.Status:
+		default:
+			ds = append(ds, copyDelivery(d))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(ds, func(i, j int) bool {
+		if !ds[i].DateCreated.Equal(ds[j].DateCreated) {
+			return ds[i].DateCreated.After(ds[j].DateCreated)
+		}
+		return ds[i].ID.String() > ds[j].ID.String()
+	})
+	return paged(ds, pageNumber, rowsPerPage), nil
+}
+
+// query returns the webhooks of the organization that match.
+func (s *WebhookStore) query(orgID string, match func(wh user.Webhook) bool) []user.Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var whs []user.Webhook
+	for _, wh := range s.webhooks {
+		if wh.OrgID.String() == orgID && match(wh) {
+			whs = append(whs, copyWebhook(wh))
+		}
+	}
+	return whs
+}
+
+// copyWebhook copies a webhook so callers and the store don't share
+// slices.
+func copyWebhook(wh user.Webhook) user.Webhook {
+	wh.Events = append([]string(nil), wh.Events...)
+	return wh
+}
+
+// copyDelivery copies a delivery so callers and the store don't share
+// the payload.
+func copyDelivery(d user.Delivery) user.Delivery {
+	d.Payload = append(json.RawMessage(nil), d.Payload...)
+	return d
+}
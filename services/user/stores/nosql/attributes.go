@@ -0,0 +1,87 @@
+package nosql
+
+import (
+	"context"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const attributeSchemaCollectionName = "attribute_schemas"
+
+// AttributeStore manages the JSON Schemas that custom user attributes are
+// validated against.
+type AttributeStore struct {
+	db  driver.Database
+	col driver.Collection
+	log *zap.SugaredLogger
+}
+
+// NewAttributeStore constructs the api for attribute schema data access.
+func NewAttributeStore(log *zap.SugaredLogger, db driver.Database) *AttributeStore {
+	col, err := db.Collection(context.Background(), attributeSchemaCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &AttributeStore{
+		log: log,
+		db:  db,
+		col: col,
+	}
+}
+
+// Save creates or replaces the attribute schema of an organization, or of the
+// deployment when the schema has no organization.
+func (s *AttributeStore) Save(ctx context.Context, schema user.AttributeSchema) (user.AttributeSchema, error) {
+	query := `UPSERT { _key: @doc._key }
+	INSERT @doc
+	REPLACE @doc
+	IN @@coll
+	RETURN NEW`
+
+	bindvars := map[string]interface{}{
+		"@coll": attributeSchemaCollectionName,
+		"doc":   toDBAttributeSchema(schema),
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return user.AttributeSchema{}, err
+	}
+	defer c.Close()
+
+	var result dbAttributeSchema
+	_, err = c.ReadDocument(ctx, &result)
+	return toCoreAttributeSchema(result), err
+}
+
+// Query returns the organization's schema, or the deployment schema when the
+// organization has none. A zero AttributeSchema is returned when neither
+// exists.
+func (s *AttributeStore) Query(ctx context.Context, orgID string) (user.AttributeSchema, error) {
+	query := `FOR k IN [@org_key, @deployment_key]
+	LET sch = DOCUMENT(@coll, k)
+	FILTER sch != null
+	LIMIT 1
+	RETURN sch`
+
+	bindvars := map[string]interface{}{
+		"coll":           attributeSchemaCollectionName,
+		"org_key":        attributeSchemaKey(orgID),
+		"deployment_key": attributeSchemaKey(""),
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return user.AttributeSchema{}, err
+	}
+	defer c.Close()
+
+	var result dbAttributeSchema
+	_, err = c.ReadDocument(ctx, &result)
+	if driver.IsNoMoreDocuments(err) {
+		return user.AttributeSchema{}, nil
+	}
+	return toCoreAttributeSchema(result), err
+}
@@ -0,0 +1,117 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const clientCollectionName = "oauth_clients"
+
+var ErrClientNotFound = errors.New("client not found")
+
+// ClientStore manages the OAuth2 clients collection.
+type ClientStore struct {
+	db  driver.Database
+	col driver.Collection
+	log *zap.SugaredLogger
+}
+
+// NewClientStore constructs the api for client data access.
+func NewClientStore(log *zap.SugaredLogger, db driver.Database) *ClientStore {
+	col, err := db.Collection(context.Background(), clientCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &ClientStore{
+		log: log,
+		db:  db,
+		col: col,
+	}
+}
+
+// Create inserts a new client into the database.
+func (s *ClientStore) Create(ctx context.Context, clt user.Client) (user.Client, error) {
+	var result dbClient
+	ctx = driver.WithReturnNew(ctx, &result)
+	_, err := s.col.CreateDocument(ctx, toDBClient(clt))
+	return toCoreClient(result), err
+}
+
+// QueryByID queries a client by id in any organization.
+func (s *ClientStore) QueryByID(ctx context.Context, id string) (user.Client, error) {
+	var result dbClient
+	_, err := s.col.ReadDocument(ctx, id, &result)
+	if driver.IsNotFound(err) {
+		return user.Client{}, ErrClientNotFound
+	}
+	return toCoreClient(result), err
+}
+
+// Query retrieves the clients of an organization ordered by name.
+func (s *ClientStore) Query(ctx context.Context, orgID string) ([]user.Client, error) {
+	query := `FOR c IN @@coll
+	FILTER c.org_id == @org_id
+	SORT c.name
+	RETURN c`
+
+	bindvars := map[string]interface{}{
+		"@coll":  clientCollectionName,
+		"org_id": orgID,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	clts, err := readAll[dbClient](ctx, c)
+	return toCoreClientSlice(clts), err
+}
+
+// UpdateSecret replaces the secret hash of a client.
+func (s *ClientStore) UpdateSecret(ctx context.Context, orgID string, id string, hash []byte, now time.Time) (user.Client, error) {
+	return s.update(ctx, orgID, id, map[string]interface{}{
+		"secret_hash":  hash,
+		"date_updated": now.UTC(),
+	})
+}
+
+// Revoke marks a client as revoked.
+func (s *ClientStore) Revoke(ctx context.Context, orgID string, id string, now time.Time) (user.Client, error) {
+	return s.update(ctx, orgID, id, map[string]interface{}{
+		"revoked":      true,
+		"date_updated": now.UTC(),
+	})
+}
+
+// update merges upd into a client of the organization.
+func (s *ClientStore) update(ctx context.Context, orgID string, id string, upd map[string]interface{}) (user.Client, error) {
+	query := `FOR c IN @@coll
+	FILTER c._key == @id AND c.org_id == @org_id
+	UPDATE c WITH @upd IN @@coll
+	RETURN NEW`
+
+	bindvars := map[string]interface{}{
+		"@coll":  clientCollectionName,
+		"id":     id,
+		"org_id": orgID,
+		"upd":    upd,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return user.Client{}, err
+	}
+	defer c.Close()
+
+	var result dbClient
+	_, err = c.ReadDocument(ctx, &result)
+	if driver.IsNoMoreDocuments(err) {
+		return user.Client{}, ErrClientNotFound
+	}
+	return toCoreClient(result), err
+}
@@ -0,0 +1,680 @@
+package nosql
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/seed/auth"
+	"github.com/google/uuid"
+)
+
+const dataKeyCollectionName = "data_keys"
+
+// indexKeyID is the id of the key behind the blind indexes. It is never
+// rotated: the blind index of an email is part of the document key of its
+// user, so a new index key would mean moving every user.
+const indexKeyID = "index"
+
+// encryptedPrefix starts encrypted values, which are
+// enc:<data key id>:<base64 nonce and ciphertext>. Values without it were
+// written before encryption was turned on.
+const encryptedPrefix = "enc:"
+
+// ErrUnknownDataKey is returned when a value was encrypted with a data key
+// the database doesn't have.
+var ErrUnknownDataKey = errors.New("unknown data key")
+
+// dbDataKey is a data key, wrapped by a key from the keystore.
+type dbDataKey struct {
+	Key         string    `json:"_key"`
+	KID         string    `json:"kid"`
+	WrappedKey  []byte    `json:"wrapped_key"`
+	Seq         int64     `json:"seq"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+// Cipher encrypts the personal data of users with AES-GCM, along with the
+// outbox events and webhook deliveries that carry them. Values are
+// encrypted with data keys stored in the database, each wrapped with
+// RSA-OAEP by a key from the keystore, so the database alone can't decrypt
+// them. The newest data key encrypts new values; older ones are kept to
+// decrypt values until they are re-encrypted.
+type Cipher struct {
+	col  driver.Collection
+	keys auth.KeyLookup
+	kid  string
+
+	mu       sync.RWMutex
+	aeads    map[string]cipher.AEAD
+	active   string
+	indexKey []byte
+}
+
+// NewCipher loads the data keys from the database, creating the first data
+// key and the index key when there are none. New data keys are wrapped by
+// the kid key from keys.
+func NewCipher(ctx context.Context, db driver.Database, keys auth.KeyLookup, kid string) (*Cipher, error) {
+	if _, err := keys.PublicKey(kid); err != nil {
+		return nil, fmt.Errorf("looking up key %s: %w", kid, err)
+	}
+	col, err := db.Collection(ctx, dataKeyCollectionName)
+	if err != nil {
+		return nil, fmt.Errorf("opening collection %s, has the database been migrated: %w", dataKeyCollectionName, err)
+	}
+
+	c := &Cipher{
+		col:   col,
+		keys:  keys,
+		kid:   kid,
+		aeads: make(map[string]cipher.AEAD),
+	}
+	if err := c.Load(ctx); err != nil {
+		return nil, err
+	}
+
+	// Another instance may create the index key first.
+	if c.indexKey == nil {
+		if err := c.create(ctx, indexKeyID); err != nil && !driver.IsConflict(err) {
+			return nil, err
+		}
+	}
+	if c.ActiveKey() == "" {
+		if _, err := c.Rotate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c, c.Load(ctx)
+}
+
+// ActiveKey returns the id of the data key new values are encrypted with.
+func (c *Cipher) ActiveKey() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.active
+}
+
+// Load reads the data keys from the database, picking up the keys other
+// instances created.
+func (c *Cipher) Load(ctx context.Context) error {
+	query := `FOR k IN @@coll RETURN k`
+	cursor, err := c.col.Database().Query(ctx, query, map[string]interface{}{"@coll": dataKeyCollectionName})
+	if err != nil {
+		return err
+	}
+	dbKeys, err := readAll[dbDataKey](ctx, cursor)
+	if err != nil {
+		return err
+	}
+
+	aeads := make(map[string]cipher.AEAD, len(dbKeys))
+	var active string
+	var activeSeq int64
+	var indexKey []byte
+	for _, dbKey := range dbKeys {
+		key, err := c.unwrap(dbKey)
+		if err != nil {
+			return err
+		}
+		if dbKey.Key == indexKeyID {
+			indexKey = key
+			continue
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("data key %s: %w", dbKey.Key, err)
+		}
+		if aeads[dbKey.Key], err = cipher.NewGCM(block); err != nil {
+			return fmt.Errorf("data key %s: %w", dbKey.Key, err)
+		}
+		if dbKey.Seq > activeSeq {
+			active, activeSeq = dbKey.Key, dbKey.Seq
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.aeads = aeads
+	c.active = active
+	c.indexKey = indexKey
+	return nil
+}
+
+// Rotate creates a data key that encrypts new values from now on, and
+// returns its id. Existing values are re-encrypted by Store.Reencrypt.
+func (c *Cipher) Rotate(ctx context.Context) (string, error) {
+	id := uuid.NewString()
+	if err := c.create(ctx, id); err != nil {
+		return "", err
+	}
+	return id, c.Load(ctx)
+}
+
+// Rewrap wraps the data keys wrapped by other keystore keys with the kid
+// key, so the old keystore keys can be retired. It returns the number of
+// data keys rewrapped.
+func (c *Cipher) Rewrap(ctx context.Context) (int, error) {
+	query := `FOR k IN @@coll FILTER k.kid != @kid RETURN k`
+	bindvars := map[string]interface{}{
+		"@coll": dataKeyCollectionName,
+		"kid":   c.kid,
+	}
+	cursor, err := c.col.Database().Query(ctx, query, bindvars)
+	if err != nil {
+		return 0, err
+	}
+	dbKeys, err := readAll[dbDataKey](ctx, cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, dbKey := range dbKeys {
+		key, err := c.unwrap(dbKey)
+		if err != nil {
+			return i, err
+		}
+		wrapped, err := c.wrap(dbKey.Key, key)
+		if err != nil {
+			return i, err
+		}
+		upd := map[string]interface{}{
+			"kid":         c.kid,
+			"wrapped_key": wrapped,
+		}
+		if _, err := c.col.UpdateDocument(ctx, dbKey.Key, upd); err != nil {
+			return i, fmt.Errorf("rewrapping data key %s: %w", dbKey.Key, err)
+		}
+	}
+	return len(dbKeys), nil
+}
+
+// create stores a new random key under the id.
+func (c *Cipher) create(ctx context.Context, id string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("generating data key: %w", err)
+	}
+	wrapped, err := c.wrap(id, key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	dbKey := dbDataKey{
+		Key:         id,
+		KID:         c.kid,
+		WrappedKey:  wrapped,
+		Seq:         now.UnixNano(),
+		DateCreated: now.UTC(),
+	}
+	_, err = c.col.CreateDocument(ctx, dbKey)
+	return err
+}
+
+// wrap encrypts the key with the kid key. The id is bound to the wrapped
+// key, so it can't be passed off as another data key.
+func (c *Cipher) wrap(id string, key []byte) ([]byte, error) {
+	pub, err := c.keys.PublicKey(c.kid)
+	if err != nil {
+		return nil, fmt.Errorf("looking up key %s: %w", c.kid, err)
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("wrapping data key %s: %w", id, err)
+	}
+	return wrapped, nil
+}
+
+func (c *Cipher) unwrap(dbKey dbDataKey) ([]byte, error) {
+	priv, err := c.keys.PrivateKey(dbKey.KID)
+	if err != nil {
+		return nil, fmt.Errorf("looking up key %s for data key %s: %w", dbKey.KID, dbKey.Key, err)
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), nil, priv, dbKey.WrappedKey, []byte(dbKey.Key))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key %s: %w", dbKey.Key, err)
+	}
+	return key, nil
+}
+
+// blindIndex returns a keyed hash of the email, which finds the user
+// without revealing the email. Equal emails in an organization have equal
+// indexes.
+func (c *Cipher) blindIndex(orgID string, email string) string {
+	c.mu.RLock()
+	mac := hmac.New(sha256.New, c.indexKey)
+	c.mu.RUnlock()
+
+	mac.Write([]byte(orgID + "\x00" + email))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// userKey builds the document key of a user from the blind index of their
+// email.
+func (c *Cipher) userKey(orgID string, email string) string {
+	return orgID + ":" + c.blindIndex(orgID, email)
+}
+
+// encrypt encrypts a field of the document with the key. The field and
+// document key are authenticated, so values can't be moved between fields
+// or users. Empty values stay empty.
+func encrypt(id string, aead cipher.AEAD, docKey string, field string, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generating nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(docKey+"\x00"+field))
+	return encryptedPrefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt decrypts a field of the document. Values that aren't encrypted
+// are returned as they are.
+func (c *Cipher) decrypt(docKey string, field string, value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	id, data, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", fmt.Errorf("decrypting %s: malformed value", field)
+	}
+
+	c.mu.RLock()
+	aead, ok := c.aeads[id]
+	c.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("decrypting %s: %w: %s", field, ErrUnknownDataKey, id)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("decrypting %s: malformed value", field)
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, []byte(docKey+"\x00"+field))
+	if err != nil {
+		return "", fmt.Errorf("decrypting %s: %w", field, err)
+	}
+	return string(plain), nil
+}
+
+// sealedFields are the fields of a user that are encrypted, by name. The
+// department is left out on purpose: it only holds a department ID, which
+// the department queries traverse and filter on.
+func sealedFields(dbUsr *dbUser) map[string]*string {
+	return map[string]*string{
+		"name":       &dbUsr.Name,
+		"email":      &dbUsr.Email,
+		"email_name": &dbUsr.EmailName,
+	}
+}
+
+// seal keys the user by the blind index of their email and encrypts their
+// personal data with the active data key.
+func (c *Cipher) seal(dbUsr *dbUser) error {
+	c.mu.RLock()
+	id := c.active
+	aead := c.aeads[id]
+	c.mu.RUnlock()
+
+	orgID := dbUsr.OrgID.String()
+	dbUsr.Key = c.userKey(orgID, dbUsr.Email)
+	dbUsr.EmailIndex = c.blindIndex(orgID, dbUsr.Email)
+	dbUsr.DataKey = id
+	for field, value := range sealedFields(dbUsr) {
+		sealed, err := encrypt(id, aead, dbUsr.Key, field, *value)
+		if err != nil {
+			return err
+		}
+		*value = sealed
+	}
+	return nil
+}
+
+// sealValue encrypts a field of the document with the active data key.
+func (c *Cipher) sealValue(docKey string, field string, value string) (string, error) {
+	c.mu.RLock()
+	id := c.active
+	aead := c.aeads[id]
+	c.mu.RUnlock()
+
+	return encrypt(id, aead, docKey, field, value)
+}
+
+// open decrypts the personal data of the user.
+func (c *Cipher) open(dbUsr *dbUser) error {
+	for field, value := range sealedFields(dbUsr) {
+		plain, err := c.decrypt(dbUsr.Key, field, *value)
+		if err != nil {
+			return err
+		}
+		*value = plain
+	}
+	return nil
+}
+
+// openUser maps a user read from the database, decrypting them when c isn't
+// nil. The data keys are reloaded when the user was encrypted with one
+// created by another instance since they were loaded.
+func openUser(ctx context.Context, c *Cipher, dbUsr dbUser) (user.User, error) {
+	if c == nil {
+		return toCoreUser(dbUsr), nil
+	}
+	sealed := dbUsr
+	err := c.open(&dbUsr)
+	if errors.Is(err, ErrUnknownDataKey) {
+		if err = c.Load(ctx); err == nil {
+			dbUsr = sealed
+			err = c.open(&dbUsr)
+		}
+	}
+	if err != nil {
+		return user.User{}, fmt.Errorf("user[%s]: %w", dbUsr.ID, err)
+	}
+	return toCoreUser(dbUsr), nil
+}
+
+// openValue decrypts a field of the document, reloading the data keys when
+// it was encrypted with one created by another instance since they were
+// loaded.
+func (c *Cipher) openValue(ctx context.Context, docKey string, field string, value string) (string, error) {
+	plain, err := c.decrypt(docKey, field, value)
+	if errors.Is(err, ErrUnknownDataKey) {
+		if err = c.Load(ctx); err == nil {
+			plain, err = c.decrypt(docKey, field, value)
+		}
+	}
+	return plain, err
+}
+
+// sealEvent encrypts the event of an outbox document, which carries the
+// user it is about.
+func (c *Cipher) sealEvent(dbEvt *dbOutboxEvent) error {
+	b, err := json.Marshal(dbEvt.Event)
+	if err != nil {
+		return err
+	}
+	if dbEvt.SealedEvent, err = c.sealValue(dbEvt.Key, "event", string(b)); err != nil {
+		return err
+	}
+	dbEvt.Event = nil
+	return nil
+}
+
+// openEvent returns the event of an outbox document, decrypting it when it
+// was sealed.
+func openEvent(ctx context.Context, c *Cipher, dbEvt dbOutboxEvent) (user.Event, error) {
+	switch {
+	case dbEvt.SealedEvent == "" && dbEvt.Event != nil:
+		return *dbEvt.Event, nil
+	case dbEvt.SealedEvent == "":
+		return user.Event{}, fmt.Errorf("event[%s]: missing", dbEvt.Key)
+	case c == nil:
+		return user.Event{}, fmt.Errorf("event[%s]: encrypted but no cipher configured", dbEvt.Key)
+	}
+
+	plain, err := c.openValue(ctx, dbEvt.Key, "event", dbEvt.SealedEvent)
+	if err != nil {
+		return user.Event{}, fmt.Errorf("event[%s]: %w", dbEvt.Key, err)
+	}
+	var evt user.Event
+	if err := json.Unmarshal([]byte(plain), &evt); err != nil {
+		return user.Event{}, fmt.Errorf("event[%s]: %w", dbEvt.Key, err)
+	}
+	return evt, nil
+}
+
+// sealDelivery encrypts the payload of a webhook delivery, which is the
+// event it delivers.
+func (c *Cipher) sealDelivery(dbD *dbDelivery) error {
+	sealed, err := c.sealValue(dbD.ID.String(), "payload", string(dbD.Payload))
+	if err != nil {
+		return err
+	}
+	dbD.Payload, dbD.SealedPayload = nil, sealed
+	return nil
+}
+
+// openDelivery maps a delivery read from the database, decrypting its
+// payload when it was sealed.
+func openDelivery(ctx context.Context, c *Cipher, dbD dbDelivery) (user.Delivery, error) {
+	if dbD.SealedPayload != "" {
+		if c == nil {
+			return user.Delivery{}, fmt.Errorf("delivery[%s]: encrypted but no cipher configured", dbD.ID)
+		}
+		plain, err := c.openValue(ctx, dbD.ID.String(), "payload", dbD.SealedPayload)
+		if err != nil {
+			return user.Delivery{}, fmt.Errorf("delivery[%s]: %w", dbD.ID, err)
+		}
+		dbD.Payload = json.RawMessage(plain)
+	}
+	return toCoreDelivery(dbD), nil
+}
+
+func openUsers(ctx context.Context, c *Cipher, dbUsers []dbUser) ([]user.User, error) {
+	usrs := make([]user.User, len(dbUsers))
+	for i, dbUsr := range dbUsers {
+		usr, err := openUser(ctx, c, dbUsr)
+		if err != nil {
+			return nil, err
+		}
+		usrs[i] = usr
+	}
+	return usrs, nil
+}
+
+// userReader reads users for the stores other than Store, which only need
+// to decrypt them.
+type userReader struct {
+	cipher *Cipher
+}
+
+// ReadOption configures how the GroupStore, DepartmentStore, ManagerStore
+// and WebhookStore read users.
+type ReadOption func(*userReader)
+
+// WithCipher decrypts the users the store reads. Users are sorted by name
+// after they are decrypted, since the database can only sort them by
+// their encrypted names. The WebhookStore encrypts the payloads of
+// deliveries with it.
+func WithCipher(c *Cipher) ReadOption {
+	return func(r *userReader) {
+		r.cipher = c
+	}
+}
+
+func newUserReader(opts []ReadOption) userReader {
+	var r userReader
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return r
+}
+
+// readUsers reads the users from the cursor, decrypting and sorting them by
+// name when the store has a cipher.
+func (r userReader) readUsers(ctx context.Context, c driver.Cursor, sorted bool) ([]user.User, error) {
+	dbUsers, err := readAll[dbUser](ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	usrs, err := openUsers(ctx, r.cipher, dbUsers)
+	if err != nil {
+		return nil, err
+	}
+	if sorted && r.cipher != nil {
+		sort.SliceStable(usrs, func(i, j int) bool { return usrs[i].Name < usrs[j].Name })
+	}
+	return usrs, nil
+}
+
+// errChanged is returned when a user changed while being re-encrypted.
+var errChanged = errors.New("user changed while re-encrypting")
+
+// Reencrypt encrypts the users that aren't encrypted with the active data
+// key, a batch at a time, and returns how many it encrypted. Users written
+// before encryption was turned on are moved to the key of their blind
+// index, along with the group memberships and reporting lines pointing at
+// them. Users that change while being re-encrypted are left for the next
+// run.
+func (s *Store) Reencrypt(ctx context.Context, batch int) (int, error) {
+	if s.cipher == nil {
+		return 0, nil
+	}
+	if err := s.cipher.Load(ctx); err != nil {
+		return 0, err
+	}
+
+	query := `FOR u IN @@coll
+	FILTER u.data_key != @active AND u._key NOT IN @skipped
+	LIMIT @rows
+	RETURN u._key`
+
+	var count int
+	skipped := []string{}
+	for {
+		bindvars := map[string]interface{}{
+			"@coll":   collectionName,
+			"active":  s.cipher.ActiveKey(),
+			"skipped": skipped,
+			"rows":    batch,
+		}
+		c, err := s.db.Query(ctx, query, bindvars)
+		if err != nil {
+			return count, err
+		}
+		keys, err := readAll[string](ctx, c)
+		if err != nil {
+			return count, err
+		}
+
+		for _, key := range keys {
+			err := s.reencrypt(ctx, key)
+			switch {
+			case errors.Is(err, errChanged), driver.IsPreconditionFailed(err), driver.IsNotFound(err):
+				skipped = append(skipped, key)
+			case err != nil:
+				return count, fmt.Errorf("reencrypting user %s: %w", key, err)
+			default:
+				count++
+			}
+		}
+		if len(keys) < batch {
+			return count, nil
+		}
+	}
+}
+
+// reencrypt encrypts the user stored under key with the active data key.
+func (s *Store) reencrypt(ctx context.Context, key string) error {
+	var dbUsr dbUser
+	meta, err := s.col.ReadDocument(ctx, key, &dbUsr)
+	if err != nil {
+		return err
+	}
+	if err := s.cipher.open(&dbUsr); err != nil {
+		return err
+	}
+	if err := s.cipher.seal(&dbUsr); err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"name":        dbUsr.Name,
+		"email":       dbUsr.Email,
+		"email_name":  dbUsr.EmailName,
+		"email_index": dbUsr.EmailIndex,
+		"data_key":    dbUsr.DataKey,
+	}
+	if dbUsr.Key == key {
+		_, err := s.col.UpdateDocument(driver.WithRevision(ctx, meta.Rev), key, fields)
+		return err
+	}
+	return s.rekey(ctx, key, meta.Rev, dbUsr.Key, fields)
+}
+
+// rekey moves the user stored under the old key to the new one, with the
+// fields changed, and points their edges at the new document.
+func (s *Store) rekey(ctx context.Context, oldKey string, rev string, newKey string, fields map[string]interface{}) error {
+	cols := driver.TransactionCollections{Write: []string{collectionName, membershipCollectionName, reportingCollectionName}}
+	tid, err := s.db.BeginTransaction(ctx, cols, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	tctx := driver.WithTransactionID(ctx, tid)
+
+	if err := s.moveUser(tctx, oldKey, rev, newKey, fields); err != nil {
+		if aerr := s.db.AbortTransaction(ctx, tid, nil); aerr != nil {
+			s.log.Errorw("aborting transaction", "error", aerr)
+		}
+		return err
+	}
+
+	if err := s.db.CommitTransaction(ctx, tid, nil); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) moveUser(ctx context.Context, oldKey string, rev string, newKey string, fields map[string]interface{}) error {
+	var doc map[string]interface{}
+	meta, err := s.col.ReadDocument(ctx, oldKey, &doc)
+	if err != nil {
+		return err
+	}
+	if meta.Rev != rev {
+		return errChanged
+	}
+
+	delete(doc, "_id")
+	delete(doc, "_rev")
+	for k, v := range fields {
+		doc[k] = v
+	}
+	doc["_key"] = newKey
+	if _, err := s.col.CreateDocument(ctx, doc); err != nil {
+		return err
+	}
+	if _, err := s.col.RemoveDocument(driver.WithRevision(ctx, rev), oldKey); err != nil {
+		return err
+	}
+
+	query := `FOR e IN @@edges
+	FILTER e._from == @old OR e._to == @old
+	UPDATE e WITH { _from: e._from == @old ? @new : e._from, _to: e._to == @old ? @new : e._to } IN @@edges`
+	for _, edges := range []string{membershipCollectionName, reportingCollectionName} {
+		bindvars := map[string]interface{}{
+			"@edges": edges,
+			"old":    collectionName + "/" + oldKey,
+			"new":    collectionName + "/" + newKey,
+		}
+		c, err := s.db.Query(ctx, query, bindvars)
+		if err != nil {
+			return err
+		}
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
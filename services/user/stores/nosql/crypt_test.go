@@ -0,0 +1,276 @@
+package nosql
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/stem/data/nosql/dbtest"
+	"github.com/google/uuid"
+)
+
+// newTestCipher builds a Cipher with random data keys, without a database.
+// The last key is active.
+func newTestCipher(t *testing.T, ids ...string) *Cipher {
+	t.Helper()
+
+	c := &Cipher{aeads: make(map[string]cipher.AEAD), indexKey: make([]byte, 32)}
+	if _, err := rand.Read(c.indexKey); err != nil {
+		t.Fatalf("generating index key : %s", err)
+	}
+	for _, id := range ids {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatalf("generating data key : %s", err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatalf("creating data key : %s", err)
+		}
+		if c.aeads[id], err = cipher.NewGCM(block); err != nil {
+			t.Fatalf("creating data key : %s", err)
+		}
+		c.active = id
+	}
+	return c
+}
+
+func Test_Cipher(t *testing.T) {
+	c := newTestCipher(t, "k1")
+	orgID := uuid.New()
+
+	usr := user.User{
+		ID:      uuid.New(),
+		OrgID:   orgID,
+		Name:    "Bill Kennedy",
+		Email:   mail.Address{Name: "Bill", Address: "bill@example.com"},
+		Roles:   []user.Role{user.RoleUser},
+		Enabled: true,
+	}
+
+	t.Log("Given the need to encrypt the personal data of users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen sealing a user.", testID)
+		{
+			dbUsr := toDBUser(usr)
+			if err := c.seal(&dbUsr); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to seal the user : %s.", dbtest.Failed, testID, err)
+			}
+			for field, value := range sealedFields(&dbUsr) {
+				if !strings.HasPrefix(*value, encryptedPrefix+"k1:") {
+					t.Fatalf("\t%s\tTest %d:\tShould encrypt %s : got %q.", dbtest.Failed, testID, field, *value)
+				}
+			}
+			if dbUsr.Key != c.userKey(orgID.String(), usr.Email.Address) || strings.Contains(dbUsr.Key, usr.Email.Address) {
+				t.Fatalf("\t%s\tTest %d:\tShould key the user by the blind index : got %q.", dbtest.Failed, testID, dbUsr.Key)
+			}
+			if dbUsr.DataKey != "k1" || dbUsr.EmailIndex != c.blindIndex(orgID.String(), usr.Email.Address) {
+				t.Fatalf("\t%s\tTest %d:\tShould record the data key and blind index : got %q, %q.", dbtest.Failed, testID, dbUsr.DataKey, dbUsr.EmailIndex)
+			}
+
+			if err := c.open(&dbUsr); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to open the user : %s.", dbtest.Failed, testID, err)
+			}
+			got := toCoreUser(dbUsr)
+			if got.Name != usr.Name || got.Email != usr.Email {
+				t.Fatalf("\t%s\tTest %d:\tShould read back the user : got %q <%s>.", dbtest.Failed, testID, got.Name, got.Email.String())
+			}
+			t.Logf("\t%s\tTest %d:\tShould encrypt the name and email.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen encrypted values are tampered with.", testID)
+		{
+			dbUsr := toDBUser(usr)
+			if err := c.seal(&dbUsr); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to seal the user : %s.", dbtest.Failed, testID, err)
+			}
+
+			moved := dbUsr
+			moved.Name = dbUsr.EmailName
+			if err := c.open(&moved); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould not open a value moved to another field.", dbtest.Failed, testID)
+			}
+
+			other := toDBUser(usr)
+			other.Email = "ed@example.com"
+			if err := c.seal(&other); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to seal the user : %s.", dbtest.Failed, testID, err)
+			}
+			other.Name = dbUsr.Name
+			if err := c.open(&other); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould not open a value moved to another user.", dbtest.Failed, testID)
+			}
+
+			unknown := dbUsr
+			unknown.Name = strings.Replace(dbUsr.Name, "k1", "k9", 1)
+			if err := c.open(&unknown); !errors.Is(err, ErrUnknownDataKey) {
+				t.Fatalf("\t%s\tTest %d:\tShould report unknown data keys : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only open values where they were sealed.", dbtest.Success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen reading users written before encryption.", testID)
+		{
+			dbUsr := toDBUser(usr)
+			if err := c.open(&dbUsr); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to open the user : %s.", dbtest.Failed, testID, err)
+			}
+			if got := toCoreUser(dbUsr); got.Name != usr.Name || got.Email != usr.Email {
+				t.Fatalf("\t%s\tTest %d:\tShould read plaintext values as they are : got %q <%s>.", dbtest.Failed, testID, got.Name, got.Email.String())
+			}
+			t.Logf("\t%s\tTest %d:\tShould read plaintext values as they are.", dbtest.Success, testID)
+		}
+
+		testID = 3
+		t.Logf("\tTest %d:\tWhen looking up users by email.", testID)
+		{
+			if c.blindIndex(orgID.String(), "bill@example.com") != c.blindIndex(orgID.String(), "bill@example.com") {
+				t.Fatalf("\t%s\tTest %d:\tShould index equal emails equally.", dbtest.Failed, testID)
+			}
+			if c.blindIndex(orgID.String(), "bill@example.com") == c.blindIndex(uuid.NewString(), "bill@example.com") {
+				t.Fatalf("\t%s\tTest %d:\tShould index emails per organization.", dbtest.Failed, testID)
+			}
+			if other := newTestCipher(t, "k1"); other.blindIndex(orgID.String(), "bill@example.com") == c.blindIndex(orgID.String(), "bill@example.com") {
+				t.Fatalf("\t%s\tTest %d:\tShould depend on the index key.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould index emails with a keyed hash.", dbtest.Success, testID)
+		}
+
+		testID = 4
+		t.Logf("\tTest %d:\tWhen storing the events about users.", testID)
+		{
+			ctx := context.Background()
+			evt := user.NewEvent(user.EventUserCreated, usr, time.Now())
+
+			dbEvt := toDBOutboxEvent(evt, time.Now())
+			if err := c.sealEvent(&dbEvt); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to seal the event : %s.", dbtest.Failed, testID, err)
+			}
+			b, _ := json.Marshal(dbEvt)
+			if dbEvt.Event != nil || strings.Contains(string(b), usr.Email.Address) || strings.Contains(string(b), usr.Name) {
+				t.Fatalf("\t%s\tTest %d:\tShould encrypt the outbox event : got %s.", dbtest.Failed, testID, b)
+			}
+			got, err := openEvent(ctx, c, dbEvt)
+			if err != nil || got.ID != evt.ID || got.Data.User.Email != usr.Email {
+				t.Fatalf("\t%s\tTest %d:\tShould open the outbox event : got %+v, %v.", dbtest.Failed, testID, got, err)
+			}
+			if _, err := openEvent(ctx, nil, dbEvt); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould not relay sealed events without a cipher.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould encrypt the outbox events.", dbtest.Success, testID)
+
+			payload, _ := json.Marshal(evt)
+			dbD := toDBDelivery(user.Delivery{ID: uuid.New(), OrgID: orgID, EventID: evt.ID, Payload: payload})
+			if err := c.sealDelivery(&dbD); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to seal the delivery : %s.", dbtest.Failed, testID, err)
+			}
+			b, _ = json.Marshal(dbD)
+			if strings.Contains(string(b), usr.Email.Address) || strings.Contains(string(b), usr.Name) {
+				t.Fatalf("\t%s\tTest %d:\tShould encrypt the delivery payload : got %s.", dbtest.Failed, testID, b)
+			}
+			d, err := openDelivery(ctx, c, dbD)
+			if err != nil || string(d.Payload) != string(payload) {
+				t.Fatalf("\t%s\tTest %d:\tShould open the delivery payload : got %s, %v.", dbtest.Failed, testID, d.Payload, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould encrypt the webhook delivery payloads.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_CipherRotation(t *testing.T) {
+	old := newTestCipher(t, "k1")
+	orgID := uuid.New()
+
+	dbUsr := toDBUser(user.User{ID: uuid.New(), OrgID: orgID, Name: "bill", Email: mail.Address{Address: "bill@example.com"}})
+	if err := old.seal(&dbUsr); err != nil {
+		t.Fatalf("\t%s\tShould be able to seal the user : %s.", dbtest.Failed, err)
+	}
+
+	// The rotated cipher has the old data key and a new active one.
+	c := newTestCipher(t, "k2")
+	c.indexKey = old.indexKey
+	c.aeads["k1"] = old.aeads["k1"]
+
+	t.Log("Given the need to rotate data keys.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a new data key is active.", testID)
+		{
+			if c.ActiveKey() != "k2" {
+				t.Fatalf("\t%s\tTest %d:\tShould encrypt with the new key : got %q.", dbtest.Failed, testID, c.ActiveKey())
+			}
+			opened := dbUsr
+			if err := c.open(&opened); err != nil || opened.Name != "bill" {
+				t.Fatalf("\t%s\tTest %d:\tShould open values sealed with the old key : %v.", dbtest.Failed, testID, err)
+			}
+			if err := c.seal(&opened); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to seal the user : %s.", dbtest.Failed, testID, err)
+			}
+			if opened.Key != dbUsr.Key || opened.DataKey != "k2" || !strings.HasPrefix(opened.Name, encryptedPrefix+"k2:") {
+				t.Fatalf("\t%s\tTest %d:\tShould re-encrypt under the same key : got %q, %q.", dbtest.Failed, testID, opened.Key, opened.DataKey)
+			}
+			t.Logf("\t%s\tTest %d:\tShould re-encrypt users with the new key.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_PageUsers(t *testing.T) {
+	at := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	newUser := func(name string, email string, days int) user.User {
+		return user.User{Name: name, Email: mail.Address{Address: email}, DateCreated: at.AddDate(0, 0, days)}
+	}
+	emails := func(usrs []user.User) string {
+		var s []string
+		for _, usr := range usrs {
+			s = append(s, usr.Email.Address)
+		}
+		return strings.Join(s, ",")
+	}
+	users := func() []user.User {
+		return []user.User{
+			newUser("cy", "c@example.com", 0),
+			newUser("al", "a@example.com", 2),
+			newUser("bo", "b@example.com", 1),
+			newUser("al", "d@example.com", 3),
+		}
+	}
+	name := "al"
+
+	tests := []struct {
+		name    string
+		filter  user.QueryFilter
+		orderBy user.OrderBy
+		page    int
+		rows    int
+		want    string
+	}{
+		{"by name", user.QueryFilter{}, user.OrderBy{Field: user.OrderByName, Direction: user.ASC}, 1, 10, "a@example.com,d@example.com,b@example.com,c@example.com"},
+		{"by name descending", user.QueryFilter{}, user.OrderBy{Field: user.OrderByName, Direction: user.DESC}, 1, 10, "c@example.com,b@example.com,d@example.com,a@example.com"},
+		{"by date created", user.QueryFilter{}, user.OrderBy{Field: user.OrderByDateCreated, Direction: user.ASC}, 1, 10, "c@example.com,b@example.com,a@example.com,d@example.com"},
+		{"second page", user.QueryFilter{}, user.OrderBy{Field: user.OrderByName, Direction: user.ASC}, 2, 3, "c@example.com"},
+		{"past the end", user.QueryFilter{}, user.OrderBy{Field: user.OrderByName, Direction: user.ASC}, 3, 3, ""},
+		{"filtered by name", user.QueryFilter{Name: &name}, user.OrderBy{Field: user.OrderByName, Direction: user.ASC}, 1, 10, "a@example.com,d@example.com"},
+	}
+
+	t.Log("Given the need to page users the database can't sort.")
+	{
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen paging %s.", testID, tt.name)
+			if got := emails(pageUsers(users(), tt.filter, tt.orderBy, tt.page, tt.rows)); got != tt.want {
+				t.Fatalf("\t%s\tTest %d:\tShould return %q : got %q.", dbtest.Failed, testID, tt.want, got)
+			}
+			t.Logf("\t%s\tTest %d:\tShould return the page in order.", dbtest.Success, testID)
+		}
+	}
+}
@@ -4,7 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/mail"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/arangodb/go-driver"
 	"github.com/gitamped/bud/services/user"
@@ -12,90 +17,548 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-const collectionName = "users"
+const (
+	collectionName            = "users"
+	deletedUserCollectionName = "deleted_users"
+)
+
+// MaxSortedUsers bounds the users of an organization Query reads when it
+// sorts or filters encrypted fields in the server. Queries that would read
+// more fail with ErrTooManyUsers rather than hold them all in memory.
+const MaxSortedUsers = 10000
+
+// orderByFields maps the fields users can be ordered by to their sort
+// expressions. Dates are sorted as timestamps, since their text doesn't
+// sort in time order.
+var orderByFields = map[string]string{
+	user.OrderByName:        "u.name",
+	user.OrderByEmail:       "u.email",
+	user.OrderByDateCreated: "DATE_TIMESTAMP(u.date_created)",
+	user.OrderByLastLoginAt: "(u.last_login_at == null ? null : DATE_TIMESTAMP(u.last_login_at))",
+}
+
+// queryNames names the query each method runs, for tracing. Methods that
+// read or write one document by its key are named after the operation.
+var queryNames = map[string]string{
+	"Create":          "users.insert",
+	"Delete":          "users.remove",
+	"QueryByID":       "users.query_by_id",
+	"QueryByEmail":    "users.read",
+	"Update":          "users.update",
+	"Query":           "users.query",
+	"QueryDormant":    "users.query_dormant",
+	"Authenticate":    "users.read",
+	"UpdateLastLogin": "users.update_last_login",
+	"UpdatePassword":  "users.update_password",
+	"PurgeDeleted":    "deleted_users.purge",
+}
 
 var (
 	ErrNotFound              = errors.New("user not found")
 	ErrUniqueEmail           = errors.New("email is not unique")
 	ErrAuthenticationFailure = errors.New("authentication failed")
+	ErrTooManyUsers          = errors.New("too many users to sort or filter by name or email, order by date created instead")
 )
 
+// IsTransient reports whether err is a failure worth retrying: the server
+// refused the connection or was unavailable, or a transaction lost a
+// write-write conflict. Nothing was written in these cases, so a retry
+// doesn't apply a change twice.
+func IsTransient(err error) bool {
+	var ae driver.ArangoError
+	if errors.As(err, &ae) {
+		return ae.Code == http.StatusServiceUnavailable || ae.ErrorNum == driver.ErrArangoConflict
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
 type Store struct {
-	db  driver.Database
-	col driver.Collection
-	log *zap.SugaredLogger
+	db      driver.Database
+	col     driver.Collection
+	deleted driver.Collection
+	outbox  driver.Collection
+	cipher  *Cipher
+	log     *zap.SugaredLogger
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithOutbox writes an event to the outbox collection for every change to a
+// user, in the same transaction as the change. An OutboxRelay publishes
+// the events.
+func WithOutbox() Option {
+	return func(s *Store) {
+		col, err := s.db.Collection(context.Background(), outboxCollectionName)
+		if err != nil {
+			s.log.Panicf("error accessing collection: %s", err)
+		}
+		s.outbox = col
+	}
+}
+
+// WritesOutbox implements user.OutboxStorer.
+func (s *Store) WritesOutbox() bool {
+	return s.outbox != nil
+}
+
+// WithEncryption encrypts the names and emails of users with c. Users are
+// keyed by the blind index of their email, so they can still be found by
+// email, and emails stay unique.
+//
+// Users written before encryption was turned on are read as they are, and
+// encrypted by Reencrypt. The database can't sort or filter encrypted
+// fields, so queries ordered by them or filtering by name read every user
+// in the organization, up to MaxSortedUsers, and Search isn't available.
+//
+// Departments aren't encrypted. A user's department is the ID of a
+// department document rather than personal data, and the department
+// member queries filter users by it.
+func WithEncryption(c *Cipher) Option {
+	return func(s *Store) {
+		s.cipher = c
+	}
 }
 
 // NewStore constructs the api for data access.
-func NewStore(log *zap.SugaredLogger, db driver.Database) *Store {
+func NewStore(log *zap.SugaredLogger, db driver.Database, opts ...Option) *Store {
 	col, err := db.Collection(context.Background(), "users")
 	if err != nil {
-		log.Panicf("error accessing collection: %s", err)
+		log.Panicf("error accessing collection, has the database been migrated: %s", err)
+	}
+	deleted, err := db.Collection(context.Background(), deletedUserCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection, has the database been migrated: %s", err)
+	}
+	s := &Store{
+		log:     log,
+		db:      db,
+		col:     col,
+		deleted: deleted,
 	}
-	return &Store{
-		log: log,
-		db:  db,
-		col: col,
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-// Delete deletes a user from the database
-func (s *Store) Delete(ctx context.Context, email mail.Address) (user.User, error) {
-	var result dbUser
-	ctx = driver.WithReturnOld(ctx, &result)
-	_, err := s.col.RemoveDocument(ctx, email.Address)
-	return toCoreUser(result), err
+// QueryName returns the name of the query the method runs, or "" for
+// methods the store doesn't have.
+func (s *Store) QueryName(method string) string {
+	return queryNames[method]
+}
+
+// Delete deletes a user, with their group memberships and the reporting
+// lines to and from them, from the database. The user is kept in the
+// deleted_users collection, still encrypted when they were, until
+// PurgeDeleted removes them.
+func (s *Store) Delete(ctx context.Context, orgID string, email mail.Address) (user.User, error) {
+	var usr user.User
+	err := s.withKey(orgID, email.Address, func(key string) error {
+		return s.withEvent(ctx, func(ctx context.Context) (user.Event, error) {
+			var result dbUser
+			ctx = driver.WithReturnOld(ctx, &result)
+			if _, err := s.col.RemoveDocument(ctx, key); err != nil {
+				return user.Event{}, err
+			}
+			var err error
+			if usr, err = openUser(ctx, s.cipher, result); err != nil {
+				return user.Event{}, err
+			}
+			if _, err := s.deleted.CreateDocument(ctx, toDBDeletedUser(result, time.Now())); err != nil {
+				return user.Event{}, err
+			}
+
+			// A user created later with the same email gets the same key, so
+			// drop the reporting lines and memberships instead of leaving them
+			// to be inherited.
+			id := collectionName + "/" + key
+			if err := removeMemberships(ctx, s.db, id); err != nil {
+				return user.Event{}, err
+			}
+			err = removeReportingLines(ctx, s.db, id, true)
+			return user.NewEvent(user.EventUserDeleted, usr, time.Now()), err
+		})
+	})
+	if driver.IsNotFound(err) {
+		return user.User{}, ErrNotFound
+	}
+	return usr, err
 }
 
 // Create inserts a new user into the database.
 func (s *Store) Create(ctx context.Context, usr user.User) (user.User, error) {
-	var result dbUser
-	ctx = driver.WithReturnNew(ctx, &result)
-	_, err := s.col.CreateDocument(ctx, toDBUser(usr))
-	return toCoreUser(result), err
+	dbUsr := toDBUser(usr)
+	if s.cipher != nil {
+		if err := s.cipher.seal(&dbUsr); err != nil {
+			return user.User{}, err
+		}
+	}
+
+	var created user.User
+	err := s.withEvent(ctx, func(ctx context.Context) (user.Event, error) {
+		// A user written before encryption was turned on keeps the plain
+		// key until they are re-encrypted.
+		if s.cipher != nil {
+			exists, err := s.col.DocumentExists(ctx, userKey(usr.OrgID.String(), usr.Email.Address))
+			if err != nil {
+				return user.Event{}, err
+			}
+			if exists {
+				return user.Event{}, ErrUniqueEmail
+			}
+		}
+
+		var result dbUser
+		ctx = driver.WithReturnNew(ctx, &result)
+		if _, err := s.col.CreateDocument(ctx, dbUsr); err != nil {
+			return user.Event{}, err
+		}
+		var err error
+		created, err = openUser(ctx, s.cipher, result)
+		return user.NewEvent(user.EventUserCreated, created, usr.DateCreated), err
+	})
+	// The key is made of the organization and the email, so a conflict
+	// other than a write-write conflict means the email is taken.
+	if driver.IsConflict(err) && !IsTransient(err) {
+		return user.User{}, ErrUniqueEmail
+	}
+	if err != nil {
+		return user.User{}, err
+	}
+	return created, nil
 }
 
 // QueryById queries a user by id.
-func (s *Store) QueryByID(ctx context.Context, id string) (user.User, error) {
+func (s *Store) QueryByID(ctx context.Context, orgID string, id string) (user.User, error) {
 	var result dbUser
 	query := `FOR u IN @@coll
-	FILTER u.user_id == @id
+	FILTER u.user_id == @id AND u.org_id == @org_id
 	LIMIT 1
 	RETURN u`
 
 	bindvars := map[string]interface{}{
-		"@coll": collectionName,
-		"id":    id,
+		"@coll":  collectionName,
+		"id":     id,
+		"org_id": orgID,
 	}
 
 	c, err := s.db.Query(ctx, query, bindvars)
-	defer c.Close()
 	if err != nil {
 		return user.User{}, err
 	}
+	defer c.Close()
 	_, err = c.ReadDocument(ctx, &result)
-	return toCoreUser(result), err
+	if driver.IsNoMoreDocuments(err) {
+		return user.User{}, ErrNotFound
+	}
+	if err != nil {
+		return user.User{}, err
+	}
+	return openUser(ctx, s.cipher, result)
 }
 
 // QueryById queries a user by email.
-func (s *Store) QueryByEmail(ctx context.Context, email string) (user.User, error) {
+func (s *Store) QueryByEmail(ctx context.Context, orgID string, email string) (user.User, error) {
 	var result dbUser
-	_, err := s.col.ReadDocument(ctx, email, &result)
-	return toCoreUser(result), err
+	err := s.withKey(orgID, email, func(key string) error {
+		_, err := s.col.ReadDocument(ctx, key, &result)
+		return err
+	})
+	if driver.IsNotFound(err) {
+		return user.User{}, ErrNotFound
+	}
+	if err != nil {
+		return user.User{}, err
+	}
+	return openUser(ctx, s.cipher, result)
 }
 
 // Update updates a user by data.
-func (s *Store) Update(ctx context.Context, updateUser user.UpdateUser) (user.User, error) {
-	var result dbUser
-	ctx = driver.WithReturnNew(ctx, &result)
-	ctx = driver.WithKeepNull(ctx, false)
-	_, err := s.col.UpdateDocument(ctx, updateUser.Email.Address, updateUser)
-	return toCoreUser(result), err
+func (s *Store) Update(ctx context.Context, orgID string, updateUser user.UpdateUser, now time.Time) (user.User, error) {
+	var usr user.User
+	err := s.withKey(orgID, updateUser.Email.Address, func(key string) error {
+		upd := toDBUpdateUser(updateUser, now)
+		if s.cipher != nil && upd.Name != nil {
+			name, err := s.cipher.sealValue(key, "name", *upd.Name)
+			if err != nil {
+				return err
+			}
+			upd.Name = &name
+		}
+
+		return s.withEvent(ctx, func(ctx context.Context) (user.Event, error) {
+			var result dbUser
+			ctx = driver.WithReturnNew(ctx, &result)
+			ctx = driver.WithKeepNull(ctx, false)
+			if _, err := s.col.UpdateDocument(ctx, key, upd); err != nil {
+				return user.Event{}, err
+			}
+			var err error
+			usr, err = openUser(ctx, s.cipher, result)
+			return user.NewEvent(user.UpdateEventType(updateUser), usr, now), err
+		})
+	})
+	if driver.IsNotFound(err) {
+		return user.User{}, ErrNotFound
+	}
+	return usr, err
+}
+
+// Query retrieves a page of users in the organization that match the filter.
+func (s *Store) Query(ctx context.Context, orgID string, filter user.QueryFilter, orderBy user.OrderBy, pageNumber int, rowsPerPage int) ([]user.User, error) {
+	sortBy, ok := orderByFields[orderBy.Field]
+	if !ok {
+		return nil, fmt.Errorf("cannot order by %q", orderBy.Field)
+	}
+	direction := "ASC"
+	if orderBy.Direction == user.DESC {
+		direction = "DESC"
+	}
+
+	bindvars := map[string]interface{}{
+		"@coll":  collectionName,
+		"org_id": orgID,
+		"offset": (pageNumber - 1) * rowsPerPage,
+		"rows":   rowsPerPage,
+	}
+
+	// The database can't filter or sort by encrypted fields, so those
+	// queries read every matching user, up to MaxSortedUsers, and finish
+	// in Go.
+	encrypted := s.cipher != nil && (filter.Name != nil || orderBy.Field == user.OrderByName || orderBy.Field == user.OrderByEmail)
+
+	var buf strings.Builder
+	buf.WriteString(`FOR u IN @@coll
+	FILTER u.org_id == @org_id`)
+
+	if filter.Name != nil && s.cipher == nil {
+		buf.WriteString(`
+	FILTER u.name == @name`)
+		bindvars["name"] = *filter.Name
+	}
+	if filter.Email != nil {
+		if s.cipher != nil {
+			buf.WriteString(`
+	FILTER u.email_index == @email_index OR u.email == @email`)
+			bindvars["email_index"] = s.cipher.blindIndex(orgID, *filter.Email)
+		} else {
+			buf.WriteString(`
+	FILTER u.email == @email`)
+		}
+		bindvars["email"] = *filter.Email
+	}
+	if filter.LastLoginBefore != nil {
+		buf.WriteString(`
+	FILTER u.last_login_at == null OR DATE_TIMESTAMP(u.last_login_at) < DATE_TIMESTAMP(@last_login_before)`)
+		bindvars["last_login_before"] = filter.LastLoginBefore.UTC()
+	}
+	if filter.LastLoginAfter != nil {
+		buf.WriteString(`
+	FILTER u.last_login_at != null AND DATE_TIMESTAMP(u.last_login_at) >= DATE_TIMESTAMP(@last_login_after)`)
+		bindvars["last_login_after"] = filter.LastLoginAfter.UTC()
+	}
+
+	// Attribute names are bound as parameters too, so they can't be used to
+	// inject AQL. Sort the names to keep the query text stable.
+	names := make([]string, 0, len(filter.Attributes))
+	for name := range filter.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		fmt.Fprintf(&buf, `
+	FILTER u.attributes[@attr_name_%d] == @attr_value_%d`, i, i)
+		bindvars[fmt.Sprintf("attr_name_%d", i)] = name
+		bindvars[fmt.Sprintf("attr_value_%d", i)] = filter.Attributes[name]
+	}
+
+	if encrypted {
+		buf.WriteString(`
+	LIMIT @rows
+	RETURN u`)
+		delete(bindvars, "offset")
+		bindvars["rows"] = MaxSortedUsers + 1
+
+		c, err := s.db.Query(ctx, buf.String(), bindvars)
+		if err != nil {
+			return nil, err
+		}
+		dbUsers, err := readAll[dbUser](ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if len(dbUsers) > MaxSortedUsers {
+			return nil, ErrTooManyUsers
+		}
+		usrs, err := openUsers(ctx, s.cipher, dbUsers)
+		if err != nil {
+			return nil, err
+		}
+		return pageUsers(usrs, filter, orderBy, pageNumber, rowsPerPage), nil
+	}
+
+	// The sort expression comes from orderByFields, never from the caller.
+	fmt.Fprintf(&buf, `
+	SORT %s %s, u._key
+	LIMIT @offset, @rows
+	RETURN u`, sortBy, direction)
+
+	c, err := s.db.Query(ctx, buf.String(), bindvars)
+	if err != nil {
+		return nil, err
+	}
+	usrs, err := readAll[dbUser](ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return openUsers(ctx, s.cipher, usrs)
+}
+
+// pageUsers filters decrypted users by name, sorts them and returns the
+// page, as Query does in the database for users that aren't encrypted.
+func pageUsers(usrs []user.User, filter user.QueryFilter, orderBy user.OrderBy, pageNumber int, rowsPerPage int) []user.User {
+	if filter.Name != nil {
+		matched := usrs[:0]
+		for _, usr := range usrs {
+			if usr.Name == *filter.Name {
+				matched = append(matched, usr)
+			}
+		}
+		usrs = matched
+	}
+
+	less := func(a, b user.User) bool {
+		switch orderBy.Field {
+		case user.OrderByName:
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+		case user.OrderByDateCreated:
+			if !a.DateCreated.Equal(b.DateCreated) {
+				return a.DateCreated.Before(b.DateCreated)
+			}
+		case user.OrderByLastLoginAt:
+			if !a.LastLoginAt.Equal(b.LastLoginAt) {
+				return a.LastLoginAt.Before(b.LastLoginAt)
+			}
+		}
+		return a.Email.Address < b.Email.Address
+	}
+	sort.SliceStable(usrs, func(i, j int) bool {
+		if orderBy.Direction == user.DESC {
+			return less(usrs[j], usrs[i])
+		}
+		return less(usrs[i], usrs[j])
+	})
+
+	offset := (pageNumber - 1) * rowsPerPage
+	if offset < 0 || offset >= len(usrs) {
+		return []user.User{}
+	}
+	end := offset + rowsPerPage
+	if end > len(usrs) {
+		end = len(usrs)
+	}
+	return usrs[offset:end]
+}
+
+// QueryDormant retrieves enabled users in any organization who haven't
+// signed in since before, or were created before it and never have.
+func (s *Store) QueryDormant(ctx context.Context, before time.Time, limit int) ([]user.User, error) {
+	query := `FOR u IN @@coll
+	FILTER u.enabled == true
+	FILTER DATE_TIMESTAMP(u.last_login_at == null ? u.date_created : u.last_login_at) < DATE_TIMESTAMP(@before)
+	LIMIT @rows
+	RETURN u`
+
+	bindvars := map[string]interface{}{
+		"@coll":  collectionName,
+		"before": before.UTC(),
+		"rows":   limit,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	usrs, err := readAll[dbUser](ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return openUsers(ctx, s.cipher, usrs)
+}
+
+// PurgeDeleted permanently removes up to limit users deleted before before
+// and returns how many it removed.
+func (s *Store) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	query := `FOR d IN @@coll
+	FILTER DATE_TIMESTAMP(d.date_deleted) < DATE_TIMESTAMP(@before)
+	LIMIT @rows
+	REMOVE d IN @@coll
+	RETURN 1`
+
+	bindvars := map[string]interface{}{
+		"@coll":  deletedUserCollectionName,
+		"before": before.UTC(),
+		"rows":   limit,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := readAll[int](ctx, c)
+	return len(removed), err
+}
+
+// UpdateLastLogin records when and where a user last signed in.
+func (s *Store) UpdateLastLogin(ctx context.Context, orgID string, email string, at time.Time, ip string) error {
+	upd := map[string]interface{}{
+		"last_login_at": at.UTC(),
+		"last_login_ip": ip,
+	}
+	err := s.withKey(orgID, email, func(key string) error {
+		_, err := s.col.UpdateDocument(ctx, key, upd)
+		return err
+	})
+	if driver.IsNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// UpdatePassword replaces the password hash of a user and clears the must
+// change password flag.
+func (s *Store) UpdatePassword(ctx context.Context, orgID string, email string, hash []byte, now time.Time) (user.User, error) {
+	upd := map[string]interface{}{
+		"password_hash":        hash,
+		"password_changed_at":  now.UTC(),
+		"must_change_password": false,
+		"date_updated":         now.UTC(),
+	}
 
+	var usr user.User
+	err := s.withKey(orgID, email, func(key string) error {
+		return s.withEvent(ctx, func(ctx context.Context) (user.Event, error) {
+			var result dbUser
+			ctx = driver.WithReturnNew(ctx, &result)
+			if _, err := s.col.UpdateDocument(ctx, key, upd); err != nil {
+				return user.Event{}, err
+			}
+			var err error
+			usr, err = openUser(ctx, s.cipher, result)
+			return user.NewEvent(user.EventUserUpdated, usr, now), err
+		})
+	})
+	if driver.IsNotFound(err) {
+		return user.User{}, ErrNotFound
+	}
+	return usr, err
 }
 
-func (s *Store) Authenticate(ctx context.Context, email string, password string) (user.User, error) {
-	usr, err := s.QueryByEmail(ctx, email)
+func (s *Store) Authenticate(ctx context.Context, orgID string, email string, password string) (user.User, error) {
+	usr, err := s.QueryByEmail(ctx, orgID, email)
 	if err != nil {
 		return user.User{}, fmt.Errorf("query: email[%s]: %w", email, err)
 	}
@@ -106,3 +569,35 @@ func (s *Store) Authenticate(ctx context.Context, email string, password string)
 
 	return usr, nil
 }
+
+// withKey calls fn with the document key of the user with the email. Users
+// written before encryption was turned on keep their plain key until they
+// are re-encrypted, so fn is tried again with it when it finds no user.
+func (s *Store) withKey(orgID string, email string, fn func(key string) error) error {
+	if s.cipher == nil {
+		return fn(userKey(orgID, email))
+	}
+	err := fn(s.cipher.userKey(orgID, email))
+	if driver.IsNotFound(err) {
+		err = fn(userKey(orgID, email))
+	}
+	return err
+}
+
+// readAll reads every remaining document from the cursor and closes it.
+func readAll[T any](ctx context.Context, c driver.Cursor) ([]T, error) {
+	defer c.Close()
+
+	var docs []T
+	for {
+		var doc T
+		_, err := c.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
@@ -0,0 +1,49 @@
+package nosql
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/stem/data/nosql/dbtest"
+)
+
+func Test_IsTransient(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "http://127.0.0.1:8529", Err: &net.OpError{
+		Op:  "dial",
+		Net: "tcp",
+		Err: os.NewSyscallError("connect", syscall.ECONNREFUSED),
+	}}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", refused, true},
+		{"unavailable", driver.ArangoError{HasError: true, Code: http.StatusServiceUnavailable, ErrorNum: 503}, true},
+		{"write-write conflict", driver.ArangoError{HasError: true, Code: http.StatusConflict, ErrorNum: driver.ErrArangoConflict}, true},
+		{"wrapped conflict", fmt.Errorf("committing: %w", driver.ArangoError{HasError: true, Code: http.StatusConflict, ErrorNum: driver.ErrArangoConflict}), true},
+		{"unique constraint", driver.ArangoError{HasError: true, Code: http.StatusConflict, ErrorNum: driver.ErrArangoUniqueConstraintViolated}, false},
+		{"not found", driver.ArangoError{HasError: true, Code: http.StatusNotFound, ErrorNum: driver.ErrArangoDocumentNotFound}, false},
+		{"store error", ErrNotFound, false},
+		{"other", errors.New("boom"), false},
+	}
+
+	t.Log("Given the need to retry transient database failures.")
+	{
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen the error is %s.", testID, tt.name)
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Fatalf("\t%s\tTest %d:\tShould report transient as %t : got %t.", dbtest.Failed, testID, tt.want, got)
+			}
+			t.Logf("\t%s\tTest %d:\tShould report transient as %t.", dbtest.Success, testID, tt.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const (
+	departmentCollectionName    = "departments"
+	subdepartmentCollectionName = "subdepartments"
+
+	// maxDepartmentDepth bounds traversals of the department tree.
+	maxDepartmentDepth = 64
+)
+
+var ErrDepartmentNotFound = errors.New("department not found")
+
+// DepartmentStore manages departments. The tree is stored as edges in the
+// subdepartments collection, from each parent to its children.
+type DepartmentStore struct {
+	userReader
+	db    driver.Database
+	col   driver.Collection
+	edges driver.Collection
+	log   *zap.SugaredLogger
+}
+
+// NewDepartmentStore constructs the api for department data access.
+func NewDepartmentStore(log *zap.SugaredLogger, db driver.Database, opts ...ReadOption) *DepartmentStore {
+	col, err := db.Collection(context.Background(), departmentCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	edges, err := db.Collection(context.Background(), subdepartmentCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &DepartmentStore{
+		userReader: newUserReader(opts),
+		log:        log,
+		db:         db,
+		col:        col,
+		edges:      edges,
+	}
+}
+
+// Create inserts a new department and the edge from its parent.
+func (s *DepartmentStore) Create(ctx context.Context, dept user.Department) (user.Department, error) {
+	orgID := dept.OrgID.String()
+	err := s.withTransaction(ctx, func(ctx context.Context) error {
+		if dept.ParentID != "" {
+			if _, err := s.QueryByID(ctx, orgID, dept.ParentID); err != nil {
+				return fmt.Errorf("parent: %w", err)
+			}
+		}
+		if _, err := s.col.CreateDocument(ctx, toDBDepartment(dept)); err != nil {
+			return err
+		}
+		if dept.ParentID != "" {
+			return s.link(ctx, dept.ParentID, dept.ID.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return user.Department{}, err
+	}
+	return s.QueryByID(ctx, orgID, dept.ID.String())
+}
+
+// Update updates a department by data.
+func (s *DepartmentStore) Update(ctx context.Context, orgID string, id string, ud user.UpdateDepartment, now time.Time) (user.Department, error) {
+	query := `FOR d IN @@coll
+	FILTER d._key == @id AND d.org_id == @org_id
+	UPDATE d WITH @upd IN @@coll OPTIONS { keepNull: false }
+	RETURN NEW`
+
+	bindvars := map[string]interface{}{
+		"@coll":  departmentCollectionName,
+		"id":     id,
+		"org_id": orgID,
+		"upd": dbUpdateDepartment{
+			Name:        ud.Name,
+			DateUpdated: now.UTC(),
+		},
+	}
+
+	if _, err := s.queryOne(ctx, query, bindvars); err != nil {
+		return user.Department{}, err
+	}
+	return s.QueryByID(ctx, orgID, id)
+}
+
+// Delete deletes a department that has no sub-departments or users, along
+// with the edge from its parent.
+func (s *DepartmentStore) Delete(ctx context.Context, orgID string, id string) (user.Department, error) {
+	var dept user.Department
+	err := s.withTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		dept, err = s.QueryByID(ctx, orgID, id)
+		if err != nil {
+			return err
+		}
+
+		query := `LET children = (FOR c IN 1..1 OUTBOUND CONCAT(@departments, "/", @id) @@edges LIMIT 1 RETURN 1)
+		LET members = (FOR u IN @@users FILTER u.org_id == @org_id AND u.department == @id LIMIT 1 RETURN 1)
+		RETURN LENGTH(children) + LENGTH(members) > 0`
+
+		bindvars := map[string]interface{}{
+			"@edges":      subdepartmentCollectionName,
+			"@users":      collectionName,
+			"departments": departmentCollectionName,
+			"id":          id,
+			"org_id":      orgID,
+		}
+
+		var notEmpty bool
+		if err := s.queryValue(ctx, query, bindvars, &notEmpty); err != nil {
+			return err
+		}
+		if notEmpty {
+			return user.ErrDepartmentNotEmpty
+		}
+
+		if err := s.unlink(ctx, id); err != nil {
+			return err
+		}
+		_, err = s.col.RemoveDocument(ctx, id)
+		return err
+	})
+	return dept, err
+}
+
+// Move replaces the edge from the department's parent with one from
+// parentID. The department's descendants move with it.
+func (s *DepartmentStore) Move(ctx context.Context, orgID string, id string, parentID string, now time.Time) (user.Department, error) {
+	err := s.withTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.QueryByID(ctx, orgID, id); err != nil {
+			return err
+		}
+
+		if parentID != "" {
+			if _, err := s.QueryByID(ctx, orgID, parentID); err != nil {
+				return fmt.Errorf("parent: %w", err)
+			}
+
+			query := fmt.Sprintf(`FOR d IN 0..%d OUTBOUND CONCAT(@departments, "/", @id) @@edges
+			FILTER d._key == @parent_id
+			LIMIT 1
+			RETURN true`, maxDepartmentDepth)
+
+			bindvars := map[string]interface{}{
+				"@edges":      subdepartmentCollectionName,
+				"departments": departmentCollectionName,
+				"id":          id,
+				"parent_id":   parentID,
+			}
+
+			var descendant bool
+			if err := s.queryValue(ctx, query, bindvars, &descendant); err != nil {
+				return err
+			}
+			if descendant {
+				return user.ErrDepartmentCycle
+			}
+		}
+
+		if err := s.unlink(ctx, id); err != nil {
+			return err
+		}
+		if parentID != "" {
+			if err := s.link(ctx, parentID, id); err != nil {
+				return err
+			}
+		}
+		_, err := s.col.UpdateDocument(ctx, id, map[string]interface{}{"date_updated": now.UTC()})
+		return err
+	})
+	if err != nil {
+		return user.Department{}, err
+	}
+	return s.QueryByID(ctx, orgID, id)
+}
+
+// QueryByID queries a department by id.
+func (s *DepartmentStore) QueryByID(ctx context.Context, orgID string, id string) (user.Department, error) {
+	query := `FOR d IN @@coll
+	FILTER d._key == @id AND d.org_id == @org_id
+	RETURN MERGE(d, { parent_id: FIRST(FOR p IN 1..1 INBOUND d @@edges RETURN p._key) })`
+
+	bindvars := map[string]interface{}{
+		"@coll":  departmentCollectionName,
+		"@edges": subdepartmentCollectionName,
+		"id":     id,
+		"org_id": orgID,
+	}
+
+	return s.queryOne(ctx, query, bindvars)
+}
+
+// Query retrieves the departments of an organization ordered by name.
+func (s *DepartmentStore) Query(ctx context.Context, orgID string) ([]user.Department, error) {
+	query := `FOR d IN @@coll
+	FILTER d.org_id == @org_id
+	SORT d.name
+	RETURN MERGE(d, { parent_id: FIRST(FOR p IN 1..1 INBOUND d @@edges RETURN p._key) })`
+
+	bindvars := map[string]interface{}{
+		"@coll":  departmentCollectionName,
+		"@edges": subdepartmentCollectionName,
+		"org_id": orgID,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	depts, err := readAll[dbDepartment](ctx, c)
+	return toCoreDepartmentSlice(depts), err
+}
+
+// QueryMembers queries the users of a department and, when descendants is
+// set, the users of every department below it.
+func (s *DepartmentStore) QueryMembers(ctx context.Context, orgID string, id string, descendants bool) ([]user.User, error) {
+	if _, err := s.QueryByID(ctx, orgID, id); err != nil {
+		return nil, err
+	}
+
+	depth := 0
+	if descendants {
+		depth = maxDepartmentDepth
+	}
+
+	query := fmt.Sprintf(`LET ids = (FOR d IN 0..%d OUTBOUND CONCAT(@departments, "/", @id) @@edges RETURN d._key)
+	FOR u IN @@users
+	FILTER u.org_id == @org_id AND u.department IN ids
+	SORT u.name
+	RETURN u`, depth)
+
+	bindvars := map[string]interface{}{
+		"@users":      collectionName,
+		"@edges":      subdepartmentCollectionName,
+		"departments": departmentCollectionName,
+		"id":          id,
+		"org_id":      orgID,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	return s.readUsers(ctx, c, true)
+}
+
+// link adds the edge from parentID to childID.
+func (s *DepartmentStore) link(ctx context.Context, parentID string, childID string) error {
+	_, err := s.edges.CreateDocument(ctx, map[string]interface{}{
+		"_from": departmentCollectionName + "/" + parentID,
+		"_to":   departmentCollectionName + "/" + childID,
+	})
+	return err
+}
+
+// unlink removes the edge from the department's parent, if it has one.
+func (s *DepartmentStore) unlink(ctx context.Context, id string) error {
+	query := `FOR e IN @@edges
+	FILTER e._to == CONCAT(@departments, "/", @id)
+	REMOVE e IN @@edges`
+
+	bindvars := map[string]interface{}{
+		"@edges":      subdepartmentCollectionName,
+		"departments": departmentCollectionName,
+		"id":          id,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// withTransaction runs fn in a stream transaction over the department
+// collections, so the tree is never seen half changed.
+func (s *DepartmentStore) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	cols := driver.TransactionCollections{
+		Read:  []string{collectionName},
+		Write: []string{departmentCollectionName, subdepartmentCollectionName},
+	}
+	tid, err := s.db.BeginTransaction(ctx, cols, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+
+	if err := fn(driver.WithTransactionID(ctx, tid)); err != nil {
+		if aerr := s.db.AbortTransaction(ctx, tid, nil); aerr != nil {
+			s.log.Errorw("aborting transaction", "error", aerr)
+		}
+		return err
+	}
+
+	if err := s.db.CommitTransaction(ctx, tid, nil); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// queryOne runs a query that must return exactly one department.
+func (s *DepartmentStore) queryOne(ctx context.Context, query string, bindvars map[string]interface{}) (user.Department, error) {
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return user.Department{}, err
+	}
+	defer c.Close()
+
+	var result dbDepartment
+	_, err = c.ReadDocument(ctx, &result)
+	if driver.IsNoMoreDocuments(err) {
+		return user.Department{}, ErrDepartmentNotFound
+	}
+	return toCoreDepartment(result), err
+}
+
+// queryValue runs a query and reads its first result into v. v is left
+// unchanged when there is none.
+func (s *DepartmentStore) queryValue(ctx context.Context, query string, bindvars map[string]interface{}, v interface{}) error {
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	_, err = c.ReadDocument(ctx, v)
+	if driver.IsNoMoreDocuments(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,288 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const (
+	groupCollectionName      = "groups"
+	membershipCollectionName = "memberships"
+)
+
+var (
+	ErrGroupNotFound       = errors.New("group not found")
+	ErrGroupMemberNotFound = errors.New("group or member not found")
+)
+
+// GroupStore manages groups and the membership edges between the users and
+// groups collections.
+type GroupStore struct {
+	userReader
+	db  driver.Database
+	col driver.Collection
+	log *zap.SugaredLogger
+}
+
+// NewGroupStore constructs the api for group data access.
+func NewGroupStore(log *zap.SugaredLogger, db driver.Database, opts ...ReadOption) *GroupStore {
+	col, err := db.Collection(context.Background(), groupCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	if _, err := db.Collection(context.Background(), membershipCollectionName); err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &GroupStore{
+		userReader: newUserReader(opts),
+		log:        log,
+		db:         db,
+		col:        col,
+	}
+}
+
+// Create inserts a new group into the database.
+func (s *GroupStore) Create(ctx context.Context, grp user.Group) (user.Group, error) {
+	var result dbGroup
+	ctx = driver.WithReturnNew(ctx, &result)
+	_, err := s.col.CreateDocument(ctx, toDBGroup(grp))
+	return toCoreGroup(result), err
+}
+
+// Update updates a group by data.
+func (s *GroupStore) Update(ctx context.Context, orgID string, id string, ug user.UpdateGroup, now time.Time) (user.Group, error) {
+	query := `FOR g IN @@coll
+	FILTER g._key == @id AND g.org_id == @org_id
+	UPDATE g WITH @upd IN @@coll OPTIONS { keepNull: false }
+	RETURN NEW`
+
+	bindvars := map[string]interface{}{
+		"@coll":  groupCollectionName,
+		"id":     id,
+		"org_id": orgID,
+		"upd": dbUpdateGroup{
+			Name:        ug.Name,
+			Description: ug.Description,
+			DateUpdated: now.UTC(),
+		},
+	}
+
+	return s.queryOne(ctx, query, bindvars)
+}
+
+// Delete deletes a group and all of its membership edges from the database.
+func (s *GroupStore) Delete(ctx context.Context, orgID string, id string) (user.Group, error) {
+	query := `FOR g IN @@coll
+	FILTER g._key == @id AND g.org_id == @org_id
+	REMOVE g IN @@coll
+	RETURN OLD`
+
+	bindvars := map[string]interface{}{
+		"@coll":  groupCollectionName,
+		"id":     id,
+		"org_id": orgID,
+	}
+
+	grp, err := s.queryOne(ctx, query, bindvars)
+	if err != nil {
+		return user.Group{}, err
+	}
+
+	query = `FOR e IN @@edges
+	FILTER e._to == CONCAT(@groups, "/", @id)
+	REMOVE e IN @@edges`
+
+	bindvars = map[string]interface{}{
+		"@edges": membershipCollectionName,
+		"groups": groupCollectionName,
+		"id":     id,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return user.Group{}, err
+	}
+	c.Close()
+
+	return grp, nil
+}
+
+// QueryByID queries a group by id.
+func (s *GroupStore) QueryByID(ctx context.Context, orgID string, id string) (user.Group, error) {
+	query := `FOR g IN @@coll
+	FILTER g._key == @id AND g.org_id == @org_id
+	RETURN g`
+
+	bindvars := map[string]interface{}{
+		"@coll":  groupCollectionName,
+		"id":     id,
+		"org_id": orgID,
+	}
+
+	return s.queryOne(ctx, query, bindvars)
+}
+
+// Query retrieves the groups of an organization ordered by name.
+func (s *GroupStore) Query(ctx context.Context, orgID string) ([]user.Group, error) {
+	query := `FOR g IN @@coll
+	FILTER g.org_id == @org_id
+	SORT g.name
+	RETURN g`
+
+	bindvars := map[string]interface{}{
+		"@coll":  groupCollectionName,
+		"org_id": orgID,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	grps, err := readAll[dbGroup](ctx, c)
+	return toCoreGroupSlice(grps), err
+}
+
+// AddMember adds the user to the group. Adding an existing member is a no-op.
+// The user and the group must both belong to the organization.
+func (s *GroupStore) AddMember(ctx context.Context, orgID string, groupID string, userID string) error {
+	query := `LET u = FIRST(FOR u IN @@users FILTER u.user_id == @user_id AND u.org_id == @org_id LIMIT 1 RETURN u)
+	LET g = DOCUMENT(@groups, @group_id)
+	FILTER u != null AND g != null AND g.org_id == @org_id
+	UPSERT { _from: u._id, _to: g._id }
+	INSERT { _from: u._id, _to: g._id }
+	UPDATE {}
+	IN @@edges
+	RETURN NEW`
+
+	bindvars := map[string]interface{}{
+		"@users":   collectionName,
+		"@edges":   membershipCollectionName,
+		"groups":   groupCollectionName,
+		"user_id":  userID,
+		"group_id": groupID,
+		"org_id":   orgID,
+	}
+
+	return s.exec(ctx, query, bindvars)
+}
+
+// RemoveMember removes the user from the group.
+func (s *GroupStore) RemoveMember(ctx context.Context, orgID string, groupID string, userID string) error {
+	query := `FOR u IN @@users
+	FILTER u.user_id == @user_id AND u.org_id == @org_id
+	FOR e IN @@edges
+	FILTER e._from == u._id AND e._to == CONCAT(@groups, "/", @group_id)
+	REMOVE e IN @@edges
+	RETURN OLD`
+
+	bindvars := map[string]interface{}{
+		"@users":   collectionName,
+		"@edges":   membershipCollectionName,
+		"groups":   groupCollectionName,
+		"user_id":  userID,
+		"group_id": groupID,
+		"org_id":   orgID,
+	}
+
+	return s.exec(ctx, query, bindvars)
+}
+
+// QueryByUser queries the groups a user belongs to.
+func (s *GroupStore) QueryByUser(ctx context.Context, orgID string, userID string) ([]user.Group, error) {
+	query := `FOR u IN @@users
+	FILTER u.user_id == @user_id AND u.org_id == @org_id
+	FOR g IN 1..1 OUTBOUND u @@edges
+	FILTER g.org_id == @org_id
+	SORT g.name
+	RETURN g`
+
+	bindvars := map[string]interface{}{
+		"@users":  collectionName,
+		"@edges":  membershipCollectionName,
+		"user_id": userID,
+		"org_id":  orgID,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	grps, err := readAll[dbGroup](ctx, c)
+	return toCoreGroupSlice(grps), err
+}
+
+// QueryMembers queries the users that belong to a group.
+func (s *GroupStore) QueryMembers(ctx context.Context, orgID string, groupID string) ([]user.User, error) {
+	query := `FOR u IN 1..1 INBOUND CONCAT(@groups, "/", @group_id) @@edges
+	FILTER u != null AND u.org_id == @org_id
+	SORT u.name
+	RETURN u`
+
+	bindvars := map[string]interface{}{
+		"@edges":   membershipCollectionName,
+		"groups":   groupCollectionName,
+		"group_id": groupID,
+		"org_id":   orgID,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	return s.readUsers(ctx, c, true)
+}
+
+// queryOne runs a query that must return exactly one group.
+func (s *GroupStore) queryOne(ctx context.Context, query string, bindvars map[string]interface{}) (user.Group, error) {
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return user.Group{}, err
+	}
+	defer c.Close()
+
+	var result dbGroup
+	_, err = c.ReadDocument(ctx, &result)
+	if driver.IsNoMoreDocuments(err) {
+		return user.Group{}, ErrGroupNotFound
+	}
+	return toCoreGroup(result), err
+}
+
+// exec runs a membership query that must affect at least one edge.
+func (s *GroupStore) exec(ctx context.Context, query string, bindvars map[string]interface{}) error {
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var edge map[string]interface{}
+	_, err = c.ReadDocument(ctx, &edge)
+	if driver.IsNoMoreDocuments(err) {
+		return ErrGroupMemberNotFound
+	}
+	return err
+}
+
+// removeMemberships removes the membership edges from the user.
+func removeMemberships(ctx context.Context, db driver.Database, id string) error {
+	query := `FOR e IN @@edges
+	FILTER e._from == @id
+	REMOVE e IN @@edges`
+
+	bindvars := map[string]interface{}{
+		"@edges": membershipCollectionName,
+		"id":     id,
+	}
+
+	c, err := db.Query(ctx, query, bindvars)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
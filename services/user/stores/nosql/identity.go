@@ -0,0 +1,50 @@
+package nosql
+
+import (
+	"context"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const identityCollectionName = "federated_identities"
+
+// IdentityStore manages the links between external identity provider
+// subjects and local users.
+type IdentityStore struct {
+	db  driver.Database
+	col driver.Collection
+	log *zap.SugaredLogger
+}
+
+// NewIdentityStore constructs the api for identity link data access.
+func NewIdentityStore(log *zap.SugaredLogger, db driver.Database) *IdentityStore {
+	col, err := db.Collection(context.Background(), identityCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &IdentityStore{
+		log: log,
+		db:  db,
+		col: col,
+	}
+}
+
+// Create inserts a new identity link into the database.
+func (s *IdentityStore) Create(ctx context.Context, link user.IdentityLink) (user.IdentityLink, error) {
+	var result dbIdentityLink
+	ctx = driver.WithReturnNew(ctx, &result)
+	_, err := s.col.CreateDocument(ctx, toDBIdentityLink(link))
+	return toCoreIdentityLink(result), err
+}
+
+// QueryBySubject queries the link of an identity provider's subject.
+func (s *IdentityStore) QueryBySubject(ctx context.Context, issuer string, subject string) (user.IdentityLink, error) {
+	var result dbIdentityLink
+	_, err := s.col.ReadDocument(ctx, identityKey(issuer, subject), &result)
+	if driver.IsNotFound(err) {
+		return user.IdentityLink{}, nil
+	}
+	return toCoreIdentityLink(result), err
+}
@@ -0,0 +1,132 @@
+package nosql
+
+import (
+	"context"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const (
+	jobLockCollectionName = "job_locks"
+	jobRunCollectionName  = "job_runs"
+)
+
+// JobStore manages the job locks and run history.
+type JobStore struct {
+	db   driver.Database
+	col  driver.Collection
+	runs driver.Collection
+	log  *zap.SugaredLogger
+}
+
+// NewJobStore constructs the api for job data access.
+func NewJobStore(log *zap.SugaredLogger, db driver.Database) *JobStore {
+	col, err := db.Collection(context.Background(), jobLockCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	runs, err := db.Collection(context.Background(), jobRunCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &JobStore{
+		log:  log,
+		db:   db,
+		col:  col,
+		runs: runs,
+	}
+}
+
+// Lock acquires the lock document of a job for the run scheduled at slot.
+// The document remembers the latest slot run, so a run is made once even
+// when replicas reach it one after the other. Replicas racing for the lock
+// conflict on the document and all but one lose.
+func (s *JobStore) Lock(ctx context.Context, job string, owner string, slot time.Time, until time.Time) (bool, error) {
+	query := `LET doc = FIRST(FOR l IN @@coll FILTER l._key == @job RETURN l)
+	FILTER doc == null OR (doc.locked_until < @now AND doc.slot < @slot)
+	UPSERT { _key: @job }
+	INSERT { _key: @job, owner: @owner, slot: @slot, locked_until: @until }
+	UPDATE { owner: @owner, slot: @slot, locked_until: @until }
+	IN @@coll
+	RETURN NEW`
+
+	// Times are compared as milliseconds, since their text doesn't sort in
+	// time order.
+	bindvars := map[string]interface{}{
+		"@coll": jobLockCollectionName,
+		"job":   job,
+		"owner": owner,
+		"slot":  slot.UnixMilli(),
+		"until": until.UnixMilli(),
+		"now":   time.Now().UnixMilli(),
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if driver.IsConflict(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	var lock map[string]interface{}
+	_, err = c.ReadDocument(ctx, &lock)
+	if driver.IsNoMoreDocuments(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unlock releases the lock of a job held by owner.
+func (s *JobStore) Unlock(ctx context.Context, job string, owner string) error {
+	query := `FOR l IN @@coll
+	FILTER l._key == @job AND l.owner == @owner
+	UPDATE l WITH { locked_until: 0 } IN @@coll`
+
+	bindvars := map[string]interface{}{
+		"@coll": jobLockCollectionName,
+		"job":   job,
+		"owner": owner,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// CreateRun inserts a run into the history.
+func (s *JobStore) CreateRun(ctx context.Context, run user.JobRun) (user.JobRun, error) {
+	var result dbJobRun
+	ctx = driver.WithReturnNew(ctx, &result)
+	_, err := s.runs.CreateDocument(ctx, toDBJobRun(run))
+	return toCoreJobRun(result), err
+}
+
+// QueryRuns retrieves a page of a job's runs, newest first.
+func (s *JobStore) QueryRuns(ctx context.Context, job string, pageNumber int, rowsPerPage int) ([]user.JobRun, error) {
+	query := `FOR r IN @@coll
+	FILTER r.job == @job
+	SORT DATE_TIMESTAMP(r.started_at) DESC
+	LIMIT @offset, @rows
+	RETURN r`
+
+	bindvars := map[string]interface{}{
+		"@coll":  jobRunCollectionName,
+		"job":    job,
+		"offset": (pageNumber - 1) * rowsPerPage,
+		"rows":   rowsPerPage,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := readAll[dbJobRun](ctx, c)
+	return toCoreJobRunSlice(runs), err
+}
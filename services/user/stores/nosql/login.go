@@ -0,0 +1,97 @@
+package nosql
+
+import (
+	"context"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const loginCollectionName = "login_history"
+
+// LoginStore manages the login history collection.
+type LoginStore struct {
+	db  driver.Database
+	col driver.Collection
+	log *zap.SugaredLogger
+}
+
+// NewLoginStore constructs the api for login history data access.
+func NewLoginStore(log *zap.SugaredLogger, db driver.Database) *LoginStore {
+	col, err := db.Collection(context.Background(), loginCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &LoginStore{
+		log: log,
+		db:  db,
+		col: col,
+	}
+}
+
+// Create inserts a login and removes the user's logins beyond the latest
+// keep.
+func (s *LoginStore) Create(ctx context.Context, l user.Login, keep int) error {
+	if _, err := s.col.CreateDocument(ctx, toDBLogin(l)); err != nil {
+		return err
+	}
+
+	query := `FOR l IN @@coll
+	FILTER l.org_id == @org_id AND l.user_id == @user_id
+	SORT DATE_TIMESTAMP(l.date_created) DESC, l._key DESC
+	LIMIT @keep, 1000000
+	REMOVE l IN @@coll`
+
+	bindvars := map[string]interface{}{
+		"@coll":   loginCollectionName,
+		"org_id":  l.OrgID.String(),
+		"user_id": l.UserID.String(),
+		"keep":    keep,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// Query retrieves a page of a user's logins, newest first.
+func (s *LoginStore) Query(ctx context.Context, orgID string, userID string, filter user.LoginFilter, pageNumber int, rowsPerPage int) ([]user.Login, error) {
+	bindvars := map[string]interface{}{
+		"@coll":   loginCollectionName,
+		"org_id":  orgID,
+		"user_id": userID,
+		"offset":  (pageNumber - 1) * rowsPerPage,
+		"rows":    rowsPerPage,
+	}
+
+	var buf strings.Builder
+	buf.WriteString(`FOR l IN @@coll
+	FILTER l.org_id == @org_id AND l.user_id == @user_id`)
+
+	if filter.Success != nil {
+		buf.WriteString(`
+	FILTER l.success == @success`)
+		bindvars["success"] = *filter.Success
+	}
+	if filter.Method != nil {
+		buf.WriteString(`
+	FILTER l.method == @method`)
+		bindvars["method"] = *filter.Method
+	}
+
+	buf.WriteString(`
+	SORT DATE_TIMESTAMP(l.date_created) DESC, l._key DESC
+	LIMIT @offset, @rows
+	RETURN l`)
+
+	c, err := s.db.Query(ctx, buf.String(), bindvars)
+	if err != nil {
+		return nil, err
+	}
+	ls, err := readAll[dbLogin](ctx, c)
+	return toCoreLoginSlice(ls), err
+}
@@ -0,0 +1,266 @@
+package nosql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const (
+	reportingCollectionName = "reporting_lines"
+
+	// maxReportingDepth bounds traversals of the org chart.
+	maxReportingDepth = 100
+)
+
+// ManagerStore manages reporting lines, stored as edges in the
+// reporting_lines collection from each user to their manager.
+type ManagerStore struct {
+	userReader
+	db    driver.Database
+	edges driver.Collection
+	log   *zap.SugaredLogger
+}
+
+// NewManagerStore constructs the api for reporting line data access.
+func NewManagerStore(log *zap.SugaredLogger, db driver.Database, opts ...ReadOption) *ManagerStore {
+	edges, err := db.Collection(context.Background(), reportingCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &ManagerStore{
+		userReader: newUserReader(opts),
+		log:        log,
+		db:         db,
+		edges:      edges,
+	}
+}
+
+// SetManager replaces the edge from the user to their manager.
+func (s *ManagerStore) SetManager(ctx context.Context, orgID string, userID string, managerID string) error {
+	// The reporting lines are locked exclusively for the whole transaction:
+	// with only a write lock, two transactions setting A's manager to B and
+	// B's manager to A could both pass the cycle check before either edge is
+	// written.
+	cols := driver.TransactionCollections{
+		Read:      []string{collectionName},
+		Exclusive: []string{reportingCollectionName},
+	}
+	tid, err := s.db.BeginTransaction(ctx, cols, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+
+	if err := s.setManager(driver.WithTransactionID(ctx, tid), orgID, userID, managerID); err != nil {
+		if aerr := s.db.AbortTransaction(ctx, tid, nil); aerr != nil {
+			s.log.Errorw("aborting transaction", "error", aerr)
+		}
+		return err
+	}
+
+	if err := s.db.CommitTransaction(ctx, tid, nil); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *ManagerStore) setManager(ctx context.Context, orgID string, userID string, managerID string) error {
+	usr, err := s.userDocumentID(ctx, orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	var mgr string
+	if managerID != "" {
+		if mgr, err = s.userDocumentID(ctx, orgID, managerID); err != nil {
+			return fmt.Errorf("manager: %w", err)
+		}
+
+		// The user can't report to someone who already reports to them.
+		query := fmt.Sprintf(`FOR v IN 0..%d OUTBOUND @manager @@edges
+		FILTER v._id == @user
+		LIMIT 1
+		RETURN true`, maxReportingDepth)
+
+		bindvars := map[string]interface{}{
+			"@edges":  reportingCollectionName,
+			"manager": mgr,
+			"user":    usr,
+		}
+
+		c, err := s.db.Query(ctx, query, bindvars)
+		if err != nil {
+			return err
+		}
+		cycle := c.HasMore()
+		c.Close()
+		if cycle {
+			return user.ErrManagerCycle
+		}
+	}
+
+	if err := removeReportingLines(ctx, s.db, usr, false); err != nil {
+		return err
+	}
+	if mgr == "" {
+		return nil
+	}
+	_, err = s.edges.CreateDocument(ctx, map[string]interface{}{
+		"_from": usr,
+		"_to":   mgr,
+	})
+	return err
+}
+
+// QueryDirectReports queries the users who report to the manager.
+func (s *ManagerStore) QueryDirectReports(ctx context.Context, orgID string, managerID string) ([]user.User, error) {
+	mgr, err := s.userDocumentID(ctx, orgID, managerID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `FOR u IN 1..1 INBOUND @manager @@edges
+	FILTER u.org_id == @org_id
+	SORT u.name
+	RETURN u`
+
+	bindvars := map[string]interface{}{
+		"@edges":  reportingCollectionName,
+		"manager": mgr,
+		"org_id":  orgID,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	return s.readUsers(ctx, c, true)
+}
+
+// QueryChain queries the managers above the user, nearest first.
+func (s *ManagerStore) QueryChain(ctx context.Context, orgID string, userID string) ([]user.User, error) {
+	usr, err := s.userDocumentID(ctx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`FOR u, e, p IN 1..%d OUTBOUND @user @@edges
+	FILTER u.org_id == @org_id
+	SORT LENGTH(p.edges)
+	RETURN u`, maxReportingDepth)
+
+	bindvars := map[string]interface{}{
+		"@edges": reportingCollectionName,
+		"user":   usr,
+		"org_id": orgID,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	return s.readUsers(ctx, c, false)
+}
+
+// QueryTree queries everyone below the manager, nearest first.
+func (s *ManagerStore) QueryTree(ctx context.Context, orgID string, managerID string) ([]user.Report, error) {
+	mgr, err := s.userDocumentID(ctx, orgID, managerID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`FOR u, e, p IN 1..%d INBOUND @manager @@edges
+	FILTER u.org_id == @org_id
+	LET depth = LENGTH(p.edges)
+	SORT depth, u.name
+	RETURN { user: u, manager_id: p.vertices[depth - 1].user_id, depth: depth }`, maxReportingDepth)
+
+	bindvars := map[string]interface{}{
+		"@edges":  reportingCollectionName,
+		"manager": mgr,
+		"org_id":  orgID,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	dbReports, err := readAll[dbReport](ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]user.Report, len(dbReports))
+	for i, r := range dbReports {
+		usr, err := openUser(ctx, s.cipher, r.User)
+		if err != nil {
+			return nil, err
+		}
+		reports[i] = user.Report{
+			User:      usr,
+			ManagerID: r.ManagerID,
+			Depth:     r.Depth,
+		}
+	}
+	if s.cipher != nil {
+		sort.SliceStable(reports, func(i, j int) bool {
+			if reports[i].Depth != reports[j].Depth {
+				return reports[i].Depth < reports[j].Depth
+			}
+			return reports[i].User.Name < reports[j].User.Name
+		})
+	}
+	return reports, nil
+}
+
+// userDocumentID returns the document id of the user, which their
+// reporting lines point at.
+func (s *ManagerStore) userDocumentID(ctx context.Context, orgID string, userID string) (string, error) {
+	query := `FOR u IN @@users
+	FILTER u.user_id == @user_id AND u.org_id == @org_id
+	LIMIT 1
+	RETURN u._id`
+
+	bindvars := map[string]interface{}{
+		"@users":  collectionName,
+		"user_id": userID,
+		"org_id":  orgID,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	var id string
+	_, err = c.ReadDocument(ctx, &id)
+	if driver.IsNoMoreDocuments(err) {
+		return "", ErrNotFound
+	}
+	return id, err
+}
+
+// removeReportingLines removes the edge from the user to their manager and,
+// when reports is set, the edges from the users who report to them.
+func removeReportingLines(ctx context.Context, db driver.Database, id string, reports bool) error {
+	query := `FOR e IN @@edges
+	FILTER e._from == @id OR (@reports AND e._to == @id)
+	REMOVE e IN @@edges`
+
+	bindvars := map[string]interface{}{
+		"@edges":  reportingCollectionName,
+		"id":      id,
+		"reports": reports,
+	}
+
+	c, err := db.Query(ctx, query, bindvars)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
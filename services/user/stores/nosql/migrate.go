@@ -0,0 +1,474 @@
+package nosql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/arangodb/go-driver"
+)
+
+const migrationCollectionName = "migrations"
+
+// Migration is a versioned change to the schema of the database. Up and
+// Down must be safe to run again after failing part way, since ArangoDB
+// can't roll back schema changes.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db driver.Database) error
+	Down    func(ctx context.Context, db driver.Database) error
+}
+
+// MigrationStatus tells whether a migration has been applied.
+type MigrationStatus struct {
+	Version int
+	Name    string
+	// AppliedAt is zero when the migration hasn't been applied.
+	AppliedAt time.Time
+}
+
+// dbMigration records an applied migration.
+type dbMigration struct {
+	Key       string    `json:"_key"`
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// Migrations are the changes that make up the schema, oldest first.
+// Migrations that have been applied must never change; change the schema
+// by appending a migration.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_collections",
+		Up: func(ctx context.Context, db driver.Database) error {
+			for _, name := range documentCollections {
+				if err := ensureCollection(ctx, db, name, driver.CollectionTypeDocument); err != nil {
+					return err
+				}
+			}
+			for _, name := range edgeCollections {
+				if err := ensureCollection(ctx, db, name, driver.CollectionTypeEdge); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db driver.Database) error {
+			for _, name := range append(append([]string(nil), edgeCollections...), documentCollections...) {
+				if err := removeCollection(ctx, db, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_indexes",
+		Up: func(ctx context.Context, db driver.Database) error {
+			return ensureIndexes(ctx, db, indexes)
+		},
+		Down: func(ctx context.Context, db driver.Database) error {
+			return removeIndexes(ctx, db, indexes)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_search_view",
+		Up:      ensureSearchView,
+		Down: func(ctx context.Context, db driver.Database) error {
+			v, err := db.View(ctx, searchViewName)
+			if driver.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("opening view %s: %w", searchViewName, err)
+			}
+			if err := v.Remove(ctx); err != nil {
+				return fmt.Errorf("removing view %s: %w", searchViewName, err)
+			}
+			return nil
+		},
+	},
+	{
+		Version: 4,
+		Name:    "validate_users",
+		Up: func(ctx context.Context, db driver.Database) error {
+			return setUserSchema(ctx, db, userSchemaV4, driver.CollectionSchemaLevelModerate)
+		},
+		Down: func(ctx context.Context, db driver.Database) error {
+			return setUserSchema(ctx, db, userSchemaV4, driver.CollectionSchemaLevelNone)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "allow_null_attributes",
+		Up: func(ctx context.Context, db driver.Database) error {
+			return setUserSchema(ctx, db, userSchema(), driver.CollectionSchemaLevelModerate)
+		},
+		Down: func(ctx context.Context, db driver.Database) error {
+			return setUserSchema(ctx, db, userSchemaV4, driver.CollectionSchemaLevelModerate)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_data_keys",
+		Up: func(ctx context.Context, db driver.Database) error {
+			if err := ensureCollection(ctx, db, dataKeyCollectionName, driver.CollectionTypeDocument); err != nil {
+				return err
+			}
+			return ensureIndexes(ctx, db, encryptionIndexes)
+		},
+		Down: func(ctx context.Context, db driver.Database) error {
+			if err := removeIndexes(ctx, db, encryptionIndexes); err != nil {
+				return err
+			}
+			return removeCollection(ctx, db, dataKeyCollectionName)
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create_deleted_users",
+		Up: func(ctx context.Context, db driver.Database) error {
+			if err := ensureCollection(ctx, db, deletedUserCollectionName, driver.CollectionTypeDocument); err != nil {
+				return err
+			}
+			return ensureIndexes(ctx, db, deletedUserIndexes)
+		},
+		Down: func(ctx context.Context, db driver.Database) error {
+			return removeCollection(ctx, db, deletedUserCollectionName)
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_tokens",
+		Up: func(ctx context.Context, db driver.Database) error {
+			if err := ensureCollection(ctx, db, tokenCollectionName, driver.CollectionTypeDocument); err != nil {
+				return err
+			}
+			return ensureIndexes(ctx, db, tokenIndexes)
+		},
+		Down: func(ctx context.Context, db driver.Database) error {
+			return removeCollection(ctx, db, tokenCollectionName)
+		},
+	},
+}
+
+// documentCollections and edgeCollections are the collections the stores
+// use.
+var (
+	documentCollections = []string{
+		collectionName, groupCollectionName, orgCollectionName, attributeSchemaCollectionName,
+		clientCollectionName, identityCollectionName, webhookCollectionName, deliveryCollectionName,
+		outboxCollectionName, loginCollectionName, jobLockCollectionName, jobRunCollectionName,
+		departmentCollectionName,
+	}
+	edgeCollections = []string{
+		membershipCollectionName, subdepartmentCollectionName, reportingCollectionName,
+	}
+)
+
+// persistentIndex is a persistent index on a collection.
+type persistentIndex struct {
+	collection string
+	name       string
+	fields     []string
+	unique     bool
+}
+
+// indexes are the persistent indexes behind the stores' queries.
+var indexes = []persistentIndex{
+	{collectionName, "users_user_id", []string{"user_id"}, true},
+	{collectionName, "users_org_name", []string{"org_id", "name"}, false},
+	{collectionName, "users_org_department", []string{"org_id", "department"}, false},
+	{collectionName, "users_enabled", []string{"enabled"}, false},
+	{groupCollectionName, "groups_org_name", []string{"org_id", "name"}, false},
+	{clientCollectionName, "oauth_clients_org_name", []string{"org_id", "name"}, false},
+	{departmentCollectionName, "departments_org_name", []string{"org_id", "name"}, false},
+	{webhookCollectionName, "webhooks_org_url", []string{"org_id", "url"}, false},
+	{deliveryCollectionName, "webhook_deliveries_org_webhook", []string{"org_id", "webhook_id"}, false},
+	{loginCollectionName, "login_history_org_user", []string{"org_id", "user_id"}, false},
+	{jobRunCollectionName, "job_runs_job", []string{"job"}, false},
+	{outboxCollectionName, "outbox_seq", []string{"seq"}, false},
+}
+
+// encryptionIndexes find encrypted users by email and the users to
+// re-encrypt.
+var encryptionIndexes = []persistentIndex{
+	{collectionName, "users_org_email_index", []string{"org_id", "email_index"}, false},
+	{collectionName, "users_data_key", []string{"data_key"}, false},
+}
+
+// deletedUserIndexes find the deleted users to purge.
+var deletedUserIndexes = []persistentIndex{
+	{deletedUserCollectionName, "deleted_users_date_deleted", []string{"date_deleted"}, false},
+}
+
+// tokenIndexes find the tokens that have expired.
+var tokenIndexes = []persistentIndex{
+	{tokenCollectionName, "tokens_expires_at", []string{"expires_at"}, false},
+}
+
+// userSchemaV4 is the JSON schema users were first validated against.
+// Only the fields the stores rely on are checked, so documents may carry
+// more.
+var userSchemaV4 = map[string]interface{}{
+	"type":     "object",
+	"required": []string{"user_id", "org_id", "email", "roles"},
+	"properties": map[string]interface{}{
+		"user_id":    map[string]interface{}{"type": "string", "minLength": 1},
+		"org_id":     map[string]interface{}{"type": "string", "minLength": 1},
+		"email":      map[string]interface{}{"type": "string", "minLength": 1},
+		"name":       map[string]interface{}{"type": "string"},
+		"roles":      map[string]interface{}{"type": []string{"array", "null"}, "items": map[string]interface{}{"type": "string"}},
+		"enabled":    map[string]interface{}{"type": "boolean"},
+		"department": map[string]interface{}{"type": "string"},
+		"attributes": map[string]interface{}{"type": "object"},
+	},
+}
+
+// userSchema returns the JSON schema users are validated against now.
+// Users without attributes store them as null, so an empty map and no
+// attributes read back apart.
+func userSchema() map[string]interface{} {
+	props := make(map[string]interface{})
+	for k, v := range userSchemaV4["properties"].(map[string]interface{}) {
+		props[k] = v
+	}
+	props["attributes"] = map[string]interface{}{"type": []string{"object", "null"}}
+	props["email_name"] = map[string]interface{}{"type": "string"}
+
+	return map[string]interface{}{
+		"type":       userSchemaV4["type"],
+		"required":   userSchemaV4["required"],
+		"properties": props,
+	}
+}
+
+func ensureIndexes(ctx context.Context, db driver.Database, idxs []persistentIndex) error {
+	for _, idx := range idxs {
+		col, err := db.Collection(ctx, idx.collection)
+		if err != nil {
+			return fmt.Errorf("opening collection %s: %w", idx.collection, err)
+		}
+		opts := driver.EnsurePersistentIndexOptions{Name: idx.name, Unique: idx.unique, InBackground: true}
+		if _, _, err := col.EnsurePersistentIndex(ctx, idx.fields, &opts); err != nil {
+			return fmt.Errorf("ensuring index %s: %w", idx.name, err)
+		}
+	}
+	return nil
+}
+
+func removeIndexes(ctx context.Context, db driver.Database, idxs []persistentIndex) error {
+	for _, idx := range idxs {
+		col, err := db.Collection(ctx, idx.collection)
+		if driver.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("opening collection %s: %w", idx.collection, err)
+		}
+		index, err := col.Index(ctx, idx.name)
+		if driver.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("opening index %s: %w", idx.name, err)
+		}
+		if err := index.Remove(ctx); err != nil {
+			return fmt.Errorf("removing index %s: %w", idx.name, err)
+		}
+	}
+	return nil
+}
+
+// setUserSchema validates users against the rule at the level.
+// CollectionSchemaLevelNone turns validation off.
+func setUserSchema(ctx context.Context, db driver.Database, rule map[string]interface{}, level driver.CollectionSchemaLevel) error {
+	col, err := db.Collection(ctx, collectionName)
+	if err != nil {
+		return fmt.Errorf("opening collection %s: %w", collectionName, err)
+	}
+	props := driver.SetCollectionPropertiesOptions{
+		Schema: &driver.CollectionSchemaOptions{
+			Rule:    rule,
+			Level:   level,
+			Message: "user document does not match the schema",
+		},
+	}
+	if err := col.SetProperties(ctx, props); err != nil {
+		return fmt.Errorf("setting schema of %s: %w", collectionName, err)
+	}
+	return nil
+}
+
+// EnsureDatabase opens the database, creating it when it doesn't exist.
+func EnsureDatabase(ctx context.Context, client driver.Client, name string) (driver.Database, error) {
+	exists, err := client.DatabaseExists(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("checking database %s: %w", name, err)
+	}
+	if !exists {
+		db, err := client.CreateDatabase(ctx, name, nil)
+		// Another instance may have created the database since we checked.
+		if err == nil || !driver.IsConflict(err) {
+			return db, err
+		}
+	}
+	return client.Database(ctx, name)
+}
+
+// MigrateUp applies the migrations the database hasn't applied yet and
+// returns the version the schema is at.
+func MigrateUp(ctx context.Context, db driver.Database) (int, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	col, err := db.Collection(ctx, migrationCollectionName)
+	if err != nil {
+		return 0, fmt.Errorf("opening collection %s: %w", migrationCollectionName, err)
+	}
+
+	version := currentVersion(applied)
+	for _, m := range sortedMigrations() {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := m.Up(ctx, db); err != nil {
+			return version, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		rec := dbMigration{Key: migrationKey(m.Version), Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}
+		if _, err := col.CreateDocument(ctx, rec); err != nil && !driver.IsConflict(err) {
+			return version, fmt.Errorf("recording migration %d %s: %w", m.Version, m.Name, err)
+		}
+		version = m.Version
+	}
+	return version, nil
+}
+
+// MigrateDown reverts the latest steps applied migrations, newest first,
+// and returns the version the schema is at.
+func MigrateDown(ctx context.Context, db driver.Database, steps int) (int, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	col, err := db.Collection(ctx, migrationCollectionName)
+	if err != nil {
+		return 0, fmt.Errorf("opening collection %s: %w", migrationCollectionName, err)
+	}
+
+	ms := sortedMigrations()
+	for i := len(ms) - 1; i >= 0 && steps > 0; i-- {
+		m := ms[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := m.Down(ctx, db); err != nil {
+			return currentVersion(applied), fmt.Errorf("reverting migration %d %s: %w", m.Version, m.Name, err)
+		}
+		if _, err := col.RemoveDocument(ctx, migrationKey(m.Version)); err != nil && !driver.IsNotFound(err) {
+			return currentVersion(applied), fmt.Errorf("unrecording migration %d %s: %w", m.Version, m.Name, err)
+		}
+		delete(applied, m.Version)
+		steps--
+	}
+	return currentVersion(applied), nil
+}
+
+// Status lists every migration and when it was applied, oldest first.
+func Status(ctx context.Context, db driver.Database) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := sortedMigrations()
+	status := make([]MigrationStatus, len(ms))
+	for i, m := range ms {
+		status[i] = MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: applied[m.Version].AppliedAt}
+	}
+	return status, nil
+}
+
+// appliedMigrations returns the applied migrations by version, creating
+// the migrations collection first if needed.
+func appliedMigrations(ctx context.Context, db driver.Database) (map[int]dbMigration, error) {
+	if err := ensureCollection(ctx, db, migrationCollectionName, driver.CollectionTypeDocument); err != nil {
+		return nil, err
+	}
+
+	c, err := db.Query(ctx, `FOR m IN @@coll RETURN m`, map[string]interface{}{"@coll": migrationCollectionName})
+	if err != nil {
+		return nil, err
+	}
+	recs, err := readAll[dbMigration](ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]dbMigration, len(recs))
+	for _, rec := range recs {
+		applied[rec.Version] = rec
+	}
+	return applied, nil
+}
+
+// currentVersion returns the newest applied version, or 0 when none is.
+func currentVersion(applied map[int]dbMigration) int {
+	var version int
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version
+}
+
+func sortedMigrations() []Migration {
+	ms := append([]Migration(nil), Migrations...)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms
+}
+
+// migrationKey zero pads the version so keys sort in version order.
+func migrationKey(version int) string {
+	return fmt.Sprintf("%06d", version)
+}
+
+// ensureCollection creates the collection when it doesn't exist.
+func ensureCollection(ctx context.Context, db driver.Database, name string, typ driver.CollectionType) error {
+	exists, err := db.CollectionExists(ctx, name)
+	if err != nil {
+		return fmt.Errorf("checking collection %s: %w", name, err)
+	}
+	if exists {
+		return nil
+	}
+	_, err = db.CreateCollection(ctx, name, &driver.CreateCollectionOptions{Type: typ})
+	// Another instance may have created the collection since we checked.
+	if err != nil && !driver.IsConflict(err) {
+		return fmt.Errorf("creating collection %s: %w", name, err)
+	}
+	return nil
+}
+
+// removeCollection drops the collection when it exists.
+func removeCollection(ctx context.Context, db driver.Database, name string) error {
+	col, err := db.Collection(ctx, name)
+	if driver.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening collection %s: %w", name, err)
+	}
+	if err := col.Remove(ctx); err != nil {
+		return fmt.Errorf("removing collection %s: %w", name, err)
+	}
+	return nil
+}
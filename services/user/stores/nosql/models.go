@@ -1,7 +1,9 @@
 package nosql
 
 import (
-	"database/sql"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"net/mail"
 	"time"
 
@@ -12,61 +14,695 @@ import (
 // dbUser represent the structure we need for moving data
 // between the app and the database.
 type dbUser struct {
-	ID           uuid.UUID      `json:"user_id"`
-	Name         string         `json:"name"`
-	Email        string         `json:"_key"`
-	Roles        []string       `json:"roles"`
-	PasswordHash []byte         `json:"password_hash"`
-	Enabled      bool           `json:"enabled"`
-	Department   sql.NullString `json:"department"`
-	DateCreated  time.Time      `json:"date_created"`
-	DateUpdated  time.Time      `json:"date_updated"`
+	Key                string         `json:"_key"`
+	ID                 uuid.UUID      `json:"user_id"`
+	OrgID              uuid.UUID      `json:"org_id"`
+	Name               string         `json:"name"`
+	Email              string         `json:"email"`
+	EmailName          string         `json:"email_name,omitempty"`
+	Roles              []string       `json:"roles"`
+	PasswordHash       []byte         `json:"password_hash"`
+	PasswordChangedAt  time.Time      `json:"password_changed_at"`
+	MustChangePassword bool           `json:"must_change_password"`
+	Enabled            bool           `json:"enabled"`
+	Department         string         `json:"department"`
+	Attributes         map[string]any `json:"attributes"`
+	LastLoginAt        *time.Time     `json:"last_login_at"`
+	LastLoginIP        string         `json:"last_login_ip,omitempty"`
+	DateCreated        time.Time      `json:"date_created"`
+	DateUpdated        time.Time      `json:"date_updated"`
+	// EmailIndex and DataKey are only set on encrypted users, see Cipher.
+	EmailIndex string `json:"email_index,omitempty"`
+	DataKey    string `json:"data_key,omitempty"`
 }
 
+// toDBUser and toCoreUser map every field of a user, so a user reads back
+// as it was written. Only the location of times is lost: they are stored
+// in UTC and read in local time.
 func toDBUser(usr user.User) dbUser {
-	roles := make([]string, len(usr.Roles))
-	for i, role := range usr.Roles {
-		roles[i] = role.Name()
+	var roles []string
+	if usr.Roles != nil {
+		roles = make([]string, len(usr.Roles))
+		for i, role := range usr.Roles {
+			roles[i] = role.Name()
+		}
+	}
+
+	// Users who have never signed in have no last login, so they sort and
+	// filter as null.
+	var lastLogin *time.Time
+	if !usr.LastLoginAt.IsZero() {
+		t := usr.LastLoginAt.UTC()
+		lastLogin = &t
 	}
 
 	return dbUser{
-		ID:           usr.ID,
-		Name:         usr.Name,
-		Email:        usr.Email.Address,
-		Roles:        roles,
-		PasswordHash: usr.PasswordHash,
-		DateCreated:  usr.DateCreated.UTC(),
-		DateUpdated:  usr.DateUpdated.UTC(),
+		Key:                userKey(usr.OrgID.String(), usr.Email.Address),
+		ID:                 usr.ID,
+		OrgID:              usr.OrgID,
+		Name:               usr.Name,
+		Email:              usr.Email.Address,
+		EmailName:          usr.Email.Name,
+		Roles:              roles,
+		PasswordHash:       usr.PasswordHash,
+		PasswordChangedAt:  usr.PasswordChangedAt.UTC(),
+		MustChangePassword: usr.MustChangePassword,
+		Enabled:            usr.Enabled,
+		Department:         usr.Department,
+		Attributes:         usr.Attributes,
+		LastLoginAt:        lastLogin,
+		LastLoginIP:        usr.LastLoginIP,
+		DateCreated:        usr.DateCreated.UTC(),
+		DateUpdated:        usr.DateUpdated.UTC(),
+	}
+}
+
+// dbDeletedUser is a deleted user, kept under their ID so a user deleted
+// again after being recreated with the same email doesn't collide.
+type dbDeletedUser struct {
+	dbUser
+	Key         string    `json:"_key"`
+	DateDeleted time.Time `json:"date_deleted"`
+}
+
+func toDBDeletedUser(dbUsr dbUser, now time.Time) dbDeletedUser {
+	return dbDeletedUser{
+		dbUser:      dbUsr,
+		Key:         dbUsr.ID.String(),
+		DateDeleted: now.UTC(),
 	}
 }
 
 func toCoreUser(dbUsr dbUser) user.User {
 	addr := mail.Address{
+		Name:    dbUsr.EmailName,
 		Address: dbUsr.Email,
 	}
 
-	roles := make([]user.Role, len(dbUsr.Roles))
-	for i, value := range dbUsr.Roles {
-		roles[i] = user.MustParseRole(value)
+	var roles []user.Role
+	if dbUsr.Roles != nil {
+		roles = make([]user.Role, len(dbUsr.Roles))
+		for i, value := range dbUsr.Roles {
+			roles[i] = user.MustParseRole(value)
+		}
 	}
 
 	usr := user.User{
-		ID:           dbUsr.ID,
-		Name:         dbUsr.Name,
-		Email:        addr,
-		Roles:        roles,
-		PasswordHash: dbUsr.PasswordHash,
-		DateCreated:  dbUsr.DateCreated.In(time.Local),
-		DateUpdated:  dbUsr.DateUpdated.In(time.Local),
+		ID:                 dbUsr.ID,
+		OrgID:              dbUsr.OrgID,
+		Name:               dbUsr.Name,
+		Email:              addr,
+		Roles:              roles,
+		PasswordHash:       dbUsr.PasswordHash,
+		PasswordChangedAt:  dbUsr.PasswordChangedAt.In(time.Local),
+		MustChangePassword: dbUsr.MustChangePassword,
+		Enabled:            dbUsr.Enabled,
+		Department:         dbUsr.Department,
+		Attributes:         dbUsr.Attributes,
+		LastLoginIP:        dbUsr.LastLoginIP,
+		DateCreated:        dbUsr.DateCreated.In(time.Local),
+		DateUpdated:        dbUsr.DateUpdated.In(time.Local),
+	}
+	if dbUsr.LastLoginAt != nil {
+		usr.LastLoginAt = dbUsr.LastLoginAt.In(time.Local)
 	}
 
 	return usr
 }
 
-func toCoreUserSlice(dbUsers []dbUser) []user.User {
-	usrs := make([]user.User, len(dbUsers))
-	for i, dbUsr := range dbUsers {
-		usrs[i] = toCoreUser(dbUsr)
+// dbSearchHit is a user that matched a search, with its score.
+type dbSearchHit struct {
+	User  dbUser  `json:"user"`
+	Score float64 `json:"score"`
+}
+
+// dbUpdateUser holds the user fields to merge into an existing document.
+type dbUpdateUser struct {
+	Name               *string        `json:"name,omitempty"`
+	Roles              []string       `json:"roles,omitempty"`
+	Department         *string        `json:"department,omitempty"`
+	Enabled            *bool          `json:"enabled,omitempty"`
+	MustChangePassword *bool          `json:"must_change_password,omitempty"`
+	Attributes         map[string]any `json:"attributes,omitempty"`
+	DateUpdated        time.Time      `json:"date_updated"`
+}
+
+func toDBUpdateUser(uu user.UpdateUser, now time.Time) dbUpdateUser {
+	var roles []string
+	for _, role := range uu.Roles {
+		roles = append(roles, role.Name())
+	}
+
+	return dbUpdateUser{
+		Name:               uu.Name,
+		Roles:              roles,
+		Department:         uu.Department,
+		Enabled:            uu.Enabled,
+		MustChangePassword: uu.MustChangePassword,
+		Attributes:         uu.Attributes,
+		DateUpdated:        now.UTC(),
+	}
+}
+
+// userKey builds the document key for a user. Emails are only unique within
+// an organization, so the key is scoped by the organization id.
+func userKey(orgID string, email string) string {
+	return orgID + ":" + email
+}
+
+// dbGroup represent the structure we need for moving group data
+// between the app and the database.
+type dbGroup struct {
+	ID          uuid.UUID `json:"_key"`
+	OrgID       uuid.UUID `json:"org_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+// dbUpdateGroup holds the group fields to merge into an existing document.
+type dbUpdateGroup struct {
+	Name        *string   `json:"name,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+func toDBGroup(grp user.Group) dbGroup {
+	return dbGroup{
+		ID:          grp.ID,
+		OrgID:       grp.OrgID,
+		Name:        grp.Name,
+		Description: grp.Description,
+		DateCreated: grp.DateCreated.UTC(),
+		DateUpdated: grp.DateUpdated.UTC(),
+	}
+}
+
+func toCoreGroup(dbGrp dbGroup) user.Group {
+	return user.Group{
+		ID:          dbGrp.ID,
+		OrgID:       dbGrp.OrgID,
+		Name:        dbGrp.Name,
+		Description: dbGrp.Description,
+		DateCreated: dbGrp.DateCreated.In(time.Local),
+		DateUpdated: dbGrp.DateUpdated.In(time.Local),
+	}
+}
+
+func toCoreGroupSlice(dbGroups []dbGroup) []user.Group {
+	grps := make([]user.Group, len(dbGroups))
+	for i, dbGrp := range dbGroups {
+		grps[i] = toCoreGroup(dbGrp)
+	}
+	return grps
+}
+
+// dbDepartment represent the structure we need for moving department data
+// between the app and the database. ParentID is read from the
+// subdepartments edges; it isn't stored in the document.
+type dbDepartment struct {
+	ID          uuid.UUID `json:"_key"`
+	OrgID       uuid.UUID `json:"org_id"`
+	ParentID    string    `json:"parent_id,omitempty"`
+	Name        string    `json:"name"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+// dbUpdateDepartment holds the department fields to merge into an existing
+// document.
+type dbUpdateDepartment struct {
+	Name        *string   `json:"name,omitempty"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+func toDBDepartment(dept user.Department) dbDepartment {
+	return dbDepartment{
+		ID:          dept.ID,
+		OrgID:       dept.OrgID,
+		Name:        dept.Name,
+		DateCreated: dept.DateCreated.UTC(),
+		DateUpdated: dept.DateUpdated.UTC(),
+	}
+}
+
+func toCoreDepartment(dbDept dbDepartment) user.Department {
+	return user.Department{
+		ID:          dbDept.ID,
+		OrgID:       dbDept.OrgID,
+		ParentID:    dbDept.ParentID,
+		Name:        dbDept.Name,
+		DateCreated: dbDept.DateCreated.In(time.Local),
+		DateUpdated: dbDept.DateUpdated.In(time.Local),
+	}
+}
+
+func toCoreDepartmentSlice(dbDepartments []dbDepartment) []user.Department {
+	depts := make([]user.Department, len(dbDepartments))
+	for i, dbDept := range dbDepartments {
+		depts[i] = toCoreDepartment(dbDept)
+	}
+	return depts
+}
+
+// dbOrganization represent the structure we need for moving organization
+// data between the app and the database.
+type dbOrganization struct {
+	ID          uuid.UUID `json:"_key"`
+	Name        string    `json:"name"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+// dbUpdateOrganization holds the organization fields to merge into an
+// existing document.
+type dbUpdateOrganization struct {
+	Name        *string   `json:"name,omitempty"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+func toDBOrganization(org user.Organization) dbOrganization {
+	return dbOrganization{
+		ID:          org.ID,
+		Name:        org.Name,
+		DateCreated: org.DateCreated.UTC(),
+		DateUpdated: org.DateUpdated.UTC(),
+	}
+}
+
+func toCoreOrganization(dbOrg dbOrganization) user.Organization {
+	return user.Organization{
+		ID:          dbOrg.ID,
+		Name:        dbOrg.Name,
+		DateCreated: dbOrg.DateCreated.In(time.Local),
+		DateUpdated: dbOrg.DateUpdated.In(time.Local),
+	}
+}
+
+func toCoreOrganizationSlice(dbOrgs []dbOrganization) []user.Organization {
+	orgs := make([]user.Organization, len(dbOrgs))
+	for i, dbOrg := range dbOrgs {
+		orgs[i] = toCoreOrganization(dbOrg)
+	}
+	return orgs
+}
+
+// dbAttributeSchema represent the structure we need for moving attribute
+// schemas between the app and the database.
+type dbAttributeSchema struct {
+	Key         string          `json:"_key"`
+	OrgID       string          `json:"org_id"`
+	Schema      json.RawMessage `json:"schema"`
+	DateUpdated time.Time       `json:"date_updated"`
+}
+
+func toDBAttributeSchema(schema user.AttributeSchema) dbAttributeSchema {
+	return dbAttributeSchema{
+		Key:         attributeSchemaKey(schema.OrgID),
+		OrgID:       schema.OrgID,
+		Schema:      schema.Schema,
+		DateUpdated: schema.DateUpdated.UTC(),
+	}
+}
+
+func toCoreAttributeSchema(dbSchema dbAttributeSchema) user.AttributeSchema {
+	return user.AttributeSchema{
+		OrgID:       dbSchema.OrgID,
+		Schema:      dbSchema.Schema,
+		DateUpdated: dbSchema.DateUpdated.In(time.Local),
+	}
+}
+
+// attributeSchemaKey builds the document key for an attribute schema. The
+// deployment schema has no organization and uses a fixed key.
+func attributeSchemaKey(orgID string) string {
+	if orgID == "" {
+		return "deployment"
+	}
+	return orgID
+}
+
+// dbClient represent the structure we need for moving client data
+// between the app and the database.
+type dbClient struct {
+	ID           uuid.UUID `json:"_key"`
+	OrgID        uuid.UUID `json:"org_id"`
+	Name         string    `json:"name"`
+	Roles        []string  `json:"roles"`
+	RedirectURIs []string  `json:"redirect_uris,omitempty"`
+	SecretHash   []byte    `json:"secret_hash"`
+	Revoked      bool      `json:"revoked"`
+	DateCreated  time.Time `json:"date_created"`
+	DateUpdated  time.Time `json:"date_updated"`
+}
+
+func toDBClient(clt user.Client) dbClient {
+	roles := make([]string, len(clt.Roles))
+	for i, role := range clt.Roles {
+		roles[i] = role.Name()
+	}
+
+	return dbClient{
+		ID:           clt.ID,
+		OrgID:        clt.OrgID,
+		Name:         clt.Name,
+		Roles:        roles,
+		RedirectURIs: clt.RedirectURIs,
+		SecretHash:   clt.SecretHash,
+		Revoked:      clt.Revoked,
+		DateCreated:  clt.DateCreated.UTC(),
+		DateUpdated:  clt.DateUpdated.UTC(),
+	}
+}
+
+func toCoreClient(dbClt dbClient) user.Client {
+	roles := make([]user.Role, len(dbClt.Roles))
+	for i, value := range dbClt.Roles {
+		roles[i] = user.MustParseRole(value)
+	}
+
+	return user.Client{
+		ID:           dbClt.ID,
+		OrgID:        dbClt.OrgID,
+		Name:         dbClt.Name,
+		Roles:        roles,
+		RedirectURIs: dbClt.RedirectURIs,
+		SecretHash:   dbClt.SecretHash,
+		Revoked:      dbClt.Revoked,
+		DateCreated:  dbClt.DateCreated.In(time.Local),
+		DateUpdated:  dbClt.DateUpdated.In(time.Local),
+	}
+}
+
+func toCoreClientSlice(dbClients []dbClient) []user.Client {
+	clts := make([]user.Client, len(dbClients))
+	for i, dbClt := range dbClients {
+		clts[i] = toCoreClient(dbClt)
+	}
+	return clts
+}
+
+// dbIdentityLink represent the structure we need for moving identity links
+// between the app and the database.
+type dbIdentityLink struct {
+	Key         string    `json:"_key"`
+	Issuer      string    `json:"issuer"`
+	Subject     string    `json:"subject"`
+	OrgID       uuid.UUID `json:"org_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+func toDBIdentityLink(link user.IdentityLink) dbIdentityLink {
+	return dbIdentityLink{
+		Key:         identityKey(link.Issuer, link.Subject),
+		Issuer:      link.Issuer,
+		Subject:     link.Subject,
+		OrgID:       link.OrgID,
+		UserID:      link.UserID,
+		DateCreated: link.DateCreated.UTC(),
+	}
+}
+
+func toCoreIdentityLink(dbLink dbIdentityLink) user.IdentityLink {
+	return user.IdentityLink{
+		Issuer:      dbLink.Issuer,
+		Subject:     dbLink.Subject,
+		OrgID:       dbLink.OrgID,
+		UserID:      dbLink.UserID,
+		DateCreated: dbLink.DateCreated.In(time.Local),
+	}
+}
+
+// identityKey builds the document key for an identity link. Issuers are URLs,
+// which aren't valid keys, so the pair is hashed.
+func identityKey(issuer string, subject string) string {
+	sum := sha256.Sum256([]byte(issuer + " " + subject))
+	return hex.EncodeToString(sum[:])
+}
+
+// dbWebhook represent the structure we need for moving webhook data
+// between the app and the database.
+type dbWebhook struct {
+	ID          uuid.UUID `json:"_key"`
+	OrgID       uuid.UUID `json:"org_id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	Secret      string    `json:"secret"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+func toDBWebhook(wh user.Webhook) dbWebhook {
+	events := wh.Events
+	if events == nil {
+		events = []string{}
+	}
+
+	return dbWebhook{
+		ID:          wh.ID,
+		OrgID:       wh.OrgID,
+		URL:         wh.URL,
+		Events:      events,
+		Secret:      wh.Secret,
+		DateCreated: wh.DateCreated.UTC(),
+		DateUpdated: wh.DateUpdated.UTC(),
+	}
+}
+
+func toCoreWebhook(dbWh dbWebhook) user.Webhook {
+	return user.Webhook{
+		ID:          dbWh.ID,
+		OrgID:       dbWh.OrgID,
+		URL:         dbWh.URL,
+		Events:      dbWh.Events,
+		Secret:      dbWh.Secret,
+		DateCreated: dbWh.DateCreated.In(time.Local),
+		DateUpdated: dbWh.DateUpdated.In(time.Local),
+	}
+}
+
+func toCoreWebhookSlice(dbWebhooks []dbWebhook) []user.Webhook {
+	whs := make([]user.Webhook, len(dbWebhooks))
+	for i, dbWh := range dbWebhooks {
+		whs[i] = toCoreWebhook(dbWh)
+	}
+	return whs
+}
+
+// dbDelivery represent the structure we need for moving delivery data
+// between the app and the database.
+type dbDelivery struct {
+	ID             uuid.UUID       `json:"_key"`
+	OrgID          uuid.UUID       `json:"org_id"`
+	WebhookID      uuid.UUID       `json:"webhook_id"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+	SealedPayload  string          `json:"sealed_payload,omitempty"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code"`
+	LastError      string          `json:"last_error"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	DateCreated    time.Time       `json:"date_created"`
+	DateUpdated    time.Time       `json:"date_updated"`
+}
+
+func toDBDelivery(d user.Delivery) dbDelivery {
+	return dbDelivery{
+		ID:             d.ID,
+		OrgID:          d.OrgID,
+		WebhookID:      d.WebhookID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		NextAttemptAt:  d.NextAttemptAt.UTC(),
+		DateCreated:    d.DateCreated.UTC(),
+		DateUpdated:    d.DateUpdated.UTC(),
+	}
+}
+
+func toCoreDelivery(dbD dbDelivery) user.Delivery {
+	return user.Delivery{
+		ID:             dbD.ID,
+		OrgID:          dbD.OrgID,
+		WebhookID:      dbD.WebhookID,
+		EventID:        dbD.EventID,
+		EventType:      dbD.EventType,
+		Payload:        dbD.Payload,
+		Status:         dbD.Status,
+		Attempts:       dbD.Attempts,
+		LastStatusCode: dbD.LastStatusCode,
+		LastError:      dbD.LastError,
+		NextAttemptAt:  dbD.NextAttemptAt.In(time.Local),
+		DateCreated:    dbD.DateCreated.In(time.Local),
+		DateUpdated:    dbD.DateUpdated.In(time.Local),
+	}
+}
+
+// dbOutboxEvent represent the structure we need for moving events through
+// the outbox.
+type dbOutboxEvent struct {
+	Key string `json:"_key"`
+	// Event is nil when the store encrypts users: SealedEvent holds it then.
+	Event       *user.Event `json:"event,omitempty"`
+	SealedEvent string      `json:"sealed_event,omitempty"`
+	// Seq orders the outbox. Unlike date_created, it sorts correctly.
+	Seq         int64     `json:"seq"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	Dead        bool      `json:"dead"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+func toDBOutboxEvent(evt user.Event, now time.Time) dbOutboxEvent {
+	return dbOutboxEvent{
+		Key:         evt.ID.String(),
+		Event:       &evt,
+		Seq:         now.UnixNano(),
+		DateCreated: now.UTC(),
+	}
+}
+
+// dbLogin represent the structure we need for moving login history
+// between the app and the database.
+type dbLogin struct {
+	ID          uuid.UUID `json:"_key"`
+	OrgID       uuid.UUID `json:"org_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Success     bool      `json:"success"`
+	Method      string    `json:"method"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	Error       string    `json:"error,omitempty"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+func toDBLogin(l user.Login) dbLogin {
+	return dbLogin{
+		ID:          l.ID,
+		OrgID:       l.OrgID,
+		UserID:      l.UserID,
+		Success:     l.Success,
+		Method:      l.Method,
+		IP:          l.IP,
+		UserAgent:   l.UserAgent,
+		Error:       l.Error,
+		DateCreated: l.DateCreated.UTC(),
+	}
+}
+
+func toCoreLogin(dbL dbLogin) user.Login {
+	return user.Login{
+		ID:          dbL.ID,
+		OrgID:       dbL.OrgID,
+		UserID:      dbL.UserID,
+		Success:     dbL.Success,
+		Method:      dbL.Method,
+		IP:          dbL.IP,
+		UserAgent:   dbL.UserAgent,
+		Error:       dbL.Error,
+		DateCreated: dbL.DateCreated.In(time.Local),
+	}
+}
+
+func toCoreLoginSlice(dbLogins []dbLogin) []user.Login {
+	ls := make([]user.Login, len(dbLogins))
+	for i, dbL := range dbLogins {
+		ls[i] = toCoreLogin(dbL)
+	}
+	return ls
+}
+
+// dbJobRun represent the structure we need for moving job history
+// between the app and the database.
+type dbJobRun struct {
+	ID          uuid.UUID `json:"_key"`
+	Job         string    `json:"job"`
+	Owner       string    `json:"owner"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+func toDBJobRun(run user.JobRun) dbJobRun {
+	return dbJobRun{
+		ID:          run.ID,
+		Job:         run.Job,
+		Owner:       run.Owner,
+		ScheduledAt: run.ScheduledAt.UTC(),
+		Status:      run.Status,
+		Error:       run.Error,
+		StartedAt:   run.StartedAt.UTC(),
+		FinishedAt:  run.FinishedAt.UTC(),
+	}
+}
+
+func toCoreJobRun(dbRun dbJobRun) user.JobRun {
+	return user.JobRun{
+		ID:          dbRun.ID,
+		Job:         dbRun.Job,
+		Owner:       dbRun.Owner,
+		ScheduledAt: dbRun.ScheduledAt.In(time.Local),
+		Status:      dbRun.Status,
+		Error:       dbRun.Error,
+		StartedAt:   dbRun.StartedAt.In(time.Local),
+		FinishedAt:  dbRun.FinishedAt.In(time.Local),
+	}
+}
+
+func toCoreJobRunSlice(dbRuns []dbJobRun) []user.JobRun {
+	runs := make([]user.JobRun, len(dbRuns))
+	for i, dbRun := range dbRuns {
+		runs[i] = toCoreJobRun(dbRun)
+	}
+	return runs
+}
+
+// dbReport is a user below a manager in the org chart.
+type dbReport struct {
+	User      dbUser `json:"user"`
+	ManagerID string `json:"manager_id"`
+	Depth     int    `json:"depth"`
+}
+
+// dbToken represent the structure we need for moving tokens between the
+// app and the database.
+type dbToken struct {
+	Hash        string    `json:"_key"`
+	Purpose     string    `json:"purpose"`
+	OrgID       uuid.UUID `json:"org_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+func toDBToken(tkn user.Token) dbToken {
+	return dbToken{
+		Hash:        tkn.Hash,
+		Purpose:     tkn.Purpose,
+		OrgID:       tkn.OrgID,
+		UserID:      tkn.UserID,
+		ExpiresAt:   tkn.ExpiresAt.UTC(),
+		DateCreated: tkn.DateCreated.UTC(),
+	}
+}
+
+func toCoreToken(dbTkn dbToken) user.Token {
+	return user.Token{
+		Hash:        dbTkn.Hash,
+		Purpose:     dbTkn.Purpose,
+		OrgID:       dbTkn.OrgID,
+		UserID:      dbTkn.UserID,
+		ExpiresAt:   dbTkn.ExpiresAt.In(time.Local),
+		DateCreated: dbTkn.DateCreated.In(time.Local),
 	}
-	return usrs
 }
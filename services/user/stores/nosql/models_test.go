@@ -0,0 +1,278 @@
+package nosql
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/mail"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/stem/data/nosql/dbtest"
+	"github.com/google/uuid"
+)
+
+func Test_UserRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	t.Log("Given the need to store users without losing data.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen mapping random users to documents and back.", testID)
+		{
+			for i := 0; i < 1000; i++ {
+				usr := randomUser(r)
+				if err := checkRoundTrip(usr); err != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould read back the user : %s : %+v.", dbtest.Failed, testID, err, usr)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould read back every user as written.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_UpdateUserDocument(t *testing.T) {
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	name := "William"
+
+	t.Log("Given the need to merge updates into user documents.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen mapping an update to a document.", testID)
+		{
+			b, err := json.Marshal(toDBUpdateUser(user.UpdateUser{Name: &name}, now))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to encode the update : %s.", dbtest.Failed, testID, err)
+			}
+			var doc map[string]any
+			json.Unmarshal(b, &doc)
+			if len(doc) != 2 || doc["name"] != name || doc["date_updated"] != now.Format(time.RFC3339) {
+				t.Fatalf("\t%s\tTest %d:\tShould only write the set fields and the update date : got %s.", dbtest.Failed, testID, b)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only write the set fields and the update date.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_DeletedUserDocument(t *testing.T) {
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	usr := randomUser(rand.New(rand.NewSource(1)))
+
+	t.Log("Given the need to keep deleted users until they are purged.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen mapping a deleted user to a document.", testID)
+		{
+			b, err := json.Marshal(toDBDeletedUser(toDBUser(usr), now))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to encode the user : %s.", dbtest.Failed, testID, err)
+			}
+			var doc map[string]any
+			json.Unmarshal(b, &doc)
+			if doc["_key"] != usr.ID.String() {
+				t.Fatalf("\t%s\tTest %d:\tShould key the document by user id : got %v.", dbtest.Failed, testID, doc["_key"])
+			}
+			t.Logf("\t%s\tTest %d:\tShould key the document by user id.", dbtest.Success, testID)
+
+			if doc["date_deleted"] != now.Format(time.RFC3339) || doc["email"] != usr.Email.Address {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the user and when they were deleted : got %s.", dbtest.Failed, testID, b)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the user and when they were deleted.", dbtest.Success, testID)
+		}
+	}
+}
+
+func FuzzUserRoundTrip(f *testing.F) {
+	f.Add("Bill Kennedy", "bill@example.com", "Bill", "engineering", true, int64(1538352000), int64(0), []byte(`{"level":3}`))
+	f.Add("", "", "", "", false, int64(0), int64(0), []byte(`null`))
+	f.Add("Ünïcode", "a@b.c", "\"quoted\" <name>", "", true, int64(-1), int64(999999999), []byte(`{"a":{"b":[1,"x",true,null]}}`))
+	f.Add("empty", "e@example.com", "", "sales", false, int64(4102444800), int64(1), []byte(`{}`))
+
+	f.Fuzz(func(t *testing.T, name string, email string, emailName string, department string, enabled bool, sec int64, nsec int64, attrs []byte) {
+		var attributes map[string]any
+		if json.Unmarshal(attrs, &attributes) != nil {
+			t.Skip()
+		}
+
+		// Times outside years 0 to 9999 can't be encoded as JSON.
+		sec %= 253402300799
+		at := time.Unix(sec, nsec%int64(time.Second)).In(time.FixedZone("", int(sec%50400)))
+
+		usr := user.User{
+			ID:                uuid.New(),
+			OrgID:             uuid.New(),
+			Name:              name,
+			Email:             mail.Address{Name: emailName, Address: email},
+			Roles:             []user.Role{user.RoleUser},
+			PasswordHash:      []byte(name),
+			Department:        department,
+			Enabled:           enabled,
+			Attributes:        attributes,
+			PasswordChangedAt: at,
+			LastLoginAt:       at,
+			LastLoginIP:       department,
+			DateCreated:       at,
+			DateUpdated:       at,
+		}
+		if err := checkRoundTrip(usr); err != "" {
+			t.Fatalf("should read back the user : %s : %+v", err, usr)
+		}
+	})
+}
+
+// checkRoundTrip maps usr to a document and back, directly and through
+// JSON as the database does, and describes how the result differs.
+func checkRoundTrip(usr user.User) string {
+	want := inLocal(usr)
+
+	if got := toCoreUser(toDBUser(usr)); !reflect.DeepEqual(got, want) {
+		return "mapped: got " + describe(got)
+	}
+
+	// JSON replaces invalid UTF-8, so such strings can't be stored as is.
+	for _, s := range []string{usr.Name, usr.Email.Name, usr.Email.Address, usr.Department, usr.LastLoginIP} {
+		if !utf8.ValidString(s) {
+			return ""
+		}
+	}
+
+	data, err := json.Marshal(toDBUser(usr))
+	if err != nil {
+		return "encoding: " + err.Error()
+	}
+	var dbUsr dbUser
+	if err := json.Unmarshal(data, &dbUsr); err != nil {
+		return "decoding: " + err.Error()
+	}
+	if got := toCoreUser(dbUsr); !reflect.DeepEqual(got, want) {
+		return "stored: got " + describe(got)
+	}
+	return ""
+}
+
+// inLocal returns usr with their times in local time, as they are read
+// back.
+func inLocal(usr user.User) user.User {
+	usr.PasswordChangedAt = usr.PasswordChangedAt.In(time.Local)
+	usr.DateCreated = usr.DateCreated.In(time.Local)
+	usr.DateUpdated = usr.DateUpdated.In(time.Local)
+	if !usr.LastLoginAt.IsZero() {
+		usr.LastLoginAt = usr.LastLoginAt.In(time.Local)
+	}
+	return usr
+}
+
+func describe(usr user.User) string {
+	data, _ := json.Marshal(usr)
+	return string(data)
+}
+
+// randomUser generates a user with every field set at random, including
+// the nil, empty and zero values the mapping must keep apart.
+func randomUser(r *rand.Rand) user.User {
+	roles := []user.Role{user.RoleSuperAdmin, user.RoleAdmin, user.RoleUser}
+
+	var usrRoles []user.Role
+	if r.Intn(5) > 0 {
+		usrRoles = []user.Role{}
+		for i := r.Intn(4); i > 0; i-- {
+			usrRoles = append(usrRoles, roles[r.Intn(len(roles))])
+		}
+	}
+
+	var hash []byte
+	if r.Intn(5) > 0 {
+		hash = make([]byte, r.Intn(61))
+		r.Read(hash)
+	}
+
+	var lastLogin time.Time
+	if r.Intn(2) == 0 {
+		lastLogin = randomTime(r)
+	}
+
+	return user.User{
+		ID:                 uuid.New(),
+		OrgID:              uuid.New(),
+		Name:               randomString(r),
+		Email:              mail.Address{Name: randomString(r), Address: randomString(r) + "@example.com"},
+		Roles:              usrRoles,
+		PasswordHash:       hash,
+		Department:         randomString(r),
+		Enabled:            r.Intn(2) == 0,
+		Attributes:         randomAttributes(r, 3),
+		PasswordChangedAt:  randomTime(r),
+		MustChangePassword: r.Intn(2) == 0,
+		LastLoginAt:        lastLogin,
+		LastLoginIP:        randomString(r),
+		DateCreated:        randomTime(r),
+		DateUpdated:        randomTime(r),
+	}
+}
+
+// randomAttributes generates nil, empty or nested attributes holding the
+// types JSON decodes to.
+func randomAttributes(r *rand.Rand, depth int) map[string]any {
+	switch r.Intn(4) {
+	case 0:
+		return nil
+	case 1:
+		return map[string]any{}
+	}
+
+	attrs := make(map[string]any)
+	for i := r.Intn(5) + 1; i > 0; i-- {
+		attrs[randomString(r)] = randomValue(r, depth)
+	}
+	return attrs
+}
+
+func randomValue(r *rand.Rand, depth int) any {
+	n := 5
+	if depth > 0 {
+		n = 7
+	}
+	switch r.Intn(n) {
+	case 0:
+		return nil
+	case 1:
+		return r.NormFloat64() * 1e6
+	case 2:
+		return float64(r.Intn(1000))
+	case 3:
+		return randomString(r)
+	case 4:
+		return r.Intn(2) == 0
+	case 5:
+		vs := make([]any, r.Intn(4))
+		for i := range vs {
+			vs[i] = randomValue(r, depth-1)
+		}
+		return vs
+	default:
+		m := make(map[string]any)
+		for i := r.Intn(4); i > 0; i-- {
+			m[randomString(r)] = randomValue(r, depth-1)
+		}
+		return m
+	}
+}
+
+func randomString(r *rand.Rand) string {
+	const chars = "abcdefghijklmnopqrstuvwxyz ABC-_.'\"<>@éü世界"
+	runes := []rune(chars)
+	s := make([]rune, r.Intn(12))
+	for i := range s {
+		s[i] = runes[r.Intn(len(runes))]
+	}
+	return string(s)
+}
+
+// randomTime generates a time with nanoseconds in a random location.
+func randomTime(r *rand.Rand) time.Time {
+	at := time.Unix(r.Int63n(4102444800), r.Int63n(int64(time.Second)))
+	offset := (r.Intn(27) - 12) * 3600
+	return at.In(time.FixedZone("", offset))
+}
@@ -0,0 +1,124 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const orgCollectionName = "organizations"
+
+var (
+	ErrOrgNotFound = errors.New("organization not found")
+	ErrOrgNotEmpty = errors.New("organization still has users")
+)
+
+// OrgStore manages the organizations collection. Organizations are the
+// tenants themselves, so unlike the other stores its queries are not
+// tenant-filtered.
+type OrgStore struct {
+	db  driver.Database
+	col driver.Collection
+	log *zap.SugaredLogger
+}
+
+// NewOrgStore constructs the api for organization data access.
+func NewOrgStore(log *zap.SugaredLogger, db driver.Database) *OrgStore {
+	col, err := db.Collection(context.Background(), orgCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &OrgStore{
+		log: log,
+		db:  db,
+		col: col,
+	}
+}
+
+// Create inserts a new organization into the database.
+func (s *OrgStore) Create(ctx context.Context, org user.Organization) (user.Organization, error) {
+	var result dbOrganization
+	ctx = driver.WithReturnNew(ctx, &result)
+	_, err := s.col.CreateDocument(ctx, toDBOrganization(org))
+	return toCoreOrganization(result), err
+}
+
+// Update updates an organization by data.
+func (s *OrgStore) Update(ctx context.Context, id string, uo user.UpdateOrganization, now time.Time) (user.Organization, error) {
+	var result dbOrganization
+	ctx = driver.WithReturnNew(ctx, &result)
+	upd := dbUpdateOrganization{
+		Name:        uo.Name,
+		DateUpdated: now.UTC(),
+	}
+	_, err := s.col.UpdateDocument(ctx, id, upd)
+	if driver.IsNotFound(err) {
+		return user.Organization{}, ErrOrgNotFound
+	}
+	return toCoreOrganization(result), err
+}
+
+// Delete deletes an organization. Organizations that still have users can't
+// be deleted.
+func (s *OrgStore) Delete(ctx context.Context, id string) (user.Organization, error) {
+	query := `RETURN LENGTH(FOR u IN @@users FILTER u.org_id == @org_id LIMIT 1 RETURN 1)`
+
+	bindvars := map[string]interface{}{
+		"@users": collectionName,
+		"org_id": id,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return user.Organization{}, err
+	}
+	defer c.Close()
+
+	var users int
+	if _, err := c.ReadDocument(ctx, &users); err != nil {
+		return user.Organization{}, err
+	}
+	if users > 0 {
+		return user.Organization{}, ErrOrgNotEmpty
+	}
+
+	var result dbOrganization
+	ctx = driver.WithReturnOld(ctx, &result)
+	_, err = s.col.RemoveDocument(ctx, id)
+	if driver.IsNotFound(err) {
+		return user.Organization{}, ErrOrgNotFound
+	}
+	return toCoreOrganization(result), err
+}
+
+// QueryByID queries an organization by id.
+func (s *OrgStore) QueryByID(ctx context.Context, id string) (user.Organization, error) {
+	var result dbOrganization
+	_, err := s.col.ReadDocument(ctx, id, &result)
+	if driver.IsNotFound(err) {
+		return user.Organization{}, ErrOrgNotFound
+	}
+	return toCoreOrganization(result), err
+}
+
+// Query retrieves all organizations ordered by name.
+func (s *OrgStore) Query(ctx context.Context) ([]user.Organization, error) {
+	query := `FOR o IN @@coll
+	SORT o.name
+	RETURN o`
+
+	bindvars := map[string]interface{}{
+		"@coll": orgCollectionName,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	orgs, err := readAll[dbOrganization](ctx, c)
+	return toCoreOrganizationSlice(orgs), err
+}
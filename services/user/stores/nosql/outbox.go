@@ -0,0 +1,199 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const outboxCollectionName = "outbox"
+
+// withEvent runs change and, when the store has an outbox, writes the event
+// it returns to the outbox. Both run in one stream transaction, so either
+// the change and its event are stored or neither is.
+func (s *Store) withEvent(ctx context.Context, change func(ctx context.Context) (user.Event, error)) error {
+	if s.outbox == nil {
+		_, err := change(ctx)
+		return err
+	}
+
+	cols := driver.TransactionCollections{Write: []string{collectionName, deletedUserCollectionName, membershipCollectionName, reportingCollectionName, outboxCollectionName}}
+	tid, err := s.db.BeginTransaction(ctx, cols, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	tctx := driver.WithTransactionID(ctx, tid)
+
+	evt, err := change(tctx)
+	if err == nil {
+		err = s.writeEvent(tctx, evt)
+	}
+	if err != nil {
+		if aerr := s.db.AbortTransaction(ctx, tid, nil); aerr != nil {
+			s.log.Errorw("aborting transaction", "error", aerr)
+		}
+		return err
+	}
+
+	if err := s.db.CommitTransaction(ctx, tid, nil); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// writeEvent adds the event to the outbox, encrypted when the store
+// encrypts users, since the event carries the user.
+func (s *Store) writeEvent(ctx context.Context, evt user.Event) error {
+	doc := toDBOutboxEvent(evt, time.Now())
+	if s.cipher != nil {
+		if err := s.cipher.sealEvent(&doc); err != nil {
+			return err
+		}
+	}
+	_, err := s.outbox.CreateDocument(ctx, doc)
+	return err
+}
+
+// OutboxRelay publishes the events in the outbox to its sinks, oldest
+// first. An event is removed from the outbox only once every sink has
+// accepted it, so events are delivered at least once: a relay that dies
+// part way through publishing an event publishes it again when restarted,
+// and sinks may see duplicates, which they can detect by event id. An event
+// that still fails after MaxAttempts is marked dead and left in the outbox,
+// so it stops holding back the events behind it.
+type OutboxRelay struct {
+	log   *zap.SugaredLogger
+	db    driver.Database
+	col   driver.Collection
+	sinks []user.EventPublisher
+
+	// BatchSize is the number of events read from the outbox at a time.
+	BatchSize int
+	// MaxAttempts is the number of times an event is published before it
+	// is given up on.
+	MaxAttempts int
+	// Cipher decrypts the events written by a Store with encryption.
+	Cipher *Cipher
+}
+
+// NewOutboxRelay constructs an OutboxRelay publishing to sinks.
+func NewOutboxRelay(log *zap.SugaredLogger, db driver.Database, sinks ...user.EventPublisher) *OutboxRelay {
+	col, err := db.Collection(context.Background(), outboxCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &OutboxRelay{
+		log:         log,
+		db:          db,
+		col:         col,
+		sinks:       sinks,
+		BatchSize:   100,
+		MaxAttempts: 10,
+	}
+}
+
+// Run relays events every interval until ctx is canceled.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while there is a backlog.
+		for {
+			n, err := r.Relay(ctx)
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					r.log.Errorw("relaying outbox", "error", err)
+				}
+				break
+			}
+			if n < r.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay publishes a batch of events and returns the number published. It
+// stops at the first event a sink rejects, so events are published in
+// order, and records the failure on the event. An event that has run out of
+// attempts is marked dead instead, and the batch goes on without it.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	query := `FOR e IN @@coll
+	FILTER e.dead != true
+	SORT e.seq, e._key
+	LIMIT @rows
+	RETURN e`
+
+	bindvars := map[string]interface{}{
+		"@coll": outboxCollectionName,
+		"rows":  r.BatchSize,
+	}
+
+	c, err := r.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return 0, err
+	}
+	evts, err := readAll[dbOutboxEvent](ctx, c)
+	if err != nil {
+		return 0, err
+	}
+
+	var published int
+	for _, evt := range evts {
+		if err := r.relay(ctx, evt); err != nil {
+			dead := evt.Attempts+1 >= r.MaxAttempts
+			upd := map[string]interface{}{
+				"attempts":   evt.Attempts + 1,
+				"last_error": err.Error(),
+				"dead":       dead,
+			}
+			if _, uerr := r.col.UpdateDocument(ctx, evt.Key, upd); uerr != nil {
+				r.log.Errorw("recording outbox failure", "event_id", evt.Key, "error", uerr)
+				return published, fmt.Errorf("publishing event[%s]: %w", evt.Key, err)
+			}
+			if !dead {
+				return published, fmt.Errorf("publishing event[%s]: %w", evt.Key, err)
+			}
+			r.log.Errorw("giving up on outbox event", "event_id", evt.Key, "attempts", evt.Attempts+1, "error", err)
+			continue
+		}
+
+		// Another relay may have published the event too.
+		if _, err := r.col.RemoveDocument(ctx, evt.Key); err != nil && !driver.IsNotFound(err) {
+			return published, fmt.Errorf("removing event[%s]: %w", evt.Key, err)
+		}
+		published++
+	}
+	return published, nil
+}
+
+// relay opens the event of the outbox document and publishes it.
+func (r *OutboxRelay) relay(ctx context.Context, dbEvt dbOutboxEvent) error {
+	evt, err := openEvent(ctx, r.Cipher, dbEvt)
+	if err != nil {
+		return err
+	}
+	return r.publish(ctx, evt)
+}
+
+// publish publishes evt to every sink.
+func (r *OutboxRelay) publish(ctx context.Context, evt user.Event) error {
+	for _, sink := range r.sinks {
+		if err := sink.Publish(ctx, evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+)
+
+const (
+	searchViewName = "users_search"
+	// searchAnalyzer splits text into lower case words without accents, so
+	// searches match regardless of case and diacritics.
+	searchAnalyzer = "user_text"
+)
+
+// ensureSearchView creates the analyzer and the ArangoSearch view over the
+// users collection when they don't exist yet. It is the create_search_view
+// migration.
+func ensureSearchView(ctx context.Context, db driver.Database) error {
+	accent, stemming := false, false
+	_, _, err := db.EnsureAnalyzer(ctx, driver.ArangoSearchAnalyzerDefinition{
+		Name: searchAnalyzer,
+		Type: driver.ArangoSearchAnalyzerTypeText,
+		Properties: driver.ArangoSearchAnalyzerProperties{
+			Locale:    "en",
+			Case:      driver.ArangoSearchCaseLower,
+			Accent:    &accent,
+			Stemming:  &stemming,
+			Stopwords: []string{},
+		},
+		Features: []driver.ArangoSearchAnalyzerFeature{
+			driver.ArangoSearchAnalyzerFeatureFrequency,
+			driver.ArangoSearchAnalyzerFeatureNorm,
+			driver.ArangoSearchAnalyzerFeaturePosition,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("ensuring analyzer %s: %w", searchAnalyzer, err)
+	}
+
+	// Departments are referenced by ID; their names are matched through
+	// the departments collection.
+	text := driver.ArangoSearchElementProperties{Analyzers: []string{searchAnalyzer}}
+	identity := driver.ArangoSearchElementProperties{Analyzers: []string{"identity"}}
+	props := driver.ArangoSearchViewProperties{
+		Links: driver.ArangoSearchLinks{
+			collectionName: driver.ArangoSearchElementProperties{
+				Fields: driver.ArangoSearchFields{
+					"org_id":     identity,
+					"name":       text,
+					"email":      text,
+					"department": identity,
+				},
+			},
+		},
+	}
+
+	exists, err := db.ViewExists(ctx, searchViewName)
+	if err != nil {
+		return fmt.Errorf("checking view %s: %w", searchViewName, err)
+	}
+	if !exists {
+		_, err = db.CreateArangoSearchView(ctx, searchViewName, &props)
+		// Another instance may have created the view since we checked.
+		if err != nil && !driver.IsConflict(err) {
+			return fmt.Errorf("creating view %s: %w", searchViewName, err)
+		}
+		return nil
+	}
+
+	// Bring views created by older versions up to date.
+	v, err := db.View(ctx, searchViewName)
+	if err != nil {
+		return fmt.Errorf("opening view %s: %w", searchViewName, err)
+	}
+	view, err := v.ArangoSearchView()
+	if err != nil {
+		return fmt.Errorf("opening view %s: %w", searchViewName, err)
+	}
+	if err := view.SetProperties(ctx, props); err != nil {
+		return fmt.Errorf("updating view %s: %w", searchViewName, err)
+	}
+	return nil
+}
+
+// Search finds the users in the organization whose name, email or
+// department name match every term, ranked by BM25. Exact words rank above
+// prefixes and misspellings.
+func (s *Store) Search(ctx context.Context, orgID string, terms []string, limit int) ([]user.SearchResult, error) {
+	if s.cipher != nil {
+		return nil, errors.New("search is not available on encrypted users")
+	}
+
+	bindvars := map[string]interface{}{
+		"@departments": departmentCollectionName,
+		"org_id":       orgID,
+		"limit":        limit,
+	}
+
+	var depts strings.Builder
+	clauses := make([]string, len(terms))
+	for i, term := range terms {
+		t := fmt.Sprintf("t%d", i)
+		d := user.Fuzziness(term)
+		bindvars[t] = term
+
+		// The departments whose name has a word matching the term.
+		fmt.Fprintf(&depts, `LET depts_%[1]s = (FOR d IN @@departments
+	FILTER d.org_id == @org_id
+	FOR w IN TOKENS(d.name, %[2]q)
+	FILTER STARTS_WITH(w, @%[1]s) OR LEVENSHTEIN_DISTANCE(w, @%[1]s) <= %[3]d
+	RETURN DISTINCT d._key)
+`, t, searchAnalyzer, d)
+
+		var matches []string
+		for _, field := range []string{"u.name", "u.email"} {
+			matches = append(matches,
+				fmt.Sprintf("BOOST(%s == @%s, 2)", field, t),
+				fmt.Sprintf("STARTS_WITH(%s, @%s)", field, t),
+			)
+			if d > 0 {
+				matches = append(matches, fmt.Sprintf("LEVENSHTEIN_MATCH(%s, @%s, %d, false)", field, t, d))
+			}
+		}
+		matches = append(matches, fmt.Sprintf(`ANALYZER(u.department IN depts_%s, "identity")`, t))
+		clauses[i] = "(" + strings.Join(matches, " OR ") + ")"
+	}
+
+	query := fmt.Sprintf(`%sFOR u IN %s
+	SEARCH u.org_id == @org_id AND ANALYZER(%s, %q)
+	LET score = BM25(u)
+	SORT score DESC, u.name
+	LIMIT @limit
+	RETURN { user: u, score: score }`, depts.String(), searchViewName, strings.Join(clauses, " AND "), searchAnalyzer)
+
+	cursor, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	hits, err := readAll[dbSearchHit](ctx, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]user.SearchResult, len(hits))
+	for i, hit := range hits {
+		results[i] = user.SearchResult{User: toCoreUser(hit.User), Score: hit.Score}
+	}
+	return results, nil
+}
@@ -0,0 +1,92 @@
+package nosql
+
+import (
+	"context"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const tokenCollectionName = "tokens"
+
+// TokenStore manages the single use tokens issued to users. Tokens are
+// keyed by their hash.
+type TokenStore struct {
+	db  driver.Database
+	col driver.Collection
+	log *zap.SugaredLogger
+}
+
+// NewTokenStore constructs the api for token data access.
+func NewTokenStore(log *zap.SugaredLogger, db driver.Database) *TokenStore {
+	col, err := db.Collection(context.Background(), tokenCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection, has the database been migrated: %s", err)
+	}
+	return &TokenStore{
+		log: log,
+		db:  db,
+		col: col,
+	}
+}
+
+// Create inserts a new token into the database.
+func (s *TokenStore) Create(ctx context.Context, tkn user.Token) error {
+	_, err := s.col.CreateDocument(ctx, toDBToken(tkn))
+	return err
+}
+
+// Consume removes and returns the unexpired token with the hash. The token
+// is checked and removed by one query, so it can only be used once.
+func (s *TokenStore) Consume(ctx context.Context, purpose string, hash string, now time.Time) (user.Token, error) {
+	query := `FOR t IN @@coll
+	FILTER t._key == @hash AND t.purpose == @purpose
+	FILTER DATE_TIMESTAMP(t.expires_at) > DATE_TIMESTAMP(@now)
+	REMOVE t IN @@coll
+	RETURN OLD`
+
+	bindvars := map[string]interface{}{
+		"@coll":   tokenCollectionName,
+		"hash":    hash,
+		"purpose": purpose,
+		"now":     now.UTC(),
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return user.Token{}, err
+	}
+	tkns, err := readAll[dbToken](ctx, c)
+	if err != nil {
+		return user.Token{}, err
+	}
+	if len(tkns) == 0 {
+		return user.Token{}, user.ErrTokenInvalid
+	}
+	return toCoreToken(tkns[0]), nil
+}
+
+// DeleteExpired removes up to limit tokens that expired before before and
+// returns how many it removed.
+func (s *TokenStore) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	query := `FOR t IN @@coll
+	FILTER DATE_TIMESTAMP(t.expires_at) < DATE_TIMESTAMP(@before)
+	LIMIT @rows
+	REMOVE t IN @@coll
+	RETURN 1`
+
+	bindvars := map[string]interface{}{
+		"@coll":  tokenCollectionName,
+		"before": before.UTC(),
+		"rows":   limit,
+	}
+
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := readAll[int](ctx, c)
+	return len(removed), err
+}
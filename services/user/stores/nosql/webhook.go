@@ -0,0 +1,244 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+const (
+	webhookCollectionName  = "webhooks"
+	deliveryCollectionName = "webhook_deliveries"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+)
+
+// WebhookStore manages the webhooks and their delivery log.
+type WebhookStore struct {
+	userReader
+	db         driver.Database
+	col        driver.Collection
+	deliveries driver.Collection
+	log        *zap.SugaredLogger
+}
+
+// NewWebhookStore constructs the api for webhook data access. With
+// WithCipher, the payloads of deliveries are encrypted, since they carry
+// the users the events are about.
+func NewWebhookStore(log *zap.SugaredLogger, db driver.Database, opts ...ReadOption) *WebhookStore {
+	col, err := db.Collection(context.Background(), webhookCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	deliveries, err := db.Collection(context.Background(), deliveryCollectionName)
+	if err != nil {
+		log.Panicf("error accessing collection: %s", err)
+	}
+	return &WebhookStore{
+		userReader: newUserReader(opts),
+		log:        log,
+		db:         db,
+		col:        col,
+		deliveries: deliveries,
+	}
+}
+
+// Create inserts a new webhook into the database.
+func (s *WebhookStore) Create(ctx context.Context, wh user.Webhook) (user.Webhook, error) {
+	var result dbWebhook
+	ctx = driver.WithReturnNew(ctx, &result)
+	_, err := s.col.CreateDocument(ctx, toDBWebhook(wh))
+	return toCoreWebhook(result), err
+}
+
+// Delete removes a webhook of the organization. Its deliveries are kept
+// in the log.
+func (s *WebhookStore) Delete(ctx context.Context, orgID string, id string) (user.Webhook, error) {
+	query := `FOR w IN @@coll
+	FILTER w._key == @id AND w.org_id == @org_id
+	REMOVE w IN @@coll
+	RETURN OLD`
+
+	bindvars := map[string]interface{}{
+		"@coll":  webhookCollectionName,
+		"id":     id,
+		"org_id": orgID,
+	}
+	return s.queryOne(ctx, query, bindvars)
+}
+
+// Query retrieves the webhooks of an organization ordered by url.
+func (s *WebhookStore) Query(ctx context.Context, orgID string) ([]user.Webhook, error) {
+	query := `FOR w IN @@coll
+	FILTER w.org_id == @org_id
+	SORT w.url
+	RETURN w`
+
+	bindvars := map[string]interface{}{
+		"@coll":  webhookCollectionName,
+		"org_id": orgID,
+	}
+	return s.queryAll(ctx, query, bindvars)
+}
+
+// QueryByEvent retrieves the webhooks of an organization subscribed to
+// eventType, either explicitly or by subscribing to every event.
+func (s *WebhookStore) QueryByEvent(ctx context.Context, orgID string, eventType string) ([]user.Webhook, error) {
+	query := `FOR w IN @@coll
+	FILTER w.org_id == @org_id
+	FILTER LENGTH(w.events) == 0 OR @event IN w.events
+	RETURN w`
+
+	bindvars := map[string]interface{}{
+		"@coll":  webhookCollectionName,
+		"org_id": orgID,
+		"event":  eventType,
+	}
+	return s.queryAll(ctx, query, bindvars)
+}
+
+// QueryByID gets the specified webhook of the organization.
+func (s *WebhookStore) QueryByID(ctx context.Context, orgID string, id string) (user.Webhook, error) {
+	query := `FOR w IN @@coll
+	FILTER w._key == @id AND w.org_id == @org_id
+	RETURN w`
+
+	bindvars := map[string]interface{}{
+		"@coll":  webhookCollectionName,
+		"id":     id,
+		"org_id": orgID,
+	}
+	return s.queryOne(ctx, query, bindvars)
+}
+
+// SaveDelivery inserts a delivery or replaces it with its latest attempt.
+func (s *WebhookStore) SaveDelivery(ctx context.Context, d user.Delivery) (user.Delivery, error) {
+	query := `UPSERT { _key: @key }
+	INSERT @doc
+	REPLACE @doc IN @@coll
+	RETURN NEW`
+
+	doc := toDBDelivery(d)
+	if s.cipher != nil {
+		if err := s.cipher.sealDelivery(&doc); err != nil {
+			return user.Delivery{}, err
+		}
+	}
+
+	bindvars := map[string]interface{}{
+		"@coll": deliveryCollectionName,
+		"key":   d.ID.String(),
+		"doc":   doc,
+	}
+	return s.queryOneDelivery(ctx, query, bindvars)
+}
+
+// QueryDeliveryByID gets the specified delivery of the organization.
+func (s *WebhookStore) QueryDeliveryByID(ctx context.Context, orgID string, id string) (user.Delivery, error) {
+	query := `FOR d IN @@coll
+	FILTER d._key == @id AND d.org_id == @org_id
+	RETURN d`
+
+	bindvars := map[string]interface{}{
+		"@coll":  deliveryCollectionName,
+		"id":     id,
+		"org_id": orgID,
+	}
+	return s.queryOneDelivery(ctx, query, bindvars)
+}
+
+// QueryDeliveries retrieves the delivery log of an organization, newest
+// first.
+func (s *WebhookStore) QueryDeliveries(ctx context.Context, orgID string, filter user.DeliveryFilter, pageNumber int, rowsPerPage int) ([]user.Delivery, error) {
+	bindvars := map[string]interface{}{
+		"@coll":  deliveryCollectionName,
+		"org_id": orgID,
+		"offset": (pageNumber - 1) * rowsPerPage,
+		"rows":   rowsPerPage,
+	}
+
+	var buf strings.Builder
+	buf.WriteString(`FOR d IN @@coll
+	FILTER d.org_id == @org_id`)
+
+	if filter.WebhookID != nil {
+		buf.WriteString(`
+	FILTER d.webhook_id == @webhook_id`)
+		bindvars["webhook_id"] = *filter.WebhookID
+	}
+	if filter.Status != nil {
+		buf.WriteString(`
+	FILTER d.status == @status`)
+		bindvars["status"] = *filter.Status
+	}
+
+	buf.WriteString(`
+	SORT d.date_created DESC
+	LIMIT @offset, @rows
+	RETURN d`)
+
+	c, err := s.db.Query(ctx, buf.String(), bindvars)
+	if err != nil {
+		return nil, err
+	}
+	dbDs, err := readAll[dbDelivery](ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	ds := make([]user.Delivery, len(dbDs))
+	for i, dbD := range dbDs {
+		if ds[i], err = openDelivery(ctx, s.cipher, dbD); err != nil {
+			return nil, err
+		}
+	}
+	return ds, nil
+}
+
+func (s *WebhookStore) queryAll(ctx context.Context, query string, bindvars map[string]interface{}) ([]user.Webhook, error) {
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return nil, err
+	}
+	whs, err := readAll[dbWebhook](ctx, c)
+	return toCoreWebhookSlice(whs), err
+}
+
+func (s *WebhookStore) queryOne(ctx context.Context, query string, bindvars map[string]interface{}) (user.Webhook, error) {
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return user.Webhook{}, err
+	}
+	defer c.Close()
+
+	var result dbWebhook
+	_, err = c.ReadDocument(ctx, &result)
+	if driver.IsNoMoreDocuments(err) {
+		return user.Webhook{}, ErrWebhookNotFound
+	}
+	return toCoreWebhook(result), err
+}
+
+func (s *WebhookStore) queryOneDelivery(ctx context.Context, query string, bindvars map[string]interface{}) (user.Delivery, error) {
+	c, err := s.db.Query(ctx, query, bindvars)
+	if err != nil {
+		return user.Delivery{}, err
+	}
+	defer c.Close()
+
+	var result dbDelivery
+	_, err = c.ReadDocument(ctx, &result)
+	if driver.IsNoMoreDocuments(err) {
+		return user.Delivery{}, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return user.Delivery{}, err
+	}
+	return openDelivery(ctx, s.cipher, result)
+}
@@ -0,0 +1,329 @@
+// Package retry decorates a user.Storer so transient database failures
+// are retried with jittered backoff, and a circuit breaker fails calls
+// fast while the database is down.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/mail"
+	"sync"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+)
+
+// ErrUnavailable is returned when the database kept failing or the circuit
+// is open. When it wraps the last failure, errors.Is matches both.
+var ErrUnavailable = errors.New("database unavailable")
+
+// unavailableError is ErrUnavailable wrapping the last failure. Its message
+// is only ErrUnavailable's, so the driver's error doesn't reach callers of
+// the service; errors.As and errors.Unwrap still find it for logging.
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string   { return ErrUnavailable.Error() }
+func (e *unavailableError) Unwrap() []error { return []error{ErrUnavailable, e.err} }
+
+// Config configures retries and the circuit breaker.
+type Config struct {
+	// Attempts is the number of times a call is tried.
+	Attempts int
+	// MinBackoff is the delay before the first retry. It doubles with
+	// every retry up to MaxBackoff, and up to half of it is random.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Threshold is the number of transient failures in a row that opens
+	// the circuit. Zero leaves the circuit closed.
+	Threshold int
+	// Cooldown is how long the circuit stays open before one call is let
+	// through to try the database again.
+	Cooldown time.Duration
+}
+
+// DefaultConfig retries for about a second before giving up.
+var DefaultConfig = Config{
+	Attempts:   4,
+	MinBackoff: 50 * time.Millisecond,
+	MaxBackoff: time.Second,
+	Threshold:  5,
+	Cooldown:   5 * time.Second,
+}
+
+// Do calls fn until it succeeds, fails with an error transient doesn't
+// accept, or has been tried cfg.Attempts times. It waits between calls,
+// and gives up early rather than wait past the deadline of ctx.
+func Do(ctx context.Context, cfg Config, transient func(error) bool, fn func(ctx context.Context) error) error {
+	return do(ctx, cfg, transient, nil, fn)
+}
+
+func do(ctx context.Context, cfg Config, transient func(error) bool, b *breaker, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		trial, ok := b.allow()
+		if !ok {
+			if err == nil {
+				return ErrUnavailable
+			}
+			break
+		}
+
+		err = fn(ctx)
+		failed := err != nil && transient(err)
+		b.record(trial, failed)
+		if !failed {
+			return err
+		}
+
+		if attempt+1 >= cfg.Attempts {
+			break
+		}
+		d := backoff(cfg, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			break
+		}
+		if wait(ctx, d) != nil {
+			break
+		}
+	}
+	return &unavailableError{err: err}
+}
+
+// backoff returns the delay before the retry after attempt.
+func backoff(cfg Config, attempt int) time.Duration {
+	d := cfg.MaxBackoff
+	if attempt < 32 && cfg.MinBackoff<<attempt < d {
+		d = cfg.MinBackoff << attempt
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// wait waits for d, or until ctx is done.
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// breaker opens after threshold transient failures in a row. Once the
+// cooldown has passed it lets one trial call through, which closes it
+// again if it succeeds. A nil breaker is always closed.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// allow reports whether a call may go ahead, and whether it is the trial
+// call of an open circuit.
+func (b *breaker) allow() (trial bool, ok bool) {
+	if b == nil || b.threshold <= 0 {
+		return false, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return false, true
+	}
+	if b.trial || b.now().Before(b.openUntil) {
+		return false, false
+	}
+	b.trial = true
+	return true, true
+}
+
+// record records the outcome of a call allow let through.
+func (b *breaker) record(trial bool, failed bool) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trial = false
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// Store is a user.Storer that retries the calls to the decorated store
+// that fail with transient errors.
+type Store struct {
+	user.Storer
+	log       *zap.SugaredLogger
+	cfg       Config
+	transient func(error) bool
+	breaker   *breaker
+}
+
+// NewStore decorates storer with retries and a circuit breaker. transient
+// reports which of its errors are worth retrying. The failure that made a
+// call give up is logged to log, since callers only see ErrUnavailable.
+func NewStore(log *zap.SugaredLogger, storer user.Storer, transient func(error) bool, cfg Config) *Store {
+	return &Store{
+		Storer:    storer,
+		log:       log,
+		cfg:       cfg,
+		transient: transient,
+		breaker: &breaker{
+			threshold: cfg.Threshold,
+			cooldown:  cfg.Cooldown,
+			now:       time.Now,
+		},
+	}
+}
+
+// Open reports whether the circuit is open, so calls fail fast.
+func (s *Store) Open() bool {
+	s.breaker.mu.Lock()
+	defer s.breaker.mu.Unlock()
+
+	return s.breaker.threshold > 0 && s.breaker.failures >= s.breaker.threshold
+}
+
+// Create creates a user.
+func (s *Store) Create(ctx context.Context, usr user.User) (result user.User, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		result, err = s.Storer.Create(ctx, usr)
+		return err
+	})
+	return result, err
+}
+
+// Delete deletes a user.
+func (s *Store) Delete(ctx context.Context, orgID string, email mail.Address) (usr user.User, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		usr, err = s.Storer.Delete(ctx, orgID, email)
+		return err
+	})
+	return usr, err
+}
+
+// QueryByID queries a user by id.
+func (s *Store) QueryByID(ctx context.Context, orgID string, id string) (usr user.User, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		usr, err = s.Storer.QueryByID(ctx, orgID, id)
+		return err
+	})
+	return usr, err
+}
+
+// QueryByEmail queries a user by email.
+func (s *Store) QueryByEmail(ctx context.Context, orgID string, email string) (usr user.User, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		usr, err = s.Storer.QueryByEmail(ctx, orgID, email)
+		return err
+	})
+	return usr, err
+}
+
+// Update updates a user.
+func (s *Store) Update(ctx context.Context, orgID string, uu user.UpdateUser, now time.Time) (usr user.User, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		usr, err = s.Storer.Update(ctx, orgID, uu, now)
+		return err
+	})
+	return usr, err
+}
+
+// Query retrieves a page of users.
+func (s *Store) Query(ctx context.Context, orgID string, filter user.QueryFilter, orderBy user.OrderBy, pageNumber int, rowsPerPage int) (usrs []user.User, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		usrs, err = s.Storer.Query(ctx, orgID, filter, orderBy, pageNumber, rowsPerPage)
+		return err
+	})
+	return usrs, err
+}
+
+// Authenticate checks the password of a user.
+func (s *Store) Authenticate(ctx context.Context, orgID string, email string, password string) (usr user.User, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		usr, err = s.Storer.Authenticate(ctx, orgID, email, password)
+		return err
+	})
+	return usr, err
+}
+
+// UpdateLastLogin records when and where a user last signed in.
+func (s *Store) UpdateLastLogin(ctx context.Context, orgID string, email string, at time.Time, ip string) error {
+	return s.do(ctx, func(ctx context.Context) error {
+		return s.Storer.UpdateLastLogin(ctx, orgID, email, at, ip)
+	})
+}
+
+// UpdatePassword replaces the password hash of a user.
+func (s *Store) UpdatePassword(ctx context.Context, orgID string, email string, hash []byte, now time.Time) (usr user.User, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		usr, err = s.Storer.UpdatePassword(ctx, orgID, email, hash, now)
+		return err
+	})
+	return usr, err
+}
+
+// QueryDormant queries dormant users from the decorated store, so other
+// decorators can reach it through this one.
+func (s *Store) QueryDormant(ctx context.Context, before time.Time, limit int) (usrs []user.User, err error) {
+	if _, ok := s.Storer.(user.DormantQuerier); !ok {
+		return nil, user.ErrUnsupported
+	}
+
+	err = s.do(ctx, func(ctx context.Context) error {
+		usrs, err = user.QueryDormant(ctx, s.Storer, before, limit)
+		return err
+	})
+	return usrs, err
+}
+
+// PurgeDeleted purges deleted users from the decorated store, so other
+// decorators can reach it through this one.
+func (s *Store) PurgeDeleted(ctx context.Context, before time.Time, limit int) (n int, err error) {
+	if _, ok := s.Storer.(user.DeletedPurger); !ok {
+		return 0, user.ErrUnsupported
+	}
+
+	err = s.do(ctx, func(ctx context.Context) error {
+		n, err = user.PurgeDeleted(ctx, s.Storer, before, limit)
+		return err
+	})
+	return n, err
+}
+
+// WritesOutbox implements user.OutboxStorer for the decorated store.
+func (s *Store) WritesOutbox() bool {
+	return user.WritesOutbox(s.Storer)
+}
+
+func (s *Store) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	err := do(ctx, s.cfg, s.transient, s.breaker, fn)
+	var ue *unavailableError
+	if errors.As(err, &ue) {
+		s.log.Warnw("giving up on the database", "error", ue.err)
+	}
+	return err
+}
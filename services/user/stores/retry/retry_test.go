@@ -0,0 +1,146 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/services/user/storertest"
+	"github.com/gitamped/bud/services/user/stores/memory"
+	"github.com/gitamped/stem/data/nosql/dbtest"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var errTransient = errors.New("connection refused")
+
+func isTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+// flakyStore fails the next failures calls to QueryByEmail with
+// errTransient, and counts the calls.
+type flakyStore struct {
+	user.Storer
+	failures int
+	calls    int
+}
+
+func (s *flakyStore) QueryByEmail(ctx context.Context, orgID string, email string) (user.User, error) {
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		return user.User{}, errTransient
+	}
+	return s.Storer.QueryByEmail(ctx, orgID, email)
+}
+
+func Test_Retry(t *testing.T) {
+	ctx := context.Background()
+	orgID := uuid.New()
+
+	backend := &flakyStore{Storer: memory.NewStore()}
+	store := NewStore(zap.NewNop().Sugar(), backend, isTransient, Config{
+		Attempts:   3,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 4 * time.Millisecond,
+		Threshold:  4,
+		Cooldown:   time.Minute,
+	})
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	store.breaker.now = func() time.Time { return now }
+
+	bill, err := store.Create(ctx, user.User{
+		ID:          uuid.New(),
+		OrgID:       orgID,
+		Name:        "bill",
+		Email:       mail.Address{Address: "bill@example.com"},
+		Roles:       []user.Role{user.RoleUser},
+		DateCreated: now,
+		DateUpdated: now,
+	})
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to create user : %s.", dbtest.Failed, err)
+	}
+	query := func() error {
+		_, err := store.QueryByEmail(ctx, orgID.String(), bill.Email.Address)
+		return err
+	}
+
+	t.Log("Given the need to ride out database failures.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the database fails briefly.", testID)
+		{
+			backend.failures, backend.calls = 2, 0
+			if err := query(); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould retry transient errors : %s.", dbtest.Failed, testID, err)
+			}
+			if backend.calls != 3 {
+				t.Fatalf("\t%s\tTest %d:\tShould try three times : got %d.", dbtest.Failed, testID, backend.calls)
+			}
+
+			backend.calls = 0
+			if _, err := store.QueryByEmail(ctx, orgID.String(), "nobody@example.com"); !errors.Is(err, memory.ErrNotFound) || backend.calls != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould not retry other errors : got %v after %d calls.", dbtest.Failed, testID, err, backend.calls)
+			}
+			t.Logf("\t%s\tTest %d:\tShould retry transient errors only.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen the database keeps failing.", testID)
+		{
+			backend.failures, backend.calls = 100, 0
+			err := query()
+			if !errors.Is(err, ErrUnavailable) || !errors.Is(err, errTransient) || backend.calls != 3 {
+				t.Fatalf("\t%s\tTest %d:\tShould give up after three tries : got %v after %d calls.", dbtest.Failed, testID, err, backend.calls)
+			}
+			if err.Error() != ErrUnavailable.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the driver's error out of the message : got %q.", dbtest.Failed, testID, err)
+			}
+
+			backend.calls = 0
+			deadline, cancel := context.WithTimeout(ctx, 0)
+			defer cancel()
+			if _, err := store.QueryByEmail(deadline, orgID.String(), bill.Email.Address); !errors.Is(err, ErrUnavailable) || backend.calls != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould not wait past the deadline : got %v after %d calls.", dbtest.Failed, testID, err, backend.calls)
+			}
+			t.Logf("\t%s\tTest %d:\tShould give up with ErrUnavailable.", dbtest.Success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen the database is down.", testID)
+		{
+			if !store.Open() {
+				t.Fatalf("\t%s\tTest %d:\tShould open the circuit after four failures in a row.", dbtest.Failed, testID)
+			}
+			backend.calls = 0
+			if err := query(); !errors.Is(err, ErrUnavailable) || backend.calls != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould fail fast : got %v after %d calls.", dbtest.Failed, testID, err, backend.calls)
+			}
+
+			now = now.Add(2 * time.Minute)
+			backend.failures, backend.calls = 1, 0
+			if err := query(); !errors.Is(err, ErrUnavailable) || backend.calls != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould let one trial call through : got %v after %d calls.", dbtest.Failed, testID, err, backend.calls)
+			}
+
+			now = now.Add(2 * time.Minute)
+			if err := query(); err != nil || store.Open() {
+				t.Fatalf("\t%s\tTest %d:\tShould close the circuit once a trial call succeeds : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould fail fast until the database is back.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Conformance(t *testing.T) {
+	storertest.Run(t, NewStore(zap.NewNop().Sugar(), memory.NewStore(), isTransient, DefaultConfig), storertest.Errors{
+		NotFound:              memory.ErrNotFound,
+		UniqueEmail:           memory.ErrUniqueEmail,
+		AuthenticationFailure: memory.ErrAuthenticationFailure,
+	})
+}
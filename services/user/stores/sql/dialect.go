@@ -0,0 +1,98 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect is the SQL database a store talks to. Its value is the name the
+// database/sql driver is registered under.
+type Dialect string
+
+// Supported dialects.
+const (
+	// SQLite suits embedded and single node deployments.
+	SQLite Dialect = "sqlite3"
+	// Postgres suits production.
+	Postgres Dialect = "postgres"
+)
+
+// ParseDialect parses the name of a dialect.
+func ParseDialect(name string) (Dialect, error) {
+	switch name {
+	case "sqlite", "sqlite3":
+		return SQLite, nil
+	case "postgres", "postgresql":
+		return Postgres, nil
+	}
+	return "", fmt.Errorf("unknown sql dialect %q", name)
+}
+
+// Open opens a database of the dialect. The dsn is passed to the driver
+// as is.
+func Open(dialect Dialect, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(string(dialect), dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, and every connection to ":memory:"
+	// opens a database of its own, so share one connection.
+	if dialect == SQLite {
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+// rebind rewrites the $1, $2, ... placeholders queries are written with
+// for the dialect. SQLite numbers $N parameters in the order they appear
+// rather than by N, so they become ?N, which it numbers by N.
+func (d Dialect) rebind(query string) string {
+	if d != SQLite {
+		return query
+	}
+	b := []byte(query)
+	for i := 0; i < len(b)-1; i++ {
+		if b[i] == '$' && b[i+1] >= '0' && b[i+1] <= '9' {
+			b[i] = '?'
+		}
+	}
+	return string(b)
+}
+
+// forUpdate returns the clause that locks the rows a select reads until
+// the transaction ends. SQLite locks the whole database on write instead.
+func (d Dialect) forUpdate() string {
+	if d == Postgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// attributeEquals returns the condition that the attribute named by the
+// name placeholder equals the JSON encoded value placeholder. Missing
+// attributes equal null, as they do in AQL.
+func (d Dialect) attributeEquals(name string, value string) string {
+	if d == Postgres {
+		return fmt.Sprintf(`COALESCE(attributes -> %s, 'null') = %s::jsonb`, name, value)
+	}
+	return fmt.Sprintf(`json_extract(COALESCE(attributes, '{}'), '$."' || %s || '"') IS json_extract(%s, '$')`, name, value)
+}
+
+// isUniqueViolation reports whether err is a primary key or unique
+// constraint violation.
+func isUniqueViolation(err error) bool {
+	var serr sqlite3.Error
+	if errors.As(err, &serr) {
+		return serr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || serr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var perr *pq.Error
+	if errors.As(err, &perr) {
+		return perr.Code == "23505"
+	}
+	return false
+}
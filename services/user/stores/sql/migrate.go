@@ -0,0 +1,123 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrations holds the schema of each dialect as numbered SQL files, such
+// as 0001_create_users.sql. Applied migrations must never change; change
+// the schema by adding a file with the next number.
+//
+//go:embed migrations
+var migrations embed.FS
+
+// Migration is a versioned change to the schema.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the migrations of the dialect, oldest first.
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", dialectDir(dialect))
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %w", err)
+	}
+
+	ms := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		num, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: version: %w", entry.Name(), err)
+		}
+		b, err := fs.ReadFile(migrations, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration %s: %w", entry.Name(), err)
+		}
+		ms = append(ms, Migration{Version: version, Name: name, SQL: string(b)})
+	}
+
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	for i := 1; i < len(ms); i++ {
+		if ms[i].Version == ms[i-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", ms[i-1].Name, ms[i].Name)
+		}
+	}
+	return ms, nil
+}
+
+// Migrate applies the migrations the database hasn't applied yet, each in
+// a transaction of its own, and records them in the schema_migrations
+// table. It returns the version the schema is at.
+func Migrate(ctx context.Context, db *sql.DB, dialect Dialect) (int, error) {
+	ms, err := Migrations(dialect)
+	if err != nil {
+		return 0, err
+	}
+
+	const schema = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER   PRIMARY KEY,
+	name       TEXT      NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+)`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return 0, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	var current int
+	row := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+	if err := row.Scan(&current); err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+
+	for _, m := range ms {
+		if m.Version <= current {
+			continue
+		}
+		if err := apply(ctx, db, dialect, m); err != nil {
+			return current, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		current = m.Version
+	}
+	return current, nil
+}
+
+// apply runs a migration and records it. When another instance applies
+// the same migration first, recording it violates the primary key and
+// the migration is rolled back.
+func apply(ctx context.Context, db *sql.DB, dialect Dialect, m Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	const record = `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, dialect.rebind(record), m.Version, m.Name, time.Now().UTC()); err != nil {
+		return fmt.Errorf("recording: %w", err)
+	}
+	return tx.Commit()
+}
+
+func dialectDir(dialect Dialect) string {
+	if dialect == Postgres {
+		return "postgres"
+	}
+	return "sqlite"
+}
@@ -0,0 +1,18 @@
+CREATE TABLE users (
+	org_id               TEXT        NOT NULL,
+	email                TEXT        NOT NULL,
+	user_id              TEXT        NOT NULL UNIQUE,
+	name                 TEXT        NOT NULL,
+	roles                JSONB       NOT NULL,
+	password_hash        BYTEA       NOT NULL,
+	password_changed_at  TIMESTAMPTZ NOT NULL,
+	must_change_password BOOLEAN     NOT NULL DEFAULT FALSE,
+	department           TEXT        NOT NULL DEFAULT '',
+	enabled              BOOLEAN     NOT NULL DEFAULT TRUE,
+	attributes           JSONB,
+	last_login_at        TIMESTAMPTZ,
+	last_login_ip        TEXT        NOT NULL DEFAULT '',
+	date_created         TIMESTAMPTZ NOT NULL,
+	date_updated         TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (org_id, email)
+);
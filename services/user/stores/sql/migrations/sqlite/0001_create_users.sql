@@ -0,0 +1,18 @@
+CREATE TABLE users (
+	org_id               TEXT      NOT NULL,
+	email                TEXT      NOT NULL,
+	user_id              TEXT      NOT NULL UNIQUE,
+	name                 TEXT      NOT NULL,
+	roles                TEXT      NOT NULL,
+	password_hash        BLOB      NOT NULL,
+	password_changed_at  TIMESTAMP NOT NULL,
+	must_change_password BOOLEAN   NOT NULL DEFAULT FALSE,
+	department           TEXT      NOT NULL DEFAULT '',
+	enabled              BOOLEAN   NOT NULL DEFAULT TRUE,
+	attributes           TEXT,
+	last_login_at        TIMESTAMP,
+	last_login_ip        TEXT      NOT NULL DEFAULT '',
+	date_created         TIMESTAMP NOT NULL,
+	date_updated         TIMESTAMP NOT NULL,
+	PRIMARY KEY (org_id, email)
+);
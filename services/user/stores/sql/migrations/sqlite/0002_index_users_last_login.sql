@@ -0,0 +1 @@
+CREATE INDEX users_enabled_last_login ON users (enabled, last_login_at);
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN email_name TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,170 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/google/uuid"
+)
+
+// userColumns are the columns of the users table, in the order scanUser
+// reads them.
+const userColumns = `user_id, org_id, name, email, email_name, roles, password_hash, password_changed_at,
+	must_change_password, department, enabled, attributes, last_login_at, last_login_ip,
+	date_created, date_updated`
+
+// dbUser represent the structure we need for moving data
+// between the app and the database.
+type dbUser struct {
+	ID                 string
+	OrgID              string
+	Name               string
+	Email              string
+	EmailName          string
+	Roles              []byte
+	PasswordHash       []byte
+	PasswordChangedAt  time.Time
+	MustChangePassword bool
+	Department         string
+	Enabled            bool
+	Attributes         []byte
+	LastLoginAt        sql.NullTime
+	LastLoginIP        string
+	DateCreated        time.Time
+	DateUpdated        time.Time
+}
+
+// args returns the values of the user in the order of userColumns.
+func (dbUsr dbUser) args() []any {
+	var attrs any
+	if dbUsr.Attributes != nil {
+		attrs = string(dbUsr.Attributes)
+	}
+	hash := dbUsr.PasswordHash
+	if hash == nil {
+		hash = []byte{}
+	}
+	return []any{
+		dbUsr.ID, dbUsr.OrgID, dbUsr.Name, dbUsr.Email, dbUsr.EmailName, string(dbUsr.Roles), hash, dbUsr.PasswordChangedAt,
+		dbUsr.MustChangePassword, dbUsr.Department, dbUsr.Enabled, attrs, dbUsr.LastLoginAt, dbUsr.LastLoginIP,
+		dbUsr.DateCreated, dbUsr.DateUpdated,
+	}
+}
+
+// scanner is implemented by sql.Row and sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser reads a row of userColumns.
+func scanUser(row scanner) (dbUser, error) {
+	var dbUsr dbUser
+	err := row.Scan(
+		&dbUsr.ID, &dbUsr.OrgID, &dbUsr.Name, &dbUsr.Email, &dbUsr.EmailName, &dbUsr.Roles, &dbUsr.PasswordHash, &dbUsr.PasswordChangedAt,
+		&dbUsr.MustChangePassword, &dbUsr.Department, &dbUsr.Enabled, &dbUsr.Attributes, &dbUsr.LastLoginAt, &dbUsr.LastLoginIP,
+		&dbUsr.DateCreated, &dbUsr.DateUpdated,
+	)
+	return dbUsr, err
+}
+
+func toDBUser(usr user.User) (dbUser, error) {
+	roles, err := json.Marshal(usr.Roles)
+	if err != nil {
+		return dbUser{}, fmt.Errorf("encoding roles: %w", err)
+	}
+	var attrs []byte
+	if usr.Attributes != nil {
+		if attrs, err = json.Marshal(usr.Attributes); err != nil {
+			return dbUser{}, fmt.Errorf("encoding attributes: %w", err)
+		}
+	}
+
+	dbUsr := dbUser{
+		ID:                 usr.ID.String(),
+		OrgID:              usr.OrgID.String(),
+		Name:               usr.Name,
+		Email:              usr.Email.Address,
+		EmailName:          usr.Email.Name,
+		Roles:              roles,
+		PasswordHash:       usr.PasswordHash,
+		PasswordChangedAt:  usr.PasswordChangedAt.UTC(),
+		MustChangePassword: usr.MustChangePassword,
+		Department:         usr.Department,
+		Enabled:            usr.Enabled,
+		Attributes:         attrs,
+		LastLoginIP:        usr.LastLoginIP,
+		DateCreated:        usr.DateCreated.UTC(),
+		DateUpdated:        usr.DateUpdated.UTC(),
+	}
+	if !usr.LastLoginAt.IsZero() {
+		dbUsr.LastLoginAt = sql.NullTime{Time: usr.LastLoginAt.UTC(), Valid: true}
+	}
+	return dbUsr, nil
+}
+
+func toCoreUser(dbUsr dbUser) (user.User, error) {
+	id, err := uuid.Parse(dbUsr.ID)
+	if err != nil {
+		return user.User{}, fmt.Errorf("parsing user id: %w", err)
+	}
+	orgID, err := uuid.Parse(dbUsr.OrgID)
+	if err != nil {
+		return user.User{}, fmt.Errorf("parsing org id: %w", err)
+	}
+	var roles []user.Role
+	if err := json.Unmarshal(dbUsr.Roles, &roles); err != nil {
+		return user.User{}, fmt.Errorf("decoding roles: %w", err)
+	}
+	var attrs map[string]any
+	if dbUsr.Attributes != nil {
+		if err := json.Unmarshal(dbUsr.Attributes, &attrs); err != nil {
+			return user.User{}, fmt.Errorf("decoding attributes: %w", err)
+		}
+	}
+
+	usr := user.User{
+		ID:                 id,
+		OrgID:              orgID,
+		Name:               dbUsr.Name,
+		Email:              mail.Address{Name: dbUsr.EmailName, Address: dbUsr.Email},
+		Roles:              roles,
+		PasswordHash:       dbUsr.PasswordHash,
+		PasswordChangedAt:  dbUsr.PasswordChangedAt.In(time.Local),
+		MustChangePassword: dbUsr.MustChangePassword,
+		Department:         dbUsr.Department,
+		Enabled:            dbUsr.Enabled,
+		Attributes:         attrs,
+		LastLoginIP:        dbUsr.LastLoginIP,
+		DateCreated:        dbUsr.DateCreated.In(time.Local),
+		DateUpdated:        dbUsr.DateUpdated.In(time.Local),
+	}
+	if dbUsr.LastLoginAt.Valid {
+		usr.LastLoginAt = dbUsr.LastLoginAt.Time.In(time.Local)
+	}
+	return usr, nil
+}
+
+// mergeAttributes merges upd into current the way ArangoDB merges
+// documents without keeping nulls: objects are merged recursively and nil
+// values are removed.
+func mergeAttributes(current map[string]any, upd map[string]any) map[string]any {
+	if current == nil {
+		current = make(map[string]any, len(upd))
+	}
+	for k, v := range upd {
+		switch v := v.(type) {
+		case nil:
+			delete(current, k)
+		case map[string]any:
+			cur, _ := current[k].(map[string]any)
+			current[k] = mergeAttributes(cur, v)
+		default:
+			current[k] = v
+		}
+	}
+	return current
+}
@@ -0,0 +1,236 @@
+package sql_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/services/user/storertest"
+	sqlstore "github.com/gitamped/bud/services/user/stores/sql"
+	"github.com/gitamped/stem/data/nosql/dbtest"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// dialects returns the databases to test against. SQLite always runs;
+// PostgreSQL runs when BUD_TEST_POSTGRES_DSN points at a database.
+func dialects(t *testing.T) map[sqlstore.Dialect]string {
+	ds := map[sqlstore.Dialect]string{
+		sqlstore.SQLite: filepath.Join(t.TempDir(), "bud.db"),
+	}
+	if dsn := os.Getenv("BUD_TEST_POSTGRES_DSN"); dsn != "" {
+		ds[sqlstore.Postgres] = dsn
+	}
+	return ds
+}
+
+func Test_Migrate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Log("Given the need to version the schema.")
+	{
+		for dialect, dsn := range dialects(t) {
+			testID := 0
+			t.Logf("\tTest %d:\tWhen migrating a %s database.", testID, dialect)
+			{
+				db, err := sqlstore.Open(dialect, dsn)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to open the database : %s.", dbtest.Failed, testID, err)
+				}
+				defer db.Close()
+
+				ms, err := sqlstore.Migrations(dialect)
+				if err != nil || len(ms) == 0 {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to list migrations : got %d, %v.", dbtest.Failed, testID, len(ms), err)
+				}
+				latest := ms[len(ms)-1].Version
+
+				for i := 0; i < 2; i++ {
+					version, err := sqlstore.Migrate(ctx, db, dialect)
+					if err != nil || version != latest {
+						t.Fatalf("\t%s\tTest %d:\tShould migrate to version %d : got %d, %v.", dbtest.Failed, testID, latest, version, err)
+					}
+				}
+
+				var applied int
+				if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil || applied != len(ms) {
+					t.Fatalf("\t%s\tTest %d:\tShould record each migration once : got %d, %v.", dbtest.Failed, testID, applied, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould apply each migration once.", dbtest.Success, testID)
+			}
+		}
+	}
+}
+
+func Test_Store(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need to keep users in a SQL database.")
+	{
+		for dialect, dsn := range dialects(t) {
+			db, err := sqlstore.Open(dialect, dsn)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to open the database : %s.", dbtest.Failed, err)
+			}
+			defer db.Close()
+			if _, err := sqlstore.Migrate(ctx, db, dialect); err != nil {
+				t.Fatalf("\t%s\tShould be able to migrate the database : %s.", dbtest.Failed, err)
+			}
+			store := sqlstore.NewStore(zap.NewNop().Sugar(), db, dialect)
+			orgID := uuid.New()
+
+			newUser := func(name string) user.User {
+				return user.User{
+					ID:           uuid.New(),
+					OrgID:        orgID,
+					Name:         name,
+					Email:        mail.Address{Name: name, Address: name + "@example.com"},
+					Roles:        []user.Role{user.RoleUser},
+					PasswordHash: []byte("hash"),
+					Enabled:      true,
+					Attributes:   map[string]any{"level": 3, "address": map[string]any{"city": "Oslo", "zip": "0150"}},
+					DateCreated:  now,
+					DateUpdated:  now,
+				}
+			}
+
+			testID := 0
+			t.Logf("\tTest %d:\tWhen handling users in %s.", testID, dialect)
+			{
+				bill, err := store.Create(ctx, newUser("bill"))
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+				}
+				if !bill.DateCreated.Equal(now) || !bill.LastLoginAt.IsZero() || !bill.Roles[0].Equal(user.RoleUser) {
+					t.Fatalf("\t%s\tTest %d:\tShould return the created user : got %+v.", dbtest.Failed, testID, bill)
+				}
+				if _, err := store.Create(ctx, newUser("bill")); !errors.Is(err, sqlstore.ErrUniqueEmail) {
+					t.Fatalf("\t%s\tTest %d:\tShould reject duplicate emails : got %v.", dbtest.Failed, testID, err)
+				}
+				other := newUser("bill")
+				other.OrgID = uuid.New()
+				if _, err := store.Create(ctx, other); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould allow the same email in another organization : %s.", dbtest.Failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould keep emails unique within an organization.", dbtest.Success, testID)
+
+				got, err := store.QueryByID(ctx, orgID.String(), bill.ID.String())
+				if err != nil || got.Email != bill.Email {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to query by id : got %+v, %v.", dbtest.Failed, testID, got, err)
+				}
+				if _, err := store.QueryByID(ctx, other.OrgID.String(), bill.ID.String()); !errors.Is(err, sqlstore.ErrNotFound) {
+					t.Fatalf("\t%s\tTest %d:\tShould not find users of another organization : got %v.", dbtest.Failed, testID, err)
+				}
+				if _, err := store.QueryByEmail(ctx, orgID.String(), "nobody@example.com"); !errors.Is(err, sqlstore.ErrNotFound) {
+					t.Fatalf("\t%s\tTest %d:\tShould not find unknown users : got %v.", dbtest.Failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to query users.", dbtest.Success, testID)
+
+				name := "William"
+				upd, err := store.Update(ctx, orgID.String(), user.UpdateUser{
+					Email:      &bill.Email,
+					Name:       &name,
+					Attributes: map[string]any{"level": nil, "address": map[string]any{"zip": "0151"}},
+				}, now.Add(time.Hour))
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to update user : %s.", dbtest.Failed, testID, err)
+				}
+				want := map[string]any{"address": map[string]any{"city": "Oslo", "zip": "0151"}}
+				if upd.Name != "William" || len(upd.Roles) != 1 || fmt.Sprint(upd.Attributes) != fmt.Sprint(want) || !upd.DateUpdated.Equal(now.Add(time.Hour)) {
+					t.Fatalf("\t%s\tTest %d:\tShould merge updates : got %+v.", dbtest.Failed, testID, upd)
+				}
+				nobody := mail.Address{Address: "nobody@example.com"}
+				if _, err := store.Update(ctx, orgID.String(), user.UpdateUser{Email: &nobody, Attributes: map[string]any{"level": 1}}, now); !errors.Is(err, sqlstore.ErrNotFound) {
+					t.Fatalf("\t%s\tTest %d:\tShould not update unknown users : got %v.", dbtest.Failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould merge updates.", dbtest.Success, testID)
+
+				for _, name := range []string{"carol", "alice"} {
+					if _, err := store.Create(ctx, newUser(name)); err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+					}
+				}
+				usrs, err := store.Query(ctx, orgID.String(), user.QueryFilter{}, user.OrderBy{Field: user.OrderByName, Direction: user.DESC}, 1, 2)
+				if err != nil || len(usrs) != 2 || usrs[0].Name != "carol" || usrs[1].Name != "alice" {
+					t.Fatalf("\t%s\tTest %d:\tShould order and page users : got %+v, %v.", dbtest.Failed, testID, usrs, err)
+				}
+				usrs, err = store.Query(ctx, orgID.String(), user.QueryFilter{Attributes: map[string]any{"level": 3.0}}, user.DefaultOrderBy, 1, 10)
+				if err != nil || len(usrs) != 2 {
+					t.Fatalf("\t%s\tTest %d:\tShould filter by attributes : got %+v, %v.", dbtest.Failed, testID, usrs, err)
+				}
+				usrs, err = store.Query(ctx, orgID.String(), user.QueryFilter{Attributes: map[string]any{"address": map[string]any{"zip": "0151", "city": "Oslo"}}}, user.DefaultOrderBy, 1, 10)
+				if err != nil || len(usrs) != 1 || usrs[0].ID != bill.ID {
+					t.Fatalf("\t%s\tTest %d:\tShould filter by object attributes : got %+v, %v.", dbtest.Failed, testID, usrs, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould filter, order and page users.", dbtest.Success, testID)
+
+				login := now.Add(24 * time.Hour)
+				if err := store.UpdateLastLogin(ctx, orgID.String(), "alice@example.com", login, "127.0.0.1"); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to record a sign in : %s.", dbtest.Failed, testID, err)
+				}
+				usrs, err = store.Query(ctx, orgID.String(), user.QueryFilter{LastLoginAfter: &now}, user.DefaultOrderBy, 1, 10)
+				if err != nil || len(usrs) != 1 || !usrs[0].LastLoginAt.Equal(login) || usrs[0].LastLoginIP != "127.0.0.1" {
+					t.Fatalf("\t%s\tTest %d:\tShould filter by last sign in : got %+v, %v.", dbtest.Failed, testID, usrs, err)
+				}
+				usrs, err = store.Query(ctx, orgID.String(), user.QueryFilter{}, user.OrderBy{Field: user.OrderByLastLoginAt, Direction: user.DESC}, 1, 10)
+				if err != nil || len(usrs) != 3 || usrs[0].Name != "alice" {
+					t.Fatalf("\t%s\tTest %d:\tShould order by last sign in : got %+v, %v.", dbtest.Failed, testID, usrs, err)
+				}
+				// Other tests may have left users of other organizations.
+				dormant, err := store.QueryDormant(ctx, now.Add(time.Hour), 1000)
+				var n int
+				for _, usr := range dormant {
+					if usr.OrgID == orgID {
+						n++
+					}
+				}
+				if err != nil || n != 2 {
+					t.Fatalf("\t%s\tTest %d:\tShould query dormant users : got %d, %v.", dbtest.Failed, testID, n, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould track sign ins.", dbtest.Success, testID)
+
+				changed, err := store.UpdatePassword(ctx, orgID.String(), bill.Email.Address, []byte("new hash"), login)
+				if err != nil || string(changed.PasswordHash) != "new hash" || !changed.PasswordChangedAt.Equal(login) {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to change the password : got %+v, %v.", dbtest.Failed, testID, changed, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to change the password.", dbtest.Success, testID)
+
+				if _, err := store.Delete(ctx, orgID.String(), bill.Email); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, err)
+				}
+				if _, err := store.Delete(ctx, orgID.String(), bill.Email); !errors.Is(err, sqlstore.ErrNotFound) {
+					t.Fatalf("\t%s\tTest %d:\tShould not delete unknown users : got %v.", dbtest.Failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to delete users.", dbtest.Success, testID)
+			}
+		}
+	}
+}
+
+func Test_Conformance(t *testing.T) {
+	for dialect, dsn := range dialects(t) {
+		t.Run(string(dialect), func(t *testing.T) {
+			db, err := sqlstore.Open(dialect, dsn)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to open the database : %s.", dbtest.Failed, err)
+			}
+			defer db.Close()
+			if _, err := sqlstore.Migrate(context.Background(), db, dialect); err != nil {
+				t.Fatalf("\t%s\tShould be able to migrate the database : %s.", dbtest.Failed, err)
+			}
+
+			storertest.Run(t, sqlstore.NewStore(zap.NewNop().Sugar(), db, dialect), storertest.Errors{
+				NotFound:              sqlstore.ErrNotFound,
+				UniqueEmail:           sqlstore.ErrUniqueEmail,
+				AuthenticationFailure: sqlstore.ErrAuthenticationFailure,
+			})
+		})
+	}
+}
@@ -0,0 +1,366 @@
+// Package sql stores users in a SQL database through database/sql: SQLite
+// for embedded and single node deployments and PostgreSQL for production.
+// It has the same semantics as the nosql store. Run Migrate before using a
+// store so the schema is up to date.
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrNotFound              = errors.New("user not found")
+	ErrUniqueEmail           = errors.New("email is not unique")
+	ErrAuthenticationFailure = errors.New("authentication failed")
+)
+
+// Store manages the set of APIs for user access.
+type Store struct {
+	log     *zap.SugaredLogger
+	db      *sql.DB
+	dialect Dialect
+}
+
+// NewStore constructs the api for data access.
+func NewStore(log *zap.SugaredLogger, db *sql.DB, dialect Dialect) *Store {
+	return &Store{
+		log:     log,
+		db:      db,
+		dialect: dialect,
+	}
+}
+
+// Create adds a new user. Emails are unique within an organization.
+func (s *Store) Create(ctx context.Context, usr user.User) (user.User, error) {
+	dbUsr, err := toDBUser(usr)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO users (%s)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+	RETURNING %s`, userColumns, userColumns)
+
+	dbUsr, err = scanUser(s.db.QueryRowContext(ctx, s.dialect.rebind(query), dbUsr.args()...))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return user.User{}, ErrUniqueEmail
+		}
+		return user.User{}, err
+	}
+	return toCoreUser(dbUsr)
+}
+
+// Delete deletes a user.
+func (s *Store) Delete(ctx context.Context, orgID string, email mail.Address) (user.User, error) {
+	query := fmt.Sprintf(`DELETE FROM users
+	WHERE org_id = $1 AND email = $2
+	RETURNING %s`, userColumns)
+
+	return s.queryOne(ctx, s.db, query, orgID, email.Address)
+}
+
+// QueryByID queries a user by id.
+func (s *Store) QueryByID(ctx context.Context, orgID string, id string) (user.User, error) {
+	query := fmt.Sprintf(`SELECT %s FROM users
+	WHERE org_id = $1 AND user_id = $2`, userColumns)
+
+	return s.queryOne(ctx, s.db, query, orgID, id)
+}
+
+// QueryByEmail queries a user by email.
+func (s *Store) QueryByEmail(ctx context.Context, orgID string, email string) (user.User, error) {
+	query := fmt.Sprintf(`SELECT %s FROM users
+	WHERE org_id = $1 AND email = $2`, userColumns)
+
+	return s.queryOne(ctx, s.db, query, orgID, email)
+}
+
+// Update merges the set fields of uu into the user. Attributes are merged
+// too, and attributes set to nil are removed.
+func (s *Store) Update(ctx context.Context, orgID string, uu user.UpdateUser, now time.Time) (user.User, error) {
+	if uu.Email == nil {
+		return user.User{}, ErrNotFound
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return user.User{}, fmt.Errorf("beginning transaction: %w", err)
+	}
+
+	usr, err := s.update(ctx, tx, orgID, uu, now)
+	if err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			s.log.Errorw("rolling back transaction", "error", rerr)
+		}
+		return user.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return user.User{}, fmt.Errorf("committing transaction: %w", err)
+	}
+	return usr, nil
+}
+
+func (s *Store) update(ctx context.Context, tx *sql.Tx, orgID string, uu user.UpdateUser, now time.Time) (user.User, error) {
+	args := []any{orgID, uu.Email.Address}
+	var sets []string
+	set := func(column string, value any) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if uu.Name != nil {
+		set("name", *uu.Name)
+	}
+	if len(uu.Roles) > 0 {
+		roles, err := json.Marshal(uu.Roles)
+		if err != nil {
+			return user.User{}, fmt.Errorf("encoding roles: %w", err)
+		}
+		set("roles", string(roles))
+	}
+	if uu.Department != nil {
+		set("department", *uu.Department)
+	}
+	if uu.Enabled != nil {
+		set("enabled", *uu.Enabled)
+	}
+	if uu.MustChangePassword != nil {
+		set("must_change_password", *uu.MustChangePassword)
+	}
+	if uu.Attributes != nil {
+		// Attributes are merged in Go, so read them under a lock.
+		query := `SELECT attributes FROM users
+		WHERE org_id = $1 AND email = $2` + s.dialect.forUpdate()
+
+		var current []byte
+		err := tx.QueryRowContext(ctx, s.dialect.rebind(query), orgID, uu.Email.Address).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return user.User{}, ErrNotFound
+		}
+		if err != nil {
+			return user.User{}, err
+		}
+
+		attrs, err := mergeJSON(current, uu.Attributes)
+		if err != nil {
+			return user.User{}, err
+		}
+		set("attributes", attrs)
+	}
+
+	set("date_updated", now.UTC())
+
+	// The columns come from the fields above, never from the caller.
+	query := fmt.Sprintf(`UPDATE users SET %s
+	WHERE org_id = $1 AND email = $2
+	RETURNING %s`, strings.Join(sets, ", "), userColumns)
+
+	return s.queryOne(ctx, tx, query, args...)
+}
+
+// Query retrieves a page of users in the organization that match the filter.
+func (s *Store) Query(ctx context.Context, orgID string, filter user.QueryFilter, orderBy user.OrderBy, pageNumber int, rowsPerPage int) ([]user.User, error) {
+	column, ok := orderByFields[orderBy.Field]
+	if !ok {
+		return nil, fmt.Errorf("cannot order by %q", orderBy.Field)
+	}
+	// Users who never signed in sort first, as null does in AQL.
+	direction := "ASC NULLS FIRST"
+	if orderBy.Direction == user.DESC {
+		direction = "DESC NULLS LAST"
+	}
+
+	args := []any{orgID}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, `SELECT %s FROM users
+	WHERE org_id = $1`, userColumns)
+
+	if filter.Name != nil {
+		fmt.Fprintf(&buf, `
+	AND name = %s`, arg(*filter.Name))
+	}
+	if filter.Email != nil {
+		fmt.Fprintf(&buf, `
+	AND email = %s`, arg(*filter.Email))
+	}
+	if filter.LastLoginBefore != nil {
+		fmt.Fprintf(&buf, `
+	AND (last_login_at IS NULL OR last_login_at < %s)`, arg(filter.LastLoginBefore.UTC()))
+	}
+	if filter.LastLoginAfter != nil {
+		fmt.Fprintf(&buf, `
+	AND last_login_at >= %s`, arg(filter.LastLoginAfter.UTC()))
+	}
+
+	// Attribute names are bound as parameters too, so they can't be used to
+	// inject SQL. Sort the names to keep the query text stable.
+	names := make([]string, 0, len(filter.Attributes))
+	for name := range filter.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := json.Marshal(filter.Attributes[name])
+		if err != nil {
+			return nil, fmt.Errorf("encoding attribute %s: %w", name, err)
+		}
+		fmt.Fprintf(&buf, `
+	AND %s`, s.dialect.attributeEquals(arg(name), arg(string(value))))
+	}
+
+	// The sort column comes from orderByFields, never from the caller.
+	fmt.Fprintf(&buf, `
+	ORDER BY %s %s, email
+	LIMIT %s OFFSET %s`, column, direction, arg(rowsPerPage), arg((pageNumber-1)*rowsPerPage))
+
+	return s.queryAll(ctx, buf.String(), args...)
+}
+
+// QueryDormant retrieves enabled users in any organization who haven't
+// signed in since before, or were created before it and never have.
+func (s *Store) QueryDormant(ctx context.Context, before time.Time, limit int) ([]user.User, error) {
+	query := fmt.Sprintf(`SELECT %s FROM users
+	WHERE enabled AND COALESCE(last_login_at, date_created) < $1
+	LIMIT $2`, userColumns)
+
+	return s.queryAll(ctx, query, before.UTC(), limit)
+}
+
+// UpdateLastLogin records when and where a user last signed in.
+func (s *Store) UpdateLastLogin(ctx context.Context, orgID string, email string, at time.Time, ip string) error {
+	query := `UPDATE users SET last_login_at = $3, last_login_ip = $4
+	WHERE org_id = $1 AND email = $2`
+
+	res, err := s.db.ExecContext(ctx, s.dialect.rebind(query), orgID, email, at.UTC(), ip)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdatePassword replaces the password hash of a user and clears the must
+// change password flag.
+func (s *Store) UpdatePassword(ctx context.Context, orgID string, email string, hash []byte, now time.Time) (user.User, error) {
+	query := fmt.Sprintf(`UPDATE users
+	SET password_hash = $3, password_changed_at = $4, must_change_password = FALSE, date_updated = $4
+	WHERE org_id = $1 AND email = $2
+	RETURNING %s`, userColumns)
+
+	return s.queryOne(ctx, s.db, query, orgID, email, hash, now.UTC())
+}
+
+// Authenticate finds a user by their email and verifies their password.
+func (s *Store) Authenticate(ctx context.Context, orgID string, email string, password string) (user.User, error) {
+	usr, err := s.QueryByEmail(ctx, orgID, email)
+	if err != nil {
+		return user.User{}, fmt.Errorf("query: email[%s]: %w", email, err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword(usr.PasswordHash, []byte(password)); err != nil {
+		return user.User{}, fmt.Errorf("comparehashandpassword: %w", ErrAuthenticationFailure)
+	}
+
+	return usr, nil
+}
+
+// orderByFields maps the fields users can be ordered by to their columns.
+var orderByFields = map[string]string{
+	user.OrderByName:        "name",
+	user.OrderByEmail:       "email",
+	user.OrderByDateCreated: "date_created",
+	user.OrderByLastLoginAt: "last_login_at",
+}
+
+// querier is implemented by sql.DB and sql.Tx.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// queryOne runs a query that returns at most one user.
+func (s *Store) queryOne(ctx context.Context, q querier, query string, args ...any) (user.User, error) {
+	dbUsr, err := scanUser(q.QueryRowContext(ctx, s.dialect.rebind(query), args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return user.User{}, ErrNotFound
+	}
+	if err != nil {
+		return user.User{}, err
+	}
+	return toCoreUser(dbUsr)
+}
+
+// queryAll runs a query that returns any number of users.
+func (s *Store) queryAll(ctx context.Context, query string, args ...any) ([]user.User, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usrs []user.User
+	for rows.Next() {
+		dbUsr, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		usr, err := toCoreUser(dbUsr)
+		if err != nil {
+			return nil, err
+		}
+		usrs = append(usrs, usr)
+	}
+	return usrs, rows.Err()
+}
+
+// mergeJSON merges upd into the JSON encoded attributes and encodes the
+// result.
+func mergeJSON(current []byte, upd map[string]any) (string, error) {
+	var attrs map[string]any
+	if current != nil {
+		if err := json.Unmarshal(current, &attrs); err != nil {
+			return "", fmt.Errorf("decoding attributes: %w", err)
+		}
+	}
+
+	// Decode the update from JSON as well, so nested objects of any map
+	// type are merged.
+	b, err := json.Marshal(upd)
+	if err != nil {
+		return "", fmt.Errorf("encoding attributes: %w", err)
+	}
+	var normalized map[string]any
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return "", fmt.Errorf("decoding attributes: %w", err)
+	}
+
+	b, err = json.Marshal(mergeAttributes(attrs, normalized))
+	if err != nil {
+		return "", fmt.Errorf("encoding attributes: %w", err)
+	}
+	return string(b), nil
+}
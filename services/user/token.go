@@ -0,0 +1,301 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/gitamped/seed/server"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Purposes of the single use tokens sent to users.
+const (
+	TokenPasswordReset = "password_reset"
+	TokenInvitation    = "invitation"
+)
+
+var (
+	// ErrTokenInvalid is returned when a token doesn't exist, has expired,
+	// has already been used or was issued for another purpose.
+	ErrTokenInvalid = errors.New("token is invalid or expired")
+	// ErrTokensUnavailable is returned by the token endpoints when no
+	// TokenStorer was configured.
+	ErrTokensUnavailable = errors.New("password reset and invitations are not available")
+)
+
+// TokenConfig sets how long tokens are valid.
+type TokenConfig struct {
+	PasswordResetTTL time.Duration
+	InvitationTTL    time.Duration
+}
+
+// DefaultTokenConfig gives users an hour to reset their password and a
+// week to accept an invitation.
+var DefaultTokenConfig = TokenConfig{
+	PasswordResetTTL: time.Hour,
+	InvitationTTL:    7 * 24 * time.Hour,
+}
+
+// Token is a single use token issued to a user. Only the hash of the token
+// is stored, so a copy of the store can't be used to reset passwords.
+type Token struct {
+	Hash        string    `json:"hash"`
+	Purpose     string    `json:"purpose"`
+	OrgID       uuid.UUID `json:"org_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+// TokenStorer interface declares the behavior this package needs to
+// persist and consume tokens.
+type TokenStorer interface {
+	Create(ctx context.Context, tkn Token) error
+	// Consume removes and returns the token with the hash. It returns
+	// ErrTokenInvalid when there is no such token for the purpose or it
+	// expired at or before now.
+	Consume(ctx context.Context, purpose string, hash string, now time.Time) (Token, error)
+	// DeleteExpired removes up to limit tokens that expired before before
+	// and returns how many it removed.
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// WithTokens lets admins issue password reset tokens and invite users, and
+// users redeem them.
+func WithTokens(tokens TokenStorer, cfg TokenConfig) Option {
+	return func(u *UserServicer) {
+		u.tokens = tokens
+		u.tokenConfig = cfg
+	}
+}
+
+// IssuePasswordReset implements UserRpcService
+func (u UserServicer) IssuePasswordReset(req IssuePasswordResetRequest, gr server.GenericRequest) IssuePasswordResetResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return IssuePasswordResetResponse{Error: err.Error()}
+	}
+	if u.tokens == nil {
+		return IssuePasswordResetResponse{Error: ErrTokensUnavailable.Error()}
+	}
+
+	// Credentials are only issued to admins acting as themselves.
+	if _, ok := impersonator(gr); ok {
+		return IssuePasswordResetResponse{Error: ErrImpersonated.Error()}
+	}
+
+	usr, err := u.storer.QueryByEmail(gr.Ctx, orgID, req.Email)
+	if err != nil {
+		return IssuePasswordResetResponse{Error: err.Error()}
+	}
+	raw, tkn, err := u.issueToken(gr.Ctx, TokenPasswordReset, usr, u.tokenConfig.PasswordResetTTL, gr.Values.Now)
+	if err != nil {
+		return IssuePasswordResetResponse{Error: err.Error()}
+	}
+	return IssuePasswordResetResponse{Token: raw, ExpiresAt: tkn.ExpiresAt}
+}
+
+// ResetPassword implements UserRpcService
+func (u UserServicer) ResetPassword(req ResetPasswordRequest, gr server.GenericRequest) ResetPasswordResponse {
+	if u.tokens == nil {
+		return ResetPasswordResponse{Error: ErrTokensUnavailable.Error()}
+	}
+	if req.Password != req.PasswordConfirm {
+		return ResetPasswordResponse{Error: "passwords do not match"}
+	}
+
+	usr, err := u.redeemToken(gr.Ctx, TokenPasswordReset, req.Token, req.Password, gr.Values.Now)
+	if err != nil {
+		return ResetPasswordResponse{Error: err.Error()}
+	}
+	u.publish(gr.Ctx, EventUserUpdated, usr, gr.Values.Now)
+	return ResetPasswordResponse{}
+}
+
+// InviteUser implements UserRpcService
+func (u UserServicer) InviteUser(req InviteUserRequest, gr server.GenericRequest) InviteUserResponse {
+	if u.tokens == nil {
+		return InviteUserResponse{Error: ErrTokensUnavailable.Error()}
+	}
+	if _, ok := impersonator(gr); ok {
+		return InviteUserResponse{Error: ErrImpersonated.Error()}
+	}
+
+	// The user can't sign in until they accept the invitation and choose a
+	// password, so give them one nobody knows in the meantime.
+	password, err := randomToken()
+	if err != nil {
+		return InviteUserResponse{Error: err.Error()}
+	}
+	nu := NewUser{
+		Name:       req.User.Name,
+		Email:      req.User.Email,
+		Roles:      req.User.Roles,
+		Department: req.User.Department,
+		Attributes: req.User.Attributes,
+		Password:   password,
+	}
+	usr, err := u.createUser(req.OrgID, nu, false, gr)
+	if err != nil {
+		return InviteUserResponse{Error: err.Error()}
+	}
+
+	raw, tkn, err := u.issueToken(gr.Ctx, TokenInvitation, usr, u.tokenConfig.InvitationTTL, gr.Values.Now)
+	if err != nil {
+		return InviteUserResponse{Error: err.Error()}
+	}
+	return InviteUserResponse{User: usr, Token: raw, ExpiresAt: tkn.ExpiresAt}
+}
+
+// AcceptInvitation implements UserRpcService
+func (u UserServicer) AcceptInvitation(req AcceptInvitationRequest, gr server.GenericRequest) AcceptInvitationResponse {
+	if u.tokens == nil {
+		return AcceptInvitationResponse{Error: ErrTokensUnavailable.Error()}
+	}
+	if req.Password != req.PasswordConfirm {
+		return AcceptInvitationResponse{Error: "passwords do not match"}
+	}
+
+	usr, err := u.redeemToken(gr.Ctx, TokenInvitation, req.Token, req.Password, gr.Values.Now)
+	if err != nil {
+		return AcceptInvitationResponse{Error: err.Error()}
+	}
+	enabled := true
+	usr, err = u.storer.Update(gr.Ctx, usr.OrgID.String(), UpdateUser{Email: &usr.Email, Enabled: &enabled}, gr.Values.Now)
+	if err != nil {
+		return AcceptInvitationResponse{Error: err.Error()}
+	}
+	u.publish(gr.Ctx, EventUserUpdated, usr, gr.Values.Now)
+	return AcceptInvitationResponse{User: usr}
+}
+
+// issueToken stores a token for usr that expires after ttl and returns it
+// with the raw token to hand to the user.
+func (u UserServicer) issueToken(ctx context.Context, purpose string, usr User, ttl time.Duration, now time.Time) (string, Token, error) {
+	raw, err := randomToken()
+	if err != nil {
+		return "", Token{}, err
+	}
+	tkn := Token{
+		Hash:        hashToken(raw),
+		Purpose:     purpose,
+		OrgID:       usr.OrgID,
+		UserID:      usr.ID,
+		ExpiresAt:   now.Add(ttl),
+		DateCreated: now,
+	}
+	if err := u.tokens.Create(ctx, tkn); err != nil {
+		return "", Token{}, err
+	}
+	return raw, tkn, nil
+}
+
+// redeemToken consumes the raw token and sets the password of the user it
+// was issued to.
+func (u UserServicer) redeemToken(ctx context.Context, purpose string, raw string, password string, now time.Time) (User, error) {
+	tkn, err := u.tokens.Consume(ctx, purpose, hashToken(raw), now)
+	if err != nil {
+		return User{}, err
+	}
+	usr, err := u.storer.QueryByID(ctx, tkn.OrgID.String(), tkn.UserID.String())
+	if err != nil {
+		return User{}, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, fmt.Errorf("generatefrompassword: %w", err)
+	}
+	return u.storer.UpdatePassword(ctx, tkn.OrgID.String(), usr.Email.Address, hash, now)
+}
+
+// randomToken generates a random URL safe token.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash a token is stored under. Tokens are random,
+// so a fast hash is enough and lets them be looked up by it.
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssuePasswordResetRequest is the request object for UserService.IssuePasswordReset.
+type IssuePasswordResetRequest struct {
+	Email string `json:"email" validate:"required"`
+}
+
+// IssuePasswordResetResponse is the response object for UserService.IssuePasswordReset.
+type IssuePasswordResetResponse struct {
+	// Token is passed to ResetPassword. Send it to the user; it can't be
+	// retrieved again.
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ResetPasswordRequest is the request object for UserService.ResetPassword.
+type ResetPasswordRequest struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+	PasswordConfirm string `json:"passwordConfirm" validate:"required"`
+}
+
+// ResetPasswordResponse is the response object for UserService.ResetPassword.
+type ResetPasswordResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// InvitedUser contains information needed to invite a user.
+type InvitedUser struct {
+	Name       string         `json:"name"`
+	Email      mail.Address   `json:"email"`
+	Roles      []Role         `json:"roles"`
+	Department string         `json:"department"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// InviteUserRequest is the request object for UserService.InviteUser.
+type InviteUserRequest struct {
+	User InvitedUser `json:"user"`
+	// OrgID invites the user to another organization. Only a super admin
+	// may set it.
+	OrgID string `json:"orgId,omitempty"`
+}
+
+// InviteUserResponse is the response object for UserService.InviteUser.
+type InviteUserResponse struct {
+	// User is disabled until they accept the invitation.
+	User User `json:"user"`
+	// Token is passed to AcceptInvitation. Send it to the user; it can't be
+	// retrieved again.
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// AcceptInvitationRequest is the request object for UserService.AcceptInvitation.
+type AcceptInvitationRequest struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+	PasswordConfirm string `json:"passwordConfirm" validate:"required"`
+}
+
+// AcceptInvitationResponse is the response object for UserService.AcceptInvitation.
+type AcceptInvitationResponse struct {
+	User  User   `json:"user"`
+	Error string `json:"error,omitempty"`
+}
@@ -2,6 +2,7 @@ package user
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/mail"
 	"time"
@@ -14,6 +15,12 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrUserDisabled is returned when a disabled user tries to sign in.
+var ErrUserDisabled = errors.New("user is disabled")
+
+// ErrPasswordUpdate is returned when UpdateUser is asked to set a password.
+var ErrPasswordUpdate = errors.New("passwords can't be updated with UpdateUser, use ChangePassword")
+
 // UserService is an API for creating users for an app.
 type UserService interface {
 	// CreateUser create a user
@@ -28,35 +35,101 @@ type UserService interface {
 	QueryUserByID(QueryUserByIDRequest, server.GenericRequest) QueryUserByIDResponse
 	// QueryByEmail gets the specified user by email
 	QueryUserByEmail(QueryUserByEmailRequest, server.GenericRequest) QueryUserByEmailResponse
+	// SearchUsers finds users by partial or misspelled names, emails and
+	// departments, most relevant first
+	SearchUsers(SearchUsersRequest, server.GenericRequest) SearchUsersResponse
 	// Authenticate finds a user by their email and verifies their password. On
 	// success it returns a Claims User representing this user. The claims can be
 	// used to generate a token for future authentication.
 	Authenticate(AuthenticateRequest, server.GenericRequest) AuthenticateResponse
+	// ImpersonateUser issues a short-lived token that lets an admin act as
+	// another user. The token identifies the admin in its act claim.
+	ImpersonateUser(ImpersonateUserRequest, server.GenericRequest) ImpersonateUserResponse
+	// ChangePassword changes the caller's password. It accepts the restricted
+	// token Authenticate issues when the password must be changed, and
+	// returns a full token.
+	ChangePassword(ChangePasswordRequest, server.GenericRequest) ChangePasswordResponse
+	// QueryLoginHistory gets when and where a user last signed in and their
+	// latest sign in attempts
+	QueryLoginHistory(QueryLoginHistoryRequest, server.GenericRequest) QueryLoginHistoryResponse
+	// IssuePasswordReset issues a single use token that lets a user choose
+	// a new password with ResetPassword
+	IssuePasswordReset(IssuePasswordResetRequest, server.GenericRequest) IssuePasswordResetResponse
+	// ResetPassword sets a user's password with a token issued by
+	// IssuePasswordReset
+	ResetPassword(ResetPasswordRequest, server.GenericRequest) ResetPasswordResponse
+	// InviteUser creates a disabled user and issues a single use token that
+	// lets them choose a password with AcceptInvitation
+	InviteUser(InviteUserRequest, server.GenericRequest) InviteUserResponse
+	// AcceptInvitation sets an invited user's password and enables them
+	AcceptInvitation(AcceptInvitationRequest, server.GenericRequest) AcceptInvitationResponse
 }
 
 // Storer interface declares the behavior this package needs to perists and
 // retrieve data.
 type Storer interface {
 	Create(ctx context.Context, usr User) (User, error)
-	Delete(ctx context.Context, email mail.Address) (User, error)
-	QueryByID(ctx context.Context, id string) (User, error)
-	QueryByEmail(ctx context.Context, email string) (User, error)
-	Update(ctx context.Context, usr UpdateUser) (User, error)
-	Authenticate(ctx context.Context, email string, password string) (User, error)
+	Delete(ctx context.Context, orgID string, email mail.Address) (User, error)
+	QueryByID(ctx context.Context, orgID string, id string) (User, error)
+	QueryByEmail(ctx context.Context, orgID string, email string) (User, error)
+	Update(ctx context.Context, orgID string, usr UpdateUser, now time.Time) (User, error)
+	Query(ctx context.Context, orgID string, filter QueryFilter, orderBy OrderBy, pageNumber int, rowsPerPage int) ([]User, error)
+	Authenticate(ctx context.Context, orgID string, email string, password string) (User, error)
+	UpdateLastLogin(ctx context.Context, orgID string, email string, at time.Time, ip string) error
+	// UpdatePassword replaces the password hash and clears the must change
+	// password flag.
+	UpdatePassword(ctx context.Context, orgID string, email string, hash []byte, now time.Time) (User, error)
 }
 
 // Required to register endpoints with the Server
 type UserRpcService interface {
 	UserService
+	// FederatedLogin issues a token for an identity asserted by an external
+	// identity provider
+	FederatedLogin(ctx context.Context, id FederatedIdentity) (string, error)
+	// IDToken checks the password of a user of the client's organization
+	// and issues an ID token addressed to the client
+	IDToken(ctx context.Context, clt Client, email string, password string, nonce string) (string, error)
 	// Registers RPCService with Server
 	Register(s *server.Server)
 }
 
 // Implements interface
 type UserServicer struct {
-	log    *zap.SugaredLogger
-	storer Storer
-	auth   auth.Auth
+	log        *zap.SugaredLogger
+	storer     Storer
+	signer     *Signer
+	groups     GroupStorer
+	schemas    AttributeStorer
+	federation *Federation
+	events     EventPublisher
+
+	logins       LoginStorer
+	loginHistory int
+	passwords    PasswordPolicy
+	searcher     Searcher
+	departments  DepartmentStorer
+	tokens       TokenStorer
+	tokenConfig  TokenConfig
+}
+
+// Option configures optional behavior of a UserServicer.
+type Option func(*UserServicer)
+
+// WithGroupClaims includes the IDs of the groups a user belongs to in the
+// tokens issued by Authenticate.
+func WithGroupClaims(groups GroupStorer) Option {
+	return func(u *UserServicer) {
+		u.groups = groups
+	}
+}
+
+// WithAttributeSchemas validates the custom attributes of created and updated
+// users against the schema that applies to their organization.
+func WithAttributeSchemas(schemas AttributeStorer) Option {
+	return func(u *UserServicer) {
+		u.schemas = schemas
+	}
 }
 
 // Authenticate implements UserRpcService
@@ -68,39 +141,138 @@ func (u UserServicer) Authenticate(req AuthenticateRequest, gr server.GenericReq
 
 	}
 
-	usr, err := u.storer.Authenticate(gr.Ctx, *&addr.Address, req.Password)
+	usr, err := u.checkPassword(gr.Ctx, req.OrgID, addr.Address, req.Password, gr.Values.Now)
+	if err != nil {
+		return AuthenticateResponse{Error: err.Error()}
+	}
+
+	if u.passwordChangeRequired(usr, gr.Values.Now) {
+		tkn, err := u.passwordChangeToken(usr)
+		if err != nil {
+			return AuthenticateResponse{Error: err.Error()}
+		}
+		return AuthenticateResponse{Token: tkn, PasswordChangeRequired: true}
+	}
+
+	claims, err := u.newClaims(gr.Ctx, usr, time.Hour)
 	if err != nil {
 		return AuthenticateResponse{Error: err.Error()}
 	}
 
+	tkn, err := u.signer.GenerateToken(claims)
+	if err != nil {
+		return AuthenticateResponse{Error: fmt.Errorf("generatetoken: %w", err).Error()}
+
+	}
+
+	return AuthenticateResponse{Token: tkn}
+}
+
+// checkPassword finds an enabled user by their email and verifies their
+// password, recording the attempt in their login history.
+func (u UserServicer) checkPassword(ctx context.Context, orgID string, email string, password string, now time.Time) (User, error) {
+	usr, err := u.storer.Authenticate(ctx, orgID, email, password)
+	if err != nil {
+		// Only attempts on existing users have a history to record them in.
+		if usr, qerr := u.storer.QueryByEmail(ctx, orgID, email); qerr == nil {
+			u.recordLogin(ctx, usr, LoginPassword, err, now)
+		}
+		return User{}, err
+	}
+	if !usr.Enabled {
+		u.recordLogin(ctx, usr, LoginPassword, ErrUserDisabled, now)
+		return User{}, ErrUserDisabled
+	}
+	u.recordLogin(ctx, usr, LoginPassword, nil, now)
+	return usr, nil
+}
+
+// ImpersonateUser implements UserRpcService
+func (u UserServicer) ImpersonateUser(req ImpersonateUserRequest, gr server.GenericRequest) ImpersonateUserResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return ImpersonateUserResponse{Error: err.Error()}
+	}
+
+	// An impersonated token can't be used to start another impersonation.
+	if _, ok := impersonator(gr); ok {
+		return ImpersonateUserResponse{Error: ErrImpersonated.Error()}
+	}
+	if req.UserID == gr.Claims.Subject {
+		return ImpersonateUserResponse{Error: "cannot impersonate yourself"}
+	}
+
+	usr, err := u.storer.QueryByID(gr.Ctx, orgID, req.UserID)
+	if err != nil {
+		return ImpersonateUserResponse{Error: err.Error()}
+	}
+	// Impersonating a user can't grant the admin roles they don't hold.
+	for _, r := range usr.Roles {
+		if !holds(gr.Claims, r) {
+			return ImpersonateUserResponse{Error: auth.ErrForbidden.Error()}
+		}
+	}
+
+	claims, err := u.newClaims(gr.Ctx, usr, impersonationTTL)
+	if err != nil {
+		return ImpersonateUserResponse{Error: err.Error()}
+	}
+	claims.Act = &Actor{Subject: gr.Claims.Subject}
+
+	tkn, err := u.signer.GenerateToken(claims)
+	if err != nil {
+		return ImpersonateUserResponse{Error: fmt.Errorf("generatetoken: %w", err).Error()}
+	}
+
+	u.log.Infow("impersonation token issued", "actor", gr.Claims.Subject, "subject", claims.Subject, "org_id", orgID, "expires_at", claims.ExpiresAt.Time)
+
+	return ImpersonateUserResponse{Token: tkn}
+}
+
+// newClaims builds the claims for a token issued to usr that is valid for ttl.
+func (u UserServicer) newClaims(ctx context.Context, usr User, ttl time.Duration) (Claims, error) {
 	// flatten roles
 	roles := make([]string, 0, len(usr.Roles))
 	for _, value := range usr.Roles {
 		roles = append(roles, value.name)
 	}
 
-	claims := auth.Claims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   usr.ID.String(),
-			Issuer:    "bud project",
-			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+	claims := Claims{
+		Claims: auth.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   usr.ID.String(),
+				Issuer:    u.signer.issuer,
+				Audience:  jwt.ClaimStrings{u.signer.audience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(ttl)),
+				IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			},
+			Roles: roles,
 		},
-		Roles: roles,
+		Email: usr.Email.Address,
+		Name:  usr.Name,
+		OrgID: usr.OrgID.String(),
 	}
 
-	tkn, err := u.auth.GenerateToken(claims)
-	if err != nil {
-		return AuthenticateResponse{Error: fmt.Errorf("generatetoken: %w", err).Error()}
-
+	if u.groups != nil {
+		grps, err := u.groups.QueryByUser(ctx, claims.OrgID, usr.ID.String())
+		if err != nil {
+			return Claims{}, fmt.Errorf("querybyuser: %w", err)
+		}
+		for _, grp := range grps {
+			claims.Groups = append(claims.Groups, grp.ID.String())
+		}
 	}
 
-	return AuthenticateResponse{Token: tkn}
+	return claims, nil
 }
 
 // QueryUserByEmail implements UserRpcService
 func (u UserServicer) QueryUserByEmail(req QueryUserByEmailRequest, gr server.GenericRequest) QueryUserByEmailResponse {
-	usr, err := u.storer.QueryByEmail(gr.Ctx, req.Email)
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryUserByEmailResponse{Error: err.Error()}
+	}
+	usr, err := u.storer.QueryByEmail(gr.Ctx, orgID, req.Email)
 	if err != nil {
 		return QueryUserByEmailResponse{Error: err.Error()}
 	}
@@ -109,7 +281,11 @@ func (u UserServicer) QueryUserByEmail(req QueryUserByEmailRequest, gr server.Ge
 
 // QueryUserByID implements UserRpcService
 func (u UserServicer) QueryUserByID(req QueryUserByIDRequest, gr server.GenericRequest) QueryUserByIDResponse {
-	usr, err := u.storer.QueryByID(gr.Ctx, req.ID)
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryUserByIDResponse{Error: err.Error()}
+	}
+	usr, err := u.storer.QueryByID(gr.Ctx, orgID, req.ID)
 	if err != nil {
 		return QueryUserByIDResponse{Error: err.Error()}
 	}
@@ -117,49 +293,159 @@ func (u UserServicer) QueryUserByID(req QueryUserByIDRequest, gr server.GenericR
 }
 
 // QueryUser implements UserRpcService
-func (UserServicer) QueryUser(QueryUserRequest, server.GenericRequest) QueryUserResponse {
-	panic("unimplemented")
+func (u UserServicer) QueryUser(req QueryUserRequest, gr server.GenericRequest) QueryUserResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryUserResponse{Error: err.Error()}
+	}
+
+	pageNumber, rowsPerPage := req.PageNumber, req.RowsPerPage
+	if pageNumber == 0 {
+		pageNumber = 1
+	}
+	if rowsPerPage == 0 {
+		rowsPerPage = 50
+	}
+
+	orderBy := DefaultOrderBy
+	if req.OrderBy != nil {
+		orderBy = *req.OrderBy
+		if err := validateOrderBy(orderBy); err != nil {
+			return QueryUserResponse{Error: err.Error()}
+		}
+	}
+
+	usrs, err := u.storer.Query(gr.Ctx, orgID, req.Filter, orderBy, pageNumber, rowsPerPage)
+	if err != nil {
+		return QueryUserResponse{Error: err.Error()}
+	}
+	return QueryUserResponse{Users: usrs}
 }
 
 // DeleteUser implements UserRpcService
 func (u UserServicer) DeleteUser(req DeleteUserRequest, gr server.GenericRequest) DeleteUserResponse {
-	du, err := u.storer.Delete(gr.Ctx, req.User.Email)
+	orgID, err := tenant(gr)
 	if err != nil {
 		return DeleteUserResponse{Error: err.Error()}
 	}
+	du, err := u.storer.Delete(gr.Ctx, orgID, req.User.Email)
+	if err != nil {
+		return DeleteUserResponse{Error: err.Error()}
+	}
+	u.publish(gr.Ctx, EventUserDeleted, du, gr.Values.Now)
 	return DeleteUserResponse{User: du}
 }
 
 // CreateUser implements UserRpcService
 func (u UserServicer) CreateUser(req CreateUserRequest, gr server.GenericRequest) CreateUserResponse {
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewUser.Password), bcrypt.DefaultCost)
+	result, err := u.createUser(req.OrgID, req.NewUser, true, gr)
+	if err != nil {
+		return CreateUserResponse{Error: err.Error()}
+	}
+	return CreateUserResponse{User: result}
+}
+
+// createUser creates nu in the caller's organization, or in orgID when it
+// is set by a super admin.
+func (u UserServicer) createUser(orgID string, nu NewUser, enabled bool, gr server.GenericRequest) (User, error) {
+	callerOrgID, err := tenant(gr)
+	if err != nil {
+		return User{}, err
+	}
+
+	// Only a super admin may create users outside of their own organization.
+	if orgID != "" && orgID != callerOrgID {
+		if !gr.Claims.Authorized(RoleSuperAdmin.Name()) {
+			return User{}, auth.ErrForbidden
+		}
+	} else {
+		orgID = callerOrgID
+	}
+
+	oid, err := uuid.Parse(orgID)
 	if err != nil {
-		return CreateUserResponse{Error: fmt.Errorf("generatefrompassword: %w", err).Error()}
+		return User{}, fmt.Errorf("parsing org id: %w", err)
+	}
+
+	if err := authorizeRoles(nu.Roles, gr); err != nil {
+		return User{}, err
+	}
+	if u.schemas != nil {
+		if err := validateAttributes(gr.Ctx, u.schemas, orgID, nu.Attributes); err != nil {
+			return User{}, err
+		}
+	}
+	if err := u.validateDepartment(gr.Ctx, orgID, nu.Department); err != nil {
+		return User{}, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(nu.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, fmt.Errorf("generatefrompassword: %w", err)
 	}
 	usr := User{
-		ID:           uuid.New(),
-		Name:         req.NewUser.Name,
-		Email:        req.NewUser.Email,
-		PasswordHash: hash,
-		Roles:        req.NewUser.Roles,
-		Department:   req.NewUser.Department,
-		Enabled:      true,
-		DateCreated:  gr.Values.Now,
-		DateUpdated:  gr.Values.Now,
+		ID:                uuid.New(),
+		OrgID:             oid,
+		Name:              nu.Name,
+		Email:             nu.Email,
+		PasswordHash:      hash,
+		PasswordChangedAt: gr.Values.Now,
+		Roles:             nu.Roles,
+		Department:        nu.Department,
+		Enabled:           enabled,
+		Attributes:        nu.Attributes,
+		DateCreated:       gr.Values.Now,
+		DateUpdated:       gr.Values.Now,
 	}
 	result, err := u.storer.Create(gr.Ctx, usr)
 	if err != nil {
-		return CreateUserResponse{Error: err.Error()}
+		return User{}, err
 	}
-	return CreateUserResponse{User: result}
+	u.publish(gr.Ctx, EventUserCreated, result, gr.Values.Now)
+	return result, nil
 }
 
 // UpdateUser implements UserRpcService
 func (u UserServicer) UpdateUser(req UpdateUserRequest, gr server.GenericRequest) UpdateUserResponse {
-	uu, err := u.storer.Update(gr.Ctx, req.UpdateUser)
+	orgID, err := tenant(gr)
 	if err != nil {
 		return UpdateUserResponse{Error: err.Error()}
 	}
+
+	if req.UpdateUser.Password != nil || req.UpdateUser.PasswordConfirm != nil {
+		return UpdateUserResponse{Error: ErrPasswordUpdate.Error()}
+	}
+
+	if err := authorizeRoles(req.UpdateUser.Roles, gr); err != nil {
+		return UpdateUserResponse{Error: err.Error()}
+	}
+
+	// Validate the attributes the user will have once the update is merged.
+	if req.UpdateUser.Attributes != nil && u.schemas != nil {
+		if req.UpdateUser.Email == nil {
+			return UpdateUserResponse{Error: "email is required"}
+		}
+		usr, err := u.storer.QueryByEmail(gr.Ctx, orgID, req.UpdateUser.Email.Address)
+		if err != nil {
+			return UpdateUserResponse{Error: err.Error()}
+		}
+		attrs := mergeAttributes(usr.Attributes, req.UpdateUser.Attributes)
+		if err := validateAttributes(gr.Ctx, u.schemas, orgID, attrs); err != nil {
+			return UpdateUserResponse{Error: err.Error()}
+		}
+	}
+
+	if req.UpdateUser.Department != nil {
+		if err := u.validateDepartment(gr.Ctx, orgID, *req.UpdateUser.Department); err != nil {
+			return UpdateUserResponse{Error: err.Error()}
+		}
+	}
+
+	uu, err := u.storer.Update(gr.Ctx, orgID, req.UpdateUser, gr.Values.Now)
+	if err != nil {
+		return UpdateUserResponse{Error: err.Error()}
+	}
+	u.publish(gr.Ctx, UpdateEventType(req.UpdateUser), uu, gr.Values.Now)
 	return UpdateUserResponse{User: uu}
 }
 
@@ -168,23 +454,55 @@ func (us UserServicer) Register(s *server.Server) {
 	s.Register("UserService", "CreateUser", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.CreateUserHandler})
 	s.Register("UserService", "DeleteUser", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.DeleteUserHandler})
 	s.Register("UserService", "QueryUserByID", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.QueryUserByIDHandler})
+	s.Register("UserService", "QueryUser", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.QueryUserHandler})
 	s.Register("UserService", "QueryUserByEmail", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.QueryUserByEmailHandler})
+	s.Register("UserService", "SearchUsers", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.SearchUsersHandler})
 	s.Register("UserService", "UpdateUser", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.UpdateUserHandler})
+	s.Register("UserService", "ImpersonateUser", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.ImpersonateUserHandler})
 	s.Register("UserService", "Authenticate", server.RPCEndpoint{Roles: []string{}, Handler: us.AuthenticateHandler})
+	s.Register("UserService", "ChangePassword", server.RPCEndpoint{Roles: []string{}, Handler: us.ChangePasswordHandler})
+	s.Register("UserService", "QueryLoginHistory", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.QueryLoginHistoryHandler})
+	s.Register("UserService", "IssuePasswordReset", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.IssuePasswordResetHandler})
+	s.Register("UserService", "ResetPassword", server.RPCEndpoint{Roles: []string{}, Handler: us.ResetPasswordHandler})
+	s.Register("UserService", "InviteUser", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: us.InviteUserHandler})
+	s.Register("UserService", "AcceptInvitation", server.RPCEndpoint{Roles: []string{}, Handler: us.AcceptInvitationHandler})
 }
 
 // Create new UserServicer
-func NewUserServicer(log *zap.SugaredLogger, storer Storer, a auth.Auth) UserRpcService {
-	return UserServicer{
+func NewUserServicer(log *zap.SugaredLogger, storer Storer, signer *Signer, opts ...Option) UserRpcService {
+	u := UserServicer{
 		log:    log,
 		storer: storer,
-		auth:   a,
+		signer: signer,
+	}
+	for _, opt := range opts {
+		opt(&u)
 	}
+	if WritesOutbox(storer) && u.events != nil {
+		log.Panicf("WithEvents can't be used with a storer that writes an outbox: every event would be published twice")
+	}
+	return u
+}
+
+// validateOrderBy checks that users can be ordered by ob.
+func validateOrderBy(ob OrderBy) error {
+	switch ob.Field {
+	case OrderByName, OrderByEmail, OrderByDateCreated, OrderByLastLoginAt:
+	default:
+		return fmt.Errorf("cannot order by %q", ob.Field)
+	}
+	if ob.Direction != ASC && ob.Direction != DESC {
+		return fmt.Errorf("unknown order direction %q", ob.Direction)
+	}
+	return nil
 }
 
 // CreateUserRequest is the request object for UserService.CreateUser.
 type CreateUserRequest struct {
 	NewUser NewUser `json:"newUser"`
+	// OrgID creates the user in another organization. Only a super admin
+	// may set it; by default users are created in the caller's organization.
+	OrgID string `json:"orgId,omitempty"`
 }
 
 // CreateUserResponse is the response object containing a UserService.CreateUser.
@@ -212,8 +530,20 @@ type DeleteUserResponse struct {
 	Error string `json:"error,omitempty"`
 }
 
-type QueryUserRequest struct{}
-type QueryUserResponse struct{}
+// QueryUserRequest is the request object for UserService.QueryUser.
+type QueryUserRequest struct {
+	Filter QueryFilter `json:"filter"`
+	// OrderBy defaults to DefaultOrderBy.
+	OrderBy     *OrderBy `json:"orderBy"`
+	PageNumber  int      `json:"page" validate:"gte=0"`
+	RowsPerPage int      `json:"rows" validate:"gte=0,lte=1000"`
+}
+
+// QueryUserResponse is the response object for UserService.QueryUser.
+type QueryUserResponse struct {
+	Users []User `json:"users"`
+	Error string `json:"error,omitempty"`
+}
 
 // QueryUserByIDRequest is the request object for UserService.QueryUserByID.
 type QueryUserByIDRequest struct {
@@ -238,11 +568,27 @@ type QueryUserByEmailResponse struct {
 }
 
 type AuthenticateRequest struct {
+	OrgID    string `json:"orgId" validate:"required"`
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
 type AuthenticateResponse struct {
+	Token string `json:"token"`
+	// PasswordChangeRequired is set when the password has expired or an
+	// admin requires it to be changed. Token is then only good for
+	// UserService.ChangePassword.
+	PasswordChangeRequired bool   `json:"password_change_required,omitempty"`
+	Error                  string `json:"error,omitempty"`
+}
+
+// ImpersonateUserRequest is the request object for UserService.ImpersonateUser.
+type ImpersonateUserRequest struct {
+	UserID string `json:"userId" validate:"required"`
+}
+
+// ImpersonateUserResponse is the response object for UserService.ImpersonateUser.
+type ImpersonateUserResponse struct {
 	Token string `json:"token"`
 	Error string `json:"error,omitempty"`
 }
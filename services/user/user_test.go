@@ -2,22 +2,39 @@ package user_test
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/mail"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/services/user/storertest"
+	"github.com/gitamped/bud/services/user/stores/memory"
 	"github.com/gitamped/bud/services/user/stores/nosql"
 	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/keystore"
 	"github.com/gitamped/seed/server"
 	"github.com/gitamped/seed/values"
 	"github.com/gitamped/stem/data/nosql/dbtest"
 	"github.com/gitamped/stem/docker"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
+// c is the ArangoDB container, nil when it couldn't be started. The tests
+// that need it skip then, and the rest still run.
 var c *docker.Container
 
 func TestMain(m *testing.M) {
@@ -25,40 +42,63 @@ func TestMain(m *testing.M) {
 	c, err = dbtest.StartDB()
 	if err != nil {
 		fmt.Println(err)
-		return
+		c = nil
+	} else {
+		defer dbtest.StopDB(c)
 	}
-	defer dbtest.StopDB(c)
 
 	m.Run()
 }
 
-func Test_User(t *testing.T) {
+// setupArango starts an integration test against its own database seeded
+// from testdata, or skips the test when ArangoDB isn't running. The database
+// is torn down when the test ends.
+func setupArango(t *testing.T, dbName string) *dbtest.Test {
+	t.Helper()
+	if c == nil {
+		t.Skip("skipping integration test: ArangoDB couldn't be started, is Docker running?")
+	}
+
 	b, _ := os.ReadFile("../../testdata/collections.txt")
 	cols := strings.Split(string(b), "\n")
+	b, _ = os.ReadFile("../../testdata/edges.txt")
+	edges := strings.Split(string(b), "\n")
 	b, _ = os.ReadFile("../../testdata/seed.txt")
 	seed := string(b)
 
 	d := dbtest.Data{
 		CollectionData: cols,
+		EdgeData:       edges,
 		SeedAql:        seed,
 	}
 
-	test := dbtest.NewIntegration(t, c, "testuser", d)
-	log := test.Log
-	db := test.DB
-	teardown := test.Teardown
-	authSvc := test.Auth
-	t.Cleanup(teardown)
-	storer := nosql.NewStore(log, db)
+	test := dbtest.NewIntegration(t, c, dbName, d)
+	t.Cleanup(test.Teardown)
+	return test
+}
+
+func Test_User(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testUser(t, zap.NewNop().Sugar(), memory.NewStore())
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testuser")
+		testUser(t, test.Log, nosql.NewStore(test.Log, test.DB))
+	})
+}
 
-	core := user.NewUserServicer(log, storer, *authSvc)
+// testUser works with the users kept in storer.
+func testUser(t *testing.T, log *zap.SugaredLogger, storer user.Storer) {
+	signer := newSigner(t)
+	core := user.NewUserServicer(log, storer, signer)
 
 	t.Log("Given the need to work with User records.")
 	{
 		testID := 0
 		t.Logf("\tTest %d:\tWhen handling a single User.", testID)
 		{
-			ctx := context.Background()
+			orgID := uuid.NewString()
+			ctx := user.SetClaims(context.Background(), user.Claims{OrgID: orgID})
 			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
 			email, err := mail.ParseAddress("user@example.com")
 			if err != nil {
@@ -130,6 +170,7 @@ func Test_User(t *testing.T) {
 
 			// authenticat user
 			au := user.AuthenticateRequest{
+				OrgID:    orgID,
 				Username: email.Address,
 				Password: "gophers",
 			}
@@ -145,8 +186,15 @@ func Test_User(t *testing.T) {
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to authenticate user.", dbtest.Success, testID)
 
+			claims, err := signer.ValidateToken(auUsr.Token)
+			if err != nil || claims.Email != email.Address || claims.Name != updateName || claims.Issuer != user.DefaultIssuer {
+				t.Fatalf("\t%s\tTest %d:\tShould include the ID token claims : got %+v, %v.", dbtest.Failed, testID, claims, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould include the ID token claims.", dbtest.Success, testID)
+
 			// authenticat user
 			auf := user.AuthenticateRequest{
+				OrgID:    orgID,
 				Username: email.Address,
 				Password: "wrong password",
 			}
@@ -178,3 +226,1860 @@ func Test_User(t *testing.T) {
 		}
 	}
 }
+
+func Test_Group(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		storer := memory.NewStore()
+		testGroup(t, zap.NewNop().Sugar(), storer, memory.NewGroupStore(storer))
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testgroup")
+		testGroup(t, test.Log, nosql.NewStore(test.Log, test.DB), nosql.NewGroupStore(test.Log, test.DB))
+	})
+}
+
+// testGroup groups the users kept in storer with groupStorer.
+func testGroup(t *testing.T, log *zap.SugaredLogger, storer user.Storer, groupStorer user.GroupStorer) {
+	signer := newSigner(t)
+	core := user.NewUserServicer(log, storer, signer, user.WithGroupClaims(groupStorer))
+	groups := user.NewGroupServicer(log, groupStorer)
+
+	t.Log("Given the need to work with Group records.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a single Group.", testID)
+		{
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			email, _ := mail.ParseAddress("member@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Jane Doe"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+
+			cuUsr := core.CreateUser(nu, gr)
+			if cuUsr.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, cuUsr.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create user.", dbtest.Success, testID)
+
+			cg := user.CreateGroupRequest{NewGroup: user.NewGroup{Name: "Gophers"}}
+			cgGrp := groups.CreateGroup(cg, gr)
+			if cgGrp.Error != "" || cgGrp.Group.Name != "Gophers" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create group %+v : got %+v.", dbtest.Failed, testID, cg, cgGrp)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create group.", dbtest.Success, testID)
+
+			am := user.AddGroupMemberRequest{GroupID: cgGrp.Group.ID.String(), UserID: cuUsr.User.ID.String()}
+			if amResp := groups.AddGroupMember(am, gr); amResp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to add group member : %s.", dbtest.Failed, testID, amResp.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to add group member.", dbtest.Success, testID)
+
+			ug := groups.QueryUserGroups(user.QueryUserGroupsRequest{UserID: cuUsr.User.ID.String()}, gr)
+			if len(ug.Groups) != 1 || ug.Groups[0].ID != cgGrp.Group.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to list user groups : got %+v.", dbtest.Failed, testID, ug)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to list user groups.", dbtest.Success, testID)
+
+			gm := groups.QueryGroupMembers(user.QueryGroupMembersRequest{GroupID: cgGrp.Group.ID.String()}, gr)
+			if len(gm.Users) != 1 || gm.Users[0].ID != cuUsr.User.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to list group members : got %+v.", dbtest.Failed, testID, gm)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to list group members.", dbtest.Success, testID)
+
+			au := core.Authenticate(user.AuthenticateRequest{OrgID: orgID, Username: email.Address, Password: "gophers"}, gr)
+			claims, err := signer.ValidateToken(au.Token)
+			if err != nil || len(claims.Groups) != 1 || claims.Groups[0] != cgGrp.Group.ID.String() {
+				t.Fatalf("\t%s\tTest %d:\tShould include groups in token claims : got %+v, %v.", dbtest.Failed, testID, claims, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould include groups in token claims.", dbtest.Success, testID)
+
+			rm := user.RemoveGroupMemberRequest{GroupID: cgGrp.Group.ID.String(), UserID: cuUsr.User.ID.String()}
+			if rmResp := groups.RemoveGroupMember(rm, gr); rmResp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to remove group member : %s.", dbtest.Failed, testID, rmResp.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to remove group member.", dbtest.Success, testID)
+
+			dg := groups.DeleteGroup(user.DeleteGroupRequest{ID: cgGrp.Group.ID.String()}, gr)
+			if dg.Group.ID != cgGrp.Group.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete group : got %+v.", dbtest.Failed, testID, dg)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete group.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen deleting a group member.", testID)
+		{
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)},
+			}
+
+			email, _ := mail.ParseAddress("leaver@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Jane Doe"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+
+			cuUsr := core.CreateUser(nu, gr)
+			if cuUsr.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, cuUsr.Error)
+			}
+			cgGrp := groups.CreateGroup(user.CreateGroupRequest{NewGroup: user.NewGroup{Name: "Gophers"}}, gr)
+			if cgGrp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create group : %s.", dbtest.Failed, testID, cgGrp.Error)
+			}
+			am := user.AddGroupMemberRequest{GroupID: cgGrp.Group.ID.String(), UserID: cuUsr.User.ID.String()}
+			if amResp := groups.AddGroupMember(am, gr); amResp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to add group member : %s.", dbtest.Failed, testID, amResp.Error)
+			}
+
+			if du := core.DeleteUser(user.DeleteUserRequest{User: cuUsr.User}, gr); du.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, du.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete user.", dbtest.Success, testID)
+
+			cuUsr = core.CreateUser(nu, gr)
+			if cuUsr.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create the user again : %s.", dbtest.Failed, testID, cuUsr.Error)
+			}
+			ug := groups.QueryUserGroups(user.QueryUserGroupsRequest{UserID: cuUsr.User.ID.String()}, gr)
+			if ug.Error != "" || len(ug.Groups) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould not inherit the deleted user's groups : got %+v.", dbtest.Failed, testID, ug)
+			}
+			gm := groups.QueryGroupMembers(user.QueryGroupMembersRequest{GroupID: cgGrp.Group.ID.String()}, gr)
+			if gm.Error != "" || len(gm.Users) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould remove the deleted user from the group : got %+v.", dbtest.Failed, testID, gm)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not inherit the deleted user's groups.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Tenant(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		storer := memory.NewStore()
+		testTenant(t, zap.NewNop().Sugar(), storer, memory.NewGroupStore(storer), memory.NewOrgStore(storer))
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testtenant")
+		testTenant(t, test.Log, nosql.NewStore(test.Log, test.DB), nosql.NewGroupStore(test.Log, test.DB), nosql.NewOrgStore(test.Log, test.DB))
+	})
+}
+
+// testTenant keeps the users, groups and organizations kept in storer,
+// groupStorer and orgStorer isolated per organization.
+func testTenant(t *testing.T, log *zap.SugaredLogger, storer user.Storer, groupStorer user.GroupStorer, orgStorer user.OrgStorer) {
+	core := user.NewUserServicer(log, storer, newSigner(t))
+	groups := user.NewGroupServicer(log, groupStorer)
+	orgs := user.NewOrgServicer(log, orgStorer)
+
+	t.Log("Given the need to keep tenant data isolated.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling two Organizations.", testID)
+		{
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			super := server.GenericRequest{
+				Ctx:    context.Background(),
+				Claims: auth.Claims{Roles: []string{user.RoleSuperAdmin.Name()}},
+				Values: &values.Values{Now: now},
+			}
+
+			orgA := orgs.CreateOrg(user.CreateOrgRequest{NewOrganization: user.NewOrganization{Name: "Org A"}}, super)
+			orgB := orgs.CreateOrg(user.CreateOrgRequest{NewOrganization: user.NewOrganization{Name: "Org B"}}, super)
+			if orgA.Error != "" || orgB.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create organizations : %s %s.", dbtest.Failed, testID, orgA.Error, orgB.Error)
+			}
+			orgIDA, orgIDB := orgA.Organization.ID.String(), orgB.Organization.ID.String()
+			t.Logf("\t%s\tTest %d:\tShould be able to create organizations.", dbtest.Success, testID)
+
+			tenantReq := func(orgID string) server.GenericRequest {
+				claims := user.Claims{Claims: auth.Claims{Roles: []string{auth.RoleAdmin}}, OrgID: orgID}
+				return server.GenericRequest{
+					Ctx:    user.SetClaims(context.Background(), claims),
+					Claims: claims.Claims,
+					Values: &values.Values{Now: now},
+				}
+			}
+			grA := tenantReq(orgIDA)
+			grB := tenantReq(orgIDB)
+
+			email, _ := mail.ParseAddress("shared@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Shared Email"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+
+			usrA := core.CreateUser(nu, grA)
+			usrB := core.CreateUser(nu, grB)
+			if usrA.Error != "" || usrB.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould allow the same email in two organizations : %s %s.", dbtest.Failed, testID, usrA.Error, usrB.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould allow the same email in two organizations.", dbtest.Success, testID)
+
+			if dup := core.CreateUser(nu, grA); dup.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject a duplicate email within an organization : got %+v.", dbtest.Failed, testID, dup)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject a duplicate email within an organization.", dbtest.Success, testID)
+
+			if cross := core.CreateUser(user.CreateUserRequest{NewUser: nu.NewUser, OrgID: orgIDB}, grA); cross.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould forbid creating users in another organization : got %+v.", dbtest.Failed, testID, cross)
+			}
+			t.Logf("\t%s\tTest %d:\tShould forbid creating users in another organization.", dbtest.Success, testID)
+
+			if q := core.QueryUserByID(user.QueryUserByIDRequest{ID: usrA.User.ID.String()}, grB); q.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould not query another tenant's user by id : got %+v.", dbtest.Failed, testID, q)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not query another tenant's user by id.", dbtest.Success, testID)
+
+			q := core.QueryUserByEmail(user.QueryUserByEmailRequest{Email: email.Address}, grB)
+			if q.User.ID != usrB.User.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould only query the tenant's own user by email : got %+v.", dbtest.Failed, testID, q)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only query the tenant's own user by email.", dbtest.Success, testID)
+
+			grp := groups.CreateGroup(user.CreateGroupRequest{NewGroup: user.NewGroup{Name: "Team A"}}, grA)
+			if q := groups.QueryGroupByID(user.QueryGroupByIDRequest{ID: grp.Group.ID.String()}, grB); q.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould not query another tenant's group : got %+v.", dbtest.Failed, testID, q)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not query another tenant's group.", dbtest.Success, testID)
+
+			am := user.AddGroupMemberRequest{GroupID: grp.Group.ID.String(), UserID: usrB.User.ID.String()}
+			if resp := groups.AddGroupMember(am, grA); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould not add another tenant's user to a group.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not add another tenant's user to a group.", dbtest.Success, testID)
+
+			au := core.Authenticate(user.AuthenticateRequest{OrgID: orgIDB, Username: email.Address, Password: "gophers"}, grB)
+			if au.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould authenticate within an organization : %s.", dbtest.Failed, testID, au.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould authenticate within an organization.", dbtest.Success, testID)
+
+			if del := orgs.DeleteOrg(user.DeleteOrgRequest{ID: orgIDA}, super); del.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould not delete an organization with users.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not delete an organization with users.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Attributes(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testAttributes(t, zap.NewNop().Sugar(), memory.NewStore(), memory.NewAttributeStore())
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testattributes")
+		testAttributes(t, test.Log, nosql.NewStore(test.Log, test.DB), nosql.NewAttributeStore(test.Log, test.DB))
+	})
+}
+
+// testAttributes validates the attributes of the users kept in storer
+// against the schemas kept in attributeStorer.
+func testAttributes(t *testing.T, log *zap.SugaredLogger, storer user.Storer, attributeStorer user.AttributeStorer) {
+	core := user.NewUserServicer(log, storer, newSigner(t), user.WithAttributeSchemas(attributeStorer))
+	attributes := user.NewAttributeServicer(log, attributeStorer)
+
+	t.Log("Given the need to work with custom User attributes.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling an attribute schema.", testID)
+		{
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: uuid.NewString()}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			sa := user.SetAttributeSchemaRequest{Schema: []byte(`{
+				"type": "object",
+				"properties": {
+					"phone": {"type": "string", "pattern": "^\\+[0-9]+$"},
+					"cost_center": {"type": "integer"}
+				},
+				"required": ["cost_center"]
+			}`)}
+			if resp := attributes.SetAttributeSchema(sa, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to set attribute schema : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to set attribute schema.", dbtest.Success, testID)
+
+			email, _ := mail.ParseAddress("attrs@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Attr User"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			nu.NewUser.Attributes = map[string]any{"phone": "555-1234"}
+
+			if resp := core.CreateUser(nu, gr); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject invalid attributes : got %+v.", dbtest.Failed, testID, resp)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject invalid attributes.", dbtest.Success, testID)
+
+			nu.NewUser.Attributes = map[string]any{"phone": "+15551234", "cost_center": 42}
+			cuUsr := core.CreateUser(nu, gr)
+			if cuUsr.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould accept valid attributes : %s.", dbtest.Failed, testID, cuUsr.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould accept valid attributes.", dbtest.Success, testID)
+
+			qu := core.QueryUser(user.QueryUserRequest{Filter: user.QueryFilter{Attributes: map[string]any{"cost_center": 42}}}, gr)
+			if len(qu.Users) != 1 || qu.Users[0].Attributes["phone"] != "+15551234" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to filter users by attribute : got %+v.", dbtest.Failed, testID, qu)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to filter users by attribute.", dbtest.Success, testID)
+
+			uu := user.UpdateUserRequest{UpdateUser: user.UpdateUser{Email: email, Attributes: map[string]any{"cost_center": nil}}}
+			if resp := core.UpdateUser(uu, gr); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject removing a required attribute : got %+v.", dbtest.Failed, testID, resp)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject removing a required attribute.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Impersonation(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testImpersonation(t, zap.NewNop().Sugar(), memory.NewStore())
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testimpersonation")
+		testImpersonation(t, test.Log, nosql.NewStore(test.Log, test.DB))
+	})
+}
+
+// testImpersonation impersonates the users kept in storer.
+func testImpersonation(t *testing.T, log *zap.SugaredLogger, storer user.Storer) {
+	signer := newSigner(t)
+	core := user.NewUserServicer(log, storer, signer)
+
+	t.Log("Given the need to impersonate a User.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen an admin impersonates a user.", testID)
+		{
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			adminID := uuid.NewString()
+			admin := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: adminID}, Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			email, _ := mail.ParseAddress("target@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Target User"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			cuUsr := core.CreateUser(nu, admin)
+			if cuUsr.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, cuUsr.Error)
+			}
+
+			iu := core.ImpersonateUser(user.ImpersonateUserRequest{UserID: cuUsr.User.ID.String()}, admin)
+			if iu.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to impersonate user : %s.", dbtest.Failed, testID, iu.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to impersonate user.", dbtest.Success, testID)
+
+			claims, err := signer.ValidateToken(iu.Token)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to validate token : %s.", dbtest.Failed, testID, err)
+			}
+			if claims.Subject != cuUsr.User.ID.String() || claims.Act == nil || claims.Act.Subject != adminID {
+				t.Fatalf("\t%s\tTest %d:\tShould identify the admin in the act claim : got %+v.", dbtest.Failed, testID, claims)
+			}
+			if claims.ExpiresAt.Sub(claims.IssuedAt.Time) > 15*time.Minute {
+				t.Fatalf("\t%s\tTest %d:\tShould issue a short-lived token : got %v.", dbtest.Failed, testID, claims.ExpiresAt)
+			}
+			t.Logf("\t%s\tTest %d:\tShould identify the admin in the act claim.", dbtest.Success, testID)
+
+			impersonated := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), claims),
+				Claims: claims.Claims,
+				Values: &values.Values{Now: now},
+			}
+			cp := user.ChangePasswordRequest{CurrentPassword: "gophers", Password: "hijacked", PasswordConfirm: "hijacked"}
+			if resp := core.ChangePassword(cp, impersonated); resp.Error != user.ErrImpersonated.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould not change password while impersonating : got %+v.", dbtest.Failed, testID, resp)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not change password while impersonating.", dbtest.Success, testID)
+
+			if resp := core.ImpersonateUser(user.ImpersonateUserRequest{UserID: adminID}, impersonated); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould not impersonate with an impersonation token.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not impersonate with an impersonation token.", dbtest.Success, testID)
+
+			other := admin
+			other.Ctx = user.SetClaims(context.Background(), user.Claims{OrgID: uuid.NewString()})
+			if resp := core.ImpersonateUser(user.ImpersonateUserRequest{UserID: cuUsr.User.ID.String()}, other); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould not impersonate a user of another organization.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not impersonate a user of another organization.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Client(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testClient(t, zap.NewNop().Sugar(), memory.NewClientStore())
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testclient")
+		testClient(t, test.Log, nosql.NewClientStore(test.Log, test.DB))
+	})
+}
+
+// testClient issues tokens to the clients kept in clientStorer.
+func testClient(t *testing.T, log *zap.SugaredLogger, clientStorer user.ClientStorer) {
+	signer := newSigner(t)
+	clients := user.NewClientServicer(log, clientStorer, signer)
+
+	t.Log("Given the need to work with OAuth2 clients.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a single client.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			nc := user.CreateClientRequest{NewClient: user.NewClient{Name: "nightly job", Roles: []user.Role{user.RoleAdmin, user.RoleUser}}}
+			cc := clients.CreateClient(nc, gr)
+			if cc.Error != "" || cc.Secret == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create client : got %+v.", dbtest.Failed, testID, cc)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create client.", dbtest.Success, testID)
+
+			clientID := cc.Client.ID.String()
+			tkn, err := clients.ClientCredentials(ctx, clientID, cc.Secret, []string{"USER"})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to issue a token : %s.", dbtest.Failed, testID, err)
+			}
+			claims, err := signer.ValidateToken(tkn.Token)
+			if err != nil || claims.ClientID != clientID || claims.OrgID != orgID || len(claims.Roles) != 1 || claims.Roles[0] != "USER" {
+				t.Fatalf("\t%s\tTest %d:\tShould scope the token roles : got %+v, %v.", dbtest.Failed, testID, claims, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to issue a scoped token.", dbtest.Success, testID)
+
+			if _, err := clients.ClientCredentials(ctx, clientID, cc.Secret, []string{"SUPER_ADMIN"}); err != user.ErrInvalidScope {
+				t.Fatalf("\t%s\tTest %d:\tShould reject a scope beyond the client's roles : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject a scope beyond the client's roles.", dbtest.Success, testID)
+
+			rc := clients.RotateClientSecret(user.RotateClientSecretRequest{ID: clientID}, gr)
+			if rc.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to rotate secret : %s.", dbtest.Failed, testID, rc.Error)
+			}
+			if _, err := clients.ClientCredentials(ctx, clientID, cc.Secret, nil); err != user.ErrInvalidClient {
+				t.Fatalf("\t%s\tTest %d:\tShould reject the old secret : got %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := clients.ClientCredentials(ctx, clientID, rc.Secret, nil); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould accept the new secret : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to rotate secret.", dbtest.Success, testID)
+
+			if resp := clients.RevokeClient(user.RevokeClientRequest{ID: clientID}, gr); resp.Error != "" || !resp.Client.Revoked {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to revoke client : got %+v.", dbtest.Failed, testID, resp)
+			}
+			if _, err := clients.ClientCredentials(ctx, clientID, rc.Secret, nil); err != user.ErrInvalidClient {
+				t.Fatalf("\t%s\tTest %d:\tShould not issue tokens to a revoked client : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to revoke client.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Federation(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testFederation(t, zap.NewNop().Sugar(), memory.NewStore(), memory.NewIdentityStore())
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testfederation")
+		testFederation(t, test.Log, nosql.NewStore(test.Log, test.DB), nosql.NewIdentityStore(test.Log, test.DB))
+	})
+}
+
+// testFederation links federated identities in identities to the users kept in storer.
+func testFederation(t *testing.T, log *zap.SugaredLogger, storer user.Storer, identities user.IdentityStorer) {
+	orgID := uuid.NewString()
+	signer := newSigner(t)
+	fed := user.Federation{
+		Identities: identities,
+		OrgID:      orgID,
+		Roles:      []user.Role{user.RoleUser},
+	}
+	linking := user.NewUserServicer(log, storer, signer, user.WithFederation(fed))
+	fed.Provision = true
+	provisioning := user.NewUserServicer(log, storer, signer, user.WithFederation(fed))
+
+	t.Log("Given the need to sign in with federated identities.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen mapping identities to local users.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			email, _ := mail.ParseAddress("local@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Local User"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleAdmin}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			cuUsr := linking.CreateUser(nu, gr)
+			if cuUsr.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, cuUsr.Error)
+			}
+
+			id := user.FederatedIdentity{Issuer: "https://idp.example.com", Subject: "sub-1", Email: email.Address}
+			if _, err := linking.FederatedLogin(ctx, id); err != user.ErrNoLocalUser {
+				t.Fatalf("\t%s\tTest %d:\tShould not trust an unverified email : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not trust an unverified email.", dbtest.Success, testID)
+
+			id.EmailVerified = true
+			tkn, err := linking.FederatedLogin(ctx, id)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould map a verified email to the local user : %s.", dbtest.Failed, testID, err)
+			}
+			claims, _ := signer.ValidateToken(tkn)
+			if claims.Subject != cuUsr.User.ID.String() {
+				t.Fatalf("\t%s\tTest %d:\tShould map a verified email to the local user : got %+v.", dbtest.Failed, testID, claims)
+			}
+			t.Logf("\t%s\tTest %d:\tShould map a verified email to the local user.", dbtest.Success, testID)
+
+			// The subject is linked now, so the email no longer matters.
+			id.Email = "changed@example.com"
+			tkn, err = linking.FederatedLogin(ctx, id)
+			if claims, _ := signer.ValidateToken(tkn); err != nil || claims.Subject != cuUsr.User.ID.String() {
+				t.Fatalf("\t%s\tTest %d:\tShould map a linked subject to the local user : got %+v, %v.", dbtest.Failed, testID, claims, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould map a linked subject to the local user.", dbtest.Success, testID)
+
+			newID := user.FederatedIdentity{Issuer: "https://idp.example.com", Subject: "sub-2", Email: "new@example.com", EmailVerified: true, Name: "New User"}
+			if _, err := linking.FederatedLogin(ctx, newID); err != user.ErrNoLocalUser {
+				t.Fatalf("\t%s\tTest %d:\tShould not provision users unless enabled : got %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not provision users unless enabled.", dbtest.Success, testID)
+
+			if _, err := provisioning.FederatedLogin(ctx, newID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould provision users just in time : %s.", dbtest.Failed, testID, err)
+			}
+			usr, err := storer.QueryByEmail(ctx, orgID, "new@example.com")
+			if err != nil || usr.Name != "New User" || len(usr.Roles) != 1 || usr.Roles[0] != user.RoleUser {
+				t.Fatalf("\t%s\tTest %d:\tShould provision users just in time : got %+v, %v.", dbtest.Failed, testID, usr, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould provision users just in time.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Webhook(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testWebhook(t, zap.NewNop().Sugar(), memory.NewStore(), memory.NewWebhookStore())
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testwebhook")
+		testWebhook(t, test.Log, nosql.NewStore(test.Log, test.DB), nosql.NewWebhookStore(test.Log, test.DB))
+	})
+}
+
+// testWebhook sends the events of the users kept in storer to the webhooks kept in webhookStorer.
+func testWebhook(t *testing.T, log *zap.SugaredLogger, storer user.Storer, webhookStorer user.WebhookStorer) {
+	// The receiver fails the first attempt of every delivery.
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	received := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		attempts[r.Header.Get(user.HeaderDelivery)]++
+		n := attempts[r.Header.Get(user.HeaderDelivery)]
+		mu.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received <- r
+		bodies <- body
+	}))
+	t.Cleanup(receiver.Close)
+
+	dispatcher := user.NewWebhookDispatcher(log, webhookStorer, user.DispatcherConfig{MaxAttempts: 2, BaseDelay: 10 * time.Millisecond})
+	t.Cleanup(dispatcher.Close)
+	webhooks := user.NewWebhookServicer(log, webhookStorer, dispatcher)
+	users := user.NewUserServicer(log, storer, newSigner(t), user.WithEvents(dispatcher))
+
+	t.Log("Given the need to send user events to webhooks.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a user is created.", testID)
+		{
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			bad := webhooks.CreateWebhook(user.CreateWebhookRequest{NewWebhook: user.NewWebhook{URL: receiver.URL, Events: []string{"user.renamed"}}}, gr)
+			if bad.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject unknown event types.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject unknown event types.", dbtest.Success, testID)
+
+			cw := webhooks.CreateWebhook(user.CreateWebhookRequest{NewWebhook: user.NewWebhook{URL: receiver.URL, Events: []string{user.EventUserCreated}}}, gr)
+			if cw.Error != "" || cw.Secret == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create webhook : got %+v.", dbtest.Failed, testID, cw)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create webhook.", dbtest.Success, testID)
+
+			email, _ := mail.ParseAddress("hooked@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Hooked User"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			if resp := users.CreateUser(nu, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+
+			var r *http.Request
+			var body []byte
+			select {
+			case r = <-received:
+				body = <-bodies
+			case <-time.After(5 * time.Second):
+				t.Fatalf("\t%s\tTest %d:\tShould retry the delivery until it succeeds.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould retry the delivery until it succeeds.", dbtest.Success, testID)
+
+			var ts int64
+			fmt.Sscanf(r.Header.Get(user.HeaderSignature), "t=%d,", &ts)
+			if r.Header.Get(user.HeaderSignature) != user.Sign(cw.Secret, time.Unix(ts, 0), body) {
+				t.Fatalf("\t%s\tTest %d:\tShould sign the payload : got %q.", dbtest.Failed, testID, r.Header.Get(user.HeaderSignature))
+			}
+			var evt user.Event
+			if err := json.Unmarshal(body, &evt); err != nil || evt.Type != user.EventUserCreated || evt.Data.User.Email.Address != email.Address || evt.Data.User.PasswordHash != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould send the event : got %+v, %v.", dbtest.Failed, testID, evt, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould send the signed event.", dbtest.Success, testID)
+
+			// Wait for the successful attempt to be recorded.
+			succeeded := user.DeliverySucceeded
+			var qd user.QueryDeliveriesResponse
+			for i := 0; i < 50; i++ {
+				qd = webhooks.QueryDeliveries(user.QueryDeliveriesRequest{Filter: user.DeliveryFilter{Status: &succeeded}}, gr)
+				if len(qd.Deliveries) == 1 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if qd.Error != "" || len(qd.Deliveries) != 1 || qd.Deliveries[0].Attempts != 2 || qd.Deliveries[0].EventID != evt.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould log the delivery attempts : got %+v.", dbtest.Failed, testID, qd)
+			}
+			t.Logf("\t%s\tTest %d:\tShould log the delivery attempts.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the endpoint keeps failing.", testID)
+		{
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			t.Cleanup(down.Close)
+
+			if cw := webhooks.CreateWebhook(user.CreateWebhookRequest{NewWebhook: user.NewWebhook{URL: down.URL}}, gr); cw.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create webhook : %s.", dbtest.Failed, testID, cw.Error)
+			}
+
+			email, _ := mail.ParseAddress("dead@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Dead Letter"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			if resp := users.CreateUser(nu, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+
+			dead := user.DeliveryDead
+			var qd user.QueryDeliveriesResponse
+			for i := 0; i < 100; i++ {
+				qd = webhooks.QueryDeliveries(user.QueryDeliveriesRequest{Filter: user.DeliveryFilter{Status: &dead}}, gr)
+				if len(qd.Deliveries) == 1 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if qd.Error != "" || len(qd.Deliveries) != 1 || qd.Deliveries[0].Attempts != 2 || qd.Deliveries[0].LastStatusCode != http.StatusInternalServerError {
+				t.Fatalf("\t%s\tTest %d:\tShould move the delivery to the dead-letter queue : got %+v.", dbtest.Failed, testID, qd)
+			}
+			t.Logf("\t%s\tTest %d:\tShould move the delivery to the dead-letter queue.", dbtest.Success, testID)
+
+			rd := webhooks.RedeliverWebhook(user.RedeliverWebhookRequest{ID: qd.Deliveries[0].ID.String()}, gr)
+			if rd.Error != "" || rd.Delivery.Status != user.DeliveryPending || rd.Delivery.Attempts != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to redeliver : got %+v.", dbtest.Failed, testID, rd)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to redeliver.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Outbox(t *testing.T) {
+	test := setupArango(t, "testoutbox")
+	log := test.Log
+	db := test.DB
+
+	users := user.NewUserServicer(log, nosql.NewStore(log, db, nosql.WithOutbox()), newSigner(t))
+
+	t.Log("Given the need to publish user events reliably.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the process restarts between a change and its event.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			email, _ := mail.ParseAddress("outbox@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Outbox User"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			cuUsr := users.CreateUser(nu, gr)
+			if cuUsr.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, cuUsr.Error)
+			}
+
+			// No relay was running when the user was created, as if the
+			// process died right after the commit.
+			sink := &recordingSink{}
+			if n, err := nosql.NewOutboxRelay(log, db, sink).Relay(ctx); err != nil || n != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould relay the event after a restart : got %d, %v.", dbtest.Failed, testID, n, err)
+			}
+			if len(sink.events) != 1 || sink.events[0].Type != user.EventUserCreated || sink.events[0].Data.User.ID != cuUsr.User.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould relay the event after a restart : got %+v.", dbtest.Failed, testID, sink.events)
+			}
+			t.Logf("\t%s\tTest %d:\tShould relay the event after a restart.", dbtest.Success, testID)
+
+			if n, err := nosql.NewOutboxRelay(log, db, sink).Relay(ctx); err != nil || n != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould remove relayed events : got %d, %v.", dbtest.Failed, testID, n, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould remove relayed events.", dbtest.Success, testID)
+
+			if resp := users.CreateUser(nu, gr); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject a duplicate user.", dbtest.Failed, testID)
+			}
+			if n, err := nosql.NewOutboxRelay(log, db, sink).Relay(ctx); err != nil || n != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould not write events for failed changes : got %d, %v.", dbtest.Failed, testID, n, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not write events for failed changes.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a sink fails.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			email, _ := mail.ParseAddress("sink@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Sink User"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			if resp := users.CreateUser(nu, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			disabled := false
+			if resp := users.UpdateUser(user.UpdateUserRequest{UpdateUser: user.UpdateUser{Email: email, Enabled: &disabled}}, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to disable user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+
+			// The first sink accepts the event but the second does not, as if
+			// the relay died before it could remove the event.
+			first, second := &recordingSink{}, &recordingSink{fail: true}
+			if _, err := nosql.NewOutboxRelay(log, db, first, second).Relay(ctx); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould report the failed sink.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould report the failed sink.", dbtest.Success, testID)
+
+			second.fail = false
+			if n, err := nosql.NewOutboxRelay(log, db, first, second).Relay(ctx); err != nil || n != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould retry the event : got %d, %v.", dbtest.Failed, testID, n, err)
+			}
+			if len(first.events) != 3 || first.events[0].ID != first.events[1].ID {
+				t.Fatalf("\t%s\tTest %d:\tShould deliver the event at least once : got %+v.", dbtest.Failed, testID, first.events)
+			}
+			if len(second.events) != 2 || second.events[0].Type != user.EventUserCreated || second.events[1].Type != user.EventUserDisabled {
+				t.Fatalf("\t%s\tTest %d:\tShould deliver the events in order : got %+v.", dbtest.Failed, testID, second.events)
+			}
+			t.Logf("\t%s\tTest %d:\tShould deliver the events in order, at least once.", dbtest.Success, testID)
+
+			file := filepath.Join(t.TempDir(), "events.jsonl")
+			fileSink, err := user.OpenFileSink(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { fileSink.Close() })
+			if resp := users.DeleteUser(user.DeleteUserRequest{User: user.User{Email: *email}}, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			if _, err := nosql.NewOutboxRelay(log, db, fileSink).Relay(ctx); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould write events to a file : %s.", dbtest.Failed, testID, err)
+			}
+			b, _ := os.ReadFile(file)
+			var evt user.Event
+			if err := json.Unmarshal(b, &evt); err != nil || evt.Type != user.EventUserDeleted || evt.Data.User.Email.Address != email.Address {
+				t.Fatalf("\t%s\tTest %d:\tShould write events to a file : got %s, %v.", dbtest.Failed, testID, b, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould write events to a file.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a sink always rejects an event.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			var created []user.User
+			for _, name := range []string{"poison", "healthy"} {
+				email, _ := mail.ParseAddress(name + "@example.com")
+				nu := user.CreateUserRequest{}
+				nu.NewUser.Name = name
+				nu.NewUser.Email = *email
+				nu.NewUser.Roles = []user.Role{user.RoleUser}
+				nu.NewUser.Password = "gophers"
+				nu.NewUser.PasswordConfirm = "gophers"
+				resp := users.CreateUser(nu, gr)
+				if resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+				}
+				created = append(created, resp.User)
+			}
+
+			sink := &recordingSink{reject: created[0].ID}
+			relay := nosql.NewOutboxRelay(log, db, sink)
+			relay.MaxAttempts = 2
+			if n, err := relay.Relay(ctx); err == nil || n != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould hold back later events while retrying : got %d, %v.", dbtest.Failed, testID, n, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould hold back later events while retrying.", dbtest.Success, testID)
+
+			if n, err := relay.Relay(ctx); err != nil || n != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould give up on the event : got %d, %v.", dbtest.Failed, testID, n, err)
+			}
+			if len(sink.events) != 1 || sink.events[0].Data.User.ID != created[1].ID {
+				t.Fatalf("\t%s\tTest %d:\tShould publish the events behind it : got %+v.", dbtest.Failed, testID, sink.events)
+			}
+			if n, err := relay.Relay(ctx); err != nil || n != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould not retry dead events : got %d, %v.", dbtest.Failed, testID, n, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould give up on the event and publish the events behind it.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_LoginHistory(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testLoginHistory(t, zap.NewNop().Sugar(), memory.NewStore(), memory.NewLoginStore())
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testloginhistory")
+		testLoginHistory(t, test.Log, nosql.NewStore(test.Log, test.DB), nosql.NewLoginStore(test.Log, test.DB))
+	})
+}
+
+// testLoginHistory records the sign ins of the users kept in storer in loginStorer.
+func testLoginHistory(t *testing.T, log *zap.SugaredLogger, storer user.Storer, loginStorer user.LoginStorer) {
+	const keep = 3
+	core := user.NewUserServicer(log, storer, newSigner(t), user.WithLoginHistory(loginStorer, keep))
+
+	t.Log("Given the need to track sign ins.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a user signs in.", testID)
+		{
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			var usrs []user.User
+			for _, addr := range []string{"never@example.com", "active@example.com"} {
+				email, _ := mail.ParseAddress(addr)
+				nu := user.CreateUserRequest{}
+				nu.NewUser.Name = addr
+				nu.NewUser.Email = *email
+				nu.NewUser.Roles = []user.Role{user.RoleUser}
+				nu.NewUser.Password = "gophers"
+				nu.NewUser.PasswordConfirm = "gophers"
+				resp := core.CreateUser(nu, gr)
+				if resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+				}
+				usrs = append(usrs, resp.User)
+			}
+			active := usrs[1]
+
+			client := user.SetClientInfo(context.Background(), user.ClientInfo{IP: "203.0.113.7", UserAgent: "curl/8.0"})
+			for i, password := range []string{"wrong", "gophers", "gophers", "gophers"} {
+				at := now.Add(time.Duration(i) * time.Minute)
+				au := core.Authenticate(user.AuthenticateRequest{OrgID: orgID, Username: active.Email.Address, Password: password}, server.GenericRequest{Ctx: client, Values: &values.Values{Now: at}})
+				if (password == "gophers") != (au.Error == "") {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to authenticate : got %+v.", dbtest.Failed, testID, au)
+				}
+			}
+
+			qh := core.QueryLoginHistory(user.QueryLoginHistoryRequest{UserID: active.ID.String()}, gr)
+			if qh.Error != "" || !qh.LastLoginAt.Equal(now.Add(3*time.Minute)) || qh.LastLoginIP != "203.0.113.7" {
+				t.Fatalf("\t%s\tTest %d:\tShould record the last login : got %+v.", dbtest.Failed, testID, qh)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record the last login.", dbtest.Success, testID)
+
+			if len(qh.Logins) != keep || !qh.Logins[0].DateCreated.Equal(now.Add(3*time.Minute)) || qh.Logins[0].UserAgent != "curl/8.0" || qh.Logins[0].Method != user.LoginPassword {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the latest logins : got %+v.", dbtest.Failed, testID, qh.Logins)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the latest logins.", dbtest.Success, testID)
+
+			// The failed attempt was the oldest, so it was dropped.
+			failed := false
+			qh = core.QueryLoginHistory(user.QueryLoginHistoryRequest{UserID: active.ID.String(), Filter: user.LoginFilter{Success: &failed}}, gr)
+			if qh.Error != "" || len(qh.Logins) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould cap the history : got %+v.", dbtest.Failed, testID, qh)
+			}
+			t.Logf("\t%s\tTest %d:\tShould cap the history.", dbtest.Success, testID)
+
+			before := now.Add(time.Minute)
+			qu := core.QueryUser(user.QueryUserRequest{Filter: user.QueryFilter{LastLoginBefore: &before}}, gr)
+			if qu.Error != "" || len(qu.Users) != 1 || qu.Users[0].ID != usrs[0].ID {
+				t.Fatalf("\t%s\tTest %d:\tShould filter users by last login : got %+v.", dbtest.Failed, testID, qu)
+			}
+			t.Logf("\t%s\tTest %d:\tShould filter users by last login.", dbtest.Success, testID)
+
+			qu = core.QueryUser(user.QueryUserRequest{OrderBy: &user.OrderBy{Field: user.OrderByLastLoginAt, Direction: user.DESC}}, gr)
+			if qu.Error != "" || len(qu.Users) != 2 || qu.Users[0].ID != active.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould order users by last login : got %+v.", dbtest.Failed, testID, qu)
+			}
+			t.Logf("\t%s\tTest %d:\tShould order users by last login.", dbtest.Success, testID)
+
+			qu = core.QueryUser(user.QueryUserRequest{OrderBy: &user.OrderBy{Field: "password_hash", Direction: user.ASC}}, gr)
+			if qu.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject unknown order fields.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject unknown order fields.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Scheduler(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testScheduler(t, zap.NewNop().Sugar(), memory.NewStore(), memory.NewJobStore())
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testscheduler")
+		testScheduler(t, test.Log, nosql.NewStore(test.Log, test.DB), nosql.NewJobStore(test.Log, test.DB))
+	})
+}
+
+// testScheduler runs jobs locked with jobStorer against the users kept in
+// storer.
+func testScheduler(t *testing.T, log *zap.SugaredLogger, storer interface {
+	user.Storer
+	user.DormantStorer
+	user.DeletedPurger
+}, jobStorer user.JobStorer) {
+	core := user.NewUserServicer(log, storer, newSigner(t))
+
+	t.Log("Given the need to run background jobs.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen several replicas schedule a job.", testID)
+		{
+			ctx := context.Background()
+			var runs int
+			job := user.Job{Name: "count", Spec: "@hourly", Run: func(ctx context.Context, now time.Time) error {
+				runs++
+				return nil
+			}}
+
+			a, b := user.NewScheduler(log, jobStorer), user.NewScheduler(log, jobStorer)
+			if err := errors.Join(a.Add(job), b.Add(job)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to add the job : %s.", dbtest.Failed, testID, err)
+			}
+
+			slot := time.Date(2018, time.October, 1, 10, 0, 0, 0, time.UTC)
+			ranA, errA := a.RunJob(ctx, job.Name, slot)
+			ranB, errB := b.RunJob(ctx, job.Name, slot)
+			if err := errors.Join(errA, errB); err != nil || !ranA || ranB || runs != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould run the job once per slot : got %v, %v, %d, %v.", dbtest.Failed, testID, ranA, ranB, runs, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould run the job once per slot.", dbtest.Success, testID)
+
+			if ran, err := b.RunJob(ctx, job.Name, slot.Add(time.Hour)); err != nil || !ran || runs != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould let any replica run the next slot : got %v, %d, %v.", dbtest.Failed, testID, ran, runs, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould let any replica run the next slot.", dbtest.Success, testID)
+
+			js := user.NewJobServicer(log, jobStorer)
+			qr := js.QueryJobRuns(user.QueryJobRunsRequest{Job: job.Name}, server.GenericRequest{Ctx: ctx})
+			if qr.Error != "" || len(qr.Runs) != 2 || qr.Runs[0].Status != user.JobSucceeded || !qr.Runs[0].ScheduledAt.Equal(slot.Add(time.Hour)) {
+				t.Fatalf("\t%s\tTest %d:\tShould record the job history : got %+v.", dbtest.Failed, testID, qr)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record the job history.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen users stop signing in.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			for _, addr := range []string{"dormant@example.com", "active@example.com"} {
+				email, _ := mail.ParseAddress(addr)
+				nu := user.CreateUserRequest{}
+				nu.NewUser.Name = addr
+				nu.NewUser.Email = *email
+				nu.NewUser.Roles = []user.Role{user.RoleUser}
+				nu.NewUser.Password = "gophers"
+				nu.NewUser.PasswordConfirm = "gophers"
+				if resp := core.CreateUser(nu, gr); resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+				}
+			}
+			later := now.Add(60 * 24 * time.Hour)
+			if err := storer.UpdateLastLogin(ctx, orgID, "active@example.com", later, "203.0.113.7"); err != nil {
+				t.Fatal(err)
+			}
+
+			dormant := user.DisableDormantUsers(log, storer, 90*24*time.Hour)
+			if err := dormant(ctx, now.Add(100*24*time.Hour)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to disable dormant users : %s.", dbtest.Failed, testID, err)
+			}
+
+			d, _ := storer.QueryByEmail(ctx, orgID, "dormant@example.com")
+			a, _ := storer.QueryByEmail(ctx, orgID, "active@example.com")
+			if d.Enabled || !a.Enabled {
+				t.Fatalf("\t%s\tTest %d:\tShould disable only dormant users : got %v, %v.", dbtest.Failed, testID, d.Enabled, a.Enabled)
+			}
+			t.Logf("\t%s\tTest %d:\tShould disable only dormant users.", dbtest.Success, testID)
+
+			au := core.Authenticate(user.AuthenticateRequest{OrgID: orgID, Username: "dormant@example.com", Password: "gophers"}, gr)
+			if au.Error != user.ErrUserDisabled.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould not let disabled users sign in : got %+v.", dbtest.Failed, testID, au)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not let disabled users sign in.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen deleted users are kept for a retention period.", testID)
+		{
+			ctx := context.Background()
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: time.Now()},
+			}
+
+			deleteUser := func(addr string) {
+				email, _ := mail.ParseAddress(addr)
+				nu := user.CreateUserRequest{}
+				nu.NewUser.Name = addr
+				nu.NewUser.Email = *email
+				nu.NewUser.Roles = []user.Role{user.RoleUser}
+				nu.NewUser.Password = "gophers"
+				nu.NewUser.PasswordConfirm = "gophers"
+				if resp := core.CreateUser(nu, gr); resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+				}
+				if _, err := storer.Delete(ctx, orgID, *email); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, err)
+				}
+			}
+
+			purge := user.PurgeDeletedUsers(log, storer, time.Hour)
+			deleteUser("kept@example.com")
+			if err := purge(ctx, time.Now()); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to purge deleted users : %s.", dbtest.Failed, testID, err)
+			}
+			if n, err := storer.PurgeDeleted(ctx, time.Now().Add(time.Minute), 10); err != nil || n != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould keep users deleted within the retention period : got %d, %v.", dbtest.Failed, testID, n, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep users deleted within the retention period.", dbtest.Success, testID)
+
+			deleteUser("purged@example.com")
+			if err := purge(ctx, time.Now().Add(2*time.Hour)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to purge deleted users : %s.", dbtest.Failed, testID, err)
+			}
+			if n, err := storer.PurgeDeleted(ctx, time.Now().Add(time.Minute), 10); err != nil || n != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould purge users deleted before the retention period : got %d left, %v.", dbtest.Failed, testID, n, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould purge users deleted before the retention period.", dbtest.Success, testID)
+		}
+	}
+}
+
+// recordingSink records the events published to it, or rejects them when
+// fail is set.
+type recordingSink struct {
+	events []user.Event
+	fail   bool
+	// reject is the ID of a user whose events the sink always rejects.
+	reject uuid.UUID
+}
+
+func (s *recordingSink) Publish(ctx context.Context, evt user.Event) error {
+	if s.fail {
+		return errors.New("sink unavailable")
+	}
+	if evt.Data.User.ID == s.reject {
+		return errors.New("event rejected")
+	}
+	s.events = append(s.events, evt)
+	return nil
+}
+
+func Test_Tokens(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testTokens(t, zap.NewNop().Sugar(), memory.NewStore(), memory.NewTokenStore())
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testtokens")
+		testTokens(t, test.Log, nosql.NewStore(test.Log, test.DB), nosql.NewTokenStore(test.Log, test.DB))
+	})
+}
+
+// testTokens resets passwords and invites the users kept in storer with
+// the tokens kept in tokens.
+func testTokens(t *testing.T, log *zap.SugaredLogger, storer user.Storer, tokens user.TokenStorer) {
+	core := user.NewUserServicer(log, storer, newSigner(t), user.WithTokens(tokens, user.DefaultTokenConfig))
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+	orgID := uuid.NewString()
+	admin := server.GenericRequest{
+		Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+		Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+		Values: &values.Values{Now: now},
+	}
+	anonymous := func(at time.Time) server.GenericRequest {
+		return server.GenericRequest{Ctx: context.Background(), Values: &values.Values{Now: at}}
+	}
+	authenticate := func(email string, password string) user.AuthenticateResponse {
+		return core.Authenticate(user.AuthenticateRequest{OrgID: orgID, Username: email, Password: password}, anonymous(now))
+	}
+
+	email, _ := mail.ParseAddress("reset@example.com")
+	nu := user.CreateUserRequest{}
+	nu.NewUser.Name = "Reset"
+	nu.NewUser.Email = *email
+	nu.NewUser.Roles = []user.Role{user.RoleUser}
+	nu.NewUser.Password = "gophers"
+	nu.NewUser.PasswordConfirm = "gophers"
+	if resp := core.CreateUser(nu, admin); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+
+	t.Log("Given the need to let users choose their password with a single use token.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen an admin issues a password reset.", testID)
+		{
+			ir := core.IssuePasswordReset(user.IssuePasswordResetRequest{Email: email.Address}, admin)
+			if ir.Error != "" || ir.Token == "" || !ir.ExpiresAt.Equal(now.Add(user.DefaultTokenConfig.PasswordResetTTL)) {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to issue a password reset : got %+v.", dbtest.Failed, testID, ir)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to issue a password reset.", dbtest.Success, testID)
+
+			req := user.ResetPasswordRequest{Token: ir.Token, Password: "gophers2", PasswordConfirm: "gophers3"}
+			if rr := core.ResetPassword(req, anonymous(now)); rr.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse passwords that don't match.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse passwords that don't match.", dbtest.Success, testID)
+
+			req.PasswordConfirm = req.Password
+			if rr := core.ResetPassword(req, anonymous(now)); rr.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reset the password : %s.", dbtest.Failed, testID, rr.Error)
+			}
+			if au := authenticate(email.Address, "gophers2"); au.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould sign in with the new password : %s.", dbtest.Failed, testID, au.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould sign in with the new password.", dbtest.Success, testID)
+
+			if rr := core.ResetPassword(req, anonymous(now)); rr.Error != user.ErrTokenInvalid.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould only accept a token once : got %q.", dbtest.Failed, testID, rr.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only accept a token once.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen an admin invites a user.", testID)
+		{
+			invited, _ := mail.ParseAddress("invited@example.com")
+			req := user.InviteUserRequest{User: user.InvitedUser{Name: "Invited", Email: *invited, Roles: []user.Role{user.RoleUser}}}
+			iu := core.InviteUser(req, admin)
+			if iu.Error != "" || iu.Token == "" || iu.User.Enabled {
+				t.Fatalf("\t%s\tTest %d:\tShould create a disabled user and an invitation : got %+v.", dbtest.Failed, testID, iu)
+			}
+			t.Logf("\t%s\tTest %d:\tShould create a disabled user and an invitation.", dbtest.Success, testID)
+
+			reset := user.ResetPasswordRequest{Token: iu.Token, Password: "gophers", PasswordConfirm: "gophers"}
+			if rr := core.ResetPassword(reset, anonymous(now)); rr.Error != user.ErrTokenInvalid.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould not reset passwords with an invitation : got %q.", dbtest.Failed, testID, rr.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not reset passwords with an invitation.", dbtest.Success, testID)
+
+			accept := user.AcceptInvitationRequest{Token: iu.Token, Password: "gophers", PasswordConfirm: "gophers"}
+			ar := core.AcceptInvitation(accept, anonymous(now.Add(time.Hour)))
+			if ar.Error != "" || !ar.User.Enabled {
+				t.Fatalf("\t%s\tTest %d:\tShould enable the user when they accept : got %+v.", dbtest.Failed, testID, ar)
+			}
+			if au := authenticate(invited.Address, "gophers"); au.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould sign in with the chosen password : %s.", dbtest.Failed, testID, au.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould sign in with the chosen password.", dbtest.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen tokens expire.", testID)
+		{
+			ctx := context.Background()
+			ttl := user.DefaultTokenConfig.PasswordResetTTL
+			expire := user.ExpireTokens(log, tokens)
+
+			ir := core.IssuePasswordReset(user.IssuePasswordResetRequest{Email: email.Address}, admin)
+			req := user.ResetPasswordRequest{Token: ir.Token, Password: "gophers4", PasswordConfirm: "gophers4"}
+			if rr := core.ResetPassword(req, anonymous(now.Add(ttl))); rr.Error != user.ErrTokenInvalid.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse expired tokens : got %q.", dbtest.Failed, testID, rr.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse expired tokens.", dbtest.Success, testID)
+
+			if err := expire(ctx, now.Add(ttl/2)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to expire tokens : %s.", dbtest.Failed, testID, err)
+			}
+			if rr := core.ResetPassword(req, anonymous(now.Add(ttl/2))); rr.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould keep tokens that haven't expired : %s.", dbtest.Failed, testID, rr.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep tokens that haven't expired.", dbtest.Success, testID)
+
+			ir = core.IssuePasswordReset(user.IssuePasswordResetRequest{Email: email.Address}, admin)
+			if err := expire(ctx, now.Add(2*ttl)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to expire tokens : %s.", dbtest.Failed, testID, err)
+			}
+			if n, err := tokens.DeleteExpired(ctx, now.Add(2*ttl), 10); err != nil || n != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould remove expired tokens : got %d left, %v.", dbtest.Failed, testID, n, err)
+			}
+			req.Token = ir.Token
+			if rr := core.ResetPassword(req, anonymous(now)); rr.Error != user.ErrTokenInvalid.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould remove expired tokens : got %q.", dbtest.Failed, testID, rr.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould remove expired tokens.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_PasswordExpiry(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testPasswordExpiry(t, zap.NewNop().Sugar(), memory.NewStore())
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testpasswordexpiry")
+		testPasswordExpiry(t, test.Log, nosql.NewStore(test.Log, test.DB))
+	})
+}
+
+// testPasswordExpiry expires the passwords of the users kept in storer.
+func testPasswordExpiry(t *testing.T, log *zap.SugaredLogger, storer user.Storer) {
+	policy, err := user.ParsePasswordPolicy([]byte(`{"roles": {"USER": "720h"}, "departments": {"finance": "24h"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := newSigner(t)
+	core := user.NewUserServicer(log, storer, signer, user.WithPasswordPolicy(policy))
+
+	t.Log("Given the need to expire passwords.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a password is older than the policy allows.", testID)
+		{
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: now},
+			}
+
+			email, _ := mail.ParseAddress("finance@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Finance"
+			nu.NewUser.Email = *email
+			nu.NewUser.Roles = []user.Role{user.RoleUser}
+			nu.NewUser.Department = "finance"
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			if resp := core.CreateUser(nu, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+
+			authenticate := func(password string, at time.Time) user.AuthenticateResponse {
+				req := user.AuthenticateRequest{OrgID: orgID, Username: email.Address, Password: password}
+				return core.Authenticate(req, server.GenericRequest{Ctx: context.Background(), Values: &values.Values{Now: at}})
+			}
+
+			au := authenticate("gophers", now.Add(time.Hour))
+			if au.Error != "" || au.PasswordChangeRequired {
+				t.Fatalf("\t%s\tTest %d:\tShould issue a full token before the password expires : got %+v.", dbtest.Failed, testID, au)
+			}
+			t.Logf("\t%s\tTest %d:\tShould issue a full token before the password expires.", dbtest.Success, testID)
+
+			// The department policy is shorter than the role policy.
+			expired := now.Add(25 * time.Hour)
+			au = authenticate("gophers", expired)
+			if au.Error != "" || !au.PasswordChangeRequired {
+				t.Fatalf("\t%s\tTest %d:\tShould require a password change : got %+v.", dbtest.Failed, testID, au)
+			}
+			claims, err := signer.ValidateToken(au.Token)
+			if err != nil || !claims.PasswordChange || len(claims.Roles) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould issue a token without roles : got %+v, %v.", dbtest.Failed, testID, claims, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould issue a token without roles.", dbtest.Success, testID)
+
+			change := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), claims),
+				Claims: claims.Claims,
+				Values: &values.Values{Now: expired},
+			}
+			cp := core.ChangePassword(user.ChangePasswordRequest{CurrentPassword: "gophers", Password: "gophers", PasswordConfirm: "gophers"}, change)
+			if cp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject reusing the password.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject reusing the password.", dbtest.Success, testID)
+
+			cp = core.ChangePassword(user.ChangePasswordRequest{CurrentPassword: "gophers", Password: "gophers2", PasswordConfirm: "gophers2"}, change)
+			if cp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to change the password : %s.", dbtest.Failed, testID, cp.Error)
+			}
+			full, err := signer.ValidateToken(cp.Token)
+			if err != nil || full.PasswordChange || len(full.Roles) == 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould issue a full token : got %+v, %v.", dbtest.Failed, testID, full, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to change the password.", dbtest.Success, testID)
+
+			au = authenticate("gophers2", expired.Add(time.Hour))
+			if au.Error != "" || au.PasswordChangeRequired {
+				t.Fatalf("\t%s\tTest %d:\tShould sign in with the new password : got %+v.", dbtest.Failed, testID, au)
+			}
+			t.Logf("\t%s\tTest %d:\tShould sign in with the new password.", dbtest.Success, testID)
+
+			must := true
+			uu := core.UpdateUser(user.UpdateUserRequest{UpdateUser: user.UpdateUser{Email: email, MustChangePassword: &must}}, gr)
+			if uu.Error != "" || !uu.User.MustChangePassword {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to force a password change : got %+v.", dbtest.Failed, testID, uu)
+			}
+			au = authenticate("gophers2", expired.Add(time.Hour))
+			if au.Error != "" || !au.PasswordChangeRequired {
+				t.Fatalf("\t%s\tTest %d:\tShould require a forced password change : got %+v.", dbtest.Failed, testID, au)
+			}
+			t.Logf("\t%s\tTest %d:\tShould require a forced password change.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_SearchUsers(t *testing.T) {
+	test := setupArango(t, "testsearchusers")
+	log := test.Log
+	db := test.DB
+
+	// The search view is created by a migration.
+	if _, err := nosql.MigrateUp(context.Background(), db); err != nil {
+		t.Fatalf("\t%s\tShould be able to migrate the database : %s.", dbtest.Failed, err)
+	}
+
+	store := nosql.NewStore(log, db)
+	depts := nosql.NewDepartmentStore(log, db)
+	core := user.NewUserServicer(log, store, newSigner(t), user.WithSearch(store), user.WithDepartments(depts))
+
+	t.Log("Given the need to search users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen searching by partial and misspelled words.", testID)
+		{
+			orgID := uuid.NewString()
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: orgID}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)},
+			}
+
+			deptIDs := make(map[string]string)
+			for _, name := range []string{"Engineering", "Finance"} {
+				resp := user.NewDepartmentServicer(log, depts).CreateDepartment(user.CreateDepartmentRequest{NewDepartment: user.NewDepartment{Name: name}}, gr)
+				if resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create department : %s.", dbtest.Failed, testID, resp.Error)
+				}
+				deptIDs[name] = resp.Department.ID.String()
+			}
+
+			people := []struct{ name, email, department string }{
+				{"Jonathan Smith", "jsmith@example.com", deptIDs["Engineering"]},
+				{"Jon Smithers", "jon@example.com", deptIDs["Finance"]},
+				{"Maria Garcia", "mgarcia@example.com", deptIDs["Engineering"]},
+			}
+			for _, p := range people {
+				email, _ := mail.ParseAddress(p.email)
+				nu := user.CreateUserRequest{}
+				nu.NewUser.Name = p.name
+				nu.NewUser.Email = *email
+				nu.NewUser.Roles = []user.Role{user.RoleUser}
+				nu.NewUser.Department = p.department
+				nu.NewUser.Password = "gophers"
+				nu.NewUser.PasswordConfirm = "gophers"
+				if resp := core.CreateUser(nu, gr); resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+				}
+			}
+
+			// The view is updated asynchronously.
+			search := func(query string, want int) user.SearchUsersResponse {
+				var resp user.SearchUsersResponse
+				for i := 0; i < 50; i++ {
+					resp = core.SearchUsers(user.SearchUsersRequest{Query: query}, gr)
+					if resp.Error != "" || len(resp.Results) == want {
+						break
+					}
+					time.Sleep(100 * time.Millisecond)
+				}
+				return resp
+			}
+
+			resp := search("smith", 2)
+			if resp.Error != "" || len(resp.Results) != 2 || resp.Results[0].User.Name != "Jonathan Smith" {
+				t.Fatalf("\t%s\tTest %d:\tShould rank exact words above prefixes : got %+v.", dbtest.Failed, testID, resp)
+			}
+			if resp.Results[0].Highlights["name"] != "Jonathan <em>Smith</em>" {
+				t.Fatalf("\t%s\tTest %d:\tShould highlight the match : got %+v.", dbtest.Failed, testID, resp.Results[0].Highlights)
+			}
+			t.Logf("\t%s\tTest %d:\tShould rank and highlight matches.", dbtest.Success, testID)
+
+			resp = search("engneering mar", 1)
+			if resp.Error != "" || len(resp.Results) != 1 || resp.Results[0].User.Name != "Maria Garcia" {
+				t.Fatalf("\t%s\tTest %d:\tShould match misspelled words : got %+v.", dbtest.Failed, testID, resp)
+			}
+			if resp.Results[0].Highlights["department"] != "<em>Engineering</em>" {
+				t.Fatalf("\t%s\tTest %d:\tShould highlight the department : got %+v.", dbtest.Failed, testID, resp.Results[0].Highlights)
+			}
+			t.Logf("\t%s\tTest %d:\tShould match misspelled words.", dbtest.Success, testID)
+
+			other := gr
+			other.Ctx = user.SetClaims(context.Background(), user.Claims{OrgID: uuid.NewString()})
+			resp = core.SearchUsers(user.SearchUsersRequest{Query: "smith"}, other)
+			if resp.Error != "" || len(resp.Results) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould only search the caller's organization : got %+v.", dbtest.Failed, testID, resp)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only search the caller's organization.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Department(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		storer := memory.NewStore()
+		testDepartment(t, zap.NewNop().Sugar(), storer, memory.NewDepartmentStore(storer))
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testdepartment")
+		testDepartment(t, test.Log, nosql.NewStore(test.Log, test.DB), nosql.NewDepartmentStore(test.Log, test.DB))
+	})
+}
+
+// testDepartment organizes the users kept in storer in the departments kept
+// in departmentStorer.
+func testDepartment(t *testing.T, log *zap.SugaredLogger, storer user.Storer, departmentStorer user.DepartmentStorer) {
+	core := user.NewDepartmentServicer(log, departmentStorer)
+	users := user.NewUserServicer(log, storer, newSigner(t), user.WithDepartments(departmentStorer))
+
+	t.Log("Given the need to organize users in departments.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a department tree.", testID)
+		{
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: uuid.NewString()}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)},
+			}
+
+			create := func(name string, parentID string) user.Department {
+				resp := core.CreateDepartment(user.CreateDepartmentRequest{NewDepartment: user.NewDepartment{Name: name, ParentID: parentID}}, gr)
+				if resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create department %s : %s.", dbtest.Failed, testID, name, resp.Error)
+				}
+				return resp.Department
+			}
+			company := create("Company", "")
+			engineering := create("Engineering", company.ID.String())
+			platform := create("Platform", engineering.ID.String())
+			operations := create("Operations", "")
+			if platform.ParentID != engineering.ID.String() {
+				t.Fatalf("\t%s\tTest %d:\tShould set the parent : got %+v.", dbtest.Failed, testID, platform)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create departments.", dbtest.Success, testID)
+
+			if resp := core.CreateDepartment(user.CreateDepartmentRequest{NewDepartment: user.NewDepartment{Name: "Orphan", ParentID: uuid.NewString()}}, gr); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject unknown parents.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject unknown parents.", dbtest.Success, testID)
+
+			for i, dept := range []user.Department{engineering, platform} {
+				email, _ := mail.ParseAddress(fmt.Sprintf("user%d@example.com", i))
+				nu := user.CreateUserRequest{}
+				nu.NewUser.Name = dept.Name
+				nu.NewUser.Email = *email
+				nu.NewUser.Roles = []user.Role{user.RoleUser}
+				nu.NewUser.Department = dept.ID.String()
+				nu.NewUser.Password = "gophers"
+				nu.NewUser.PasswordConfirm = "gophers"
+				if resp := users.CreateUser(nu, gr); resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+				}
+			}
+
+			email, _ := mail.ParseAddress("nobody@example.com")
+			nu := user.CreateUserRequest{}
+			nu.NewUser.Name = "Nobody"
+			nu.NewUser.Email = *email
+			nu.NewUser.Department = uuid.NewString()
+			nu.NewUser.Password = "gophers"
+			nu.NewUser.PasswordConfirm = "gophers"
+			if resp := users.CreateUser(nu, gr); resp.Error == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject unknown departments.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject unknown departments.", dbtest.Success, testID)
+
+			members := func(id string, descendants bool) []user.User {
+				resp := core.QueryDepartmentMembers(user.QueryDepartmentMembersRequest{ID: id, IncludeDescendants: descendants}, gr)
+				if resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to query members : %s.", dbtest.Failed, testID, resp.Error)
+				}
+				return resp.Users
+			}
+			if n := len(members(company.ID.String(), false)); n != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould only list direct members : got %d.", dbtest.Failed, testID, n)
+			}
+			if n := len(members(company.ID.String(), true)); n != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould list the members of descendants : got %d.", dbtest.Failed, testID, n)
+			}
+			t.Logf("\t%s\tTest %d:\tShould list the members of descendants.", dbtest.Success, testID)
+
+			mv := core.MoveDepartment(user.MoveDepartmentRequest{ID: engineering.ID.String(), ParentID: operations.ID.String()}, gr)
+			if mv.Error != "" || mv.Department.ParentID != operations.ID.String() {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to move a subtree : got %+v.", dbtest.Failed, testID, mv)
+			}
+			if n := len(members(company.ID.String(), true)); n != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould move the members with the subtree : got %d.", dbtest.Failed, testID, n)
+			}
+			if n := len(members(operations.ID.String(), true)); n != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould move the members with the subtree : got %d.", dbtest.Failed, testID, n)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to move a subtree.", dbtest.Success, testID)
+
+			mv = core.MoveDepartment(user.MoveDepartmentRequest{ID: operations.ID.String(), ParentID: platform.ID.String()}, gr)
+			if mv.Error != user.ErrDepartmentCycle.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould reject moving a department under its descendant : got %+v.", dbtest.Failed, testID, mv)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject moving a department under its descendant.", dbtest.Success, testID)
+
+			if resp := core.DeleteDepartment(user.DeleteDepartmentRequest{ID: engineering.ID.String()}, gr); resp.Error != user.ErrDepartmentNotEmpty.Error() {
+				t.Fatalf("\t%s\tTest %d:\tShould not delete departments that aren't empty : got %+v.", dbtest.Failed, testID, resp)
+			}
+			if resp := core.DeleteDepartment(user.DeleteDepartmentRequest{ID: company.ID.String()}, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete an empty department : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			qs := core.QueryDepartments(user.QueryDepartmentsRequest{}, gr)
+			if qs.Error != "" || len(qs.Departments) != 3 {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query departments : got %+v.", dbtest.Failed, testID, qs)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only delete empty departments.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Manager(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		storer := memory.NewStore()
+		testManager(t, zap.NewNop().Sugar(), storer, memory.NewManagerStore(storer))
+	})
+	t.Run("arangodb", func(t *testing.T) {
+		test := setupArango(t, "testmanager")
+		testManager(t, test.Log, nosql.NewStore(test.Log, test.DB), nosql.NewManagerStore(test.Log, test.DB))
+	})
+}
+
+// testManager records who reports to whom among the users kept in storer
+// with managerStorer.
+func testManager(t *testing.T, log *zap.SugaredLogger, storer user.Storer, managerStorer user.ManagerStorer) {
+	users := user.NewUserServicer(log, storer, newSigner(t))
+	core := user.NewManagerServicer(log, managerStorer)
+
+	t.Log("Given the need to record who reports to whom.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen building an org chart.", testID)
+		{
+			gr := server.GenericRequest{
+				Ctx:    user.SetClaims(context.Background(), user.Claims{OrgID: uuid.NewString()}),
+				Claims: auth.Claims{Roles: []string{auth.RoleAdmin}},
+				Values: &values.Values{Now: time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)},
+			}
+
+			// ceo <- cto <- (dev1, dev2), ceo <- cfo
+			ids := make(map[string]string)
+			for _, name := range []string{"ceo", "cfo", "cto", "dev1", "dev2"} {
+				email, _ := mail.ParseAddress(name + "@example.com")
+				nu := user.CreateUserRequest{}
+				nu.NewUser.Name = name
+				nu.NewUser.Email = *email
+				nu.NewUser.Roles = []user.Role{user.RoleUser}
+				nu.NewUser.Password = "gophers"
+				nu.NewUser.PasswordConfirm = "gophers"
+				resp := users.CreateUser(nu, gr)
+				if resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, resp.Error)
+				}
+				ids[name] = resp.User.ID.String()
+			}
+			for report, manager := range map[string]string{"cfo": "ceo", "cto": "ceo", "dev1": "cto", "dev2": "cfo"} {
+				if resp := core.SetManager(user.SetManagerRequest{UserID: ids[report], ManagerID: ids[manager]}, gr); resp.Error != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to set a manager : %s.", dbtest.Failed, testID, resp.Error)
+				}
+			}
+			// Replace dev2's manager.
+			if resp := core.SetManager(user.SetManagerRequest{UserID: ids["dev2"], ManagerID: ids["cto"]}, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to change a manager : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to set managers.", dbtest.Success, testID)
+
+			dr := core.QueryDirectReports(user.QueryDirectReportsRequest{ManagerID: ids["cto"]}, gr)
+			if dr.Error != "" || len(dr.Users) != 2 || dr.Users[0].Name != "dev1" || dr.Users[1].Name != "dev2" {
+				t.Fatalf("\t%s\tTest %d:\tShould list direct reports : got %+v.", dbtest.Failed, testID, dr)
+			}
+			if dr = core.QueryDirectReports(user.QueryDirectReportsRequest{ManagerID: ids["cfo"]}, gr); dr.Error != "" || len(dr.Users) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould replace the previous manager : got %+v.", dbtest.Failed, testID, dr)
+			}
+			t.Logf("\t%s\tTest %d:\tShould list direct reports.", dbtest.Success, testID)
+
+			rc := core.QueryReportingChain(user.QueryReportingChainRequest{UserID: ids["dev1"]}, gr)
+			if rc.Error != "" || len(rc.Managers) != 2 || rc.Managers[0].Name != "cto" || rc.Managers[1].Name != "ceo" {
+				t.Fatalf("\t%s\tTest %d:\tShould list the reporting chain : got %+v.", dbtest.Failed, testID, rc)
+			}
+			t.Logf("\t%s\tTest %d:\tShould list the reporting chain.", dbtest.Success, testID)
+
+			rt := core.QueryReportingTree(user.QueryReportingTreeRequest{ManagerID: ids["ceo"]}, gr)
+			if rt.Error != "" || len(rt.Reports) != 4 {
+				t.Fatalf("\t%s\tTest %d:\tShould list the reporting tree : got %+v.", dbtest.Failed, testID, rt)
+			}
+			last := rt.Reports[3]
+			if last.User.Name != "dev2" || last.Depth != 2 || last.ManagerID != ids["cto"] {
+				t.Fatalf("\t%s\tTest %d:\tShould give the manager and depth of each report : got %+v.", dbtest.Failed, testID, last)
+			}
+			t.Logf("\t%s\tTest %d:\tShould list the reporting tree.", dbtest.Success, testID)
+
+			for _, cycle := range [][2]string{{"ceo", "ceo"}, {"ceo", "dev1"}, {"cto", "dev2"}} {
+				resp := core.SetManager(user.SetManagerRequest{UserID: ids[cycle[0]], ManagerID: ids[cycle[1]]}, gr)
+				if resp.Error != user.ErrManagerCycle.Error() {
+					t.Fatalf("\t%s\tTest %d:\tShould reject making %s report to %s : got %+v.", dbtest.Failed, testID, cycle[0], cycle[1], resp)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject cycles.", dbtest.Success, testID)
+
+			email, _ := mail.ParseAddress("cto@example.com")
+			if resp := users.DeleteUser(user.DeleteUserRequest{User: user.User{Email: *email}}, gr); resp.Error != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, resp.Error)
+			}
+			rt = core.QueryReportingTree(user.QueryReportingTreeRequest{ManagerID: ids["ceo"]}, gr)
+			if rt.Error != "" || len(rt.Reports) != 1 || rt.Reports[0].User.Name != "cfo" {
+				t.Fatalf("\t%s\tTest %d:\tShould drop the reporting lines of deleted users : got %+v.", dbtest.Failed, testID, rt)
+			}
+			t.Logf("\t%s\tTest %d:\tShould drop the reporting lines of deleted users.", dbtest.Success, testID)
+
+			// cfo and dev2 try to become each other's manager at once.
+			var wg sync.WaitGroup
+			errs := make([]string, 2)
+			for i, pair := range [][2]string{{"cfo", "dev2"}, {"dev2", "cfo"}} {
+				wg.Add(1)
+				go func(i int, pair [2]string) {
+					defer wg.Done()
+					errs[i] = core.SetManager(user.SetManagerRequest{UserID: ids[pair[0]], ManagerID: ids[pair[1]]}, gr).Error
+				}(i, pair)
+			}
+			wg.Wait()
+			if errs[0] == "" && errs[1] == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject cycles made by concurrent changes.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject cycles made by concurrent changes.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Migrate(t *testing.T) {
+	test := setupArango(t, "testmigrate")
+	db := test.DB
+	ctx := context.Background()
+	latest := nosql.Migrations[len(nosql.Migrations)-1].Version
+
+	t.Log("Given the need to version the database schema.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen migrating up and down.", testID)
+		{
+			// The collections already exist, so migrating must tolerate them.
+			for i := 0; i < 2; i++ {
+				version, err := nosql.MigrateUp(ctx, db)
+				if err != nil || version != latest {
+					t.Fatalf("\t%s\tTest %d:\tShould migrate to version %d : got %d, %v.", dbtest.Failed, testID, latest, version, err)
+				}
+			}
+			status, err := nosql.Status(ctx, db)
+			if err != nil || len(status) != len(nosql.Migrations) {
+				t.Fatalf("\t%s\tTest %d:\tShould list the migrations : got %+v, %v.", dbtest.Failed, testID, status, err)
+			}
+			for _, st := range status {
+				if st.AppliedAt.IsZero() {
+					t.Fatalf("\t%s\tTest %d:\tShould have applied migration %d.", dbtest.Failed, testID, st.Version)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould apply each migration once.", dbtest.Success, testID)
+
+			users, err := db.Collection(ctx, "users")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to open users : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := users.CreateDocument(ctx, map[string]any{"_key": "invalid", "org_id": 1}); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould reject users that don't match the schema.", dbtest.Failed, testID)
+			}
+			if exists, err := users.IndexExists(ctx, "users_user_id"); err != nil || !exists {
+				t.Fatalf("\t%s\tTest %d:\tShould index users by id : got %t, %v.", dbtest.Failed, testID, exists, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould validate and index users.", dbtest.Success, testID)
+
+			version, err := nosql.MigrateDown(ctx, db, 2)
+			if err != nil || version != latest-2 {
+				t.Fatalf("\t%s\tTest %d:\tShould revert two migrations : got %d, %v.", dbtest.Failed, testID, version, err)
+			}
+			if _, err := users.CreateDocument(ctx, map[string]any{"_key": "invalid", "org_id": 1}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould stop validating users : %s.", dbtest.Failed, testID, err)
+			}
+			if exists, _ := db.ViewExists(ctx, "users_search"); exists {
+				t.Fatalf("\t%s\tTest %d:\tShould remove the search view.", dbtest.Failed, testID)
+			}
+			status, _ = nosql.Status(ctx, db)
+			if !status[len(status)-1].AppliedAt.IsZero() {
+				t.Fatalf("\t%s\tTest %d:\tShould unrecord reverted migrations : got %+v.", dbtest.Failed, testID, status)
+			}
+			t.Logf("\t%s\tTest %d:\tShould revert migrations.", dbtest.Success, testID)
+		}
+	}
+}
+
+func Test_Conformance(t *testing.T) {
+	test := setupArango(t, "testconformance")
+
+	// The outbox makes every change a stream transaction, which is the
+	// path that conflicts under concurrent writes.
+	storertest.Run(t, nosql.NewStore(test.Log, test.DB, nosql.WithOutbox()), storertest.Errors{
+		NotFound:              nosql.ErrNotFound,
+		UniqueEmail:           nosql.ErrUniqueEmail,
+		AuthenticationFailure: nosql.ErrAuthenticationFailure,
+	})
+}
+
+// newSigner builds a token signer backed by a freshly generated key.
+func newSigner(t *testing.T) *user.Signer {
+	const keyID = "4754d86b-7a6d-4df5-9c65-224741361492"
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := user.NewSigner(keyID, keystore.NewMap(map[string]*rsa.PrivateKey{keyID: privateKey}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
@@ -0,0 +1,241 @@
+package user
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// WebhookService is an API for subscribing endpoints to user events.
+type WebhookService interface {
+	// CreateWebhook registers an endpoint and returns its signing secret
+	CreateWebhook(CreateWebhookRequest, server.GenericRequest) CreateWebhookResponse
+	// DeleteWebhook removes an endpoint
+	DeleteWebhook(DeleteWebhookRequest, server.GenericRequest) DeleteWebhookResponse
+	// QueryWebhooks lists the endpoints of the caller's organization
+	QueryWebhooks(QueryWebhooksRequest, server.GenericRequest) QueryWebhooksResponse
+	// QueryDeliveries gets the delivery log, including the dead-letter queue
+	QueryDeliveries(QueryDeliveriesRequest, server.GenericRequest) QueryDeliveriesResponse
+	// RedeliverWebhook sends a failed or dead delivery again
+	RedeliverWebhook(RedeliverWebhookRequest, server.GenericRequest) RedeliverWebhookResponse
+}
+
+// WebhookStorer interface declares the behavior this package needs to
+// persist and retrieve webhooks and their deliveries.
+type WebhookStorer interface {
+	Create(ctx context.Context, wh Webhook) (Webhook, error)
+	Delete(ctx context.Context, orgID string, id string) (Webhook, error)
+	Query(ctx context.Context, orgID string) ([]Webhook, error)
+	// QueryByEvent returns the webhooks subscribed to the event type.
+	QueryByEvent(ctx context.Context, orgID string, eventType string) ([]Webhook, error)
+	QueryByID(ctx context.Context, orgID string, id string) (Webhook, error)
+	SaveDelivery(ctx context.Context, d Delivery) (Delivery, error)
+	QueryDeliveryByID(ctx context.Context, orgID string, id string) (Delivery, error)
+	QueryDeliveries(ctx context.Context, orgID string, filter DeliveryFilter, pageNumber int, rowsPerPage int) ([]Delivery, error)
+}
+
+// Required to register endpoints with the Server
+type WebhookRpcService interface {
+	WebhookService
+	// Registers RPCService with Server
+	Register(s *server.Server)
+}
+
+// Implements interface
+type WebhookServicer struct {
+	log        *zap.SugaredLogger
+	storer     WebhookStorer
+	dispatcher *WebhookDispatcher
+}
+
+// CreateWebhook implements WebhookRpcService
+func (w WebhookServicer) CreateWebhook(req CreateWebhookRequest, gr server.GenericRequest) CreateWebhookResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return CreateWebhookResponse{Error: err.Error()}
+	}
+
+	// Signing secrets are only issued to admins acting as themselves.
+	if _, ok := impersonator(gr); ok {
+		return CreateWebhookResponse{Error: ErrImpersonated.Error()}
+	}
+
+	oid, err := uuid.Parse(orgID)
+	if err != nil {
+		return CreateWebhookResponse{Error: fmt.Errorf("parsing org id: %w", err).Error()}
+	}
+
+	for _, typ := range req.NewWebhook.Events {
+		if !knownEventType(typ) {
+			return CreateWebhookResponse{Error: fmt.Sprintf("unknown event type %q", typ)}
+		}
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return CreateWebhookResponse{Error: err.Error()}
+	}
+
+	wh := Webhook{
+		ID:          uuid.New(),
+		OrgID:       oid,
+		URL:         req.NewWebhook.URL,
+		Events:      req.NewWebhook.Events,
+		Secret:      secret,
+		DateCreated: gr.Values.Now,
+		DateUpdated: gr.Values.Now,
+	}
+	result, err := w.storer.Create(gr.Ctx, wh)
+	if err != nil {
+		return CreateWebhookResponse{Error: err.Error()}
+	}
+	return CreateWebhookResponse{Webhook: result, Secret: secret}
+}
+
+// DeleteWebhook implements WebhookRpcService
+func (w WebhookServicer) DeleteWebhook(req DeleteWebhookRequest, gr server.GenericRequest) DeleteWebhookResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return DeleteWebhookResponse{Error: err.Error()}
+	}
+	wh, err := w.storer.Delete(gr.Ctx, orgID, req.ID)
+	if err != nil {
+		return DeleteWebhookResponse{Error: err.Error()}
+	}
+	return DeleteWebhookResponse{Webhook: wh}
+}
+
+// QueryWebhooks implements WebhookRpcService
+func (w WebhookServicer) QueryWebhooks(req QueryWebhooksRequest, gr server.GenericRequest) QueryWebhooksResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryWebhooksResponse{Error: err.Error()}
+	}
+	whs, err := w.storer.Query(gr.Ctx, orgID)
+	if err != nil {
+		return QueryWebhooksResponse{Error: err.Error()}
+	}
+	return QueryWebhooksResponse{Webhooks: whs}
+}
+
+// QueryDeliveries implements WebhookRpcService
+func (w WebhookServicer) QueryDeliveries(req QueryDeliveriesRequest, gr server.GenericRequest) QueryDeliveriesResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return QueryDeliveriesResponse{Error: err.Error()}
+	}
+
+	pageNumber, rowsPerPage := req.PageNumber, req.RowsPerPage
+	if pageNumber == 0 {
+		pageNumber = 1
+	}
+	if rowsPerPage == 0 {
+		rowsPerPage = 50
+	}
+
+	ds, err := w.storer.QueryDeliveries(gr.Ctx, orgID, req.Filter, pageNumber, rowsPerPage)
+	if err != nil {
+		return QueryDeliveriesResponse{Error: err.Error()}
+	}
+	return QueryDeliveriesResponse{Deliveries: ds}
+}
+
+// RedeliverWebhook implements WebhookRpcService
+func (w WebhookServicer) RedeliverWebhook(req RedeliverWebhookRequest, gr server.GenericRequest) RedeliverWebhookResponse {
+	orgID, err := tenant(gr)
+	if err != nil {
+		return RedeliverWebhookResponse{Error: err.Error()}
+	}
+	d, err := w.dispatcher.Redeliver(gr.Ctx, orgID, req.ID)
+	if err != nil {
+		return RedeliverWebhookResponse{Error: err.Error()}
+	}
+	return RedeliverWebhookResponse{Delivery: d}
+}
+
+// Register implements WebhookRpcService
+func (w WebhookServicer) Register(s *server.Server) {
+	s.Register("WebhookService", "CreateWebhook", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: w.CreateWebhookHandler})
+	s.Register("WebhookService", "DeleteWebhook", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: w.DeleteWebhookHandler})
+	s.Register("WebhookService", "QueryWebhooks", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: w.QueryWebhooksHandler})
+	s.Register("WebhookService", "QueryDeliveries", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: w.QueryDeliveriesHandler})
+	s.Register("WebhookService", "RedeliverWebhook", server.RPCEndpoint{Roles: []string{auth.RoleAdmin}, Handler: w.RedeliverWebhookHandler})
+}
+
+// Create new WebhookServicer
+func NewWebhookServicer(log *zap.SugaredLogger, storer WebhookStorer, dispatcher *WebhookDispatcher) WebhookRpcService {
+	return WebhookServicer{
+		log:        log,
+		storer:     storer,
+		dispatcher: dispatcher,
+	}
+}
+
+func knownEventType(typ string) bool {
+	for _, known := range EventTypes {
+		if typ == known {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateWebhookRequest is the request object for WebhookService.CreateWebhook.
+type CreateWebhookRequest struct {
+	NewWebhook NewWebhook `json:"newWebhook"`
+}
+
+// CreateWebhookResponse is the response object for WebhookService.CreateWebhook.
+type CreateWebhookResponse struct {
+	Webhook Webhook `json:"webhook"`
+	Secret  string  `json:"secret"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// DeleteWebhookRequest is the request object for WebhookService.DeleteWebhook.
+type DeleteWebhookRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// DeleteWebhookResponse is the response object for WebhookService.DeleteWebhook.
+type DeleteWebhookResponse struct {
+	Webhook Webhook `json:"webhook"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// QueryWebhooksRequest is the request object for WebhookService.QueryWebhooks.
+type QueryWebhooksRequest struct{}
+
+// QueryWebhooksResponse is the response object for WebhookService.QueryWebhooks.
+type QueryWebhooksResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// QueryDeliveriesRequest is the request object for WebhookService.QueryDeliveries.
+type QueryDeliveriesRequest struct {
+	Filter      DeliveryFilter `json:"filter"`
+	PageNumber  int            `json:"page" validate:"gte=0"`
+	RowsPerPage int            `json:"rows" validate:"gte=0,lte=1000"`
+}
+
+// QueryDeliveriesResponse is the response object for WebhookService.QueryDeliveries.
+type QueryDeliveriesResponse struct {
+	Deliveries []Delivery `json:"deliveries"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// RedeliverWebhookRequest is the request object for WebhookService.RedeliverWebhook.
+type RedeliverWebhookRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// RedeliverWebhookResponse is the response object for WebhookService.RedeliverWebhook.
+type RedeliverWebhookResponse struct {
+	Delivery Delivery `json:"delivery"`
+	Error    string   `json:"error,omitempty"`
+}
@@ -20,7 +20,7 @@ func main() {
 	}
 	patterns := []string{"github.com/gitamped/bud/services/user"}
 	p := parser.New(patterns...)
-	p.ExcludeInterfaces = []string{"UserRpcService"}
+	p.ExcludeInterfaces = []string{"AttributeRpcService", "ClientRpcService", "DepartmentRpcService", "GroupRpcService", "JobRpcService", "ManagerRpcService", "OrgRpcService", "UserRpcService", "WebhookRpcService"}
 	p.Verbose = false
 	def, err := p.Parse()
 	if err != nil {
@@ -0,0 +1,34 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/mid"
+)
+
+// AuthMiddleware validates the bearer token on a request. The full bud claims
+// are stored in the context for the servicers, and the embedded auth.Claims
+// are stored for the seed role checks. Requests without a valid access
+// token, including those carrying an ID token or a token from another
+// issuer, are passed on unauthenticated.
+func AuthMiddleware(signer *user.Signer) mid.Middleware {
+	m := func(h http.HandlerFunc) http.HandlerFunc {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			// Expecting: bearer <token>
+			parts := strings.Split(r.Header.Get("authorization"), " ")
+			if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
+				if claims, err := signer.ValidateToken(parts[1]); err == nil {
+					ctx := auth.SetClaims(r.Context(), claims.Claims)
+					ctx = user.SetClaims(ctx, claims)
+					r = r.WithContext(ctx)
+				}
+			}
+			h.ServeHTTP(w, r)
+		}
+		return handler
+	}
+	return m
+}
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/seed/mid"
+)
+
+// ClientInfoMiddleware stores the IP address and user agent of the client in
+// the request context, so servicers can record where requests came from.
+// The X-Forwarded-For header is only read when trustProxy is set, since
+// any client can send it.
+func ClientInfoMiddleware(trustProxy bool) mid.Middleware {
+	m := func(h http.HandlerFunc) http.HandlerFunc {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+			if fwd := r.Header.Get("X-Forwarded-For"); trustProxy && fwd != "" {
+				ip = strings.TrimSpace(strings.Split(fwd, ",")[0])
+			}
+
+			ctx := user.SetClientInfo(r.Context(), user.ClientInfo{IP: ip, UserAgent: r.UserAgent()})
+			h.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return handler
+	}
+	return m
+}
@@ -0,0 +1,326 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/seed/server"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Paths of the federated login flow.
+const (
+	FederatedLoginPath    = "/auth/oidc/login"
+	FederatedCallbackPath = "/auth/oidc/callback"
+)
+
+// flowCookie holds the state, nonce and PKCE verifier of a login in flight.
+const flowCookie = "bud_oidc_flow"
+
+// Federator maps an identity asserted by an identity provider to a bud token.
+// It is implemented by user.UserServicer.
+type Federator interface {
+	FederatedLogin(ctx context.Context, id user.FederatedIdentity) (string, error)
+}
+
+// ProviderConfig describes a relying party registration with an OpenID
+// Connect identity provider.
+type ProviderConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of FederatedCallbackPath.
+	RedirectURL string
+	// Client is used to reach the provider. http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// Provider is an OpenID Connect relying party for a single identity provider.
+// It signs users in with the authorization code flow and PKCE.
+type Provider struct {
+	cfg           ProviderConfig
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+
+	mu   sync.RWMutex
+	keys map[string]*rsa.PublicKey
+}
+
+// NewProvider constructs a Provider from the identity provider's discovery
+// document.
+func NewProvider(ctx context.Context, cfg ProviderConfig) (*Provider, error) {
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	var md struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, cfg.Client, strings.TrimSuffix(cfg.Issuer, "/")+DiscoveryPath, &md); err != nil {
+		return nil, fmt.Errorf("fetching discovery document: %w", err)
+	}
+	if md.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("issuer %q does not match discovery document issuer %q", cfg.Issuer, md.Issuer)
+	}
+
+	return &Provider{
+		cfg:           cfg,
+		authEndpoint:  md.AuthorizationEndpoint,
+		tokenEndpoint: md.TokenEndpoint,
+		jwksURI:       md.JWKSURI,
+		keys:          map[string]*rsa.PublicKey{},
+	}, nil
+}
+
+// Login starts the flow by redirecting the browser to the identity provider.
+func (p *Provider) Login() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err1 := randomString()
+		nonce, err2 := randomString()
+		verifier, err3 := randomString()
+		if err := errors.Join(err1, err2, err3); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     flowCookie,
+			Value:    strings.Join([]string{state, nonce, verifier}, "."),
+			Path:     FederatedCallbackPath,
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(p.cfg.RedirectURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		challenge := sha256.Sum256([]byte(verifier))
+		q := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {p.cfg.ClientID},
+			"redirect_uri":          {p.cfg.RedirectURL},
+			"scope":                 {"openid email profile"},
+			"state":                 {state},
+			"nonce":                 {nonce},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+			"code_challenge_method": {"S256"},
+		}
+		http.Redirect(w, r, p.authEndpoint+"?"+q.Encode(), http.StatusFound)
+	}
+}
+
+// Callback completes the flow. It exchanges the authorization code, validates
+// the ID token and responds with the bud token issued by users.
+func (p *Provider) Callback(users Federator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(flowCookie)
+		if err != nil {
+			http.Error(w, "login flow not started", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: flowCookie, Path: FederatedCallbackPath, MaxAge: -1})
+
+		parts := strings.Split(c.Value, ".")
+		if len(parts) != 3 {
+			http.Error(w, "malformed login flow", http.StatusBadRequest)
+			return
+		}
+		state, nonce, verifier := parts[0], parts[1], parts[2]
+
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			http.Error(w, "identity provider: "+e, http.StatusUnauthorized)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1 {
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		}
+
+		rawIDToken, err := p.exchange(r.Context(), q.Get("code"), verifier)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		id, err := p.verify(r.Context(), rawIDToken, nonce)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		tkn, err := users.FederatedLogin(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		server.Encode(w, r, http.StatusOK, user.AuthenticateResponse{Token: tkn})
+	}
+}
+
+// exchange redeems the authorization code for the provider's ID token.
+func (p *Provider) exchange(ctx context.Context, code string, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("exchanging code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("exchanging code: status %d", resp.StatusCode)
+	}
+
+	var tr struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("decoding token response: %w", err)
+	}
+	if tr.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return tr.IDToken, nil
+}
+
+// idTokenClaims are the ID token claims a Provider reads.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// verify validates the ID token's signature, issuer, audience, expiry and
+// nonce.
+func (p *Provider) verify(ctx context.Context, rawIDToken string, nonce string) (user.FederatedIdentity, error) {
+	keyFunc := func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	}
+
+	var claims idTokenClaims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256"}))
+	if _, err := parser.ParseWithClaims(rawIDToken, &claims, keyFunc); err != nil {
+		return user.FederatedIdentity{}, fmt.Errorf("parsing id token: %w", err)
+	}
+
+	switch {
+	case claims.Issuer != p.cfg.Issuer:
+		return user.FederatedIdentity{}, errors.New("id token issuer mismatch")
+	case !claims.VerifyAudience(p.cfg.ClientID, true):
+		return user.FederatedIdentity{}, errors.New("id token audience mismatch")
+	case claims.ExpiresAt == nil:
+		return user.FederatedIdentity{}, errors.New("id token has no expiry")
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return user.FederatedIdentity{}, errors.New("id token nonce mismatch")
+	case claims.Subject == "":
+		return user.FederatedIdentity{}, errors.New("id token has no subject")
+	}
+
+	return user.FederatedIdentity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// key returns the provider's public key for kid. The key set is fetched
+// again when kid is unknown, so the provider can rotate its keys.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.RLock()
+	k, ok := p.keys[kid]
+	p.mu.RUnlock()
+	if ok {
+		return k, nil
+	}
+
+	var set JWKSet
+	if err := getJSON(ctx, p.cfg.Client, p.jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key %q: %w", jwk.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.Exponent)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key %q: %w", jwk.KeyID, err)
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// getJSON decodes the JSON document at rawURL into v.
+func getJSON(ctx context.Context, client *http.Client, rawURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// randomString returns 32 random bytes encoded for use in URLs.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
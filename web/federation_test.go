@@ -0,0 +1,190 @@
+package web_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/web"
+	"github.com/gitamped/seed/keystore"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// idp is a stand-in OpenID Connect identity provider. It approves every
+// authorization request for a single identity.
+type idp struct {
+	srv      *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	identity map[string]any
+
+	// the pending authorization, keyed by code
+	codes map[string]url.Values
+}
+
+func newIDP(t *testing.T, clientID string, identity map[string]any) *idp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &idp{key: key, clientID: clientID, identity: identity, codes: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(web.DiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.srv.URL,
+			"authorization_endpoint": p.srv.URL + "/authorize",
+			"token_endpoint":         p.srv.URL + "/token",
+			"jwks_uri":               p.srv.URL + web.JWKSPath,
+		})
+	})
+	mux.HandleFunc(web.JWKSPath, func(w http.ResponseWriter, r *http.Request) {
+		jwks, err := web.JWKS(keystore.NewMap(map[string]*rsa.PrivateKey{"idp-key": p.key}), "idp-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwks(w, r)
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		p.codes["code-1"] = q
+		redirect := q.Get("redirect_uri") + "?" + url.Values{"code": {"code-1"}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		auth, ok := p.codes[r.PostForm.Get("code")]
+		if !ok {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		delete(p.codes, r.PostForm.Get("code"))
+
+		// Verify the PKCE challenge against the verifier.
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.Get("code_challenge") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   p.srv.URL,
+			"aud":   p.clientID,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": auth.Get("nonce"),
+		}
+		for k, v := range p.identity {
+			claims[k] = v
+		}
+		tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		tkn.Header["kid"] = "idp-key"
+		signed, err := tkn.SignedString(p.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": signed})
+	})
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+// federator records the identity it is asked to log in.
+type federator struct {
+	got user.FederatedIdentity
+}
+
+func (f *federator) FederatedLogin(ctx context.Context, id user.FederatedIdentity) (string, error) {
+	f.got = id
+	return "bud-token", nil
+}
+
+func Test_FederatedLogin(t *testing.T) {
+	t.Log("Given the need to sign in with an external identity provider.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the provider authenticates the user.", testID)
+		{
+			identity := map[string]any{"sub": "idp-user-1", "email": "jane@example.com", "email_verified": true, "name": "Jane"}
+			p := newIDP(t, "bud", identity)
+
+			users := &federator{}
+			mux := http.NewServeMux()
+			rp := httptest.NewServer(mux)
+			t.Cleanup(rp.Close)
+
+			provider, err := web.NewProvider(context.Background(), web.ProviderConfig{
+				Issuer:       p.srv.URL,
+				ClientID:     "bud",
+				ClientSecret: "secret",
+				RedirectURL:  rp.URL + web.FederatedCallbackPath,
+			})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to discover the provider : %s.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to discover the provider.", success, testID)
+
+			mux.Handle(web.FederatedLoginPath, provider.Login())
+			mux.Handle(web.FederatedCallbackPath, provider.Callback(users))
+
+			jar, _ := cookiejar.New(nil)
+			client := &http.Client{Jar: jar}
+			resp, err := client.Get(rp.URL + web.FederatedLoginPath)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to complete the flow : %s.", failed, testID, err)
+			}
+			defer resp.Body.Close()
+
+			var ar user.AuthenticateResponse
+			if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil || resp.StatusCode != http.StatusOK || ar.Token != "bud-token" {
+				t.Fatalf("\t%s\tTest %d:\tShould be issued a bud token : got %d %+v %v.", failed, testID, resp.StatusCode, ar, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be issued a bud token.", success, testID)
+
+			want := user.FederatedIdentity{Issuer: p.srv.URL, Subject: "idp-user-1", Email: "jane@example.com", EmailVerified: true, Name: "Jane"}
+			if users.got != want {
+				t.Fatalf("\t%s\tTest %d:\tShould map the ID token claims : got %+v.", failed, testID, users.got)
+			}
+			t.Logf("\t%s\tTest %d:\tShould map the ID token claims.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the callback is forged.", testID)
+		{
+			p := newIDP(t, "bud", map[string]any{"sub": "idp-user-1"})
+			provider, err := web.NewProvider(context.Background(), web.ProviderConfig{
+				Issuer:      p.srv.URL,
+				ClientID:    "bud",
+				RedirectURL: "http://localhost" + web.FederatedCallbackPath,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, web.FederatedCallbackPath+"?code=code-1&state=forged", nil)
+			r.AddCookie(&http.Cookie{Name: "bud_oidc_flow", Value: "state.nonce.verifier"})
+			w := httptest.NewRecorder()
+			provider.Callback(&federator{})(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("\t%s\tTest %d:\tShould reject a state mismatch : got %d.", failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject a state mismatch.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Package web contains the http plumbing bud adds on top of the seed server.
+package web
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+	"github.com/gitamped/seed/validate"
+	"github.com/gitamped/seed/values"
+)
+
+// Handler dispatches RPC requests to the endpoints registered with s. It
+// behaves like server.DefaultHandler but also passes the request context to
+// the endpoint, so servicers can read request scoped values such as the
+// caller's organization. Install it with:
+//
+//	s.Handler = mid.MultipleMiddleware(web.Handler(s), mw...)
+func Handler(s *server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		rpc, ok := s.Routes[r.URL.Path]
+		if !ok {
+			s.NotFound.ServeHTTP(w, r)
+			return
+		}
+
+		g := server.GenericRequest{
+			Ctx:    ctx,
+			Claims: auth.Claims{},
+		}
+
+		if len(rpc.Roles) > 0 {
+			claims, err := auth.GetClaims(ctx)
+			if err != nil {
+				server.Unauthorized(w, r)
+				return
+			}
+			g.Claims = claims
+		}
+
+		if ok := server.Authorized(rpc.Roles, g.Claims.Roles); !ok {
+			server.Unauthorized(w, r)
+			return
+		}
+
+		v, err := values.GetValues(ctx)
+		if err != nil {
+			server.StatusNotAcceptable(w, r)
+			return
+		}
+		g.Values = v
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			server.StatusNotAcceptable(w, r)
+			return
+		}
+
+		response, err := rpc.Handler(g, b)
+		if err != nil {
+			s.OnErr(w, r, err)
+			return
+		}
+
+		if err := validate.Check(response); err != nil {
+			s.OnErr(w, r, err)
+			return
+		}
+
+		if err := server.Encode(w, r, http.StatusOK, response); err != nil {
+			s.OnErr(w, r, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/seed/mid"
+	"go.uber.org/zap"
+)
+
+// ImpersonationMiddleware logs every request made with an impersonation
+// token so the admin behind it can be held accountable. It must run after
+// AuthMiddleware has stored the claims.
+func ImpersonationMiddleware(log *zap.SugaredLogger) mid.Middleware {
+	m := func(h http.HandlerFunc) http.HandlerFunc {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if claims, err := user.GetClaims(r.Context()); err == nil && claims.Act != nil {
+				log.Infow("impersonated request", "method", r.Method, "path", r.URL.Path, "subject", claims.Subject, "actor", claims.Act.Subject, "org_id", claims.OrgID)
+			}
+			h.ServeHTTP(w, r)
+		}
+		return handler
+	}
+	return m
+}
@@ -0,0 +1,184 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/seed/server"
+)
+
+// Paths of the OAuth2 endpoints.
+const (
+	TokenPath     = "/oauth/token"
+	AuthorizePath = "/oauth/authorize"
+)
+
+// TokenResponse is a successful OAuth2 access token response.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// TokenError is an OAuth2 error response.
+type TokenError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Token implements the OAuth2 token endpoint for the client_credentials
+// grant. Clients authenticate with HTTP Basic auth or with the client_id
+// and client_secret form parameters, and request roles with the scope
+// parameter.
+func Token(clients user.ClientRpcService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		if r.Method != http.MethodPost {
+			tokenError(w, r, http.StatusMethodNotAllowed, "invalid_request", "token requests must be POST")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			tokenError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		if gt := r.PostForm.Get("grant_type"); gt != "client_credentials" {
+			tokenError(w, r, http.StatusBadRequest, "unsupported_grant_type", "")
+			return
+		}
+
+		clientID, secret, ok := r.BasicAuth()
+		if !ok {
+			clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		if clientID == "" || secret == "" {
+			tokenError(w, r, http.StatusUnauthorized, "invalid_client", "")
+			return
+		}
+
+		tkn, err := clients.ClientCredentials(r.Context(), clientID, secret, strings.Fields(r.PostForm.Get("scope")))
+		switch {
+		case errors.Is(err, user.ErrInvalidClient):
+			tokenError(w, r, http.StatusUnauthorized, "invalid_client", "")
+			return
+		case errors.Is(err, user.ErrInvalidScope):
+			tokenError(w, r, http.StatusBadRequest, "invalid_scope", "")
+			return
+		case err != nil:
+			tokenError(w, r, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+
+		resp := TokenResponse{
+			AccessToken: tkn.Token,
+			TokenType:   "Bearer",
+			ExpiresIn:   int(tkn.ExpiresIn.Seconds()),
+			Scope:       strings.Join(tkn.Roles, " "),
+		}
+		server.Encode(w, r, http.StatusOK, resp)
+	}
+}
+
+// tokenError writes an OAuth2 error response.
+func tokenError(w http.ResponseWriter, r *http.Request, status int, code string, description string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+	server.Encode(w, r, status, TokenError{Error: code, ErrorDescription: description})
+}
+
+// Authorize implements the OpenID Connect authorization endpoint for the
+// implicit flow with the id_token response type. The client must have
+// registered the redirect URI. Users sign in with HTTP Basic auth, their
+// email and password, which browsers prompt for; serve it over TLS. The ID
+// token is returned in the fragment of the redirect URI along with state.
+func Authorize(clients user.ClientRpcService, users user.UserRpcService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			tokenError(w, r, http.StatusMethodNotAllowed, "invalid_request", "authorization requests must be GET or POST")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			tokenError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+
+		// Until the redirect URI is known to belong to the client, errors
+		// are shown to the user rather than sent to it.
+		redirectURI := r.Form.Get("redirect_uri")
+		clt, err := clients.AuthorizeClient(r.Context(), r.Form.Get("client_id"), redirectURI)
+		switch {
+		case errors.Is(err, user.ErrInvalidClient), errors.Is(err, user.ErrInvalidRedirectURI):
+			tokenError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		case err != nil:
+			tokenError(w, r, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+
+		state := r.Form.Get("state")
+		switch {
+		case r.Form.Get("response_type") != "id_token":
+			authorizeRedirect(w, r, redirectURI, state, url.Values{"error": {"unsupported_response_type"}})
+			return
+		case !hasScope(r.Form.Get("scope"), "openid"):
+			authorizeRedirect(w, r, redirectURI, state, url.Values{"error": {"invalid_scope"}, "error_description": {"the openid scope is required"}})
+			return
+		case r.Form.Get("nonce") == "":
+			authorizeRedirect(w, r, redirectURI, state, url.Values{"error": {"invalid_request"}, "error_description": {"nonce is required"}})
+			return
+		}
+
+		email, password, ok := r.BasicAuth()
+		if !ok {
+			authorizeChallenge(w, r, clt.Name)
+			return
+		}
+		tkn, err := users.IDToken(r.Context(), clt, email, password, r.Form.Get("nonce"))
+		switch {
+		case errors.Is(err, user.ErrInvalidCredentials):
+			authorizeChallenge(w, r, clt.Name)
+			return
+		case errors.Is(err, user.ErrUserDisabled), errors.Is(err, user.ErrPasswordChangeRequired):
+			authorizeRedirect(w, r, redirectURI, state, url.Values{"error": {"access_denied"}, "error_description": {err.Error()}})
+			return
+		case err != nil:
+			authorizeRedirect(w, r, redirectURI, state, url.Values{"error": {"server_error"}})
+			return
+		}
+
+		authorizeRedirect(w, r, redirectURI, state, url.Values{"id_token": {tkn}})
+	}
+}
+
+// authorizeRedirect sends the authorization response in the fragment of the
+// redirect URI.
+func authorizeRedirect(w http.ResponseWriter, r *http.Request, redirectURI string, state string, v url.Values) {
+	if state != "" {
+		v.Set("state", state)
+	}
+	http.Redirect(w, r, redirectURI+"#"+v.Encode(), http.StatusFound)
+}
+
+// authorizeChallenge asks the browser for the user's email and password.
+func authorizeChallenge(w http.ResponseWriter, r *http.Request, realm string) {
+	w.Header().Set("WWW-Authenticate", `Basic realm=`+strconv.Quote(realm)+`, charset="UTF-8"`)
+	http.Error(w, "sign in with your email and password", http.StatusUnauthorized)
+}
+
+// hasScope reports whether the space separated scope includes want.
+func hasScope(scope string, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+)
+
+// Paths the OpenID Connect metadata is published on.
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	JWKSPath      = "/.well-known/jwks.json"
+)
+
+// ProviderMetadata is the OpenID Connect discovery document.
+type ProviderMetadata struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	ScopesSupported                  []string `json:"scopes_supported"`
+	GrantTypesSupported              []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethods         []string `json:"token_endpoint_auth_methods_supported"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	ResponseModesSupported           []string `json:"response_modes_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+}
+
+// JWK is an RSA public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// JWKSet is a JSON Web Key Set.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Discovery serves the OpenID Connect discovery document for issuer. The
+// issuer must be the base URL the server is reachable on, since clients
+// locate the key set relative to it.
+func Discovery(issuer string) http.HandlerFunc {
+	base := strings.TrimSuffix(issuer, "/")
+	md := ProviderMetadata{
+		Issuer:                           issuer,
+		AuthorizationEndpoint:            base + AuthorizePath,
+		JWKSURI:                          base + JWKSPath,
+		TokenEndpoint:                    base + TokenPath,
+		ScopesSupported:                  []string{"openid"},
+		GrantTypesSupported:              []string{"implicit", "client_credentials"},
+		TokenEndpointAuthMethods:         []string{"client_secret_basic", "client_secret_post"},
+		ResponseTypesSupported:           []string{"id_token"},
+		ResponseModesSupported:           []string{"fragment"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{"RS256"},
+		ClaimsSupported:                  []string{"sub", "iss", "aud", "exp", "iat", "nonce", "email", "name", "roles", "org_id", "groups", "client_id"},
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := server.Encode(w, r, http.StatusOK, md); err != nil {
+			server.StatusNotAcceptable(w, r)
+		}
+	}
+}
+
+// JWKS serves the public half of the kids keys as a JSON Web Key Set so
+// tokens can be verified without sharing the PEM files.
+func JWKS(keyLookup auth.KeyLookup, kids ...string) (http.HandlerFunc, error) {
+	set := JWKSet{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		pub, err := keyLookup.PublicKey(kid)
+		if err != nil {
+			return nil, fmt.Errorf("looking up key %q: %w", kid, err)
+		}
+		set.Keys = append(set.Keys, JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: "RS256",
+			KeyID:     kid,
+			Modulus:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := server.Encode(w, r, http.StatusOK, set); err != nil {
+			server.StatusNotAcceptable(w, r)
+		}
+	}, nil
+}
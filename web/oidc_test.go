@@ -0,0 +1,286 @@
+package web_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/services/user/stores/memory"
+	"github.com/gitamped/bud/web"
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/keystore"
+	"github.com/gitamped/seed/server"
+	"github.com/gitamped/seed/values"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func Test_Discovery(t *testing.T) {
+	t.Log("Given the need to publish the OpenID Connect discovery document.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the document is requested.", testID)
+		{
+			w := httptest.NewRecorder()
+			web.Discovery("https://bud.example.com/")(w, httptest.NewRequest(http.MethodGet, web.DiscoveryPath, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("\t%s\tTest %d:\tShould respond with 200 : got %d.", failed, testID, w.Code)
+			}
+
+			var md map[string]any
+			if err := json.NewDecoder(w.Body).Decode(&md); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to decode the document : %s.", failed, testID, err)
+			}
+			// The fields OpenID Connect Discovery 1.0 requires.
+			for _, field := range []string{"issuer", "authorization_endpoint", "jwks_uri", "response_types_supported", "subject_types_supported", "id_token_signing_alg_values_supported"} {
+				if v, ok := md[field]; !ok || v == "" {
+					t.Fatalf("\t%s\tTest %d:\tShould contain %s : got %v.", failed, testID, field, md)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould contain the required fields.", success, testID)
+
+			want := map[string]string{
+				"issuer":                 "https://bud.example.com/",
+				"authorization_endpoint": "https://bud.example.com" + web.AuthorizePath,
+				"token_endpoint":         "https://bud.example.com" + web.TokenPath,
+				"jwks_uri":               "https://bud.example.com" + web.JWKSPath,
+			}
+			for field, v := range want {
+				if md[field] != v {
+					t.Fatalf("\t%s\tTest %d:\tShould set %s to %q : got %v.", failed, testID, field, v, md[field])
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould locate the endpoints relative to the issuer.", success, testID)
+		}
+	}
+}
+
+func Test_JWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const kid = "54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"
+
+	t.Log("Given the need to publish the keys tokens are signed with.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the key set is requested.", testID)
+		{
+			jwks, err := web.JWKS(keystore.NewMap(map[string]*rsa.PrivateKey{kid: key}), kid)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to build the key set : %s.", failed, testID, err)
+			}
+			w := httptest.NewRecorder()
+			jwks(w, httptest.NewRequest(http.MethodGet, web.JWKSPath, nil))
+
+			var set web.JWKSet
+			if err := json.NewDecoder(w.Body).Decode(&set); err != nil || len(set.Keys) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould return one key : got %+v %v.", failed, testID, set, err)
+			}
+			jwk := set.Keys[0]
+			if jwk.KeyID != kid || jwk.KeyType != "RSA" || jwk.Algorithm != "RS256" {
+				t.Fatalf("\t%s\tTest %d:\tShould describe the signing key : got %+v.", failed, testID, jwk)
+			}
+
+			n, err := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+			if err != nil || new(big.Int).SetBytes(n).Cmp(key.N) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould publish the modulus of the key : %v.", failed, testID, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(jwk.Exponent)
+			if err != nil || new(big.Int).SetBytes(e).Int64() != int64(key.E) {
+				t.Fatalf("\t%s\tTest %d:\tShould publish the exponent of the key : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould publish the public half of the signing key.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a key is missing.", testID)
+		{
+			if _, err := web.JWKS(keystore.NewMap(map[string]*rsa.PrivateKey{kid: key}), "missing"); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould fail to build the key set.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould fail to build the key set.", success, testID)
+		}
+	}
+}
+
+// registeredClients authorizes a single client, standing in for the client
+// store.
+type registeredClients struct {
+	user.ClientRpcService
+	clt user.Client
+}
+
+func (c registeredClients) AuthorizeClient(ctx context.Context, clientID string, redirectURI string) (user.Client, error) {
+	if clientID != c.clt.ID.String() {
+		return user.Client{}, user.ErrInvalidClient
+	}
+	for _, uri := range c.clt.RedirectURIs {
+		if uri == redirectURI {
+			return c.clt, nil
+		}
+	}
+	return user.Client{}, user.ErrInvalidRedirectURI
+}
+
+func Test_Authorize(t *testing.T) {
+	const kid = "4754d86b-7a6d-4df5-9c65-224741361492"
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := user.NewSigner(kid, keystore.NewMap(map[string]*rsa.PrivateKey{kid: key}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orgID := uuid.New()
+	users := user.NewUserServicer(zap.NewNop().Sugar(), memory.NewStore(), signer)
+	claims := user.Claims{Claims: auth.Claims{Roles: []string{auth.RoleAdmin}}, OrgID: orgID.String()}
+	created := users.CreateUser(user.CreateUserRequest{NewUser: user.NewUser{
+		Name:            "Jane Doe",
+		Email:           mail.Address{Address: "jane@example.com"},
+		Roles:           []user.Role{user.RoleUser},
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}}, server.GenericRequest{Ctx: user.SetClaims(context.Background(), claims), Claims: claims.Claims, Values: &values.Values{Now: time.Now()}})
+	if created.Error != "" {
+		t.Fatalf("creating user : %s", created.Error)
+	}
+
+	const redirectURI = "https://app.example.com/callback"
+	clt := user.Client{ID: uuid.New(), OrgID: orgID, Name: "app", RedirectURIs: []string{redirectURI}}
+	authorize := web.Authorize(registeredClients{clt: clt}, users)
+
+	request := func(q url.Values, email string, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, web.AuthorizePath+"?"+q.Encode(), nil)
+		if email != "" {
+			r.SetBasicAuth(email, password)
+		}
+		w := httptest.NewRecorder()
+		authorize(w, r)
+		return w
+	}
+	valid := func() url.Values {
+		return url.Values{
+			"client_id":     {clt.ID.String()},
+			"redirect_uri":  {redirectURI},
+			"response_type": {"id_token"},
+			"scope":         {"openid"},
+			"nonce":         {"n-0S6_WzA2Mj"},
+			"state":         {"af0ifjsldkj"},
+		}
+	}
+	// fragment returns the values in the fragment of the redirect.
+	fragment := func(w *httptest.ResponseRecorder) url.Values {
+		t.Helper()
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if err != nil || w.Code != http.StatusFound {
+			t.Fatalf("\t%s\tShould redirect : got %d %q.", failed, w.Code, w.Header().Get("Location"))
+		}
+		if got := loc.Scheme + "://" + loc.Host + loc.Path; got != redirectURI {
+			t.Fatalf("\t%s\tShould redirect to the client : got %q.", failed, got)
+		}
+		v, err := url.ParseQuery(loc.Fragment)
+		if err != nil {
+			t.Fatalf("\t%s\tShould put the response in the fragment : %s.", failed, err)
+		}
+		return v
+	}
+
+	t.Log("Given the need to sign users in to registered clients.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the user signs in.", testID)
+		{
+			w := request(valid(), "jane@example.com", "gophers")
+			v := fragment(w)
+			if v.Get("state") != "af0ifjsldkj" {
+				t.Fatalf("\t%s\tTest %d:\tShould return the state : got %v.", failed, testID, v)
+			}
+			var idc user.Claims
+			if _, err := jwt.ParseWithClaims(v.Get("id_token"), &idc, func(*jwt.Token) (any, error) { return &key.PublicKey, nil }); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould return a valid ID token : %v.", failed, testID, err)
+			}
+			if len(idc.Audience) != 1 || idc.Audience[0] != clt.ID.String() || idc.Nonce != "n-0S6_WzA2Mj" || idc.Subject != created.User.ID.String() {
+				t.Fatalf("\t%s\tTest %d:\tShould address the token to the client with the nonce : got %+v.", failed, testID, idc)
+			}
+			if len(idc.Roles) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould not put roles in the ID token : got %v.", failed, testID, idc.Roles)
+			}
+			t.Logf("\t%s\tTest %d:\tShould redirect with an ID token for the client.", success, testID)
+
+			api := web.AuthMiddleware(signer)(web.SCIM(users, nil))
+			r := httptest.NewRequest(http.MethodGet, web.SCIMPath+"Users", nil)
+			r.Header.Set("Authorization", "Bearer "+v.Get("id_token"))
+			w = httptest.NewRecorder()
+			api(w, r)
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tTest %d:\tShould not let the ID token call the API : got %d.", failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not let the ID token call the API.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the user isn't signed in.", testID)
+		{
+			for _, w := range []*httptest.ResponseRecorder{request(valid(), "", ""), request(valid(), "jane@example.com", "wrong")} {
+				if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+					t.Fatalf("\t%s\tTest %d:\tShould ask for credentials : got %d.", failed, testID, w.Code)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould ask for credentials.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the redirect URI isn't registered.", testID)
+		{
+			q := valid()
+			q.Set("redirect_uri", "https://evil.example.com/callback")
+			if w := request(q, "jane@example.com", "gophers"); w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse without redirecting : got %d %q.", failed, testID, w.Code, w.Header().Get("Location"))
+			}
+			q = valid()
+			q.Set("client_id", uuid.NewString())
+			if w := request(q, "jane@example.com", "gophers"); w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse unknown clients without redirecting : got %d.", failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse without redirecting.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the request is malformed.", testID)
+		{
+			tests := []struct {
+				field string
+				value string
+				want  string
+			}{
+				{"response_type", "code", "unsupported_response_type"},
+				{"scope", "profile", "invalid_scope"},
+				{"nonce", "", "invalid_request"},
+			}
+			for _, tt := range tests {
+				q := valid()
+				q.Set(tt.field, tt.value)
+				v := fragment(request(q, "jane@example.com", "gophers"))
+				if v.Get("error") != tt.want || v.Get("state") != "af0ifjsldkj" || v.Get("id_token") != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould redirect with %s for %s : got %v.", failed, testID, tt.want, tt.field, v)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould redirect with an error.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,973 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/server"
+	"github.com/gitamped/seed/values"
+)
+
+// SCIMPath is the base path of the SCIM 2.0 endpoints.
+const SCIMPath = "/scim/v2/"
+
+// SCIM schema URIs.
+const (
+	SCIMUserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMGroupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMPatchOpSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SCIMConfigSchema       = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// scimMaxResults bounds the resources returned by a single list request.
+const scimMaxResults = 1000
+
+// SCIMUser is the SCIM representation of a user. The userName is the
+// user's email.
+type SCIMUser struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	UserName    string           `json:"userName"`
+	Name        *SCIMName        `json:"name,omitempty"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Emails      []SCIMMultiValue `json:"emails,omitempty"`
+	Active      *bool            `json:"active,omitempty"`
+	Password    string           `json:"password,omitempty"`
+	Roles       []SCIMMultiValue `json:"roles,omitempty"`
+	Meta        *SCIMMeta        `json:"meta,omitempty"`
+}
+
+// SCIMName is the name of a SCIM user.
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// SCIMGroup is the SCIM representation of a group.
+type SCIMGroup struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []SCIMMultiValue `json:"members,omitempty"`
+	Meta        *SCIMMeta        `json:"meta,omitempty"`
+}
+
+// SCIMMultiValue is an element of a multi-valued attribute.
+type SCIMMultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMMeta is the resource metadata.
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// SCIMListResponse is a page of resources.
+type SCIMListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// SCIMPatchOp is a PATCH request.
+type SCIMPatchOp struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMPatchOperation is a single operation of a PATCH request.
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// SCIMError is a SCIM error response.
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// scimErr is an error with the status and SCIM error type to respond with.
+type scimErr struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e scimErr) Error() string {
+	return e.detail
+}
+
+func badRequest(scimType string, format string, args ...any) error {
+	return scimErr{status: http.StatusBadRequest, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...any) error {
+	return scimErr{status: http.StatusNotFound, detail: fmt.Sprintf(format, args...)}
+}
+
+// SCIM serves the SCIM 2.0 Users and Groups endpoints on top of the user and
+// group services. Callers authenticate with a bearer token carrying the
+// admin role, such as a token issued to an OAuth2 client, and provision the
+// organization the token is scoped to. It must run after AuthMiddleware.
+func SCIM(users user.UserService, groups user.GroupService) http.HandlerFunc {
+	h := scimHandler{users: users, groups: groups}
+	return func(w http.ResponseWriter, r *http.Request) {
+		gr, err := scimRequest(r)
+		if err != nil {
+			h.error(w, r, err)
+			return
+		}
+
+		resource, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, SCIMPath), "/")
+		base := scimBaseURL(r)
+
+		var status int
+		var resp any
+		switch {
+		case resource == "ServiceProviderConfig" && id == "" && r.Method == http.MethodGet:
+			status, resp = http.StatusOK, scimServiceProviderConfig(base)
+		case resource == "Users":
+			status, resp, err = h.serveUsers(gr, r, base, id)
+		case resource == "Groups":
+			status, resp, err = h.serveGroups(gr, r, base, id)
+		default:
+			err = notFound("unknown resource %q", resource)
+		}
+		if err != nil {
+			h.error(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/scim+json")
+		if resp == nil {
+			w.WriteHeader(status)
+			return
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			h.error(w, r, err)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write(b)
+	}
+}
+
+// scimRequest builds the request passed to the services from the caller's
+// token.
+func scimRequest(r *http.Request) (server.GenericRequest, error) {
+	claims, err := auth.GetClaims(r.Context())
+	if err != nil {
+		return server.GenericRequest{}, scimErr{status: http.StatusUnauthorized, detail: "missing or invalid bearer token"}
+	}
+	if !claims.Authorized(auth.RoleAdmin) {
+		return server.GenericRequest{}, scimErr{status: http.StatusForbidden, detail: auth.ErrForbidden.Error()}
+	}
+	v, err := values.GetValues(r.Context())
+	if err != nil {
+		v = &values.Values{Now: time.Now().UTC()}
+	}
+	return server.GenericRequest{Ctx: r.Context(), Claims: claims, Values: v}, nil
+}
+
+// scimBaseURL is the URL resource locations are relative to.
+func scimBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + strings.TrimSuffix(SCIMPath, "/")
+}
+
+type scimHandler struct {
+	users  user.UserService
+	groups user.GroupService
+}
+
+func (h scimHandler) error(w http.ResponseWriter, r *http.Request, err error) {
+	var se scimErr
+	if !errors.As(err, &se) {
+		se = scimErr{status: http.StatusInternalServerError, detail: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/scim+json")
+	b, _ := json.Marshal(SCIMError{
+		Schemas:  []string{SCIMErrorSchema},
+		Status:   strconv.Itoa(se.status),
+		SCIMType: se.scimType,
+		Detail:   se.detail,
+	})
+	w.WriteHeader(se.status)
+	w.Write(b)
+}
+
+// serveUsers dispatches a request for the Users endpoint.
+func (h scimHandler) serveUsers(gr server.GenericRequest, r *http.Request, base string, id string) (int, any, error) {
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		resp, err := h.listUsers(gr, r, base)
+		return http.StatusOK, resp, err
+	case id == "" && r.Method == http.MethodPost:
+		var su SCIMUser
+		if err := decodeSCIM(r, &su); err != nil {
+			return 0, nil, err
+		}
+		resp, err := h.createUser(gr, base, su)
+		return http.StatusCreated, resp, err
+	case id != "" && r.Method == http.MethodGet:
+		usr, err := h.user(gr, id)
+		return http.StatusOK, toSCIMUser(usr, base), err
+	case id != "" && r.Method == http.MethodPut:
+		var su SCIMUser
+		if err := decodeSCIM(r, &su); err != nil {
+			return 0, nil, err
+		}
+		resp, err := h.replaceUser(gr, base, id, su)
+		return http.StatusOK, resp, err
+	case id != "" && r.Method == http.MethodPatch:
+		var op SCIMPatchOp
+		if err := decodeSCIM(r, &op); err != nil {
+			return 0, nil, err
+		}
+		resp, err := h.patchUser(gr, base, id, op)
+		return http.StatusOK, resp, err
+	case id != "" && r.Method == http.MethodDelete:
+		usr, err := h.user(gr, id)
+		if err != nil {
+			return 0, nil, err
+		}
+		if resp := h.users.DeleteUser(user.DeleteUserRequest{User: usr}, gr); resp.Error != "" {
+			return 0, nil, errors.New(resp.Error)
+		}
+		return http.StatusNoContent, nil, nil
+	}
+	return 0, nil, scimErr{status: http.StatusMethodNotAllowed, detail: r.Method + " not supported"}
+}
+
+// user gets a user of the caller's organization by id.
+func (h scimHandler) user(gr server.GenericRequest, id string) (user.User, error) {
+	resp := h.users.QueryUserByID(user.QueryUserByIDRequest{ID: id}, gr)
+	if resp.Error != "" {
+		return user.User{}, notFound("user %s not found", id)
+	}
+	return resp.User, nil
+}
+
+func (h scimHandler) listUsers(gr server.GenericRequest, r *http.Request, base string) (SCIMListResponse, error) {
+	f, err := listFilter(r)
+	if err != nil {
+		return SCIMListResponse{}, err
+	}
+
+	// Narrow the query when the filter is the usual lookup by userName.
+	var qf user.QueryFilter
+	if cf, ok := f.(compareFilter); ok && cf.op == "eq" && len(cf.path) == 1 && strings.EqualFold(cf.path[0], "userName") {
+		if email, ok := cf.value.(string); ok {
+			qf.Email = &email
+		}
+	}
+
+	var resources []any
+	for page := 1; ; page++ {
+		resp := h.users.QueryUser(user.QueryUserRequest{Filter: qf, PageNumber: page, RowsPerPage: scimMaxResults}, gr)
+		if resp.Error != "" {
+			return SCIMListResponse{}, errors.New(resp.Error)
+		}
+		for _, usr := range resp.Users {
+			su := toSCIMUser(usr, base)
+			if ok, err := matches(f, su); err != nil {
+				return SCIMListResponse{}, err
+			} else if ok {
+				resources = append(resources, su)
+			}
+		}
+		if len(resp.Users) < scimMaxResults {
+			break
+		}
+	}
+
+	return listResponse(r, resources)
+}
+
+func (h scimHandler) createUser(gr server.GenericRequest, base string, su SCIMUser) (SCIMUser, error) {
+	email, err := mail.ParseAddress(su.UserName)
+	if err != nil {
+		return SCIMUser{}, badRequest("invalidValue", "userName must be an email address")
+	}
+
+	if resp := h.users.QueryUser(user.QueryUserRequest{Filter: user.QueryFilter{Email: &email.Address}}, gr); resp.Error == "" && len(resp.Users) > 0 {
+		return SCIMUser{}, scimErr{status: http.StatusConflict, scimType: "uniqueness", detail: "userName is already in use"}
+	}
+
+	roles, err := scimRoles(su.Roles)
+	if err != nil {
+		return SCIMUser{}, err
+	}
+	if len(roles) == 0 {
+		roles = []user.Role{user.RoleUser}
+	}
+
+	// Passwords are only set by admins acting as themselves.
+	if claims, err := user.GetClaims(gr.Ctx); err == nil && claims.Act != nil && su.Password != "" {
+		return SCIMUser{}, scimErr{status: http.StatusForbidden, detail: user.ErrImpersonated.Error()}
+	}
+
+	// Users provisioned without a password sign in through federation or a
+	// password reset.
+	password := su.Password
+	if password == "" {
+		if password, err = randomString(); err != nil {
+			return SCIMUser{}, err
+		}
+	}
+
+	nu := user.CreateUserRequest{NewUser: user.NewUser{
+		Name:            scimDisplayName(su),
+		Email:           *email,
+		Roles:           roles,
+		Password:        password,
+		PasswordConfirm: password,
+	}}
+	resp := h.users.CreateUser(nu, gr)
+	if resp.Error != "" {
+		return SCIMUser{}, badRequest("invalidValue", "%s", resp.Error)
+	}
+	usr := resp.User
+
+	if su.Active != nil && !*su.Active {
+		if usr, err = h.updateUser(gr, user.UpdateUser{Email: &usr.Email, Enabled: su.Active}); err != nil {
+			return SCIMUser{}, err
+		}
+	}
+	return toSCIMUser(usr, base), nil
+}
+
+func (h scimHandler) replaceUser(gr server.GenericRequest, base string, id string, su SCIMUser) (SCIMUser, error) {
+	usr, err := h.user(gr, id)
+	if err != nil {
+		return SCIMUser{}, err
+	}
+	if !strings.EqualFold(su.UserName, usr.Email.Address) {
+		return SCIMUser{}, badRequest("mutability", "userName can't be changed")
+	}
+
+	name := scimDisplayName(su)
+	uu := user.UpdateUser{Email: &usr.Email, Name: &name}
+	if su.Roles != nil {
+		if uu.Roles, err = scimRoles(su.Roles); err != nil {
+			return SCIMUser{}, err
+		}
+	}
+	active := su.Active == nil || *su.Active
+	uu.Enabled = &active
+
+	if usr, err = h.updateUser(gr, uu); err != nil {
+		return SCIMUser{}, err
+	}
+	return toSCIMUser(usr, base), nil
+}
+
+func (h scimHandler) patchUser(gr server.GenericRequest, base string, id string, op SCIMPatchOp) (SCIMUser, error) {
+	usr, err := h.user(gr, id)
+	if err != nil {
+		return SCIMUser{}, err
+	}
+
+	uu := user.UpdateUser{Email: &usr.Email}
+	roles := append([]user.Role(nil), usr.Roles...)
+	rolesChanged := false
+
+	// apply applies a value to a single attribute of the user.
+	var apply func(op string, attr string, f scimFilter, sub string, raw json.RawMessage) error
+	apply = func(op string, attr string, f scimFilter, sub string, raw json.RawMessage) error {
+		switch {
+		case attr == "":
+			// A value without a path holds the attributes to set.
+			var attrs map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &attrs); err != nil {
+				return badRequest("invalidValue", "value must be an object when path is omitted")
+			}
+			for k, v := range attrs {
+				a, af, asub, err := parsePatchPath(k)
+				if err != nil {
+					return badRequest("invalidPath", "%s", err.Error())
+				}
+				if err := apply(op, a, af, asub, v); err != nil {
+					return err
+				}
+			}
+		case strings.EqualFold(attr, "active"):
+			active, err := scimBool(raw)
+			if err != nil {
+				return err
+			}
+			uu.Enabled = &active
+		case strings.EqualFold(attr, "displayName"),
+			strings.EqualFold(attr, "name") && (sub == "" || strings.EqualFold(sub, "formatted")):
+			var name string
+			if sub == "" && strings.EqualFold(attr, "name") {
+				var n SCIMName
+				if err := json.Unmarshal(raw, &n); err != nil {
+					return badRequest("invalidValue", "invalid name")
+				}
+				name = scimDisplayName(SCIMUser{Name: &n})
+			} else if err := json.Unmarshal(raw, &name); err != nil {
+				return badRequest("invalidValue", "%s must be a string", attr)
+			}
+			uu.Name = &name
+		case strings.EqualFold(attr, "userName"):
+			var userName string
+			if err := json.Unmarshal(raw, &userName); err != nil || !strings.EqualFold(userName, usr.Email.Address) {
+				return badRequest("mutability", "userName can't be changed")
+			}
+		case strings.EqualFold(attr, "roles"):
+			rolesChanged = true
+			if op == "remove" {
+				roles = removeRoles(roles, f, raw)
+				return nil
+			}
+			var values []SCIMMultiValue
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return badRequest("invalidValue", "roles must be a list")
+			}
+			parsed, err := scimRoles(values)
+			if err != nil {
+				return err
+			}
+			if op == "replace" {
+				roles = nil
+			}
+			for _, r := range parsed {
+				if !hasRole(roles, r) {
+					roles = append(roles, r)
+				}
+			}
+		default:
+			return badRequest("invalidPath", "attribute %q can't be patched", attr)
+		}
+		return nil
+	}
+
+	for _, o := range op.Operations {
+		opName := strings.ToLower(o.Op)
+		if opName != "add" && opName != "replace" && opName != "remove" {
+			return SCIMUser{}, badRequest("invalidSyntax", "unknown op %q", o.Op)
+		}
+		attr, f, sub, err := parsePatchPath(o.Path)
+		if err != nil {
+			return SCIMUser{}, badRequest("invalidPath", "%s", err.Error())
+		}
+		if opName == "remove" && !strings.EqualFold(attr, "roles") {
+			return SCIMUser{}, badRequest("mutability", "%q can't be removed", o.Path)
+		}
+		if err := apply(opName, attr, f, sub, o.Value); err != nil {
+			return SCIMUser{}, err
+		}
+	}
+
+	if rolesChanged {
+		if len(roles) == 0 {
+			return SCIMUser{}, badRequest("invalidValue", "a user must have at least one role")
+		}
+		uu.Roles = roles
+	}
+
+	if usr, err = h.updateUser(gr, uu); err != nil {
+		return SCIMUser{}, err
+	}
+	return toSCIMUser(usr, base), nil
+}
+
+func (h scimHandler) updateUser(gr server.GenericRequest, uu user.UpdateUser) (user.User, error) {
+	resp := h.users.UpdateUser(user.UpdateUserRequest{UpdateUser: uu}, gr)
+	if resp.Error != "" {
+		return user.User{}, badRequest("invalidValue", "%s", resp.Error)
+	}
+	return resp.User, nil
+}
+
+// serveGroups dispatches a request for the Groups endpoint.
+func (h scimHandler) serveGroups(gr server.GenericRequest, r *http.Request, base string, id string) (int, any, error) {
+	withMembers := !strings.Contains(strings.ToLower(r.URL.Query().Get("excludedAttributes")), "members")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		resp, err := h.listGroups(gr, r, base, withMembers)
+		return http.StatusOK, resp, err
+	case id == "" && r.Method == http.MethodPost:
+		var sg SCIMGroup
+		if err := decodeSCIM(r, &sg); err != nil {
+			return 0, nil, err
+		}
+		resp := h.groups.CreateGroup(user.CreateGroupRequest{NewGroup: user.NewGroup{Name: sg.DisplayName}}, gr)
+		if resp.Error != "" {
+			return 0, nil, badRequest("invalidValue", "%s", resp.Error)
+		}
+		id := resp.Group.ID.String()
+		if err := h.setMembers(gr, id, nil, memberIDs(sg.Members)); err != nil {
+			return 0, nil, err
+		}
+		g, err := h.group(gr, base, id, true)
+		return http.StatusCreated, g, err
+	case id != "" && r.Method == http.MethodGet:
+		g, err := h.group(gr, base, id, withMembers)
+		return http.StatusOK, g, err
+	case id != "" && r.Method == http.MethodPut:
+		var sg SCIMGroup
+		if err := decodeSCIM(r, &sg); err != nil {
+			return 0, nil, err
+		}
+		current, err := h.group(gr, base, id, true)
+		if err != nil {
+			return 0, nil, err
+		}
+		resp := h.groups.UpdateGroup(user.UpdateGroupRequest{ID: id, UpdateGroup: user.UpdateGroup{Name: &sg.DisplayName}}, gr)
+		if resp.Error != "" {
+			return 0, nil, badRequest("invalidValue", "%s", resp.Error)
+		}
+		if err := h.setMembers(gr, id, memberIDs(current.Members), memberIDs(sg.Members)); err != nil {
+			return 0, nil, err
+		}
+		g, err := h.group(gr, base, id, true)
+		return http.StatusOK, g, err
+	case id != "" && r.Method == http.MethodPatch:
+		var op SCIMPatchOp
+		if err := decodeSCIM(r, &op); err != nil {
+			return 0, nil, err
+		}
+		if err := h.patchGroup(gr, base, id, op); err != nil {
+			return 0, nil, err
+		}
+		g, err := h.group(gr, base, id, withMembers)
+		return http.StatusOK, g, err
+	case id != "" && r.Method == http.MethodDelete:
+		if resp := h.groups.DeleteGroup(user.DeleteGroupRequest{ID: id}, gr); resp.Error != "" {
+			return 0, nil, notFound("group %s not found", id)
+		}
+		return http.StatusNoContent, nil, nil
+	}
+	return 0, nil, scimErr{status: http.StatusMethodNotAllowed, detail: r.Method + " not supported"}
+}
+
+// group gets a group of the caller's organization by id.
+func (h scimHandler) group(gr server.GenericRequest, base string, id string, withMembers bool) (SCIMGroup, error) {
+	resp := h.groups.QueryGroupByID(user.QueryGroupByIDRequest{ID: id}, gr)
+	if resp.Error != "" {
+		return SCIMGroup{}, notFound("group %s not found", id)
+	}
+	return h.toSCIMGroup(gr, base, resp.Group, withMembers)
+}
+
+func (h scimHandler) toSCIMGroup(gr server.GenericRequest, base string, grp user.Group, withMembers bool) (SCIMGroup, error) {
+	sg := SCIMGroup{
+		Schemas:     []string{SCIMGroupSchema},
+		ID:          grp.ID.String(),
+		DisplayName: grp.Name,
+		Meta: &SCIMMeta{
+			ResourceType: "Group",
+			Created:      grp.DateCreated,
+			LastModified: grp.DateUpdated,
+			Location:     base + "/Groups/" + grp.ID.String(),
+		},
+	}
+	if !withMembers {
+		return sg, nil
+	}
+
+	resp := h.groups.QueryGroupMembers(user.QueryGroupMembersRequest{GroupID: sg.ID}, gr)
+	if resp.Error != "" {
+		return SCIMGroup{}, errors.New(resp.Error)
+	}
+	for _, usr := range resp.Users {
+		sg.Members = append(sg.Members, SCIMMultiValue{Value: usr.ID.String(), Display: usr.Name})
+	}
+	return sg, nil
+}
+
+func (h scimHandler) listGroups(gr server.GenericRequest, r *http.Request, base string, withMembers bool) (SCIMListResponse, error) {
+	f, err := listFilter(r)
+	if err != nil {
+		return SCIMListResponse{}, err
+	}
+
+	resp := h.groups.QueryGroups(user.QueryGroupsRequest{}, gr)
+	if resp.Error != "" {
+		return SCIMListResponse{}, errors.New(resp.Error)
+	}
+
+	var resources []any
+	for _, grp := range resp.Groups {
+		// Members are needed to evaluate the filter even when excluded from
+		// the response.
+		sg, err := h.toSCIMGroup(gr, base, grp, withMembers || f != nil)
+		if err != nil {
+			return SCIMListResponse{}, err
+		}
+		ok, err := matches(f, sg)
+		if err != nil {
+			return SCIMListResponse{}, err
+		}
+		if !ok {
+			continue
+		}
+		if !withMembers {
+			sg.Members = nil
+		}
+		resources = append(resources, sg)
+	}
+
+	return listResponse(r, resources)
+}
+
+func (h scimHandler) patchGroup(gr server.GenericRequest, base string, id string, op SCIMPatchOp) error {
+	current, err := h.group(gr, base, id, true)
+	if err != nil {
+		return err
+	}
+	members := memberIDs(current.Members)
+	want := make(map[string]bool, len(members))
+	for _, m := range members {
+		want[m] = true
+	}
+
+	var name *string
+	for _, o := range op.Operations {
+		opName := strings.ToLower(o.Op)
+		attr, f, _, err := parsePatchPath(o.Path)
+		if err != nil {
+			return badRequest("invalidPath", "%s", err.Error())
+		}
+
+		// A value without a path holds the attributes to set.
+		if attr == "" {
+			var sg SCIMGroup
+			if err := json.Unmarshal(o.Value, &sg); err != nil {
+				return badRequest("invalidValue", "value must be an object when path is omitted")
+			}
+			if sg.DisplayName != "" {
+				name = &sg.DisplayName
+			}
+			if sg.Members != nil {
+				if opName == "replace" {
+					want = map[string]bool{}
+				}
+				for _, m := range memberIDs(sg.Members) {
+					want[m] = true
+				}
+			}
+			continue
+		}
+
+		switch {
+		case strings.EqualFold(attr, "displayName"):
+			var n string
+			if err := json.Unmarshal(o.Value, &n); err != nil || opName == "remove" {
+				return badRequest("invalidValue", "displayName must be a string")
+			}
+			name = &n
+		case strings.EqualFold(attr, "members"):
+			var values []SCIMMultiValue
+			if len(o.Value) > 0 {
+				if err := json.Unmarshal(o.Value, &values); err != nil {
+					return badRequest("invalidValue", "members must be a list")
+				}
+			}
+			switch opName {
+			case "add":
+				for _, m := range memberIDs(values) {
+					want[m] = true
+				}
+			case "replace":
+				want = map[string]bool{}
+				for _, m := range memberIDs(values) {
+					want[m] = true
+				}
+			case "remove":
+				switch {
+				case f != nil:
+					for m := range want {
+						if f.match(map[string]any{"value": m}) {
+							delete(want, m)
+						}
+					}
+				case len(values) > 0:
+					for _, m := range memberIDs(values) {
+						delete(want, m)
+					}
+				default:
+					want = map[string]bool{}
+				}
+			default:
+				return badRequest("invalidSyntax", "unknown op %q", o.Op)
+			}
+		default:
+			return badRequest("invalidPath", "attribute %q can't be patched", attr)
+		}
+	}
+
+	if name != nil {
+		if resp := h.groups.UpdateGroup(user.UpdateGroupRequest{ID: id, UpdateGroup: user.UpdateGroup{Name: name}}, gr); resp.Error != "" {
+			return badRequest("invalidValue", "%s", resp.Error)
+		}
+	}
+
+	wanted := make([]string, 0, len(want))
+	for m := range want {
+		wanted = append(wanted, m)
+	}
+	return h.setMembers(gr, id, members, wanted)
+}
+
+// setMembers adds and removes members so the group holds exactly want.
+func (h scimHandler) setMembers(gr server.GenericRequest, groupID string, have []string, want []string) error {
+	haveSet := make(map[string]bool, len(have))
+	for _, m := range have {
+		haveSet[m] = true
+	}
+	wantSet := make(map[string]bool, len(want))
+	for _, m := range want {
+		wantSet[m] = true
+		if haveSet[m] {
+			continue
+		}
+		if resp := h.groups.AddGroupMember(user.AddGroupMemberRequest{GroupID: groupID, UserID: m}, gr); resp.Error != "" {
+			return badRequest("invalidValue", "adding member %s: %s", m, resp.Error)
+		}
+	}
+	for _, m := range have {
+		if wantSet[m] {
+			continue
+		}
+		if resp := h.groups.RemoveGroupMember(user.RemoveGroupMemberRequest{GroupID: groupID, UserID: m}, gr); resp.Error != "" {
+			return badRequest("invalidValue", "removing member %s: %s", m, resp.Error)
+		}
+	}
+	return nil
+}
+
+// toSCIMUser converts a user into its SCIM representation.
+func toSCIMUser(usr user.User, base string) SCIMUser {
+	active := usr.Enabled
+	su := SCIMUser{
+		Schemas:     []string{SCIMUserSchema},
+		ID:          usr.ID.String(),
+		UserName:    usr.Email.Address,
+		Name:        &SCIMName{Formatted: usr.Name},
+		DisplayName: usr.Name,
+		Emails:      []SCIMMultiValue{{Value: usr.Email.Address, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &SCIMMeta{
+			ResourceType: "User",
+			Created:      usr.DateCreated,
+			LastModified: usr.DateUpdated,
+			Location:     base + "/Users/" + usr.ID.String(),
+		},
+	}
+	for _, r := range usr.Roles {
+		su.Roles = append(su.Roles, SCIMMultiValue{Value: r.Name()})
+	}
+	return su
+}
+
+// scimDisplayName picks the name to store for a SCIM user.
+func scimDisplayName(su SCIMUser) string {
+	switch {
+	case su.DisplayName != "":
+		return su.DisplayName
+	case su.Name != nil && su.Name.Formatted != "":
+		return su.Name.Formatted
+	case su.Name != nil:
+		return strings.TrimSpace(su.Name.GivenName + " " + su.Name.FamilyName)
+	}
+	return su.UserName
+}
+
+// scimRoles parses the roles of a SCIM user.
+func scimRoles(values []SCIMMultiValue) ([]user.Role, error) {
+	roles := make([]user.Role, 0, len(values))
+	for _, v := range values {
+		r, err := user.ParseRole(v.Value)
+		if err != nil {
+			return nil, badRequest("invalidValue", "invalid role %q", v.Value)
+		}
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+// removeRoles removes the roles selected by a value filter or listed in raw.
+// All roles are removed when neither is given.
+func removeRoles(roles []user.Role, f scimFilter, raw json.RawMessage) []user.Role {
+	var values []SCIMMultiValue
+	if len(raw) > 0 {
+		json.Unmarshal(raw, &values)
+	}
+	if f == nil && len(values) == 0 {
+		return nil
+	}
+
+	var kept []user.Role
+	for _, r := range roles {
+		drop := f != nil && f.match(map[string]any{"value": r.Name()})
+		for _, v := range values {
+			drop = drop || v.Value == r.Name()
+		}
+		if !drop {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+func hasRole(roles []user.Role, r user.Role) bool {
+	for _, have := range roles {
+		if have.Equal(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// scimBool parses a boolean. Some provisioning clients send booleans as
+// strings.
+func scimBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, nil
+		}
+	}
+	return false, badRequest("invalidValue", "expected a boolean")
+}
+
+func memberIDs(values []SCIMMultiValue) []string {
+	ids := make([]string, 0, len(values))
+	for _, v := range values {
+		ids = append(ids, v.Value)
+	}
+	return ids
+}
+
+// decodeSCIM decodes the request body into v.
+func decodeSCIM(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return badRequest("invalidSyntax", "decoding request: %s", err)
+	}
+	return nil
+}
+
+// listFilter parses the filter of a list request. It returns nil when the
+// request isn't filtered.
+func listFilter(r *http.Request) (scimFilter, error) {
+	raw := r.URL.Query().Get("filter")
+	if raw == "" {
+		return nil, nil
+	}
+	f, err := parseFilter(raw)
+	if err != nil {
+		return nil, badRequest("invalidFilter", "%s", err.Error())
+	}
+	return f, nil
+}
+
+// matches reports whether the resource matches the filter.
+func matches(f scimFilter, resource any) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	res, err := toGeneric(resource)
+	if err != nil {
+		return false, err
+	}
+	return f.match(res), nil
+}
+
+// listResponse pages resources using the startIndex and count parameters.
+func listResponse(r *http.Request, resources []any) (SCIMListResponse, error) {
+	q := r.URL.Query()
+	start, count := 1, scimMaxResults
+	if s := q.Get("startIndex"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return SCIMListResponse{}, badRequest("invalidValue", "invalid startIndex")
+		}
+		if n > 1 {
+			start = n
+		}
+	}
+	if s := q.Get("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return SCIMListResponse{}, badRequest("invalidValue", "invalid count")
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n < count {
+			count = n
+		}
+	}
+
+	page := []any{}
+	if start-1 < len(resources) {
+		end := start - 1 + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		page = resources[start-1 : end]
+	}
+
+	return SCIMListResponse{
+		Schemas:      []string{SCIMListResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}, nil
+}
+
+// scimServiceProviderConfig describes the SCIM features supported.
+func scimServiceProviderConfig(base string) map[string]any {
+	return map[string]any{
+		"schemas":          []string{SCIMConfigSchema},
+		"documentationUri": "",
+		"patch":            map[string]any{"supported": true},
+		"bulk":             map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]any{"supported": true, "maxResults": scimMaxResults},
+		"changePassword":   map[string]any{"supported": false},
+		"sort":             map[string]any{"supported": false},
+		"etag":             map[string]any{"supported": false},
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with a bearer token carrying the ADMIN role",
+			"primary":     true,
+		}},
+		"meta": map[string]any{"resourceType": "ServiceProviderConfig", "location": base + "/ServiceProviderConfig"},
+	}
+}
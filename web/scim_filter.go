@@ -0,0 +1,437 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// scimFilter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2).
+// Filters are evaluated against resources decoded into generic JSON values.
+type scimFilter interface {
+	match(res map[string]any) bool
+}
+
+// logicalFilter joins two filters with "and" or "or".
+type logicalFilter struct {
+	and         bool
+	left, right scimFilter
+}
+
+func (f logicalFilter) match(res map[string]any) bool {
+	if f.and {
+		return f.left.match(res) && f.right.match(res)
+	}
+	return f.left.match(res) || f.right.match(res)
+}
+
+// notFilter negates a filter.
+type notFilter struct {
+	f scimFilter
+}
+
+func (f notFilter) match(res map[string]any) bool {
+	return !f.f.match(res)
+}
+
+// valuePathFilter matches when an element of a multi-valued attribute
+// matches the nested filter, as in emails[type eq "work"].
+type valuePathFilter struct {
+	path []string
+	f    scimFilter
+}
+
+func (f valuePathFilter) match(res map[string]any) bool {
+	for _, v := range lookup(res, f.path) {
+		if elem, ok := v.(map[string]any); ok && f.f.match(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareFilter compares an attribute with a value.
+type compareFilter struct {
+	path  []string
+	op    string
+	value any
+}
+
+func (f compareFilter) match(res map[string]any) bool {
+	values := lookup(res, f.path)
+	if f.op == "pr" {
+		for _, v := range values {
+			if v != nil && v != "" {
+				return true
+			}
+		}
+		return false
+	}
+	if f.op == "ne" {
+		return !compareFilter{path: f.path, op: "eq", value: f.value}.match(res)
+	}
+	if f.value == nil && f.op == "eq" {
+		return len(values) == 0
+	}
+	for _, v := range values {
+		if compare(v, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies op to an attribute value and a filter value. Strings are
+// compared case insensitively, since none of the attributes bud exposes
+// are case exact.
+func compare(v any, op string, want any) bool {
+	switch want := want.(type) {
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		s, want = strings.ToLower(s), strings.ToLower(want)
+		switch op {
+		case "eq":
+			return s == want
+		case "co":
+			return strings.Contains(s, want)
+		case "sw":
+			return strings.HasPrefix(s, want)
+		case "ew":
+			return strings.HasSuffix(s, want)
+		case "gt":
+			return s > want
+		case "ge":
+			return s >= want
+		case "lt":
+			return s < want
+		case "le":
+			return s <= want
+		}
+	case float64:
+		n, ok := v.(float64)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return n == want
+		case "gt":
+			return n > want
+		case "ge":
+			return n >= want
+		case "lt":
+			return n < want
+		case "le":
+			return n <= want
+		}
+	case bool:
+		b, ok := v.(bool)
+		return ok && op == "eq" && b == want
+	}
+	return false
+}
+
+// lookup returns the values of the attribute at path. Attribute names are
+// case insensitive and multi-valued attributes are flattened, so
+// emails.value returns the value of every email.
+func lookup(res map[string]any, path []string) []any {
+	values := []any{res}
+	for _, name := range path {
+		var next []any
+		for _, v := range values {
+			m, ok := v.(map[string]any)
+			if !ok {
+				continue
+			}
+			for k, child := range m {
+				if !strings.EqualFold(k, name) {
+					continue
+				}
+				if arr, ok := child.([]any); ok {
+					next = append(next, arr...)
+				} else if child != nil {
+					next = append(next, child)
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// toGeneric converts a resource into generic JSON values for filtering.
+func toGeneric(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var res map[string]any
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// parseFilter parses a SCIM filter expression.
+func parseFilter(s string) (scimFilter, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := filterParser{toks: toks}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.toks[p.pos].text)
+	}
+	return f, nil
+}
+
+// parsePatchPath parses the path of a PATCH operation, such as
+// members[value eq "2819c223"] or name.formatted.
+func parsePatchPath(s string) (attr string, f scimFilter, sub string, err error) {
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		j := strings.LastIndexByte(s, ']')
+		if j < i {
+			return "", nil, "", fmt.Errorf("invalid path %q", s)
+		}
+		if f, err = parseFilter(s[i+1 : j]); err != nil {
+			return "", nil, "", err
+		}
+		attr, sub = s[:i], strings.TrimPrefix(s[j+1:], ".")
+		return stripSchema(attr), f, sub, nil
+	}
+	path := attrPath(s)
+	if len(path) > 1 {
+		sub = strings.Join(path[1:], ".")
+	}
+	return path[0], nil, sub, nil
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokOpen
+	tokClose
+	tokOpenBracket
+	tokCloseBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits a filter into words, quoted strings and brackets.
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			toks = append(toks, token{tokOpen, "("})
+			i++
+		case c == ')':
+			toks = append(toks, token{tokClose, ")"})
+			i++
+		case c == '[':
+			toks = append(toks, token{tokOpenBracket, "["})
+			i++
+		case c == ']':
+			toks = append(toks, token{tokCloseBracket, "]"})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			var str string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &str); err != nil {
+				return nil, fmt.Errorf("invalid string in filter: %w", err)
+			}
+			toks = append(toks, token{tokString, str})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && !strings.ContainsRune("()[]\"", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, token{tokWord, s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// filterParser is a recursive descent parser. Precedence from lowest to
+// highest is or, and, not.
+type filterParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *filterParser) peek() (token, bool) {
+	if p.pos >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+func (p *filterParser) keyword(kw string) bool {
+	t, ok := p.peek()
+	if ok && t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) or() (scimFilter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (scimFilter, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) unary() (scimFilter, error) {
+	if p.keyword("not") {
+		f, err := p.group()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{f: f}, nil
+	}
+	if t, ok := p.peek(); ok && t.kind == tokOpen {
+		return p.group()
+	}
+	return p.attrExp()
+}
+
+func (p *filterParser) group() (scimFilter, error) {
+	if t, ok := p.peek(); !ok || t.kind != tokOpen {
+		return nil, fmt.Errorf("expected ( in filter")
+	}
+	p.pos++
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); !ok || t.kind != tokClose {
+		return nil, fmt.Errorf("expected ) in filter")
+	}
+	p.pos++
+	return f, nil
+}
+
+func (p *filterParser) attrExp() (scimFilter, error) {
+	t, ok := p.peek()
+	if !ok || t.kind != tokWord {
+		return nil, fmt.Errorf("expected attribute in filter")
+	}
+	p.pos++
+	path := attrPath(t.text)
+
+	// valuePath: attr[filter]
+	if t, ok := p.peek(); ok && t.kind == tokOpenBracket {
+		p.pos++
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.kind != tokCloseBracket {
+			return nil, fmt.Errorf("expected ] in filter")
+		}
+		p.pos++
+		return valuePathFilter{path: path, f: f}, nil
+	}
+
+	t, ok = p.peek()
+	if !ok || t.kind != tokWord {
+		return nil, fmt.Errorf("expected operator in filter")
+	}
+	p.pos++
+	op := strings.ToLower(t.text)
+	switch op {
+	case "pr":
+		return compareFilter{path: path, op: op}, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, fmt.Errorf("unknown operator %q in filter", t.text)
+	}
+
+	t, ok = p.peek()
+	if !ok || (t.kind != tokWord && t.kind != tokString) {
+		return nil, fmt.Errorf("expected value in filter")
+	}
+	p.pos++
+	if t.kind == tokString {
+		return compareFilter{path: path, op: op, value: t.text}, nil
+	}
+	switch strings.ToLower(t.text) {
+	case "true":
+		return compareFilter{path: path, op: op, value: true}, nil
+	case "false":
+		return compareFilter{path: path, op: op, value: false}, nil
+	case "null":
+		return compareFilter{path: path, op: op, value: nil}, nil
+	}
+	n, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q in filter", t.text)
+	}
+	return compareFilter{path: path, op: op, value: n}, nil
+}
+
+// attrPath splits an attribute path into its attribute and sub-attributes,
+// dropping any schema URI prefix.
+func attrPath(s string) []string {
+	return strings.Split(stripSchema(s), ".")
+}
+
+// stripSchema removes the schema URI prefix of a fully qualified attribute,
+// as in urn:ietf:params:scim:schemas:core:2.0:User:userName.
+func stripSchema(s string) string {
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
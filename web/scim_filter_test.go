@@ -0,0 +1,72 @@
+package web
+
+import (
+	"testing"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func Test_SCIMFilter(t *testing.T) {
+	res := map[string]any{
+		"userName":    "Bjensen@example.com",
+		"displayName": "Barbara Jensen",
+		"active":      true,
+		"name":        map[string]any{"formatted": "Ms. Barbara J Jensen III", "familyName": "Jensen"},
+		"emails": []any{
+			map[string]any{"value": "bjensen@example.com", "type": "work"},
+			map[string]any{"value": "babs@jensen.org", "type": "home"},
+		},
+		"meta": map[string]any{"lastModified": "2011-05-13T04:42:34Z"},
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`userName eq "bjensen@example.com"`, true},
+		{`userName eq "other@example.com"`, false},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "bjen"`, true},
+		{`name.familyName co "ens"`, true},
+		{`displayName ew "jensen"`, true},
+		{`title pr`, false},
+		{`name pr`, true},
+		{`active eq true`, true},
+		{`active ne true`, false},
+		{`meta.lastModified gt "2011-05-13T04:42:34Z"`, false},
+		{`meta.lastModified ge "2011-05-13T04:42:34Z"`, true},
+		{`emails.value eq "babs@jensen.org"`, true},
+		{`emails[type eq "work" and value co "@example.com"]`, true},
+		{`emails[type eq "home" and value co "@example.com"]`, false},
+		{`userName eq "x" or displayName sw "Barb"`, true},
+		{`userName eq "x" or displayName sw "Barb" and active eq false`, false},
+		{`(userName eq "x" or displayName sw "Barb") and not (active eq false)`, true},
+		{`displayName eq "Barbara \"Babs\" Jensen"`, false},
+	}
+
+	t.Log("Given the need to filter SCIM resources.")
+	{
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen filtering with %s.", testID, tt.filter)
+			{
+				f, err := parseFilter(tt.filter)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to parse the filter : %s.", failed, testID, err)
+				}
+				if got := f.match(res); got != tt.want {
+					t.Fatalf("\t%s\tTest %d:\tShould match %v : got %v.", failed, testID, tt.want, got)
+				}
+				t.Logf("\t%s\tTest %d:\tShould match %v.", success, testID, tt.want)
+			}
+		}
+
+		for _, bad := range []string{`userName`, `userName eq`, `userName zz "x"`, `(userName eq "x"`, `userName eq "x`} {
+			if _, err := parseFilter(bad); err == nil {
+				t.Fatalf("\t%s\tShould reject invalid filter %s.", failed, bad)
+			}
+		}
+		t.Logf("\t%s\tShould reject invalid filters.", success)
+	}
+}
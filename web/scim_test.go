@@ -0,0 +1,252 @@
+package web_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gitamped/bud/services/user"
+	"github.com/gitamped/bud/services/user/stores/memory"
+	"github.com/gitamped/bud/web"
+	"github.com/gitamped/seed/auth"
+	"github.com/gitamped/seed/keystore"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// scimServer serves the SCIM endpoints from an in-memory user store.
+type scimServer struct {
+	t       *testing.T
+	signer  *user.Signer
+	handler http.HandlerFunc
+}
+
+func newSCIMServer(t *testing.T) scimServer {
+	const kid = "4754d86b-7a6d-4df5-9c65-224741361492"
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := user.NewSigner(kid, keystore.NewMap(map[string]*rsa.PrivateKey{kid: key}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := user.NewUserServicer(zap.NewNop().Sugar(), memory.NewStore(), signer)
+	return scimServer{t: t, signer: signer, handler: web.AuthMiddleware(signer)(web.SCIM(users, nil))}
+}
+
+// token issues a bearer token for a caller in the organization holding the
+// roles.
+func (s scimServer) token(orgID string, roles ...string) string {
+	claims := user.Claims{Claims: auth.Claims{Roles: roles}, OrgID: orgID}
+	claims.Subject = uuid.NewString()
+	claims.Issuer = user.DefaultIssuer
+	claims.Audience = jwt.ClaimStrings{user.DefaultAudience}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	return s.sign(claims)
+}
+
+// sign signs the claims as they are.
+func (s scimServer) sign(claims user.Claims) string {
+	tkn, err := s.signer.GenerateToken(claims)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return tkn
+}
+
+// do sends a request with the token and decodes the response into v.
+func (s scimServer) do(method string, path string, token string, body string, v any) int {
+	s.t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/scim+json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.handler(w, r)
+
+	b, _ := io.ReadAll(w.Body)
+	if v != nil && len(b) > 0 {
+		if err := json.Unmarshal(b, v); err != nil {
+			s.t.Fatalf("\t%s\tShould respond with JSON : %s : %s.", failed, err, b)
+		}
+	}
+	return w.Code
+}
+
+func Test_SCIMAuth(t *testing.T) {
+	s := newSCIMServer(t)
+	orgID := uuid.NewString()
+
+	foreign := user.Claims{Claims: auth.Claims{Roles: []string{auth.RoleAdmin}}, OrgID: orgID}
+	foreign.Subject = uuid.NewString()
+	foreign.Issuer = "https://idp.example.com"
+	foreign.Audience = jwt.ClaimStrings{user.DefaultAudience}
+	foreign.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"a token that isn't ours", "not.a.token", http.StatusUnauthorized},
+		{"a token from another issuer", s.sign(foreign), http.StatusUnauthorized},
+		{"a token without the admin role", s.token(orgID, auth.RoleUser), http.StatusForbidden},
+	}
+
+	t.Log("Given the need to only let admins provision users.")
+	{
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen calling with %s.", testID, tt.name)
+			{
+				var se web.SCIMError
+				status := s.do(http.MethodGet, web.SCIMPath+"Users", tt.token, "", &se)
+				if status != tt.status {
+					t.Fatalf("\t%s\tTest %d:\tShould respond with %d : got %d.", failed, testID, tt.status, status)
+				}
+				if len(se.Schemas) != 1 || se.Schemas[0] != web.SCIMErrorSchema || se.Status != strconv.Itoa(tt.status) {
+					t.Fatalf("\t%s\tTest %d:\tShould respond with a SCIM error : got %+v.", failed, testID, se)
+				}
+				t.Logf("\t%s\tTest %d:\tShould respond with %d and a SCIM error.", success, testID, tt.status)
+			}
+		}
+	}
+}
+
+func Test_SCIMUsers(t *testing.T) {
+	s := newSCIMServer(t)
+	orgID := uuid.NewString()
+	admin := s.token(orgID, auth.RoleAdmin)
+
+	t.Log("Given the need to provision users over SCIM.")
+	{
+		var created web.SCIMUser
+
+		testID := 0
+		t.Logf("\tTest %d:\tWhen creating a user.", testID)
+		{
+			body := `{"schemas":["` + web.SCIMUserSchema + `"],"userName":"jane@example.com","name":{"givenName":"Jane","familyName":"Doe"},"active":true}`
+			if status := s.do(http.MethodPost, web.SCIMPath+"Users", admin, body, &created); status != http.StatusCreated {
+				t.Fatalf("\t%s\tTest %d:\tShould respond with 201 : got %d.", failed, testID, status)
+			}
+			if created.ID == "" || created.UserName != "jane@example.com" || created.DisplayName != "Jane Doe" || created.Active == nil || !*created.Active {
+				t.Fatalf("\t%s\tTest %d:\tShould return the user : got %+v.", failed, testID, created)
+			}
+			if len(created.Schemas) != 1 || created.Schemas[0] != web.SCIMUserSchema || created.Meta == nil || !strings.HasSuffix(created.Meta.Location, "/Users/"+created.ID) {
+				t.Fatalf("\t%s\tTest %d:\tShould return a SCIM user resource : got %+v.", failed, testID, created)
+			}
+			if len(created.Roles) != 1 || created.Roles[0].Value != user.RoleUser.Name() {
+				t.Fatalf("\t%s\tTest %d:\tShould grant USER by default : got %+v.", failed, testID, created.Roles)
+			}
+			t.Logf("\t%s\tTest %d:\tShould create the user.", success, testID)
+
+			var se web.SCIMError
+			if status := s.do(http.MethodPost, web.SCIMPath+"Users", admin, body, &se); status != http.StatusConflict || se.SCIMType != "uniqueness" {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse a duplicate userName : got %d %+v.", failed, testID, status, se)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse a duplicate userName.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen listing users.", testID)
+		{
+			s.do(http.MethodPost, web.SCIMPath+"Users", admin, `{"userName":"ed@example.com","displayName":"Ed"}`, nil)
+
+			var lr web.SCIMListResponse
+			q := url.Values{"filter": {`userName eq "jane@example.com"`}}
+			if status := s.do(http.MethodGet, web.SCIMPath+"Users?"+q.Encode(), admin, "", &lr); status != http.StatusOK {
+				t.Fatalf("\t%s\tTest %d:\tShould respond with 200 : got %d.", failed, testID, status)
+			}
+			if len(lr.Schemas) != 1 || lr.Schemas[0] != web.SCIMListResponseSchema || lr.TotalResults != 1 || lr.StartIndex != 1 || len(lr.Resources) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould return a ListResponse with the matching user : got %+v.", failed, testID, lr)
+			}
+			t.Logf("\t%s\tTest %d:\tShould return a ListResponse with the matching user.", success, testID)
+
+			if status := s.do(http.MethodGet, web.SCIMPath+"Users", admin, "", &lr); status != http.StatusOK || lr.TotalResults != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould list every user : got %d %+v.", failed, testID, status, lr)
+			}
+			other := s.token(uuid.NewString(), auth.RoleAdmin)
+			if status := s.do(http.MethodGet, web.SCIMPath+"Users", other, "", &lr); status != http.StatusOK || lr.TotalResults != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould only list the token's organization : got %d %+v.", failed, testID, status, lr)
+			}
+			t.Logf("\t%s\tTest %d:\tShould list the users of the organization.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen replacing a user.", testID)
+		{
+			var replaced web.SCIMUser
+			body := `{"schemas":["` + web.SCIMUserSchema + `"],"userName":"jane@example.com","displayName":"Jane Smith","active":false,"roles":[{"value":"ADMIN"}]}`
+			if status := s.do(http.MethodPut, web.SCIMPath+"Users/"+created.ID, admin, body, &replaced); status != http.StatusOK {
+				t.Fatalf("\t%s\tTest %d:\tShould respond with 200 : got %d.", failed, testID, status)
+			}
+			if replaced.DisplayName != "Jane Smith" || *replaced.Active || len(replaced.Roles) != 1 || replaced.Roles[0].Value != "ADMIN" {
+				t.Fatalf("\t%s\tTest %d:\tShould replace the user : got %+v.", failed, testID, replaced)
+			}
+			t.Logf("\t%s\tTest %d:\tShould replace the user.", success, testID)
+
+			var se web.SCIMError
+			body = `{"userName":"janet@example.com","displayName":"Jane Smith"}`
+			if status := s.do(http.MethodPut, web.SCIMPath+"Users/"+created.ID, admin, body, &se); status != http.StatusBadRequest || se.SCIMType != "mutability" {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse to change the userName : got %d %+v.", failed, testID, status, se)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse to change the userName.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen patching a user.", testID)
+		{
+			var patched web.SCIMUser
+			body := `{"schemas":["` + web.SCIMPatchOpSchema + `"],"Operations":[
+				{"op":"replace","value":{"active":true,"displayName":"Jane Doe"}},
+				{"op":"add","path":"roles","value":[{"value":"USER"}]},
+				{"op":"remove","path":"roles[value eq \"ADMIN\"]"}
+			]}`
+			if status := s.do(http.MethodPatch, web.SCIMPath+"Users/"+created.ID, admin, body, &patched); status != http.StatusOK {
+				t.Fatalf("\t%s\tTest %d:\tShould respond with 200 : got %d.", failed, testID, status)
+			}
+			if patched.DisplayName != "Jane Doe" || !*patched.Active || len(patched.Roles) != 1 || patched.Roles[0].Value != "USER" {
+				t.Fatalf("\t%s\tTest %d:\tShould apply the operations in order : got %+v.", failed, testID, patched)
+			}
+			t.Logf("\t%s\tTest %d:\tShould apply the operations in order.", success, testID)
+
+			var se web.SCIMError
+			body = `{"schemas":["` + web.SCIMPatchOpSchema + `"],"Operations":[{"op":"remove","path":"roles"}]}`
+			if status := s.do(http.MethodPatch, web.SCIMPath+"Users/"+created.ID, admin, body, &se); status != http.StatusBadRequest || se.SCIMType != "invalidValue" {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse to remove every role : got %d %+v.", failed, testID, status, se)
+			}
+			body = `{"schemas":["` + web.SCIMPatchOpSchema + `"],"Operations":[{"op":"remove","path":"userName"}]}`
+			if status := s.do(http.MethodPatch, web.SCIMPath+"Users/"+created.ID, admin, body, &se); status != http.StatusBadRequest || se.SCIMType != "mutability" {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse to remove the userName : got %d %+v.", failed, testID, status, se)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse invalid operations.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen deleting a user.", testID)
+		{
+			if status := s.do(http.MethodDelete, web.SCIMPath+"Users/"+created.ID, admin, "", nil); status != http.StatusNoContent {
+				t.Fatalf("\t%s\tTest %d:\tShould respond with 204 : got %d.", failed, testID, status)
+			}
+			var se web.SCIMError
+			if status := s.do(http.MethodGet, web.SCIMPath+"Users/"+created.ID, admin, "", &se); status != http.StatusNotFound || se.Status != "404" || se.Schemas[0] != web.SCIMErrorSchema {
+				t.Fatalf("\t%s\tTest %d:\tShould no longer find the user : got %d %+v.", failed, testID, status, se)
+			}
+			if status := s.do(http.MethodDelete, web.SCIMPath+"Users/"+created.ID, admin, "", &se); status != http.StatusNotFound {
+				t.Fatalf("\t%s\tTest %d:\tShould respond with 404 the second time : got %d.", failed, testID, status)
+			}
+			t.Logf("\t%s\tTest %d:\tShould delete the user.", success, testID)
+		}
+	}
+}